@@ -0,0 +1,297 @@
+package cache
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/plugin/pkg/reuseport"
+
+	"github.com/miekg/dns"
+)
+
+// The admin endpoint lists and purges cached items over HTTP. Every cache that is configured with the same
+// address shares one listener, the caches are registered on startup and unregistered on shutdown. This
+// keeps the listener open during a reload. Each cache keeps its own token, a request only sees the caches
+// whose token it carries.
+
+const adminPath = "/cache/entries"
+
+var (
+	adminsMu sync.Mutex
+	admins   = map[string]*admin{}
+)
+
+// admin is the admin endpoint listening on addr.
+type admin struct {
+	addr string
+
+	mu     sync.RWMutex
+	caches []registeredCache
+	ln     net.Listener
+	srv    *http.Server
+}
+
+// registeredCache is a cache registered with an admin endpoint, together with the token that gives access to it.
+type registeredCache struct {
+	*Cache
+	token string
+}
+
+// registerAdmin adds c to the admin endpoint on addr, and starts it if this is the first cache for addr. Only
+// requests with token as bearer token can list or purge c.
+func registerAdmin(addr, token string, c *Cache) error {
+	adminsMu.Lock()
+	defer adminsMu.Unlock()
+
+	a, ok := admins[addr]
+	if !ok {
+		a = &admin{addr: addr}
+		ln, err := reuseport.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		a.ln = ln
+		a.srv = &http.Server{Handler: a.handler()}
+		admins[addr] = a
+		go func() { a.srv.Serve(ln) }()
+	}
+
+	a.mu.Lock()
+	a.caches = append(a.caches, registeredCache{Cache: c, token: token})
+	a.mu.Unlock()
+	return nil
+}
+
+// unregisterAdmin removes c from the admin endpoint on addr, and stops it if it was the last cache.
+func unregisterAdmin(addr string, c *Cache) error {
+	adminsMu.Lock()
+	defer adminsMu.Unlock()
+
+	a, ok := admins[addr]
+	if !ok {
+		return nil
+	}
+	a.mu.Lock()
+	a.caches = slices.DeleteFunc(a.caches, func(c1 registeredCache) bool { return c1.Cache == c })
+	last := len(a.caches) == 0
+	a.mu.Unlock()
+	if !last {
+		return nil
+	}
+	delete(admins, addr)
+	// Also close the open connections, so they can not be used once the endpoint is gone.
+	return a.srv.Close()
+}
+
+// handler returns the HTTP handler of a.
+func (a *admin) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(adminPath, func(w http.ResponseWriter, r *http.Request) {
+		caches := a.authorized(r)
+		if len(caches) == 0 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		f, err := parseFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			entries := []entry{}
+			for _, c := range caches {
+				entries = append(entries, c.entries(f)...)
+			}
+			writeJSON(w, entries)
+		case http.MethodDelete:
+			if f.name == "" && f.suffix == "" {
+				http.Error(w, "name or suffix is required to purge", http.StatusBadRequest)
+				return
+			}
+			n := 0
+			warning := ""
+			for _, c := range caches {
+				n += c.purge(f)
+				if c.backend != nil && f.suffix != "" {
+					warning = "only the entries that were in memory are purged from the backend"
+				}
+			}
+			log.Infof("Purged %d cache items for %s", n, f)
+			writeJSON(w, struct {
+				Purged  int    `json:"purged"`
+				Warning string `json:"warning,omitempty"`
+			}{n, warning})
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+// authorized returns the caches of a whose token r has as bearer token.
+func (a *admin) authorized(r *http.Request) []*Cache {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	var caches []*Cache
+	for _, c := range a.caches {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) == 1 {
+			caches = append(caches, c.Cache)
+		}
+	}
+	return caches
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// filter selects cached items by name, type or suffix. The zero filter selects all items.
+type filter struct {
+	name   string
+	qtype  uint16
+	suffix string
+}
+
+// parseFilter parses the name, type and suffix query parameters.
+func parseFilter(q url.Values) (filter, error) {
+	var f filter
+	if name := q.Get("name"); name != "" {
+		f.name = strings.ToLower(dns.Fqdn(name))
+		if _, ok := dns.IsDomainName(f.name); !ok {
+			return f, fmt.Errorf("invalid name: %q", name)
+		}
+	}
+	if suffix := q.Get("suffix"); suffix != "" {
+		f.suffix = strings.ToLower(dns.Fqdn(suffix))
+		if _, ok := dns.IsDomainName(f.suffix); !ok {
+			return f, fmt.Errorf("invalid suffix: %q", suffix)
+		}
+	}
+	if f.name != "" && f.suffix != "" {
+		return f, errors.New("name and suffix are mutually exclusive")
+	}
+	if typ := q.Get("type"); typ != "" {
+		qtype, ok := dns.StringToType[strings.ToUpper(typ)]
+		if !ok {
+			return f, fmt.Errorf("invalid type: %q", typ)
+		}
+		f.qtype = qtype
+	}
+	return f, nil
+}
+
+// match returns true if i is selected by f.
+func (f filter) match(i *item) bool {
+	if f.qtype != 0 && i.QType != f.qtype {
+		return false
+	}
+	name := strings.ToLower(i.Name)
+	if f.name != "" && name != f.name {
+		return false
+	}
+	return f.suffix == "" || dns.IsSubDomain(f.suffix, name)
+}
+
+func (f filter) String() string {
+	s := f.name
+	if f.suffix != "" {
+		s = "*." + f.suffix
+	}
+	if f.qtype != 0 {
+		s += "/" + dns.TypeToString[f.qtype]
+	}
+	return s
+}
+
+// entry is a cached item as listed by the admin endpoint.
+type entry struct {
+	Zones   string   `json:"zones"`
+	Cache   string   `json:"cache"` // Success or Denial
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Rcode   string   `json:"rcode"`
+	TTL     int      `json:"ttl"` // remaining TTL, negative for stale items
+	Subnet  string   `json:"subnet,omitempty"`
+	Records []string `json:"records,omitempty"` // only when inspecting a single name
+}
+
+// entries returns the items in c that are selected by f.
+func (c *Cache) entries(f filter) []entry {
+	now := c.now().UTC()
+	var entries []entry
+	for _, kc := range []struct {
+		kind string
+		ca   *cache.Cache
+	}{{Success, c.pcache}, {Denial, c.ncache}} {
+		kc.ca.Walk(func(m map[uint64]any, key uint64) bool {
+			i, ok := m[key].(*item)
+			if !ok || !f.match(i) {
+				return true
+			}
+			e := entry{
+				Zones: c.zonesMetricLabel,
+				Cache: kc.kind,
+				Name:  i.Name,
+				Type:  dns.TypeToString[i.QType],
+				Rcode: dns.RcodeToString[i.Rcode],
+				TTL:   i.ttl(now),
+			}
+			if i.ecs != nil {
+				e.Subnet = fmt.Sprintf("%s/%d", i.ecs.Address, i.ecs.SourceScope)
+			}
+			if f.name != "" {
+				for _, section := range [][]dns.RR{i.Answer, i.Ns, i.Extra} {
+					for _, rr := range section {
+						e.Records = append(e.Records, rr.String())
+					}
+				}
+			}
+			entries = append(entries, e)
+			return true
+		})
+	}
+	return entries
+}
+
+// purge removes the items in c that are selected by f, and returns how many were removed. With aggressive
+// NSEC, the NSEC and NSEC3 records of the zones around the purged names are removed as well, these might deny
+// the existence of a name that was just added. With a backend, the items are deleted from the backend too.
+func (c *Cache) purge(f filter) int {
+	if c.nsec != nil {
+		c.nsec.purge(f.name + f.suffix)
+	}
+	n := 0
+	purged := map[question]struct{}{}
+	for _, ca := range []*cache.Cache{c.pcache, c.ncache} {
+		ca.Walk(func(m map[uint64]any, key uint64) bool {
+			if i, ok := m[key].(*item); ok && f.match(i) {
+				delete(m, key)
+				purged[question{strings.ToLower(i.Name), i.QType}] = struct{}{}
+				n++
+			}
+			return true
+		})
+	}
+	if c.backend != nil {
+		c.purgeBackend(f, purged)
+	}
+	return n
+}
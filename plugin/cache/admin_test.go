@@ -0,0 +1,255 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// adminCache returns a cache with a number of names in it.
+func adminCache(t *testing.T) *Cache {
+	t.Helper()
+	c := New()
+	for _, q := range []struct {
+		name  string
+		qtype uint16
+	}{
+		{"a.example.org.", dns.TypeA},
+		{"a.example.org.", dns.TypeAAAA},
+		{"b.example.org.", dns.TypeA},
+		{"other.example.net.", dns.TypeA},
+	} {
+		c.Next = BackendHandler()
+		req := new(dns.Msg)
+		req.SetQuestion(q.name, q.qtype)
+		c.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+	}
+	c.Next = nxDomainBackend(60)
+	req := new(dns.Msg)
+	req.SetQuestion("nx.example.org.", dns.TypeA)
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+
+	if n := c.pcache.Len() + c.ncache.Len(); n != 5 {
+		t.Fatalf("Expected 5 cached items, got %d", n)
+	}
+	return c
+}
+
+func adminRequest(t *testing.T, srv *httptest.Server, method, query, token string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+adminPath+"?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestAdminAuth(t *testing.T) {
+	a := &admin{caches: []registeredCache{{Cache: adminCache(t), token: "s3cret"}}}
+	srv := httptest.NewServer(a.handler())
+	defer srv.Close()
+
+	for _, token := range []string{"", "wrong"} {
+		resp := adminRequest(t, srv, http.MethodDelete, "suffix=.", token)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status %d for token %q, got %d", http.StatusUnauthorized, token, resp.StatusCode)
+		}
+	}
+	if n := a.caches[0].pcache.Len(); n != 4 {
+		t.Errorf("Expected nothing to be purged without the token, got %d items", n)
+	}
+}
+
+func TestAdminEntries(t *testing.T) {
+	a := &admin{caches: []registeredCache{{Cache: adminCache(t), token: "s3cret"}}}
+	srv := httptest.NewServer(a.handler())
+	defer srv.Close()
+
+	tests := []struct {
+		query    string
+		expected int
+		records  bool
+	}{
+		{"", 5, false},
+		{"suffix=example.org", 4, false},
+		{"name=a.example.org", 2, true},
+		{"name=A.Example.Org.&type=aaaa", 1, true},
+		{"type=A", 4, false},
+		{"name=nx.example.org", 1, true},
+	}
+	for _, tc := range tests {
+		resp := adminRequest(t, srv, http.MethodGet, tc.query, "s3cret")
+		var entries []entry
+		err := json.NewDecoder(resp.Body).Decode(&entries)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Query %q: failed to decode entries: %s", tc.query, err)
+		}
+		if len(entries) != tc.expected {
+			t.Errorf("Query %q: expected %d entries, got %d", tc.query, tc.expected, len(entries))
+			continue
+		}
+		for _, e := range entries {
+			if e.TTL <= 0 {
+				t.Errorf("Query %q: expected positive TTL for %s, got %d", tc.query, e.Name, e.TTL)
+			}
+			if hasRecords := len(e.Records) > 0; hasRecords != tc.records {
+				t.Errorf("Query %q: expected records %t for %s, got %t", tc.query, tc.records, e.Name, hasRecords)
+			}
+		}
+	}
+}
+
+func TestAdminPurge(t *testing.T) {
+	c := adminCache(t)
+	a := &admin{caches: []registeredCache{{Cache: c, token: "s3cret"}}}
+	srv := httptest.NewServer(a.handler())
+	defer srv.Close()
+
+	tests := []struct {
+		query    string
+		purged   int
+		left     int
+		expected int // status
+	}{
+		{"name=a.example.org&type=A", 1, 4, http.StatusOK},
+		{"name=a.example.org&type=A", 0, 4, http.StatusOK},
+		{"name=a.example.org", 1, 3, http.StatusOK},
+		{"suffix=example.org", 2, 1, http.StatusOK},
+		{"", 0, 1, http.StatusBadRequest},
+		{"type=A", 0, 1, http.StatusBadRequest},
+		{"name=other.example.net&type=BOGUS", 0, 1, http.StatusBadRequest},
+		{"name=other.example.net&suffix=net", 0, 1, http.StatusBadRequest},
+		{"suffix=.", 1, 0, http.StatusOK},
+	}
+	for _, tc := range tests {
+		resp := adminRequest(t, srv, http.MethodDelete, tc.query, "s3cret")
+		if resp.StatusCode != tc.expected {
+			t.Errorf("Query %q: expected status %d, got %d", tc.query, tc.expected, resp.StatusCode)
+		}
+		if resp.StatusCode == http.StatusOK {
+			var result struct{ Purged int }
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatalf("Query %q: failed to decode result: %s", tc.query, err)
+			}
+			if result.Purged != tc.purged {
+				t.Errorf("Query %q: expected %d purged, got %d", tc.query, tc.purged, result.Purged)
+			}
+		}
+		resp.Body.Close()
+		if left := c.pcache.Len() + c.ncache.Len(); left != tc.left {
+			t.Errorf("Query %q: expected %d items left, got %d", tc.query, tc.left, left)
+		}
+	}
+
+	resp := adminRequest(t, srv, http.MethodPost, "suffix=.", "s3cret")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+func TestAdminRegister(t *testing.T) {
+	const addr = "127.0.0.1:0"
+	c1, c2 := adminCache(t), adminCache(t)
+	if err := registerAdmin(addr, "s3cret", c1); err != nil {
+		t.Fatalf("Failed to start admin endpoint: %s", err)
+	}
+	if err := registerAdmin(addr, "s3cret", c2); err != nil {
+		t.Fatalf("Failed to register second cache: %s", err)
+	}
+	a := admins[addr]
+	url := "http://" + a.ln.Addr().String() + adminPath + "?suffix=a.example.org"
+
+	unregisterAdmin(addr, c1)
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected admin endpoint to be up with a cache left: %s", err)
+	}
+	resp.Body.Close()
+	if c1.pcache.Len() != 4 || c2.pcache.Len() != 2 {
+		t.Errorf("Expected only the registered cache to be purged, got %d and %d items", c1.pcache.Len(), c2.pcache.Len())
+	}
+
+	unregisterAdmin(addr, c2)
+	if _, ok := admins[addr]; ok {
+		t.Error("Expected admin endpoint to be stopped after the last cache")
+	}
+	if _, err := http.DefaultClient.Do(req); err == nil {
+		t.Error("Expected admin endpoint to be closed")
+	}
+}
+
+func TestAdminTokens(t *testing.T) {
+	c1, c2 := adminCache(t), adminCache(t)
+	a := &admin{caches: []registeredCache{{Cache: c1, token: "s3cret"}, {Cache: c2, token: "other"}}}
+	srv := httptest.NewServer(a.handler())
+	defer srv.Close()
+
+	// A request only acts on the caches whose token it carries.
+	resp := adminRequest(t, srv, http.MethodDelete, "suffix=a.example.org", "other")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if c1.pcache.Len() != 4 || c2.pcache.Len() != 2 {
+		t.Errorf("Expected only the cache with the token to be purged, got %d and %d items", c1.pcache.Len(), c2.pcache.Len())
+	}
+
+	resp = adminRequest(t, srv, http.MethodGet, "suffix=.", "s3cret")
+	var entries []entry
+	json.NewDecoder(resp.Body).Decode(&entries)
+	resp.Body.Close()
+	if len(entries) != 5 {
+		t.Errorf("Expected the 5 entries of the cache with the token, got %d", len(entries))
+	}
+}
+
+func TestAdminPurgeBackend(t *testing.T) {
+	f := newFakeRedis(t, "")
+	b, err := newBackend(f.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	c := adminCache(t)
+	WithBackend(b, time.Second)(c)
+	a := &admin{caches: []registeredCache{{Cache: c, token: "s3cret"}}}
+	srv := httptest.NewServer(a.handler())
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		query   string
+		warning bool
+	}{
+		{"name=a.example.org", false},
+		{"suffix=example.org", true},
+	} {
+		resp := adminRequest(t, srv, http.MethodDelete, tc.query, "s3cret")
+		var result struct {
+			Warning string `json:"warning"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if (result.Warning != "") != tc.warning {
+			t.Errorf("Query %q: expected a warning %t, got %q", tc.query, tc.warning, result.Warning)
+		}
+	}
+}
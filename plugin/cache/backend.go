@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/cache/freq"
+	"github.com/coredns/coredns/plugin/pkg/response"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// Backend is a second level cache that is shared between CoreDNS instances. A miss in the cache is looked up
+// in the backend before going to the next plugin, and replies that are cached are written to it. The values
+// are replies in wire format; the backend should expire them after their TTL.
+type Backend interface {
+	// Get returns the value stored under key, or nil if there is none.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores value under key for ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the keys, keys that don't exist are ignored.
+	Delete(ctx context.Context, keys ...string) error
+	// Close closes the backend.
+	Close() error
+}
+
+// backends holds the backends by URL scheme.
+var backends = map[string]func(*url.URL) (Backend, error){
+	"redis": newRedis,
+}
+
+// newBackend returns the backend for the URL s.
+func newBackend(s string) (Backend, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := backends[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unknown cache backend: %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("cache backend without address: %s", s)
+	}
+	return f(u)
+}
+
+const (
+	defaultBackendTimeout = 50 * time.Millisecond
+	// backendBackoff is how long the backend is not used after an error; the cache is memory only then.
+	backendBackoff = 5 * time.Second
+	// backendMaxWrites is the number of writes to the backend in flight, more are dropped.
+	backendMaxWrites = 64
+	// backendPurgeTimeout is the timeout of a purge from the admin endpoint, that can delete many keys.
+	backendPurgeTimeout = 5 * time.Second
+)
+
+// backendKey returns the key in the backend for key. Replies for all clients are stored, they don't depend on
+// the client subnet, so the key only depends on the question and the DO and CD bits. The key is prefixed with
+// the namespace of c, so caches for other zones or views, that may give other replies, don't share keys.
+func (c *Cache) backendKey(key uint64) string {
+	ns := c.backendNamespace
+	if ns == "" {
+		ns = c.zonesMetricLabel
+		if c.viewMetricLabel != "" {
+			ns = c.viewMetricLabel + "/" + ns
+		}
+	}
+	return ns + ":" + strconv.FormatUint(key, 16)
+}
+
+// backendUp returns true if the backend can be used at now, it is not when an error was seen recently.
+func (c *Cache) backendUp(now time.Time) bool {
+	return now.UnixNano() >= c.backendDown.Load()
+}
+
+// backendFailed records an error of the backend, it is not used for backendBackoff.
+func (c *Cache) backendFailed(server string, err error) {
+	backendErrors.WithLabelValues(server, c.zonesMetricLabel, c.viewMetricLabel).Inc()
+	down := c.now().Add(backendBackoff).UnixNano()
+	if old := c.backendDown.Swap(down); old < c.now().UnixNano() {
+		log.Warningf("Cache backend failed, using memory only for %s: %s", backendBackoff, err)
+	}
+}
+
+// getBackend looks up the query in state in the backend. An item found is stored in the cache and returned.
+func (c *Cache) getBackend(ctx context.Context, state request.Request, now time.Time, server string) *item {
+	if !c.backendUp(now) {
+		return nil
+	}
+	key := hash(state.Name(), state.QType(), state.Do(), state.Req.CheckingDisabled)
+	ctx, cancel := context.WithTimeout(ctx, c.backendTimeout)
+	defer cancel()
+	buf, err := c.backend.Get(ctx, c.backendKey(key))
+	if err != nil {
+		c.backendFailed(server, err)
+		return nil
+	}
+	if buf == nil {
+		return nil
+	}
+	i, mt, err := unpackBackendItem(buf, now)
+	if err != nil {
+		log.Debugf("Invalid item in cache backend for %s/%d: %s", state.Name(), state.QType(), err)
+		return nil
+	}
+	if !i.matches(state, clientSubnet{}) || i.ttl(now) <= 0 {
+		return nil
+	}
+
+	ca, except := c.pcache, c.pexcept
+	if mt == response.NameError || mt == response.NoData || mt == response.ServerError {
+		ca, except = c.ncache, c.nexcept
+	}
+	if plugin.Zones(except).Matches(i.Name) != "" {
+		return nil
+	}
+	ca.Put(key, i)
+	backendHits.WithLabelValues(server, c.zonesMetricLabel, c.viewMetricLabel).Inc()
+	return i
+}
+
+// setBackend writes i to the backend under key, in the background. When too many writes are in flight, or the
+// backend is down, i is not written.
+func (w *ResponseWriter) setBackend(key uint64, i *item) {
+	now := w.now()
+	if i.ecs != nil || !w.backendUp(now) {
+		return
+	}
+	buf, err := packBackendItem(i)
+	if err != nil {
+		return
+	}
+	select {
+	case w.backendWrites <- struct{}{}:
+	default:
+		return
+	}
+	go func() {
+		defer func() { <-w.backendWrites }()
+		ctx, cancel := context.WithTimeout(context.Background(), w.backendTimeout)
+		defer cancel()
+		if err := w.backend.Set(ctx, w.backendKey(key), buf, time.Duration(i.origTTL)*time.Second); err != nil {
+			w.backendFailed(w.server, err)
+		}
+	}()
+}
+
+// packBackendItem returns i as stored in the backend: the time it was stored in unix nanoseconds as an int64,
+// its TTL in seconds as an uint32, both big endian, and i as a packed DNS message.
+func packBackendItem(i *item) ([]byte, error) {
+	msg, err := i.pack()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, 12+len(msg))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(i.stored.UnixNano()))
+	buf = binary.BigEndian.AppendUint32(buf, i.origTTL)
+	return append(buf, msg...), nil
+}
+
+// unpackBackendItem returns the item in buf, and the type of the reply in it.
+func unpackBackendItem(buf []byte, now time.Time) (*item, response.Type, error) {
+	if len(buf) < 12 {
+		return nil, 0, errors.New("short item")
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(buf[12:]); err != nil {
+		return nil, 0, err
+	}
+	if len(m.Question) != 1 {
+		return nil, 0, errors.New("item without question")
+	}
+	mt, _ := response.Typify(m, now)
+	i := &item{
+		Name:               m.Question[0].Name,
+		QType:              m.Question[0].Qtype,
+		Rcode:              m.Rcode,
+		AuthenticatedData:  m.AuthenticatedData,
+		RecursionAvailable: m.RecursionAvailable,
+		Answer:             m.Answer,
+		Ns:                 m.Ns,
+		Extra:              m.Extra,
+		origTTL:            binary.BigEndian.Uint32(buf[8:12]),
+		stored:             time.Unix(0, int64(binary.BigEndian.Uint64(buf[:8]))).UTC(),
+		size:               len(buf) - 12,
+		Freq:               new(freq.Freq),
+	}
+	return i, mt, nil
+}
+
+// purgeBackend deletes the keys selected by f from the backend. For a name the keys of all types are deleted, for
+// a suffix only the keys of the questions in purged, the items that were purged from memory: the backend can't be
+// searched for the names below the suffix.
+func (c *Cache) purgeBackend(f filter, purged map[question]struct{}) {
+	var keys []string
+	add := func(name string, qtype uint16) {
+		for _, do := range []bool{false, true} {
+			for _, cd := range []bool{false, true} {
+				keys = append(keys, c.backendKey(hash(name, qtype, do, cd)))
+			}
+		}
+	}
+	switch {
+	case f.name != "" && f.qtype != 0:
+		add(f.name, f.qtype)
+	case f.name != "":
+		for qtype := range dns.TypeToString {
+			add(f.name, qtype)
+		}
+	default:
+		for q := range purged {
+			add(q.name, q.qtype)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), backendPurgeTimeout)
+	defer cancel()
+	if err := c.backend.Delete(ctx, keys...); err != nil {
+		log.Warningf("Failed to purge %s from the cache backend: %s", f, err)
+	}
+}
+
+// question is the name and type of a cached item.
+type question struct {
+	name  string
+	qtype uint16
+}
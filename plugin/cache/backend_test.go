@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// waitForSets waits until the backend f has seen n sets.
+func waitForSets(t *testing.T, f *fakeRedis, n int) {
+	t.Helper()
+	for range 100 {
+		if f.setCount() >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d sets in the backend, got %d", n, f.setCount())
+}
+
+func TestCacheBackend(t *testing.T) {
+	f := newFakeRedis(t, "")
+	replica := func() *Cache {
+		b, err := newBackend(f.url())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { b.Close() })
+		return NewCache("", "", WithBackend(b, time.Second))
+	}
+
+	c1 := replica()
+	c1.Next = BackendHandler()
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	c1.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+	c1.Next = nxDomainBackend(60)
+	nx := new(dns.Msg)
+	nx.SetQuestion("nx.example.org.", dns.TypeA)
+	c1.ServeDNS(context.TODO(), &test.ResponseWriter{}, nx)
+	waitForSets(t, f, 2)
+
+	// Another replica gets the replies from the backend, without asking its next plugin.
+	c2 := replica()
+	c2.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		t.Errorf("Expected %s to be served from the backend", r.Question[0].Name)
+		return dns.RcodeServerFailure, nil
+	})
+	for _, tc := range []struct {
+		req   *dns.Msg
+		rcode int
+	}{
+		{req, dns.RcodeSuccess},
+		{nx, dns.RcodeNameError},
+	} {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		c2.ServeDNS(context.TODO(), rec, tc.req)
+		if rec.Msg == nil || rec.Msg.Rcode != tc.rcode {
+			t.Fatalf("Expected reply with rcode %d for %s", tc.rcode, tc.req.Question[0].Name)
+		}
+		if ttl := rec.Msg.Answer; tc.rcode == dns.RcodeSuccess && (len(ttl) == 0 || ttl[0].Header().Ttl == 0) {
+			t.Errorf("Expected answer with a TTL, got %v", rec.Msg.Answer)
+		}
+	}
+	if c2.pcache.Len() != 1 || c2.ncache.Len() != 1 {
+		t.Errorf("Expected the replies from the backend in memory, got %d and %d", c2.pcache.Len(), c2.ncache.Len())
+	}
+}
+
+func TestCacheBackendNamespace(t *testing.T) {
+	f := newFakeRedis(t, "")
+	b, err := newBackend(f.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	c1 := NewCache("example.org.", "", WithBackend(b, time.Second))
+	c1.Next = BackendHandler()
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	c1.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+	waitForSets(t, f, 1)
+
+	key := hash("example.org.", dns.TypeA, false, false)
+	if k := "coredns:cache:example.org.:" + strconv.FormatUint(key, 16); !f.has(k) {
+		t.Errorf("Expected the reply under key %q", k)
+	}
+
+	tests := []struct {
+		cache  *Cache
+		shared bool
+	}{
+		{NewCache("example.org.", "", WithBackend(b, time.Second)), true},
+		{NewCache("example.org.", "internal", WithBackend(b, time.Second)), false},
+		{NewCache(".", "", WithBackend(b, time.Second)), false},
+		{NewCache(".", "", WithBackend(b, time.Second), WithBackendNamespace("example.org.")), true},
+	}
+	for i, tc := range tests {
+		asked := false
+		tc.cache.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			asked = true
+			return BackendHandler().ServeDNS(ctx, w, r)
+		})
+		tc.cache.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+		if asked == tc.shared {
+			t.Errorf("Test %d: expected the reply from the backend to be used %t, got %t", i, tc.shared, !asked)
+		}
+	}
+}
+
+func TestCacheBackendPurge(t *testing.T) {
+	f := newFakeRedis(t, "")
+	b, err := newBackend(f.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	c1 := NewCache("", "", WithBackend(b, time.Second))
+	c1.Next = BackendHandler()
+	for _, name := range []string{"a.example.org.", "b.example.org.", "c.example.org."} {
+		c1.ServeDNS(context.TODO(), &test.ResponseWriter{}, nsecQuery(name, dns.TypeA, false))
+	}
+	waitForSets(t, f, 3)
+	stored := func(name string) bool {
+		return f.has(redisDefaultPrefix + c1.backendKey(hash(name, dns.TypeA, false, false)))
+	}
+
+	// Another replica doesn't have the names in memory, a purge of a name still deletes it from the backend.
+	c2 := NewCache("", "", WithBackend(b, time.Second))
+	c2.purge(filter{name: "a.example.org."})
+	if stored("a.example.org.") {
+		t.Error("Expected a.example.org. to be purged from the backend")
+	}
+	// A purge of a suffix only deletes what is in memory.
+	c2.purge(filter{suffix: "example.org."})
+	if !stored("b.example.org.") {
+		t.Error("Expected b.example.org. to be left in the backend")
+	}
+	c1.purge(filter{suffix: "example.org."})
+	if stored("b.example.org.") || stored("c.example.org.") {
+		t.Error("Expected the names in memory to be purged from the backend")
+	}
+}
+
+func TestCacheBackendDown(t *testing.T) {
+	f := newFakeRedis(t, "")
+	f.ln.Close()
+	b, _ := newBackend(f.url())
+	c := NewCache("", "", WithBackend(b, time.Second))
+	c.Next = BackendHandler()
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, req)
+	if rec.Msg == nil || len(rec.Msg.Answer) == 0 {
+		t.Fatal("Expected reply from the next plugin, when the backend is down")
+	}
+	if c.backendUp(c.now()) {
+		t.Error("Expected backend not to be used after an error")
+	}
+	if c.pcache.Len() != 1 {
+		t.Error("Expected reply to be cached in memory")
+	}
+	if c.backendUp(c.now().Add(backendBackoff)) != true {
+		t.Error("Expected backend to be used again after the backoff")
+	}
+}
+
+func TestBackendItem(t *testing.T) {
+	now := time.Now()
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.Answer = []dns.RR{test.A("example.org. 300 IN A 127.0.0.1")}
+	i := newItem(m, now.Add(-10*time.Second), 300*time.Second)
+
+	buf, err := packBackendItem(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i1, _, err := unpackBackendItem(buf, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i1.ttl(now) != 290 {
+		t.Errorf("Expected TTL 290, got %d", i1.ttl(now))
+	}
+	if i1.Name != i.Name || len(i1.Answer) != 1 {
+		t.Errorf("Expected item for %s with 1 answer, got %s with %d", i.Name, i1.Name, len(i1.Answer))
+	}
+	if _, _, err := unpackBackendItem(buf[:10], now); err == nil {
+		t.Error("Expected error for a short item")
+	}
+}
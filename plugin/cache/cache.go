@@ -4,6 +4,7 @@ package cache
 import (
 	"hash/fnv"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
@@ -11,6 +12,7 @@ import (
 	"github.com/coredns/coredns/plugin/pkg/dnsutil"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/plugin/pkg/response"
+	"github.com/coredns/coredns/plugin/pkg/singleflight"
 	"github.com/coredns/coredns/request"
 
 	"github.com/miekg/dns"
@@ -27,20 +29,31 @@ type Cache struct {
 
 	ncache  *cache.Cache
 	ncap    int
+	nbytes  int // maximum bytes used, 0 for no limit
 	nttl    time.Duration
 	minnttl time.Duration
 
 	pcache  *cache.Cache
 	pcap    int
+	pbytes  int // maximum bytes used, 0 for no limit
 	pttl    time.Duration
 	minpttl time.Duration
 	failttl time.Duration // TTL for caching SERVFAIL responses
 
+	// Admission of new items in a full cache
+	admission *tinyLFU
+
 	// Prefetch.
 	prefetch   int
 	duration   time.Duration
 	percentage int
 
+	// Early refresh, with the beta of XFetch
+	earlyRefresh float64
+
+	// Coalescing of identical misses
+	inflight *singleflight.Group
+
 	// Stale serve
 	staleUpTo   time.Duration
 	verifyStale bool
@@ -52,6 +65,30 @@ type Cache struct {
 	// Keep ttl option
 	keepttl bool
 
+	// ECS, cache per client subnet
+	ecs       bool
+	ecsScopes *cache.Cache
+
+	// Aggressive use of NSEC and NSEC3 records
+	nsec *nsecCache
+
+	// Shared second level cache
+	backend          Backend
+	backendTimeout   time.Duration
+	backendNamespace string        // prefix of the keys, the view and zones of the cache when empty
+	backendDown      atomic.Int64  // unix nanoseconds until which the backend is not used after an error
+	backendWrites    chan struct{} // limits the writes in flight
+
+	// Admin endpoint
+	adminAddr  string
+	adminToken string
+
+	// Persist the cache to disk
+	persistFile     string
+	persistInterval time.Duration
+	persistStop     chan struct{}
+	persistDone     chan struct{}
+
 	// Testing.
 	now func() time.Time
 }
@@ -73,10 +110,22 @@ func New() *Cache {
 		prefetch:   0,
 		duration:   1 * time.Minute,
 		percentage: 10,
+		inflight:   new(singleflight.Group),
 		now:        time.Now,
 	}
 }
 
+// newCaches creates the success and denial caches with the configured capacities, byte limits and admission
+// policy.
+func (c *Cache) newCaches() {
+	c.pcache = cache.NewBytes(c.pcap, c.pbytes)
+	c.ncache = cache.NewBytes(c.ncap, c.nbytes)
+	if c.admission != nil {
+		c.pcache.SetAdmit(c.admit)
+		c.ncache.SetAdmit(c.admit)
+	}
+}
+
 // key returns key under which we store the item, -1 will be returned if we don't store the message.
 // Currently we do not cache Truncated, errors zone transfers or dynamic update messages.
 // qname holds the already lowercased qname.
@@ -129,10 +178,13 @@ type ResponseWriter struct {
 	state  request.Request
 	server string // Server handling the request.
 
-	do         bool // When true the original request had the DO bit set.
-	cd         bool // When true the original request had the CD bit set.
-	ad         bool // When true the original request had the AD bit set.
-	prefetch   bool // When true write nothing back to the client.
+	do         bool      // When true the original request had the DO bit set.
+	cd         bool      // When true the original request had the CD bit set.
+	ad         bool      // When true the original request had the AD bit set.
+	prefetch   bool      // When true write nothing back to the client.
+	shared     bool      // When true the reply is shared with another query, that already stored it.
+	stored     bool      // Set to true when the reply is stored in the cache.
+	start      time.Time // When the query was passed to the next plugin.
 	remoteAddr net.Addr
 
 	wildcardFunc func() string // function to retrieve wildcard name that synthesized the result.
@@ -163,6 +215,7 @@ func newPrefetchResponseWriter(server string, state request.Request, c *Cache) *
 		do:             state.Do(),
 		cd:             state.Req.CheckingDisabled,
 		prefetch:       true,
+		start:          c.now(),
 		remoteAddr:     addr,
 	}
 }
@@ -182,6 +235,15 @@ func (w *ResponseWriter) WriteMsg(res *dns.Msg) error {
 
 	// key returns empty string for anything we don't want to cache.
 	hasKey, key := key(w.state.Name(), res, mt, w.do, w.cd)
+	var subnet *dns.EDNS0_SUBNET
+	if w.ecs {
+		var valid bool
+		subnet, valid = replySubnet(res)
+		hasKey = hasKey && valid
+		if hasKey && subnet != nil {
+			key = subnetKey(key, subnet)
+		}
+	}
 
 	msgTTL := dnsutil.MinimalTTL(res, mt)
 	var duration time.Duration
@@ -200,17 +262,26 @@ func (w *ResponseWriter) WriteMsg(res *dns.Msg) error {
 	res.Ns = filterRRSlice(res.Ns, ttl, false)
 	res.Extra = filterRRSlice(res.Extra, ttl, false)
 
+	if w.ecs {
+		// The OPT record is gone, echo the client subnet option of the query with the scope of the reply.
+		newClientSubnet(w.state).setScope(res, subnet)
+	}
+
 	if !w.do && !w.ad {
 		// unset AD bit if requester is not OK with DNSSEC
 		// But retain AD bit if requester set the AD bit in the request, per RFC6840 5.7-5.8
 		res.AuthenticatedData = false
 	}
 
-	if hasKey && duration > 0 {
+	if hasKey && duration > 0 && !w.shared {
 		if w.state.Match(res) {
-			w.set(res, key, mt, duration)
+			w.set(res, key, mt, duration, subnet)
 			cacheSize.WithLabelValues(w.server, Success, w.zonesMetricLabel, w.viewMetricLabel).Set(float64(w.pcache.Len()))
 			cacheSize.WithLabelValues(w.server, Denial, w.zonesMetricLabel, w.viewMetricLabel).Set(float64(w.ncache.Len()))
+			if w.pbytes > 0 || w.nbytes > 0 {
+				cacheBytes.WithLabelValues(w.server, Success, w.zonesMetricLabel, w.viewMetricLabel).Set(float64(w.pcache.Bytes()))
+				cacheBytes.WithLabelValues(w.server, Denial, w.zonesMetricLabel, w.viewMetricLabel).Set(float64(w.ncache.Bytes()))
+			}
 		} else {
 			// Don't log it, but increment counter
 			cacheDrops.WithLabelValues(w.server, w.zonesMetricLabel, w.viewMetricLabel).Inc()
@@ -224,7 +295,7 @@ func (w *ResponseWriter) WriteMsg(res *dns.Msg) error {
 	return w.ResponseWriter.WriteMsg(res)
 }
 
-func (w *ResponseWriter) set(m *dns.Msg, key uint64, mt response.Type, duration time.Duration) {
+func (w *ResponseWriter) set(m *dns.Msg, key uint64, mt response.Type, duration time.Duration, subnet *dns.EDNS0_SUBNET) {
 	// duration is expected > 0
 	// and key is valid
 	switch mt {
@@ -233,29 +304,44 @@ func (w *ResponseWriter) set(m *dns.Msg, key uint64, mt response.Type, duration
 			// zone is in exception list, do not cache
 			return
 		}
-		i := newItem(m, w.now(), duration)
-		if w.wildcardFunc != nil {
-			i.wildcard = w.wildcardFunc()
-		}
-		if w.pcache.Add(key, i) {
-			evictions.WithLabelValues(w.server, Success, w.zonesMetricLabel, w.viewMetricLabel).Inc()
+		i := w.newItem(m, duration, subnet)
+		added, evicted := w.pcache.Put(key, i)
+		if evicted > 0 {
+			evictions.WithLabelValues(w.server, Success, w.zonesMetricLabel, w.viewMetricLabel).Add(float64(evicted))
 		}
 		// when pre-fetching, remove the negative cache entry if it exists
 		if w.prefetch {
 			w.ncache.Remove(key)
 		}
+		if !added {
+			rejections.WithLabelValues(w.server, Success, w.zonesMetricLabel, w.viewMetricLabel).Inc()
+			return
+		}
+		w.stored = true
+		if w.backend != nil {
+			w.setBackend(key, i)
+		}
 
 	case response.NameError, response.NoData, response.ServerError:
 		if plugin.Zones(w.nexcept).Matches(m.Question[0].Name) != "" {
 			// zone is in exception list, do not cache
 			return
 		}
-		i := newItem(m, w.now(), duration)
-		if w.wildcardFunc != nil {
-			i.wildcard = w.wildcardFunc()
+		i := w.newItem(m, duration, subnet)
+		added, evicted := w.ncache.Put(key, i)
+		if evicted > 0 {
+			evictions.WithLabelValues(w.server, Denial, w.zonesMetricLabel, w.viewMetricLabel).Add(float64(evicted))
 		}
-		if w.ncache.Add(key, i) {
-			evictions.WithLabelValues(w.server, Denial, w.zonesMetricLabel, w.viewMetricLabel).Inc()
+		if !added {
+			rejections.WithLabelValues(w.server, Denial, w.zonesMetricLabel, w.viewMetricLabel).Inc()
+		} else {
+			w.stored = true
+			if w.backend != nil {
+				w.setBackend(key, i)
+			}
+		}
+		if w.nsec != nil && mt != response.ServerError {
+			w.nsec.add(m, w.now(), duration)
 		}
 
 	case response.OtherError:
@@ -265,6 +351,24 @@ func (w *ResponseWriter) set(m *dns.Msg, key uint64, mt response.Type, duration
 	}
 }
 
+// newItem returns a new item for m, to be stored in the cache for duration. With ECS, subnet is the subnet the
+// item is valid for, nil when it is valid for all clients.
+func (w *ResponseWriter) newItem(m *dns.Msg, duration time.Duration, subnet *dns.EDNS0_SUBNET) *item {
+	now := w.now()
+	i := newItem(m, now, duration)
+	if !w.start.IsZero() {
+		i.delta = now.Sub(w.start)
+	}
+	if w.wildcardFunc != nil {
+		i.wildcard = w.wildcardFunc()
+	}
+	if subnet != nil {
+		i.ecs = subnet
+		w.addScope(i.Name, i.QType, subnet)
+	}
+	return i
+}
+
 // Write implements the dns.ResponseWriter interface.
 func (w *ResponseWriter) Write(buf []byte) (int, error) {
 	log.Warning("Caching called with Write: not caching reply")
@@ -291,7 +291,7 @@ func TestCacheInsertion(t *testing.T) {
 
 			if valid {
 				// Insert cache entry
-				crr.set(m, k, mt, c.pttl)
+				crr.set(m, k, mt, c.pttl, nil)
 			}
 
 			// Attempt to retrieve cache entry
@@ -871,7 +871,7 @@ func TestCacheSeparation(t *testing.T) {
 
 			if valid {
 				// Insert cache entry
-				crr.set(m, k, mt, c.pttl)
+				crr.set(m, k, mt, c.pttl, nil)
 			}
 
 			// Attempt to retrieve cache entry
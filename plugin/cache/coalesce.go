@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// coalesced is the result of a refresh that is shared between identical queries that missed the cache.
+type coalesced struct {
+	reply  *dns.Msg // the reply of the next plugin, nil if it did not write one
+	stored bool     // true if the reply was stored in the cache
+	rcode  int
+}
+
+// coalesceWriter keeps a copy of the reply, so it can be shared.
+type coalesceWriter struct {
+	*ResponseWriter
+	reply *dns.Msg
+}
+
+// WriteMsg implements the dns.ResponseWriter interface.
+func (w *coalesceWriter) WriteMsg(res *dns.Msg) error {
+	w.reply = res.Copy()
+	return w.ResponseWriter.WriteMsg(res)
+}
+
+// doCoalesced refreshes the item for the query in state, while making sure only one refresh is in flight for
+// identical queries. The queries that wait for that one get its reply, if it was stored in the cache; they
+// would have gotten it from the cache, had they been a little later. Otherwise they do their own refresh.
+func (c *Cache) doCoalesced(ctx context.Context, state request.Request, crr *ResponseWriter) (int, error) {
+	leader := false
+	v, err := c.inflight.Do(c.coalesceKey(state), func() (any, error) {
+		leader = true
+		cw := &coalesceWriter{ResponseWriter: crr}
+		rcode, err := c.doRefresh(ctx, state, cw)
+		return coalesced{reply: cw.reply, stored: crr.stored, rcode: rcode}, err
+	})
+	res := v.(coalesced)
+	if leader {
+		return res.rcode, err
+	}
+	if !res.stored || res.reply == nil {
+		return c.doRefresh(ctx, state, crr)
+	}
+
+	cacheCoalesced.WithLabelValues(crr.server, c.zonesMetricLabel, c.viewMetricLabel).Inc()
+	m := res.reply.Copy()
+	m.Id = state.Req.Id
+	m.Question = state.Req.Question
+	crr.shared = true
+	crr.WriteMsg(m)
+	return res.rcode, err
+}
+
+// coalesceKey returns the key under which identical queries are coalesced. These are the queries that would
+// get the same item from the cache, with ECS this includes the client subnet.
+func (c *Cache) coalesceKey(state request.Request) uint64 {
+	k := hash(state.Name(), state.QType(), state.Do(), state.Req.CheckingDisabled)
+	if !c.ecs {
+		return k
+	}
+	cs := newClientSubnet(state)
+	return subnetKey(k, &dns.EDNS0_SUBNET{Family: cs.family, SourceScope: cs.source, Address: cs.ip})
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// slowBackend replies with rcode after release is closed, and counts the queries it gets.
+func slowBackend(calls *atomic.Int32, release chan struct{}, rcode int) plugin.Handler {
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		calls.Add(1)
+		<-release
+		m := new(dns.Msg)
+		m.SetRcode(r, rcode)
+		m.RecursionAvailable = true
+		if rcode == dns.RcodeSuccess {
+			m.Answer = []dns.RR{test.A("example.org. 300 IN A 127.0.0.53")}
+		}
+		w.WriteMsg(m)
+		return rcode, nil
+	})
+}
+
+// concurrentQueries sends n identical queries at the same time to c, while the backend is blocked until
+// release is closed. It returns the replies.
+func concurrentQueries(c *Cache, n int, release chan struct{}) []*dns.Msg {
+	replies := make([]*dns.Msg, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := new(dns.Msg)
+			req.SetQuestion("example.org.", dns.TypeA)
+			req.Id = uint16(i + 1)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			c.ServeDNS(context.TODO(), rec, req)
+			replies[i] = rec.Msg
+		}()
+	}
+	// Give the queries time to queue up behind the first one.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	return replies
+}
+
+func TestCacheCoalesce(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	c := New()
+	c.Next = slowBackend(&calls, release, dns.RcodeSuccess)
+
+	replies := concurrentQueries(c, 10, release)
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected 1 query to the backend, got %d", n)
+	}
+	for i, m := range replies {
+		if m == nil {
+			t.Fatalf("Query %d: expected a reply", i)
+		}
+		if m.Id != uint16(i+1) {
+			t.Errorf("Query %d: expected ID %d, got %d", i, i+1, m.Id)
+		}
+		if len(m.Answer) != 1 {
+			t.Errorf("Query %d: expected 1 answer, got %d", i, len(m.Answer))
+		}
+	}
+}
+
+func TestCacheCoalesceNotCached(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	c := New()
+	c.failttl = 0
+	c.Next = slowBackend(&calls, release, dns.RcodeServerFailure)
+
+	// A reply that is not cached is not shared either, every query gets its own.
+	replies := concurrentQueries(c, 5, release)
+	if n := calls.Load(); n != 5 {
+		t.Errorf("Expected 5 queries to the backend, got %d", n)
+	}
+	for i, m := range replies {
+		if m == nil || m.Rcode != dns.RcodeServerFailure {
+			t.Errorf("Query %d: expected SERVFAIL reply", i)
+		}
+	}
+}
+
+func TestCacheEarlyRefresh(t *testing.T) {
+	tests := []struct {
+		beta     float64
+		expected int32
+	}{
+		{0, 1},
+		{1e-9, 1}, // only just before expiry
+		{1e9, 2},  // right away
+	}
+	for _, tc := range tests {
+		var calls atomic.Int32
+		refreshed := make(chan struct{}, 1)
+		c := New()
+		c.earlyRefresh = tc.beta
+		c.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			if calls.Add(1) > 1 {
+				refreshed <- struct{}{}
+			}
+			return ttlBackend(300).ServeDNS(ctx, w, r)
+		})
+
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+		c.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+		c.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+
+		if tc.expected > 1 {
+			select {
+			case <-refreshed:
+			case <-time.After(time.Second):
+			}
+		}
+		if n := calls.Load(); n != tc.expected {
+			t.Errorf("Beta %v: expected %d queries to the backend, got %d", tc.beta, tc.expected, n)
+		}
+	}
+}
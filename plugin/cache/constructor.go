@@ -2,10 +2,12 @@ package cache
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/plugin/pkg/singleflight"
 )
 
 // NewCache returns a new cache with the given options.
@@ -51,9 +53,31 @@ func WithZones(zones ...string) func(*Cache) {
 func WithCacheSize(size int) func(*Cache) {
 	return func(c *Cache) {
 		c.ncap = size
-		c.ncache = cache.New(size)
 		c.pcap = size
-		c.pcache = cache.New(size)
+		c.newCaches()
+	}
+}
+
+// WithMaxBytes configures the maximum number of bytes the items in the success and denial caches may use, on
+// top of their capacity. The size of an item is the size of the packed reply. A maximum of 0 means no limit.
+func WithMaxBytes(pbytes, nbytes int) func(*Cache) {
+	if pbytes < 0 || nbytes < 0 {
+		panic("max bytes must be greater than or equal to 0")
+	}
+
+	return func(c *Cache) {
+		c.pbytes = pbytes
+		c.nbytes = nbytes
+		c.newCaches()
+	}
+}
+
+// WithAdmission configures the cache to use TinyLFU admission: when the cache is full, a reply only evicts an
+// item when its name is asked for more often.
+func WithAdmission() func(*Cache) {
+	return func(c *Cache) {
+		c.admission = newTinyLFU(c.pcap + c.ncap)
+		c.newCaches()
 	}
 }
 
@@ -127,6 +151,71 @@ func WithPrefetch(prefetchAmount int, duration time.Duration, percentage int) fu
 	}
 }
 
+// WithEarlyRefresh configures the cache to refresh items probabilistically before they expire, the closer to
+// expiring the more likely. A higher beta refreshes earlier, 1 is a good default.
+func WithEarlyRefresh(beta float64) func(*Cache) {
+	if beta <= 0 {
+		panic("beta must be greater than 0")
+	}
+
+	return func(c *Cache) {
+		c.earlyRefresh = beta
+	}
+}
+
+// WithAggressiveNSEC configures the cache to synthesize negative replies from the NSEC and NSEC3 records in
+// validated negative replies, see RFC 8198.
+func WithAggressiveNSEC() func(*Cache) {
+	return func(c *Cache) {
+		c.nsec = newNSECCache(c.ncap)
+	}
+}
+
+// WithECS configures the cache to store replies that depend on the EDNS0 client subnet per subnet, and to only
+// serve them to clients in the scope of the reply.
+func WithECS() func(*Cache) {
+	return func(c *Cache) {
+		c.ecs = true
+		c.ecsScopes = cache.New(defaultCap)
+	}
+}
+
+// WithPersist configures the cache to be written to file every interval, and when the server stops. On
+// startup the cache is loaded from file.
+func WithPersist(file string, interval time.Duration) func(*Cache) {
+	if interval <= 0 {
+		panic("persist interval must be greater than 0")
+	}
+
+	return func(c *Cache) {
+		c.persistFile = file
+		c.persistInterval = interval
+	}
+}
+
+// WithBackend configures a shared second level cache. Misses are looked up in b before going to the next plugin,
+// and replies that are cached are written to b. An operation on b that takes longer than timeout fails, after
+// a failure b is not used for a few seconds.
+func WithBackend(b Backend, timeout time.Duration) func(*Cache) {
+	if timeout <= 0 {
+		panic("backend timeout must be greater than 0")
+	}
+
+	return func(c *Cache) {
+		c.backend = b
+		c.backendTimeout = timeout
+		c.backendWrites = make(chan struct{}, backendMaxWrites)
+	}
+}
+
+// WithBackendNamespace sets the prefix of the keys in the shared backend to ns. By default the keys are prefixed
+// with the view and zones of the cache.
+func WithBackendNamespace(ns string) func(*Cache) {
+	return func(c *Cache) {
+		c.backendNamespace = ns
+	}
+}
+
 // Opt is a functional option for configuring the cache.
 type Opt func(*Cache)
 
@@ -140,20 +229,31 @@ var _ = (*struct {
 
 	ncache  *cache.Cache
 	ncap    int
+	nbytes  int // maximum bytes used, 0 for no limit
 	nttl    time.Duration
 	minnttl time.Duration
 
 	pcache  *cache.Cache
 	pcap    int
+	pbytes  int // maximum bytes used, 0 for no limit
 	pttl    time.Duration
 	minpttl time.Duration
 	failttl time.Duration // TTL for caching SERVFAIL responses
 
+	// Admission of new items in a full cache
+	admission *tinyLFU
+
 	// Prefetch.
 	prefetch   int
 	duration   time.Duration
 	percentage int
 
+	// Early refresh, with the beta of XFetch
+	earlyRefresh float64
+
+	// Coalescing of identical misses
+	inflight *singleflight.Group
+
 	// Stale serve
 	staleUpTo   time.Duration
 	verifyStale bool
@@ -165,6 +265,30 @@ var _ = (*struct {
 	// Keep ttl option
 	keepttl bool
 
+	// ECS, cache per client subnet
+	ecs       bool
+	ecsScopes *cache.Cache
+
+	// Aggressive use of NSEC and NSEC3 records
+	nsec *nsecCache
+
+	// Shared second level cache
+	backend          Backend
+	backendTimeout   time.Duration
+	backendNamespace string        // prefix of the keys, the view and zones of the cache when empty
+	backendDown      atomic.Int64  // unix nanoseconds until which the backend is not used after an error
+	backendWrites    chan struct{} // limits the writes in flight
+
+	// Admin endpoint
+	adminAddr  string
+	adminToken string
+
+	// Persist the cache to disk
+	persistFile     string
+	persistInterval time.Duration
+	persistStop     chan struct{}
+	persistDone     chan struct{}
+
 	// Testing.
 	now func() time.Time
 })((*Cache)(nil))
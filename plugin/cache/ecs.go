@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// With ECS (RFC 7871) enabled, a reply with a non-zero SCOPE PREFIX-LENGTH is stored under a key that also
+// holds the subnet it is valid for: the address of the reply's client subnet option, masked to the scope. Replies
+// without the option, or with a scope of zero, apply to all clients and are stored under the normal key.
+//
+// As the scope is only known from the reply, the cache keeps, per name and type, the scope prefix lengths
+// that replies were stored with. A lookup tries the client's subnet masked to each of these, most specific
+// first, and then the normal key.
+
+// Address families in the EDNS0 client subnet option.
+const (
+	familyIPv4 = 1
+	familyIPv6 = 2
+)
+
+// scopeSet is the set of scope prefix lengths that replies for a name and type were stored with.
+type scopeSet struct {
+	v4 atomic.Uint64    // IPv4 scopes are 1-32
+	v6 [3]atomic.Uint64 // IPv6 scopes are 1-128
+}
+
+// add adds the scope for family to s.
+func (s *scopeSet) add(family uint16, scope uint8) {
+	if family == familyIPv4 {
+		s.v4.Or(1 << scope)
+		return
+	}
+	s.v6[scope/64].Or(1 << (scope % 64))
+}
+
+// has returns true if s has the scope for family.
+func (s *scopeSet) has(family uint16, scope uint8) bool {
+	if family == familyIPv4 {
+		return s.v4.Load()&(1<<scope) != 0
+	}
+	return s.v6[scope/64].Load()&(1<<(scope%64)) != 0
+}
+
+// maxScope returns the longest scope prefix length for family.
+func maxScope(family uint16) uint8 {
+	if family == familyIPv4 {
+		return net.IPv4len * 8
+	}
+	return net.IPv6len * 8
+}
+
+// clientSubnet is the subnet a query is for.
+type clientSubnet struct {
+	family uint16
+	ip     net.IP
+	source uint8             // the source prefix length
+	opt    *dns.EDNS0_SUBNET // the option in the query, nil if it had none
+}
+
+// newClientSubnet returns the subnet of the query in state: the one in its client subnet option, or else the
+// address of the client itself.
+func newClientSubnet(state request.Request) clientSubnet {
+	if o := state.Req.IsEdns0(); o != nil {
+		for _, e := range o.Option {
+			if s, ok := e.(*dns.EDNS0_SUBNET); ok {
+				return clientSubnet{family: s.Family, ip: s.Address, source: s.SourceNetmask, opt: s}
+			}
+		}
+	}
+	ip := net.ParseIP(state.IP())
+	if ip4 := ip.To4(); ip4 != nil {
+		return clientSubnet{family: familyIPv4, ip: ip4, source: maxScope(familyIPv4)}
+	}
+	return clientSubnet{family: familyIPv6, ip: ip, source: maxScope(familyIPv6)}
+}
+
+// contains returns true if the address of cs is in the subnet.
+func (cs clientSubnet) contains(subnet *dns.EDNS0_SUBNET) bool {
+	if cs.family != subnet.Family || cs.source < subnet.SourceScope || cs.ip == nil {
+		return false
+	}
+	bits := int(maxScope(subnet.Family))
+	mask := net.CIDRMask(int(subnet.SourceScope), bits)
+	return cs.ip.Mask(mask).Equal(subnet.Address.Mask(mask))
+}
+
+// replySubnet returns the subnet the reply m is valid for, this is nil if it is valid for all clients. The
+// returned option has the address masked to the scope, and the source prefix length set to the scope. It
+// returns false if the option in the reply is invalid.
+func replySubnet(m *dns.Msg) (*dns.EDNS0_SUBNET, bool) {
+	o := m.IsEdns0()
+	if o == nil {
+		return nil, true
+	}
+	for _, e := range o.Option {
+		s, ok := e.(*dns.EDNS0_SUBNET)
+		if !ok {
+			continue
+		}
+		if s.SourceScope == 0 {
+			return nil, true
+		}
+		if (s.Family != familyIPv4 && s.Family != familyIPv6) || s.SourceScope > maxScope(s.Family) || s.Address == nil {
+			return nil, false
+		}
+		ip := s.Address.Mask(net.CIDRMask(int(s.SourceScope), int(maxScope(s.Family))))
+		if ip == nil {
+			return nil, false
+		}
+		return &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        s.Family,
+			SourceNetmask: s.SourceScope,
+			SourceScope:   s.SourceScope,
+			Address:       ip,
+		}, true
+	}
+	return nil, true
+}
+
+// subnetKey returns the key for the item under key k that is valid for subnet.
+func subnetKey(k uint64, subnet *dns.EDNS0_SUBNET) uint64 {
+	h := fnv.New64()
+	var buf [11]byte
+	binary.BigEndian.PutUint64(buf[:], k)
+	binary.BigEndian.PutUint16(buf[8:], subnet.Family)
+	buf[10] = subnet.SourceScope
+	h.Write(buf[:])
+	h.Write(subnet.Address)
+	return h.Sum64()
+}
+
+// scopeKey returns the key of the scope set for name and type. This leaves out the DO and CD bits of the
+// query, so the set can be found from an item.
+func scopeKey(name string, qtype uint16) uint64 {
+	return hash(strings.ToLower(name), qtype, false, false)
+}
+
+// addScope records that an item for name and type is stored for subnet.
+func (c *Cache) addScope(name string, qtype uint16, subnet *dns.EDNS0_SUBNET) {
+	k := scopeKey(name, qtype)
+	if s, ok := c.ecsScopes.Get(k); ok {
+		s.(*scopeSet).add(subnet.Family, subnet.SourceScope)
+		return
+	}
+	s := new(scopeSet)
+	s.add(subnet.Family, subnet.SourceScope)
+	c.ecsScopes.Add(k, s)
+}
+
+// keys returns the keys the item for the query in state might be stored under, in the order they should be
+// tried, along with the subnet of the query. Without ECS this is only the normal key.
+func (c *Cache) keys(state request.Request) ([]uint64, clientSubnet) {
+	k := hash(state.Name(), state.QType(), state.Do(), state.Req.CheckingDisabled)
+	if !c.ecs {
+		return []uint64{k}, clientSubnet{}
+	}
+
+	cs := newClientSubnet(state)
+	s, ok := c.ecsScopes.Get(scopeKey(state.Name(), state.QType()))
+	if !ok || cs.ip == nil || (cs.family != familyIPv4 && cs.family != familyIPv6) {
+		return []uint64{k}, cs
+	}
+	scopes := s.(*scopeSet)
+	keys := make([]uint64, 0, 2)
+	for scope := min(cs.source, maxScope(cs.family)); scope > 0; scope-- {
+		if !scopes.has(cs.family, scope) {
+			continue
+		}
+		ip := cs.ip.Mask(net.CIDRMask(int(scope), int(maxScope(cs.family))))
+		if ip == nil {
+			continue
+		}
+		keys = append(keys, subnetKey(k, &dns.EDNS0_SUBNET{Family: cs.family, SourceScope: scope, Address: ip}))
+	}
+	return append(keys, k), cs
+}
+
+// setScope adds the client subnet option of the query to the reply m, with the scope of subnet, the subnet the
+// reply is valid for. Nothing is added when the query had no client subnet option.
+func (cs clientSubnet) setScope(m *dns.Msg, subnet *dns.EDNS0_SUBNET) {
+	if cs.opt == nil {
+		return
+	}
+	e := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        cs.opt.Family,
+		SourceNetmask: cs.opt.SourceNetmask,
+		Address:       cs.opt.Address,
+	}
+	if subnet != nil {
+		e.SourceScope = subnet.SourceScope
+	}
+	o := m.IsEdns0()
+	if o == nil {
+		m.SetEdns0(dns.MinMsgSize, false)
+		o = m.IsEdns0()
+	}
+	o.Option = append(o.Option, e)
+}
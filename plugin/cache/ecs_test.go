@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// ecsBackend replies with the client subnet option of the query echoed with a scope of scope, and the
+// address of the subnet as the answer. Queries without the option get 127.0.0.1.
+func ecsBackend(scope uint8) plugin.Handler {
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Response, m.RecursionAvailable = true, true
+
+		answer := net.ParseIP("127.0.0.1")
+		if o := r.IsEdns0(); o != nil {
+			m.SetEdns0(o.UDPSize(), false)
+			for _, e := range o.Option {
+				if s, ok := e.(*dns.EDNS0_SUBNET); ok {
+					answer = s.Address.Mask(net.CIDRMask(int(scope), 32))
+					m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_SUBNET{
+						Code: dns.EDNS0SUBNET, Family: s.Family, SourceNetmask: s.SourceNetmask, SourceScope: scope, Address: s.Address,
+					})
+				}
+			}
+		}
+		m.Answer = []dns.RR{test.A("example.org. 300 IN A " + answer.String())}
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+}
+
+func newECSCache(next plugin.Handler) *Cache {
+	c := New()
+	c.ecs = true
+	c.ecsScopes = cache.New(defaultCap)
+	c.Next = next
+	return c
+}
+
+// ecsQuery returns a query for example.org. with a client subnet option for addr/source, or without the option
+// if addr is empty.
+func ecsQuery(addr string, source uint8) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	if addr == "" {
+		return m
+	}
+	m.SetEdns0(4096, false)
+	m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_SUBNET{
+		Code: dns.EDNS0SUBNET, Family: familyIPv4, SourceNetmask: source, Address: net.ParseIP(addr).To4(),
+	})
+	return m
+}
+
+func TestCacheECS(t *testing.T) {
+	c := newECSCache(ecsBackend(24))
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{}, ecsQuery("10.0.0.1", 32))
+	if c.pcache.Len() != 1 {
+		t.Fatalf("Expected reply to be cached, got %d items", c.pcache.Len())
+	}
+	c.Next = upstreamCalled
+
+	tests := []struct {
+		addr     string
+		source   uint8
+		remoteIP string
+		hit      bool
+	}{
+		{"10.0.0.1", 32, "", true},
+		{"10.0.0.200", 32, "", true},
+		{"10.0.0.0", 24, "", true},
+		{"10.0.1.1", 32, "", false},
+		{"10.0.0.1", 16, "", false}, // source prefix shorter than the scope
+		{"", 0, "10.0.0.7", true},   // no option, the address of the client is used
+		{"", 0, "10.0.1.7", false},
+	}
+	for i, tc := range tests {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: tc.remoteIP})
+		ret, _ := c.ServeDNS(context.TODO(), rec, ecsQuery(tc.addr, tc.source))
+		if hit := ret != 255; hit != tc.hit {
+			t.Errorf("Test %d: expected hit %t, got %t", i, tc.hit, hit)
+			continue
+		}
+		if !tc.hit {
+			continue
+		}
+		if a := rec.Msg.Answer[0].(*dns.A).A.String(); a != "10.0.0.0" {
+			t.Errorf("Test %d: expected answer 10.0.0.0, got %s", i, a)
+		}
+		if tc.addr == "" {
+			continue
+		}
+		s, ok := rec.Msg.IsEdns0().Option[0].(*dns.EDNS0_SUBNET)
+		if !ok {
+			t.Fatalf("Test %d: expected client subnet option in reply", i)
+		}
+		if s.SourceScope != 24 || s.SourceNetmask != tc.source {
+			t.Errorf("Test %d: expected source %d and scope 24, got %d and %d", i, tc.source, s.SourceNetmask, s.SourceScope)
+		}
+	}
+}
+
+func TestCacheECSPerSubnet(t *testing.T) {
+	c := newECSCache(ecsBackend(24))
+	for _, addr := range []string{"10.0.0.1", "10.0.1.1"} {
+		c.ServeDNS(context.TODO(), &test.ResponseWriter{}, ecsQuery(addr, 32))
+	}
+	if c.pcache.Len() != 2 {
+		t.Fatalf("Expected one item per subnet, got %d items", c.pcache.Len())
+	}
+	c.Next = upstreamCalled
+
+	for _, tc := range []struct{ addr, answer string }{
+		{"10.0.0.9", "10.0.0.0"},
+		{"10.0.1.9", "10.0.1.0"},
+	} {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if ret, _ := c.ServeDNS(context.TODO(), rec, ecsQuery(tc.addr, 32)); ret == 255 {
+			t.Errorf("Expected %s to be served from cache", tc.addr)
+			continue
+		}
+		if a := rec.Msg.Answer[0].(*dns.A).A.String(); a != tc.answer {
+			t.Errorf("Expected answer %s for %s, got %s", tc.answer, tc.addr, a)
+		}
+	}
+}
+
+func TestCacheECSGlobalScope(t *testing.T) {
+	c := newECSCache(ecsBackend(0))
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{}, ecsQuery("10.0.0.1", 32))
+	c.Next = upstreamCalled
+
+	for _, addr := range []string{"10.0.0.1", "192.168.0.1", ""} {
+		if ret, _ := c.ServeDNS(context.TODO(), &test.ResponseWriter{}, ecsQuery(addr, 32)); ret == 255 {
+			t.Errorf("Expected reply with scope 0 to be served to %q", addr)
+		}
+	}
+}
+
+func TestSnapshotECS(t *testing.T) {
+	c := newECSCache(ecsBackend(24))
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{}, ecsQuery("10.0.0.1", 32))
+
+	buf := new(bytes.Buffer)
+	if _, err := c.writeSnapshot(buf); err != nil {
+		t.Fatalf("Failed to write snapshot: %s", err)
+	}
+	snapshot := buf.Bytes()
+
+	// Without ECS, an item that is only valid for a subnet can't be used.
+	if n, err := New().readSnapshot(bytes.NewReader(snapshot)); err != nil || n != 0 {
+		t.Errorf("Expected no items read without ECS, got %d: %v", n, err)
+	}
+
+	c2 := newECSCache(upstreamCalled)
+	if n, err := c2.readSnapshot(bytes.NewReader(snapshot)); err != nil || n != 1 {
+		t.Fatalf("Expected 1 item read, got %d: %v", n, err)
+	}
+	for _, tc := range []struct {
+		addr string
+		hit  bool
+	}{
+		{"10.0.0.9", true},
+		{"10.0.1.9", false},
+	} {
+		ret, _ := c2.ServeDNS(context.TODO(), &test.ResponseWriter{}, ecsQuery(tc.addr, 32))
+		if hit := ret != 255; hit != tc.hit {
+			t.Errorf("Expected hit %t for %s, got %t", tc.hit, tc.addr, hit)
+		}
+	}
+}
@@ -46,6 +46,17 @@ func (f *Freq) Hits() int {
 	return f.hits
 }
 
+// Recent returns the number of hits, or 0 if the last hit was longer than d ago, as the next Update
+// would then start counting over.
+func (f *Freq) Recent(d time.Duration, now time.Time) int {
+	f.RLock()
+	defer f.RUnlock()
+	if f.last.Before(now.Add(-1 * d)) {
+		return 0
+	}
+	return f.hits
+}
+
 // Reset resets f to time t and hits to hits.
 func (f *Freq) Reset(t time.Time, hits int) {
 	f.Lock()
@@ -29,6 +29,21 @@ func TestReset(t *testing.T) {
 	hitsCheck(t, f, 0)
 }
 
+func TestRecent(t *testing.T) {
+	now := time.Now().UTC()
+	f := New(now)
+	window := 1 * time.Minute
+
+	f.Update(window, now)
+	f.Update(window, now)
+	if x := f.Recent(window, now.Add(30*time.Second)); x != 2 {
+		t.Fatalf("Expected recent hits to be 2, got %d", x)
+	}
+	if x := f.Recent(window, now.Add(2*time.Minute)); x != 0 {
+		t.Fatalf("Expected recent hits to be 0, got %d", x)
+	}
+}
+
 func hitsCheck(t *testing.T, f *Freq, expected int) {
 	t.Helper()
 	if x := f.Hits(); x != expected {
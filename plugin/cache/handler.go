@@ -3,6 +3,7 @@ package cache
 import (
 	"context"
 	"math"
+	"math/rand/v2"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
@@ -34,10 +35,26 @@ func (c *Cache) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 	// DNSSEC RRs in the response are written to cache with the response.
 
 	i := c.getIgnoreTTL(now, state, server)
+	if i == nil && c.nsec != nil && !cd {
+		if m := c.nsec.synthesize(state, now, do, ad); m != nil {
+			nsecSynthesized.WithLabelValues(server, c.zonesMetricLabel, c.viewMetricLabel).Inc()
+			w.WriteMsg(m)
+			return dns.RcodeSuccess, nil
+		}
+	}
+	if i == nil && c.backend != nil {
+		i = c.getBackend(ctx, state, now, server)
+	}
 	if i == nil {
+		if c.admission != nil {
+			c.admission.increment(sketchKey(state.Name(), state.QType()))
+		}
 		crr := &ResponseWriter{ResponseWriter: w, Cache: c, state: state, server: server, do: do, ad: ad, cd: cd,
-			nexcept: c.nexcept, pexcept: c.pexcept, wildcardFunc: wildcardFunc(ctx)}
-		return c.doRefresh(ctx, state, crr)
+			nexcept: c.nexcept, pexcept: c.pexcept, wildcardFunc: wildcardFunc(ctx), start: now}
+		return c.doCoalesced(ctx, state, crr)
+	}
+	if c.prefetch > 0 || c.admission != nil {
+		i.Update(c.duration, now)
 	}
 	ttl := i.ttl(now)
 	if ttl < 0 {
@@ -61,6 +78,12 @@ func (c *Cache) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 	} else if c.shouldPrefetch(i, now) {
 		cw := newPrefetchResponseWriter(server, state, c)
 		go c.doPrefetch(ctx, state, cw, i, now)
+	} else if c.shouldRefreshEarly(i, now) {
+		cw := newPrefetchResponseWriter(server, state, c)
+		go func() {
+			c.doPrefetch(ctx, state, cw, i, now)
+			i.refreshing.Store(false)
+		}()
 	}
 
 	if i.wildcard != "" {
@@ -76,6 +99,9 @@ func (c *Cache) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 		now = i.stored
 	}
 	resp := i.toMsg(r, now, do, ad)
+	if c.ecs {
+		newClientSubnet(state).setScope(resp, i.ecs)
+	}
 	w.WriteMsg(resp)
 	return dns.RcodeSuccess, nil
 }
@@ -112,33 +138,52 @@ func (c *Cache) shouldPrefetch(i *item, now time.Time) bool {
 	if c.prefetch <= 0 {
 		return false
 	}
-	i.Update(c.duration, now)
 	threshold := int(math.Ceil(float64(c.percentage) / 100 * float64(i.origTTL)))
 	return i.Hits() >= c.prefetch && i.ttl(now) <= threshold
 }
 
+// shouldRefreshEarly returns true if i should be refreshed before it expires. This uses XFetch from "Optimal
+// Probabilistic Cache Stampede Prevention": the closer i is to expiring, the more likely it is to be refreshed,
+// so a popular item is refreshed by one of the queries for it, just before it expires. Only one early refresh
+// of an item is done at a time.
+func (c *Cache) shouldRefreshEarly(i *item, now time.Time) bool {
+	if c.earlyRefresh <= 0 {
+		return false
+	}
+	// The time it took to fetch the item is usually far below the granularity of TTLs.
+	delta := max(i.delta, time.Second)
+	early := time.Duration(float64(delta) * c.earlyRefresh * -math.Log(1-rand.Float64()))
+	expires := i.stored.Add(time.Duration(i.origTTL) * time.Second)
+	if now.Add(early).Before(expires) {
+		return false
+	}
+	return i.refreshing.CompareAndSwap(false, true)
+}
+
 // Name implements the Handler interface.
 func (c *Cache) Name() string { return "cache" }
 
 // getIgnoreTTL unconditionally returns an item if it exists in the cache.
 func (c *Cache) getIgnoreTTL(now time.Time, state request.Request, server string) *item {
-	k := hash(state.Name(), state.QType(), state.Do(), state.Req.CheckingDisabled)
+	keys, cs := c.keys(state)
 	cacheRequests.WithLabelValues(server, c.zonesMetricLabel, c.viewMetricLabel).Inc()
 
-	if i, ok := c.ncache.Get(k); ok {
-		itm := i.(*item)
-		ttl := itm.ttl(now)
-		if itm.matches(state) && (ttl > 0 || (c.staleUpTo > 0 && -ttl < int(c.staleUpTo.Seconds()))) {
-			cacheHits.WithLabelValues(server, Denial, c.zonesMetricLabel, c.viewMetricLabel).Inc()
-			return i.(*item)
+	for _, k := range keys {
+		if i, ok := c.ncache.Get(k); ok {
+			itm := i.(*item)
+			ttl := itm.ttl(now)
+			if itm.matches(state, cs) && (ttl > 0 || (c.staleUpTo > 0 && -ttl < int(c.staleUpTo.Seconds()))) {
+				cacheHits.WithLabelValues(server, Denial, c.zonesMetricLabel, c.viewMetricLabel).Inc()
+				return i.(*item)
+			}
 		}
-	}
-	if i, ok := c.pcache.Get(k); ok {
-		itm := i.(*item)
-		ttl := itm.ttl(now)
-		if itm.matches(state) && (ttl > 0 || (c.staleUpTo > 0 && -ttl < int(c.staleUpTo.Seconds()))) {
-			cacheHits.WithLabelValues(server, Success, c.zonesMetricLabel, c.viewMetricLabel).Inc()
-			return i.(*item)
+		if i, ok := c.pcache.Get(k); ok {
+			itm := i.(*item)
+			ttl := itm.ttl(now)
+			if itm.matches(state, cs) && (ttl > 0 || (c.staleUpTo > 0 && -ttl < int(c.staleUpTo.Seconds()))) {
+				cacheHits.WithLabelValues(server, Success, c.zonesMetricLabel, c.viewMetricLabel).Inc()
+				return i.(*item)
+			}
 		}
 	}
 	cacheMisses.WithLabelValues(server, c.zonesMetricLabel, c.viewMetricLabel).Inc()
@@ -146,12 +191,14 @@ func (c *Cache) getIgnoreTTL(now time.Time, state request.Request, server string
 }
 
 func (c *Cache) exists(state request.Request) *item {
-	k := hash(state.Name(), state.QType(), state.Do(), state.Req.CheckingDisabled)
-	if i, ok := c.ncache.Get(k); ok {
-		return i.(*item)
-	}
-	if i, ok := c.pcache.Get(k); ok {
-		return i.(*item)
+	keys, cs := c.keys(state)
+	for _, k := range keys {
+		if i, ok := c.ncache.Get(k); ok && i.(*item).matches(state, cs) {
+			return i.(*item)
+		}
+		if i, ok := c.pcache.Get(k); ok && i.(*item).matches(state, cs) {
+			return i.(*item)
+		}
 	}
 	return nil
 }
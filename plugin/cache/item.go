@@ -2,6 +2,7 @@ package cache
 
 import (
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/coredns/coredns/plugin/cache/freq"
@@ -20,9 +21,14 @@ type item struct {
 	Ns                 []dns.RR
 	Extra              []dns.RR
 	wildcard           string
+	ecs                *dns.EDNS0_SUBNET // the subnet the item is valid for, nil when valid for all clients
 
 	origTTL uint32
 	stored  time.Time
+	size    int // size of the packed reply
+
+	delta      time.Duration // how long it took to get the item from the next plugin
+	refreshing atomic.Bool   // set when the item is being refreshed early
 
 	*freq.Freq
 }
@@ -52,6 +58,7 @@ func newItem(m *dns.Msg, now time.Time, d time.Duration) *item {
 
 	i.origTTL = uint32(d.Seconds())
 	i.stored = now.UTC()
+	i.size = m.Len()
 
 	i.Freq = new(freq.Freq)
 
@@ -90,14 +97,18 @@ func (i *item) toMsg(m *dns.Msg, now time.Time, do bool, ad bool) *dns.Msg {
 	return m1
 }
 
+// Size implements the cache.Sizer interface.
+func (i *item) Size() int { return i.size }
+
 func (i *item) ttl(now time.Time) int {
 	ttl := int(i.origTTL) - int(now.UTC().Sub(i.stored).Seconds())
 	return ttl
 }
 
-func (i *item) matches(state request.Request) bool {
-	if state.QType() == i.QType && strings.EqualFold(state.QName(), i.Name) {
-		return true
+// matches returns true if i is the item for the query in state, from a client in subnet cs.
+func (i *item) matches(state request.Request, cs clientSubnet) bool {
+	if state.QType() != i.QType || !strings.EqualFold(state.QName(), i.Name) {
+		return false
 	}
-	return false
+	return i.ecs == nil || cs.contains(i.ecs)
 }
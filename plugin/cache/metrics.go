@@ -15,6 +15,13 @@ var (
 		Name:      "entries",
 		Help:      "The number of elements in the cache.",
 	}, []string{"server", "type", "zones", "view"})
+	// cacheBytes is the number of bytes used by the elements in the cache by cache type, with max_bytes.
+	cacheBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "bytes",
+		Help:      "The number of bytes used by the elements in the cache.",
+	}, []string{"server", "type", "zones", "view"})
 	// cacheRequests is a counter of all requests through the cache.
 	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: plugin.Namespace,
@@ -57,6 +64,21 @@ var (
 		Name:      "served_stale_total",
 		Help:      "The number of requests served from stale cache entries.",
 	}, []string{"server", "zones", "view"})
+	// cacheCoalesced is the number of requests that missed the cache, and got the reply of an identical
+	// request that was in flight.
+	cacheCoalesced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "coalesced_total",
+		Help:      "The number of cache misses that were coalesced with an identical request in flight.",
+	}, []string{"server", "zones", "view"})
+	// nsecSynthesized is the number of negative replies synthesized from cached NSEC and NSEC3 records.
+	nsecSynthesized = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "nsec_synthesized_total",
+		Help:      "The number of negative replies synthesized from cached NSEC and NSEC3 records.",
+	}, []string{"server", "zones", "view"})
 	// evictions is the counter of cache evictions.
 	evictions = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: plugin.Namespace,
@@ -64,4 +86,25 @@ var (
 		Name:      "evictions_total",
 		Help:      "The count of cache evictions.",
 	}, []string{"server", "type", "zones", "view"})
+	// backendHits is the counter of misses that were found in the shared backend.
+	backendHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "backend_hits_total",
+		Help:      "The count of cache misses that were found in the shared backend.",
+	}, []string{"server", "zones", "view"})
+	// backendErrors is the counter of failed operations on the shared backend.
+	backendErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "backend_errors_total",
+		Help:      "The count of failed operations on the shared backend.",
+	}, []string{"server", "zones", "view"})
+	// rejections is the counter of replies that were not stored, because they were not admitted or too big.
+	rejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "cache",
+		Name:      "rejections_total",
+		Help:      "The count of replies that were not admitted to the cache.",
+	}, []string{"server", "type", "zones", "view"})
 )
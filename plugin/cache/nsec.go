@@ -0,0 +1,465 @@
+package cache
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// Aggressive use of DNSSEC-validated cache (RFC 8198). The NSEC and NSEC3 records of negative replies that
+// were validated by the upstream (AD bit set) are kept per zone, and are used to synthesize NXDOMAIN and NODATA
+// replies for names that were never asked for. Wildcard expansion is not synthesized, nor is anything proven by
+// an NSEC3 record with the opt-out flag.
+
+// nsec3MaxIterations is the maximum number of NSEC3 hash iterations we use a zone for, see RFC 9276.
+const nsec3MaxIterations = 100
+
+// nsecCache holds the NSEC and NSEC3 records per zone.
+type nsecCache struct {
+	mu    sync.RWMutex
+	zones map[string]*nsecZone
+	size  int // maximum number of zones, and of records per zone
+}
+
+func newNSECCache(size int) *nsecCache {
+	return &nsecCache{zones: make(map[string]*nsecZone), size: size}
+}
+
+// nsecZone holds the records of a single zone.
+type nsecZone struct {
+	name string
+
+	mu         sync.RWMutex
+	soa        []dns.RR // SOA with its signatures
+	soaExpires time.Time
+	ra         bool
+	nsec       []*nsecRecord // sorted on owner name in canonical order
+	nsec3      []*nsecRecord // sorted on hashed owner name
+}
+
+// nsecRecord is an NSEC or NSEC3 record with its signatures.
+type nsecRecord struct {
+	key     string // the owner name for NSEC, the hash label for NSEC3, lowercased
+	next    string // the next name for NSEC, the next hash for NSEC3, lowercased
+	rr      dns.RR
+	sigs    []dns.RR
+	expires time.Time
+}
+
+// add stores the NSEC and NSEC3 records in the negative reply m, if m is validated and signed.
+func (nc *nsecCache) add(m *dns.Msg, now time.Time, duration time.Duration) {
+	if !m.AuthenticatedData {
+		return
+	}
+	var soa *dns.SOA
+	for _, rr := range m.Ns {
+		if s, ok := rr.(*dns.SOA); ok {
+			soa = s
+		}
+	}
+	if soa == nil {
+		return
+	}
+	zone := strings.ToLower(soa.Hdr.Name)
+
+	sigs := map[uint16][]dns.RR{}
+	for _, rr := range m.Ns {
+		if sig, ok := rr.(*dns.RRSIG); ok && sig.ValidityPeriod(now) && strings.EqualFold(sig.SignerName, zone) {
+			sigs[sig.TypeCovered] = append(sigs[sig.TypeCovered], sig)
+		}
+	}
+	if len(sigs[dns.TypeSOA]) == 0 {
+		return
+	}
+	// signed returns the signatures of rr, these have the same owner name.
+	signed := func(rr dns.RR) []dns.RR {
+		var rs []dns.RR
+		for _, sig := range sigs[rr.Header().Rrtype] {
+			if strings.EqualFold(sig.Header().Name, rr.Header().Name) {
+				rs = append(rs, sig)
+			}
+		}
+		return rs
+	}
+
+	var records []*nsecRecord
+	for _, rr := range m.Ns {
+		r := &nsecRecord{rr: rr, sigs: signed(rr), expires: now.Add(duration)}
+		if len(r.sigs) == 0 {
+			continue
+		}
+		owner := strings.ToLower(rr.Header().Name)
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			if !dns.IsSubDomain(zone, owner) {
+				continue
+			}
+			r.key, r.next = owner, strings.ToLower(rr.NextDomain)
+		case *dns.NSEC3:
+			i := strings.IndexByte(owner, '.')
+			if i < 0 || owner[i+1:] != zone || rr.Hash != dns.SHA1 || rr.Iterations > nsec3MaxIterations {
+				continue
+			}
+			r.key, r.next = owner[:i], strings.ToLower(rr.NextDomain)
+		default:
+			continue
+		}
+		records = append(records, r)
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	z := nc.zone(zone, true)
+	if z == nil {
+		return
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.soa = append([]dns.RR{soa}, signed(soa)...)
+	z.soaExpires = now.Add(duration)
+	z.ra = m.RecursionAvailable
+	for _, r := range records {
+		if _, ok := r.rr.(*dns.NSEC); ok {
+			z.nsec = insert(z.nsec, r, canonicalCompare, now, nc.size)
+		} else {
+			z.nsec3 = insert(z.nsec3, r, strings.Compare, now, nc.size)
+		}
+	}
+}
+
+// zone returns the zone with name, creating it if create is true. It returns nil if the zone does not exist,
+// or can't be created because there are too many zones.
+func (nc *nsecCache) zone(name string, create bool) *nsecZone {
+	nc.mu.RLock()
+	z := nc.zones[name]
+	nc.mu.RUnlock()
+	if z != nil || !create {
+		return z
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if z := nc.zones[name]; z != nil {
+		return z
+	}
+	if len(nc.zones) >= nc.size {
+		return nil
+	}
+	z = &nsecZone{name: name}
+	nc.zones[name] = z
+	return z
+}
+
+// purge removes the zones that name is in, or that are below name.
+func (nc *nsecCache) purge(name string) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	for zone := range nc.zones {
+		if dns.IsSubDomain(zone, name) || dns.IsSubDomain(name, zone) {
+			delete(nc.zones, zone)
+		}
+	}
+}
+
+// insert adds r to the sorted records, replacing the record with the same key. When there are size records,
+// the expired ones are removed first; if there is still no room r is not added.
+func insert(records []*nsecRecord, r *nsecRecord, cmp func(a, b string) int, now time.Time, size int) []*nsecRecord {
+	i, found := slices.BinarySearchFunc(records, r.key, func(e *nsecRecord, key string) int { return cmp(e.key, key) })
+	if found {
+		records[i] = r
+		return records
+	}
+	if len(records) >= size {
+		records = slices.DeleteFunc(records, func(e *nsecRecord) bool { return !now.Before(e.expires) })
+		if len(records) >= size {
+			return records
+		}
+		i, _ = slices.BinarySearchFunc(records, r.key, func(e *nsecRecord, key string) int { return cmp(e.key, key) })
+	}
+	return slices.Insert(records, i, r)
+}
+
+// find returns the record with key, or else the record before key (wrapping around), which might cover it.
+// The bool is true if the record matches key. It returns nil if there are no records that have not expired.
+func find(records []*nsecRecord, key string, cmp func(a, b string) int, now time.Time) (*nsecRecord, bool) {
+	if len(records) == 0 {
+		return nil, false
+	}
+	i, found := slices.BinarySearchFunc(records, key, func(e *nsecRecord, key string) int { return cmp(e.key, key) })
+	if !found {
+		i--
+		if i < 0 {
+			i = len(records) - 1
+		}
+	}
+	r := records[i]
+	if !now.Before(r.expires) {
+		return nil, false
+	}
+	return r, found
+}
+
+// covers returns true if key is between the key and the next key of r, taking into account that the last
+// record in a zone wraps around.
+func (r *nsecRecord) covers(key string, cmp func(a, b string) int) bool {
+	if cmp(r.next, r.key) <= 0 {
+		return cmp(key, r.key) > 0 || cmp(key, r.next) < 0
+	}
+	return cmp(key, r.key) > 0 && cmp(key, r.next) < 0
+}
+
+// hasType returns true if type t is in the bitmap of r.
+func (r *nsecRecord) hasType(t uint16) bool {
+	switch rr := r.rr.(type) {
+	case *dns.NSEC:
+		return slices.Contains(rr.TypeBitMap, t)
+	case *dns.NSEC3:
+		return slices.Contains(rr.TypeBitMap, t)
+	}
+	return false
+}
+
+// nodata returns true if r proves there is no data of type qtype at its owner name.
+func (r *nsecRecord) nodata(qtype uint16) bool {
+	if r.hasType(qtype) || r.hasType(dns.TypeCNAME) {
+		return false
+	}
+	if qtype == dns.TypeDS {
+		// Only the NSEC of a delegation in the parent denies a DS, never the one of the apex of the child
+		// zone (RFC 6840, section 4.4).
+		return r.hasType(dns.TypeNS) && !r.hasType(dns.TypeSOA)
+	}
+	// The NSEC of a delegation comes from the parent, it says nothing about the child zone.
+	return !r.delegation()
+}
+
+// delegation returns true if r is for a delegation, or a DNAME. Names below it are not in the zone.
+func (r *nsecRecord) delegation() bool {
+	return (r.hasType(dns.TypeNS) && !r.hasType(dns.TypeSOA)) || r.hasType(dns.TypeDNAME)
+}
+
+// optOut returns true if r is an NSEC3 record with the opt-out flag.
+func (r *nsecRecord) optOut() bool {
+	rr, ok := r.rr.(*dns.NSEC3)
+	return ok && rr.Flags&1 == 1
+}
+
+// synthesize returns a negative reply for the query in state, made from the cached NSEC or NSEC3 records.
+// It returns nil if the records don't prove the name or type does not exist.
+func (nc *nsecCache) synthesize(state request.Request, now time.Time, do, ad bool) *dns.Msg {
+	qname, qtype := state.Name(), state.QType()
+
+	// The DS records of a zone are in its parent, so its lookup starts there.
+	start, end := 0, false
+	if qtype == dns.TypeDS {
+		if start, end = dns.NextLabel(qname, 0); end {
+			return nil
+		}
+	}
+	var z *nsecZone
+	for off := start; !end; off, end = dns.NextLabel(qname, off) {
+		if z = nc.zone(qname[off:], false); z != nil {
+			break
+		}
+	}
+	if z == nil {
+		return nil
+	}
+
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	if !now.Before(z.soaExpires) {
+		return nil
+	}
+	rcode, proof := z.nsecProof(qname, qtype, now)
+	if proof == nil {
+		rcode, proof = z.nsec3Proof(qname, qtype, now)
+	}
+	if proof == nil {
+		return nil
+	}
+
+	m := new(dns.Msg)
+	m.SetRcode(state.Req, rcode)
+	m.Authoritative = true // see item.toMsg
+	m.AuthenticatedData = do || ad
+	m.RecursionAvailable = z.ra
+
+	expires := z.soaExpires
+	rrs := slices.Clone(z.soa)
+	for _, r := range proof {
+		if r.expires.Before(expires) {
+			expires = r.expires
+		}
+		rrs = append(rrs, r.rr)
+		rrs = append(rrs, r.sigs...)
+	}
+	ttl := uint32(expires.Sub(now).Seconds())
+	for _, rr := range rrs {
+		if !do {
+			switch rr.Header().Rrtype {
+			case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+				continue
+			}
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = ttl
+		m.Ns = append(m.Ns, rr)
+	}
+	return m
+}
+
+// nsecProof returns the NSEC records that prove qname or qtype does not exist.
+func (z *nsecZone) nsecProof(qname string, qtype uint16, now time.Time) (int, []*nsecRecord) {
+	r, match := find(z.nsec, qname, canonicalCompare, now)
+	if r == nil {
+		return 0, nil
+	}
+	if match {
+		if !r.nodata(qtype) {
+			return 0, nil
+		}
+		return dns.RcodeSuccess, []*nsecRecord{r}
+	}
+	if !r.covers(qname, canonicalCompare) {
+		return 0, nil
+	}
+	if dns.IsSubDomain(r.key, qname) && r.delegation() {
+		return 0, nil
+	}
+	if dns.IsSubDomain(qname, r.next) {
+		// qname is an empty non-terminal, it exists but has no records.
+		return dns.RcodeSuccess, []*nsecRecord{r}
+	}
+
+	// The closest encloser is the longest name that qname has in common with the names around it.
+	labels := max(dns.CompareDomainName(qname, r.key), dns.CompareDomainName(qname, r.next))
+	ce := ancestor(qname, labels)
+	if !dns.IsSubDomain(z.name, ce) {
+		return 0, nil
+	}
+	wildcard := "*." + ce
+	if ce == "." {
+		wildcard = "*."
+	}
+	w, match := find(z.nsec, wildcard, canonicalCompare, now)
+	if w == nil || match || !w.covers(wildcard, canonicalCompare) {
+		return 0, nil
+	}
+	if w == r {
+		return dns.RcodeNameError, []*nsecRecord{r}
+	}
+	return dns.RcodeNameError, []*nsecRecord{r, w}
+}
+
+// nsec3Proof returns the NSEC3 records that prove qname or qtype does not exist, see RFC 5155, section 8.
+func (z *nsecZone) nsec3Proof(qname string, qtype uint16, now time.Time) (int, []*nsecRecord) {
+	if len(z.nsec3) == 0 {
+		return 0, nil
+	}
+	params := z.nsec3[0].rr.(*dns.NSEC3)
+	hash := func(name string) string {
+		return strings.ToLower(dns.HashName(name, params.Hash, params.Iterations, params.Salt))
+	}
+
+	if r, match := find(z.nsec3, hash(qname), strings.Compare, now); r != nil && match {
+		if !r.nodata(qtype) {
+			return 0, nil
+		}
+		return dns.RcodeSuccess, []*nsecRecord{r}
+	}
+
+	// Find the closest encloser, and the next closer name below it.
+	nextCloser := qname
+	for off, end := dns.NextLabel(qname, 0); !end; off, end = dns.NextLabel(qname, off) {
+		ce := qname[off:]
+		if !dns.IsSubDomain(z.name, ce) {
+			return 0, nil
+		}
+		c, match := find(z.nsec3, hash(ce), strings.Compare, now)
+		if c == nil {
+			return 0, nil
+		}
+		if !match {
+			nextCloser = ce
+			continue
+		}
+		if c.delegation() {
+			return 0, nil
+		}
+
+		h := hash(nextCloser)
+		n, match := find(z.nsec3, h, strings.Compare, now)
+		if n == nil || match || !n.covers(h, strings.Compare) || n.optOut() {
+			return 0, nil
+		}
+		h = hash("*." + ce)
+		w, match := find(z.nsec3, h, strings.Compare, now)
+		if w == nil || match || !w.covers(h, strings.Compare) {
+			return 0, nil
+		}
+
+		proof := []*nsecRecord{c}
+		for _, r := range []*nsecRecord{n, w} {
+			if !slices.Contains(proof, r) {
+				proof = append(proof, r)
+			}
+		}
+		return dns.RcodeNameError, proof
+	}
+	return 0, nil
+}
+
+// ancestor returns the ancestor of name that has labels labels.
+func ancestor(name string, labels int) string {
+	idx := dns.Split(name)
+	if labels >= len(idx) {
+		return name
+	}
+	if labels <= 0 {
+		return "."
+	}
+	return name[idx[len(idx)-labels]:]
+}
+
+// canonicalCompare compares the names a and b in the canonical order of RFC 4034, section 6.1: label by label
+// starting at the right, with the labels compared as lowercase byte strings.
+func canonicalCompare(a, b string) int {
+	la, lb := dns.SplitDomainName(strings.ToLower(a)), dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(unescapeLabel(la[i]), unescapeLabel(lb[j])); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// unescapeLabel returns the label in wire format, with \X and \DDD escapes resolved, so labels compare as octets.
+func unescapeLabel(l string) string {
+	if !strings.Contains(l, "\\") {
+		return l
+	}
+	b := make([]byte, 0, len(l))
+	for i := 0; i < len(l); i++ {
+		if l[i] != '\\' || i+1 == len(l) {
+			b = append(b, l[i])
+			continue
+		}
+		if i+3 < len(l) && isDigit(l[i+1]) && isDigit(l[i+2]) && isDigit(l[i+3]) {
+			b = append(b, (l[i+1]-'0')*100+(l[i+2]-'0')*10+(l[i+3]-'0'))
+			i += 3
+			continue
+		}
+		b = append(b, l[i+1])
+		i++
+	}
+	return string(b)
+}
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
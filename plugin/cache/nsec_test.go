@@ -0,0 +1,276 @@
+package cache
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// rrsig returns a signature for type covered at owner, that is valid now. The signature itself is not
+// checked by the cache.
+func rrsig(owner string, covered uint16) dns.RR {
+	return &dns.RRSIG{
+		Hdr:         dns.RR_Header{Name: owner, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		TypeCovered: covered, Algorithm: dns.ECDSAP256SHA256, Labels: uint8(dns.CountLabel(owner)), OrigTtl: 3600,
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()), Inception: uint32(time.Now().Add(-time.Hour).Unix()),
+		KeyTag: 12345, SignerName: "example.org.", Signature: "c2lnbmF0dXJl",
+	}
+}
+
+func nsec(owner, next string, types ...uint16) dns.RR {
+	return &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: owner, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 3600},
+		NextDomain: next, TypeBitMap: types,
+	}
+}
+
+func signedSOA() []dns.RR {
+	return []dns.RR{
+		test.SOA("example.org. 3600 IN SOA sns.dns.icann.org. noc.dns.icann.org. 2016082540 7200 3600 1209600 3600"),
+		rrsig("example.org.", dns.TypeSOA),
+	}
+}
+
+// signedNegative returns a validated negative reply with rcode for the query r, with the records in ns, which
+// are all signed.
+func signedNegative(r *dns.Msg, rcode int, ns ...dns.RR) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetRcode(r, rcode)
+	m.RecursionAvailable, m.AuthenticatedData = true, true
+	m.Ns = signedSOA()
+	for _, rr := range ns {
+		m.Ns = append(m.Ns, rr, rrsig(rr.Header().Name, rr.Header().Rrtype))
+	}
+	m.SetEdns0(4096, true)
+	return m
+}
+
+// nsecBackend replies with the reply for the query name in replies, it fails the test for any other name.
+func nsecBackend(t *testing.T, replies map[string]func(*dns.Msg) *dns.Msg) plugin.Handler {
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		f, ok := replies[r.Question[0].Name+dns.TypeToString[r.Question[0].Qtype]]
+		if !ok {
+			return 255, nil // Below, a 255 means we tried querying upstream.
+		}
+		w.WriteMsg(f(r))
+		return dns.RcodeSuccess, nil
+	})
+}
+
+func nsecQuery(name string, qtype uint16, do bool) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	if do {
+		m.SetEdns0(4096, true)
+	}
+	return m
+}
+
+func TestAggressiveNSEC(t *testing.T) {
+	c := New()
+	c.nsec = newNSECCache(defaultCap)
+	c.Next = nsecBackend(t, map[string]func(*dns.Msg) *dns.Msg{
+		"b.example.org.A": func(r *dns.Msg) *dns.Msg {
+			return signedNegative(r, dns.RcodeNameError,
+				nsec("example.org.", "a.example.org.", dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY),
+				nsec("a.example.org.", "d.example.org.", dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC))
+		},
+		"m.example.org.A": func(r *dns.Msg) *dns.Msg {
+			return signedNegative(r, dns.RcodeNameError,
+				nsec("l.example.org.", "x.n.example.org.", dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC))
+		},
+		"sub.example.org.DS": func(r *dns.Msg) *dns.Msg {
+			return signedNegative(r, dns.RcodeSuccess,
+				nsec("sub.example.org.", "example.org.", dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC))
+		},
+	})
+	for _, q := range []*dns.Msg{nsecQuery("b.example.org.", dns.TypeA, true), nsecQuery("m.example.org.", dns.TypeA, true), nsecQuery("sub.example.org.", dns.TypeDS, true)} {
+		if ret, _ := c.ServeDNS(context.TODO(), &test.ResponseWriter{}, q); ret == 255 {
+			t.Fatalf("Expected reply for %s", q.Question[0].Name)
+		}
+	}
+
+	tests := []struct {
+		qname      string
+		qtype      uint16
+		do         bool
+		expected   int // rcode, or 255 if the query should go to the backend
+		nsecProofs int
+	}{
+		{"c.example.org.", dns.TypeA, true, dns.RcodeNameError, 2},
+		{"C.Example.Org.", dns.TypeAAAA, true, dns.RcodeNameError, 2},
+		{"x.c.example.org.", dns.TypeA, true, dns.RcodeNameError, 2},
+		{"c.example.org.", dns.TypeA, false, dns.RcodeNameError, 0},
+		{"a.example.org.", dns.TypeTXT, true, dns.RcodeSuccess, 1}, // NODATA
+		{"a.example.org.", dns.TypeA, true, 255, 0},                // exists
+		{"n.example.org.", dns.TypeA, true, dns.RcodeSuccess, 1},   // empty non-terminal, NODATA
+		{"e.example.org.", dns.TypeA, true, 255, 0},                // not covered
+		{"sub.example.org.", dns.TypeA, true, 255, 0},              // delegation
+		{"x.sub.example.org.", dns.TypeA, true, 255, 0},            // below delegation
+		{"example.net.", dns.TypeA, true, 255, 0},                  // other zone
+		{"example.org.", dns.TypeDS, true, 255, 0},                 // the apex NSEC is of the child zone
+		{"a.example.org.", dns.TypeDS, true, 255, 0},               // not a delegation
+		{"c.example.org.", dns.TypeDS, true, dns.RcodeNameError, 2},
+	}
+	for _, tc := range tests {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		ret, _ := c.ServeDNS(context.TODO(), rec, nsecQuery(tc.qname, tc.qtype, tc.do))
+		if tc.expected == 255 {
+			if ret != 255 {
+				t.Errorf("%s/%s: expected query to go to the backend", tc.qname, dns.TypeToString[tc.qtype])
+			}
+			continue
+		}
+		if ret == 255 {
+			t.Errorf("%s/%s: expected synthesized reply", tc.qname, dns.TypeToString[tc.qtype])
+			continue
+		}
+		m := rec.Msg
+		if m.Rcode != tc.expected {
+			t.Errorf("%s/%s: expected rcode %s, got %s", tc.qname, dns.TypeToString[tc.qtype], dns.RcodeToString[tc.expected], dns.RcodeToString[m.Rcode])
+		}
+		if m.Question[0].Name != tc.qname {
+			t.Errorf("%s/%s: expected question to be echoed, got %s", tc.qname, dns.TypeToString[tc.qtype], m.Question[0].Name)
+		}
+		proofs := 0
+		for _, rr := range m.Ns {
+			if rr.Header().Rrtype == dns.TypeNSEC {
+				proofs++
+			}
+			if !tc.do && rr.Header().Rrtype == dns.TypeRRSIG {
+				t.Errorf("%s/%s: expected no signatures without DO", tc.qname, dns.TypeToString[tc.qtype])
+			}
+		}
+		if proofs != tc.nsecProofs {
+			t.Errorf("%s/%s: expected %d NSEC records, got %d", tc.qname, dns.TypeToString[tc.qtype], tc.nsecProofs, proofs)
+		}
+		if _, ok := m.Ns[0].(*dns.SOA); !ok {
+			t.Errorf("%s/%s: expected SOA first in authority section", tc.qname, dns.TypeToString[tc.qtype])
+		}
+	}
+}
+
+func TestAggressiveNSECNotValidated(t *testing.T) {
+	c := New()
+	c.nsec = newNSECCache(defaultCap)
+	c.Next = nsecBackend(t, map[string]func(*dns.Msg) *dns.Msg{
+		"b.example.org.A": func(r *dns.Msg) *dns.Msg {
+			m := signedNegative(r, dns.RcodeNameError,
+				nsec("example.org.", "a.example.org.", dns.TypeNS, dns.TypeSOA),
+				nsec("a.example.org.", "d.example.org.", dns.TypeA))
+			m.AuthenticatedData = false
+			return m
+		},
+	})
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{}, nsecQuery("b.example.org.", dns.TypeA, true))
+	if ret, _ := c.ServeDNS(context.TODO(), &test.ResponseWriter{}, nsecQuery("c.example.org.", dns.TypeA, true)); ret != 255 {
+		t.Error("Expected no reply synthesized from records that were not validated")
+	}
+}
+
+func TestAggressiveNSECExpired(t *testing.T) {
+	c := New()
+	c.nsec = newNSECCache(defaultCap)
+	c.Next = nsecBackend(t, map[string]func(*dns.Msg) *dns.Msg{
+		"b.example.org.A": func(r *dns.Msg) *dns.Msg {
+			return signedNegative(r, dns.RcodeNameError,
+				nsec("example.org.", "a.example.org.", dns.TypeNS, dns.TypeSOA),
+				nsec("a.example.org.", "d.example.org.", dns.TypeA))
+		},
+	})
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{}, nsecQuery("b.example.org.", dns.TypeA, true))
+
+	c.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if ret, _ := c.ServeDNS(context.TODO(), &test.ResponseWriter{}, nsecQuery("c.example.org.", dns.TypeA, true)); ret != 255 {
+		t.Error("Expected no reply synthesized from expired records")
+	}
+}
+
+// nsec3Chain returns the NSEC3 records for a zone with the names in names, sorted on their hash.
+func nsec3Chain(optOut bool, names ...string) []dns.RR {
+	hashes := make([]string, len(names))
+	for i, name := range names {
+		hashes[i] = dns.HashName(name, dns.SHA1, 0, "")
+	}
+	slices.Sort(hashes)
+	var flags uint8
+	if optOut {
+		flags = 1
+	}
+	rrs := make([]dns.RR, len(hashes))
+	for i, h := range hashes {
+		rrs[i] = &dns.NSEC3{
+			Hdr:  dns.RR_Header{Name: strings.ToLower(h) + ".example.org.", Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 3600},
+			Hash: dns.SHA1, Flags: flags, Iterations: 0, SaltLength: 0, Salt: "",
+			HashLength: 20, NextDomain: hashes[(i+1)%len(hashes)], TypeBitMap: []uint16{dns.TypeA, dns.TypeRRSIG},
+		}
+	}
+	return rrs
+}
+
+func TestAggressiveNSEC3(t *testing.T) {
+	for _, optOut := range []bool{false, true} {
+		c := New()
+		c.nsec = newNSECCache(defaultCap)
+		c.Next = nsecBackend(t, map[string]func(*dns.Msg) *dns.Msg{
+			"b.example.org.A": func(r *dns.Msg) *dns.Msg {
+				return signedNegative(r, dns.RcodeNameError, nsec3Chain(optOut, "example.org.", "a.example.org.")...)
+			},
+		})
+		c.ServeDNS(context.TODO(), &test.ResponseWriter{}, nsecQuery("b.example.org.", dns.TypeA, true))
+
+		tests := []struct {
+			qname    string
+			qtype    uint16
+			expected int
+		}{
+			{"c.example.org.", dns.TypeA, dns.RcodeNameError},
+			{"x.c.example.org.", dns.TypeA, dns.RcodeNameError},
+			{"a.example.org.", dns.TypeTXT, dns.RcodeSuccess}, // NODATA
+			{"a.example.org.", dns.TypeA, 255},
+			{"example.org.", dns.TypeDS, 255}, // the apex NSEC3 is of the child zone
+		}
+		for _, tc := range tests {
+			expected := tc.expected
+			if optOut && expected == dns.RcodeNameError {
+				expected = 255
+			}
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			ret, _ := c.ServeDNS(context.TODO(), rec, nsecQuery(tc.qname, tc.qtype, true))
+			if expected == 255 {
+				if ret != 255 {
+					t.Errorf("Opt-out %t, %s/%s: expected query to go to the backend", optOut, tc.qname, dns.TypeToString[tc.qtype])
+				}
+				continue
+			}
+			if ret == 255 {
+				t.Errorf("Opt-out %t, %s/%s: expected synthesized reply", optOut, tc.qname, dns.TypeToString[tc.qtype])
+				continue
+			}
+			if rec.Msg.Rcode != expected {
+				t.Errorf("Opt-out %t, %s/%s: expected rcode %s, got %s", optOut, tc.qname, dns.TypeToString[tc.qtype], dns.RcodeToString[expected], dns.RcodeToString[rec.Msg.Rcode])
+			}
+		}
+	}
+}
+
+func TestCanonicalCompare(t *testing.T) {
+	// The example from RFC 4034, section 6.1.
+	names := []string{
+		"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.", "zABC.a.EXAMPLE.",
+		"z.example.", "\\001.z.example.", "*.z.example.", "\\200.z.example.",
+	}
+	for i := 1; i < len(names); i++ {
+		if canonicalCompare(names[i-1], names[i]) >= 0 {
+			t.Errorf("Expected %s before %s", names[i-1], names[i])
+		}
+	}
+}
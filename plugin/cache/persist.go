@@ -0,0 +1,329 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/cache/freq"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+
+	"github.com/miekg/dns"
+)
+
+// The snapshot starts with snapshotMagic and the version of the format. It is followed by one record per
+// cached item, each record is:
+//
+//	kind      uint8  - snapshotSuccess or snapshotDenial
+//	key       uint64 - the key of the item in the cache
+//	stored    int64  - the time the item was stored, in unix nanoseconds
+//	origTTL   uint32 - the TTL of the item when stored, in seconds
+//	wildcard  uint16 length, followed by the wildcard name
+//	msg       uint32 length, followed by the item as a packed DNS message
+//
+// With ECS, the subnet an item is valid for is stored as a client subnet option in the message.
+// All integers are big endian.
+const (
+	snapshotMagic   = "CDNSCACHE"
+	snapshotVersion = 1
+
+	snapshotSuccess uint8 = 0
+	snapshotDenial  uint8 = 1
+
+	defaultPersistInterval = 5 * time.Minute
+)
+
+// errSnapshotVersion is returned when a snapshot is read that has an unknown version.
+var errSnapshotVersion = errors.New("unsupported cache snapshot version")
+
+// snapshotItem is an item in the cache, as collected when writing a snapshot.
+type snapshotItem struct {
+	kind uint8
+	key  uint64
+	*item
+}
+
+// writeSnapshot writes all items in the cache to w. It returns the number of items written.
+func (c *Cache) writeSnapshot(w io.Writer) (int, error) {
+	// Collect the items first, so the shards are not locked while writing.
+	var items []snapshotItem
+	collect := func(kind uint8, ca *cache.Cache) {
+		ca.Walk(func(m map[uint64]any, key uint64) bool {
+			if i, ok := m[key].(*item); ok {
+				items = append(items, snapshotItem{kind, key, i})
+			}
+			return true
+		})
+	}
+	collect(snapshotSuccess, c.pcache)
+	collect(snapshotDenial, c.ncache)
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(snapshotMagic)
+	binary.Write(bw, binary.BigEndian, uint16(snapshotVersion))
+
+	n := 0
+	for _, si := range items {
+		buf, err := si.pack()
+		if err != nil {
+			log.Debugf("Not writing cache item %s/%d to snapshot: %s", si.Name, si.QType, err)
+			continue
+		}
+		bw.WriteByte(si.kind)
+		binary.Write(bw, binary.BigEndian, si.key)
+		binary.Write(bw, binary.BigEndian, si.stored.UnixNano())
+		binary.Write(bw, binary.BigEndian, si.origTTL)
+		binary.Write(bw, binary.BigEndian, uint16(len(si.wildcard)))
+		bw.WriteString(si.wildcard)
+		binary.Write(bw, binary.BigEndian, uint32(len(buf)))
+		if _, err := bw.Write(buf); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, bw.Flush()
+}
+
+// pack returns i as a packed DNS message.
+func (i *item) pack() ([]byte, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(i.Name, i.QType)
+	m.Response = true
+	m.Rcode = i.Rcode
+	m.AuthenticatedData = i.AuthenticatedData
+	m.RecursionAvailable = i.RecursionAvailable
+	m.Answer = i.Answer
+	m.Ns = i.Ns
+	m.Extra = slices.Clone(i.Extra)
+	if i.ecs != nil {
+		m.SetEdns0(dns.MinMsgSize, false)
+		o := m.IsEdns0()
+		o.Option = append(o.Option, i.ecs)
+	}
+	return m.Pack()
+}
+
+// readSnapshot reads the items in the snapshot in r into the cache. Items that have expired, or that
+// should not be cached with the current configuration are skipped. It returns the number of items added.
+func (c *Cache) readSnapshot(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != snapshotMagic {
+		return 0, errors.New("not a cache snapshot")
+	}
+	var version uint16
+	if err := binary.Read(br, binary.BigEndian, &version); err != nil {
+		return 0, err
+	}
+	if version != snapshotVersion {
+		return 0, fmt.Errorf("%w: %d", errSnapshotVersion, version)
+	}
+
+	now := c.now().UTC()
+	n := 0
+	for {
+		kind, key, i, err := readItem(br)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+
+		zone := plugin.Zones(c.Zones).Matches(i.Name)
+		if zone == "" {
+			continue
+		}
+		ttl, except, ca := c.pttl, c.pexcept, c.pcache
+		if kind == snapshotDenial {
+			ttl, except, ca = c.nttl, c.nexcept, c.ncache
+		}
+		if plugin.Zones(except).Matches(i.Name) != "" {
+			continue
+		}
+		if i.ecs != nil && !c.ecs {
+			// Only valid for some clients, without ECS we can't tell which.
+			continue
+		}
+		remaining := time.Duration(i.ttl(now)) * time.Second
+		if remaining <= 0 {
+			continue
+		}
+		if remaining > ttl {
+			// The maximum TTL was lowered since the snapshot was written.
+			i.origTTL = uint32(ttl.Seconds())
+			i.stored = now
+		}
+		if i.ecs != nil {
+			c.addScope(i.Name, i.QType, i.ecs)
+		}
+		if added, _ := ca.Put(key, i); added {
+			n++
+		}
+	}
+}
+
+func readItem(r *bufio.Reader) (uint8, uint64, *item, error) {
+	kind, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err // io.EOF when there are no more items
+	}
+
+	var (
+		key      uint64
+		stored   int64
+		origTTL  uint32
+		wlen     uint16
+		mlen     uint32
+		readErrs []error
+	)
+	read := func(data any) { readErrs = append(readErrs, binary.Read(r, binary.BigEndian, data)) }
+	read(&key)
+	read(&stored)
+	read(&origTTL)
+	read(&wlen)
+	wildcard := make([]byte, wlen)
+	_, werr := io.ReadFull(r, wildcard)
+	read(&mlen)
+	if err := errors.Join(append(readErrs, werr)...); err != nil {
+		return 0, 0, nil, fmt.Errorf("truncated cache snapshot: %w", err)
+	}
+	if kind != snapshotSuccess && kind != snapshotDenial {
+		return 0, 0, nil, fmt.Errorf("unknown item kind in cache snapshot: %d", kind)
+	}
+
+	if mlen > dns.MaxMsgSize {
+		return 0, 0, nil, fmt.Errorf("cache snapshot item too large: %d bytes", mlen)
+	}
+	buf := make([]byte, mlen)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, 0, nil, fmt.Errorf("truncated cache snapshot: %w", err)
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil {
+		return 0, 0, nil, err
+	}
+	if len(m.Question) != 1 {
+		return 0, 0, nil, errors.New("cache snapshot item without question")
+	}
+
+	var ecs *dns.EDNS0_SUBNET
+	if o := m.IsEdns0(); o != nil {
+		for _, e := range o.Option {
+			if s, ok := e.(*dns.EDNS0_SUBNET); ok {
+				ecs = s
+			}
+		}
+		m.Extra = slices.DeleteFunc(m.Extra, func(rr dns.RR) bool { return rr.Header().Rrtype == dns.TypeOPT })
+	}
+
+	i := &item{
+		Name:               m.Question[0].Name,
+		QType:              m.Question[0].Qtype,
+		Rcode:              m.Rcode,
+		AuthenticatedData:  m.AuthenticatedData,
+		RecursionAvailable: m.RecursionAvailable,
+		Answer:             m.Answer,
+		Ns:                 m.Ns,
+		Extra:              m.Extra,
+		wildcard:           string(wildcard),
+		ecs:                ecs,
+		origTTL:            origTTL,
+		stored:             time.Unix(0, stored).UTC(),
+		size:               m.Len(),
+		Freq:               new(freq.Freq),
+	}
+	return kind, key, i, nil
+}
+
+// saveSnapshot writes the cache to c.persistFile. It writes to a temporary file first, so a crash while
+// writing leaves the previous snapshot intact.
+func (c *Cache) saveSnapshot() error {
+	f, err := os.CreateTemp(filepath.Dir(c.persistFile), filepath.Base(c.persistFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	n, err := c.writeSnapshot(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), c.persistFile); err != nil {
+		return err
+	}
+	log.Debugf("Wrote %d cache items to %s", n, c.persistFile)
+	return nil
+}
+
+// loadSnapshot loads the items in c.persistFile into the cache. A missing file is not an error, that is
+// what happens on the first start.
+func (c *Cache) loadSnapshot() error {
+	f, err := os.Open(c.persistFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, err := c.readSnapshot(f)
+	log.Infof("Loaded %d cache items from %s", n, c.persistFile)
+	return err
+}
+
+// startPersist loads the snapshot and starts writing it every c.persistInterval.
+func (c *Cache) startPersist() {
+	if err := c.loadSnapshot(); err != nil {
+		log.Warningf("Failed to load cache snapshot %s: %s", c.persistFile, err)
+	}
+	c.resumePersist()
+}
+
+// resumePersist starts writing the snapshot every c.persistInterval, without loading it.
+func (c *Cache) resumePersist() {
+	c.persistStop = make(chan struct{})
+	c.persistDone = make(chan struct{})
+	go func() {
+		defer close(c.persistDone)
+		tick := time.NewTicker(c.persistInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				if err := c.saveSnapshot(); err != nil {
+					log.Warningf("Failed to write cache snapshot %s: %s", c.persistFile, err)
+				}
+			case <-c.persistStop:
+				return
+			}
+		}
+	}()
+}
+
+// stopPersist stops the periodic writes and writes the snapshot one last time. On a reload this is done
+// before the new instance loads the snapshot, later calls do nothing.
+func (c *Cache) stopPersist() {
+	if c.persistStop == nil {
+		return
+	}
+	close(c.persistStop)
+	<-c.persistDone
+	c.persistStop = nil
+
+	if err := c.saveSnapshot(); err != nil {
+		log.Warningf("Failed to write cache snapshot %s: %s", c.persistFile, err)
+	}
+}
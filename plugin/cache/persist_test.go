@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// filledCache returns a cache with a positive answer for example.org. and a negative one for nx.example.org.
+func filledCache(t *testing.T) *Cache {
+	t.Helper()
+	c := New()
+	for _, tc := range []struct {
+		qname string
+		next  plugin.Handler
+	}{
+		{"example.org.", BackendHandler()},
+		{"nx.example.org.", nxDomainBackend(3600)},
+	} {
+		c.Next = tc.next
+		req := new(dns.Msg)
+		req.SetQuestion(tc.qname, dns.TypeA)
+		c.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+	}
+	if c.pcache.Len() != 1 || c.ncache.Len() != 1 {
+		t.Fatalf("Expected one positive and one negative item, got %d and %d", c.pcache.Len(), c.ncache.Len())
+	}
+	return c
+}
+
+// upstreamCalled is a plugin.Handler that returns 255, to tell a cache miss from a hit.
+var upstreamCalled = plugin.HandlerFunc(func(context.Context, dns.ResponseWriter, *dns.Msg) (int, error) {
+	return 255, nil
+})
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	c := filledCache(t)
+
+	buf := new(bytes.Buffer)
+	n, err := c.writeSnapshot(buf)
+	if err != nil {
+		t.Fatalf("Failed to write snapshot: %s", err)
+	}
+	if n != 2 {
+		t.Fatalf("Expected 2 items written, got %d", n)
+	}
+
+	c2 := New()
+	c2.Next = upstreamCalled
+	now := time.Now().Add(100 * time.Second)
+	c2.now = func() time.Time { return now }
+	if n, err := c2.readSnapshot(buf); err != nil || n != 2 {
+		t.Fatalf("Expected 2 items read, got %d: %v", n, err)
+	}
+
+	tests := []struct {
+		qname string
+		rcode int
+		ttl   uint32
+	}{
+		{"example.org.", dns.RcodeSuccess, 203},
+		{"nx.example.org.", dns.RcodeNameError, 1700},
+	}
+	for _, tc := range tests {
+		req := new(dns.Msg)
+		req.SetQuestion(tc.qname, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if ret, _ := c2.ServeDNS(context.TODO(), rec, req); ret == 255 {
+			t.Errorf("Expected %s to be served from the loaded cache", tc.qname)
+			continue
+		}
+		if rec.Msg.Rcode != tc.rcode {
+			t.Errorf("Expected rcode %d for %s, got %d", tc.rcode, tc.qname, rec.Msg.Rcode)
+		}
+		rrs := append(rec.Msg.Answer, rec.Msg.Ns...)
+		if len(rrs) != 1 {
+			t.Fatalf("Expected one record for %s, got %d", tc.qname, len(rrs))
+		}
+		if ttl := rrs[0].Header().Ttl; ttl != tc.ttl {
+			t.Errorf("Expected TTL %d for %s, got %d", tc.ttl, tc.qname, ttl)
+		}
+	}
+}
+
+func TestSnapshotSkip(t *testing.T) {
+	c := filledCache(t)
+	buf := new(bytes.Buffer)
+	if _, err := c.writeSnapshot(buf); err != nil {
+		t.Fatalf("Failed to write snapshot: %s", err)
+	}
+	snapshot := buf.Bytes()
+
+	tests := []struct {
+		name     string
+		setup    func(*Cache)
+		expected int
+	}{
+		{"expired", func(c *Cache) {
+			now := time.Now().Add(time.Hour)
+			c.now = func() time.Time { return now }
+		}, 0},
+		{"other zone", func(c *Cache) { c.Zones = []string{"example.net."} }, 0},
+		{"success disabled", func(c *Cache) { c.pexcept = []string{"."} }, 1},
+		{"denial disabled", func(c *Cache) { c.nexcept = []string{"example.org."} }, 1},
+	}
+	for _, tc := range tests {
+		c2 := New()
+		tc.setup(c2)
+		n, err := c2.readSnapshot(bytes.NewReader(snapshot))
+		if err != nil {
+			t.Errorf("Test %s: failed to read snapshot: %s", tc.name, err)
+			continue
+		}
+		if n != tc.expected || c2.pcache.Len()+c2.ncache.Len() != tc.expected {
+			t.Errorf("Test %s: expected %d items, got %d", tc.name, tc.expected, n)
+		}
+	}
+}
+
+func TestSnapshotTTLCapped(t *testing.T) {
+	c := filledCache(t)
+	buf := new(bytes.Buffer)
+	if _, err := c.writeSnapshot(buf); err != nil {
+		t.Fatalf("Failed to write snapshot: %s", err)
+	}
+
+	c2 := New()
+	c2.pttl = 60 * time.Second
+	if _, err := c2.readSnapshot(buf); err != nil {
+		t.Fatalf("Failed to read snapshot: %s", err)
+	}
+	c2.pcache.Walk(func(m map[uint64]any, key uint64) bool {
+		if ttl := m[key].(*item).ttl(time.Now()); ttl > 60 {
+			t.Errorf("Expected TTL to be capped to 60, got %d", ttl)
+		}
+		return true
+	})
+}
+
+func TestSnapshotInvalid(t *testing.T) {
+	c := filledCache(t)
+	buf := new(bytes.Buffer)
+	if _, err := c.writeSnapshot(buf); err != nil {
+		t.Fatalf("Failed to write snapshot: %s", err)
+	}
+	snapshot := buf.Bytes()
+
+	newer := bytes.Clone(snapshot)
+	binary.BigEndian.PutUint16(newer[len(snapshotMagic):], snapshotVersion+1)
+	if _, err := New().readSnapshot(bytes.NewReader(newer)); !errors.Is(err, errSnapshotVersion) {
+		t.Errorf("Expected %q, got %v", errSnapshotVersion, err)
+	}
+
+	if _, err := New().readSnapshot(bytes.NewReader([]byte("garbage"))); err == nil {
+		t.Error("Expected error for a file that is not a snapshot")
+	}
+
+	if _, err := New().readSnapshot(bytes.NewReader(snapshot[:len(snapshot)-5])); err == nil {
+		t.Error("Expected error for a truncated snapshot")
+	}
+
+	// An item claiming a message larger than any DNS message, without the data to back it up.
+	huge := bytes.NewBufferString(snapshotMagic)
+	binary.Write(huge, binary.BigEndian, uint16(snapshotVersion))
+	huge.WriteByte(snapshotSuccess)
+	binary.Write(huge, binary.BigEndian, uint64(1))                // key
+	binary.Write(huge, binary.BigEndian, time.Now().UnixNano())    // stored
+	binary.Write(huge, binary.BigEndian, uint32(3600))             // origTTL
+	binary.Write(huge, binary.BigEndian, uint16(0))                // wildcard length
+	binary.Write(huge, binary.BigEndian, uint32(dns.MaxMsgSize+1)) // msg length
+	if _, err := New().readSnapshot(huge); err == nil {
+		t.Error("Expected error for an item larger than a DNS message")
+	}
+}
+
+func TestPersist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache")
+
+	c := filledCache(t)
+	c.persistFile = file
+	c.persistInterval = time.Hour
+	// There is no file yet, this is not an error.
+	c.startPersist()
+	c.stopPersist()
+
+	c2 := New()
+	c2.persistFile = file
+	c2.persistInterval = time.Hour
+	c2.startPersist()
+	defer c2.stopPersist()
+	if c2.pcache.Len() != 1 || c2.ncache.Len() != 1 {
+		t.Errorf("Expected one positive and one negative item, got %d and %d", c2.pcache.Len(), c2.ncache.Len())
+	}
+}
+
+func TestPersistReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache")
+
+	old := filledCache(t)
+	old.persistFile = file
+	old.persistInterval = time.Hour
+	old.startPersist()
+
+	// On a reload the old instance writes the snapshot before the new one starts.
+	old.stopPersist()
+	c := New()
+	c.persistFile = file
+	c.persistInterval = time.Hour
+	c.startPersist()
+	defer c.stopPersist()
+	if c.pcache.Len() != 1 || c.ncache.Len() != 1 {
+		t.Errorf("Expected one positive and one negative item, got %d and %d", c.pcache.Len(), c.ncache.Len())
+	}
+
+	// The shutdown of the old instance doesn't write the snapshot again.
+	old.pcache.Clear()
+	old.stopPersist()
+	c2 := New()
+	c2.persistFile = file
+	c2.loadSnapshot()
+	if c2.pcache.Len() != 1 {
+		t.Errorf("Expected the snapshot of the reload to be kept, got %d positive items", c2.pcache.Len())
+	}
+}
@@ -0,0 +1,225 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redis is a Backend that speaks the Redis protocol (RESP), so it works with Redis, Valkey, KeyDB and the like.
+// It only needs GET, SET and DEL. Connections are dialed when needed, and kept for reuse.
+type redis struct {
+	addr     string
+	username string
+	password string
+	db       int
+	prefix   string
+
+	idle chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+const (
+	redisMaxIdle       = 16
+	redisDefaultPrefix = "coredns:cache:"
+)
+
+// errRedisNil is the nil reply, that is returned for a key that does not exist.
+var errRedisNil = errors.New("redis: nil")
+
+// newRedis returns a redis backend for the URL u: redis://[[USERNAME]:PASSWORD@]HOST[:PORT][/DB][?prefix=PREFIX].
+func newRedis(u *url.URL) (Backend, error) {
+	r := &redis{addr: u.Host, prefix: redisDefaultPrefix, idle: make(chan *redisConn, redisMaxIdle)}
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.username = u.User.Username()
+		r.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid redis database: %s", db)
+		}
+		r.db = n
+	}
+	if p, ok := u.Query()["prefix"]; ok {
+		r.prefix = p[0]
+	}
+	return r, nil
+}
+
+// Get implements the Backend interface.
+func (r *redis) Get(ctx context.Context, key string) ([]byte, error) {
+	v, err := r.do(ctx, "GET", r.prefix+key)
+	if err == errRedisNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected reply to GET: %v", v)
+	}
+	return b, nil
+}
+
+// Set implements the Backend interface.
+func (r *redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := r.do(ctx, "SET", r.prefix+key, value, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// Delete implements the Backend interface.
+func (r *redis) Delete(ctx context.Context, keys ...string) error {
+	args := make([]any, 0, len(keys)+1)
+	args = append(args, "DEL")
+	for _, k := range keys {
+		args = append(args, r.prefix+k)
+	}
+	_, err := r.do(ctx, args...)
+	return err
+}
+
+// Close implements the Backend interface.
+func (r *redis) Close() error {
+	for {
+		select {
+		case c := <-r.idle:
+			c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// do sends the command args and returns the reply. Connections that fail are closed, the others are reused.
+func (r *redis) do(ctx context.Context, args ...any) (any, error) {
+	c, err := r.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v, err := c.do(ctx, args...)
+	var rerr redisError
+	if err != nil && err != errRedisNil && !errors.As(err, &rerr) {
+		c.Close()
+		return nil, err
+	}
+	select {
+	case r.idle <- c:
+	default:
+		c.Close()
+	}
+	return v, err
+}
+
+// conn returns an idle connection, or dials a new one.
+func (r *redis) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-r.idle:
+		return c, nil
+	default:
+	}
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", r.addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+	if r.password != "" {
+		args := []any{"AUTH", r.password}
+		if r.username != "" {
+			args = []any{"AUTH", r.username, r.password}
+		}
+		if _, err := c.do(ctx, args...); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if r.db != 0 {
+		if _, err := c.do(ctx, "SELECT", strconv.Itoa(r.db)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// do writes the command args as an array of bulk strings, and reads the reply.
+func (c *redisConn) do(ctx context.Context, args ...any) (any, error) {
+	deadline, _ := ctx.Deadline()
+	c.SetDeadline(deadline)
+
+	buf := make([]byte, 0, 64)
+	buf = fmt.Appendf(buf, "*%d\r\n", len(args))
+	for _, a := range args {
+		var b []byte
+		switch a := a.(type) {
+		case string:
+			b = []byte(a)
+		case []byte:
+			b = a
+		}
+		buf = fmt.Appendf(buf, "$%d\r\n", len(b))
+		buf = append(buf, b...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := c.Write(buf); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+// redisError is an error reply.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// readReply reads a reply: a simple string, an error, an integer or a bulk string. Arrays are not used by
+// the commands we send.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+	return nil, fmt.Errorf("redis: unsupported reply type %q", line[0])
+}
@@ -5,6 +5,8 @@ package cache
 import (
 	"errors"
 	"fmt"
+	"net"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -28,6 +30,35 @@ func setup(c *caddy.Controller) error {
 		return nil
 	})
 
+	if ca.adminAddr != "" {
+		c.OnStartup(func() error { return registerAdmin(ca.adminAddr, ca.adminToken, ca) })
+		c.OnShutdown(func() error { return unregisterAdmin(ca.adminAddr, ca) })
+	}
+
+	if ca.backend != nil {
+		c.OnShutdown(ca.backend.Close)
+	}
+
+	if ca.persistFile != "" {
+		c.OnStartup(func() error {
+			ca.startPersist()
+			return nil
+		})
+		// Write the snapshot before the new instance loads it on a reload.
+		c.OnRestart(func() error {
+			ca.stopPersist()
+			return nil
+		})
+		c.OnRestartFailed(func() error {
+			ca.resumePersist()
+			return nil
+		})
+		c.OnShutdown(func() error {
+			ca.stopPersist()
+			return nil
+		})
+	}
+
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		ca.Next = next
 		return ca
@@ -36,8 +67,32 @@ func setup(c *caddy.Controller) error {
 	return nil
 }
 
+// minBytes is the smallest max_bytes, so each of the 256 shards of the cache can hold a few big replies.
+const minBytes = 1 << 20
+
+// parseBytes parses a number of bytes, with an optional K, M or G suffix for KiB, MiB and GiB.
+func parseBytes(s string) (int, error) {
+	mult := 1
+	for suffix, m := range map[string]int{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30} {
+		if strings.HasSuffix(strings.ToUpper(s), suffix) {
+			s, mult = s[:len(s)-1], m
+			break
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid number of bytes: %d", n)
+	}
+	return n * mult, nil
+}
+
 func cacheParse(c *caddy.Controller) (*Cache, error) {
 	ca := New()
+	aggressiveNSEC := false
+	admission := false
 
 	j := 0
 	for c.Next() {
@@ -245,6 +300,119 @@ func cacheParse(c *caddy.Controller) (*Cache, error) {
 					return nil, c.ArgErr()
 				}
 				ca.keepttl = true
+			case "early_refresh":
+				// early_refresh [BETA]
+				args := c.RemainingArgs()
+				if len(args) > 1 {
+					return nil, c.ArgErr()
+				}
+				ca.earlyRefresh = 1
+				if len(args) == 1 {
+					beta, err := strconv.ParseFloat(args[0], 64)
+					if err != nil {
+						return nil, err
+					}
+					if beta <= 0 {
+						return nil, fmt.Errorf("early_refresh beta must be positive: %s", args[0])
+					}
+					ca.earlyRefresh = beta
+				}
+			case "max_bytes":
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+				n, err := parseBytes(args[1])
+				if err != nil {
+					return nil, err
+				}
+				if n < minBytes {
+					return nil, fmt.Errorf("max_bytes must be at least %d: %s", minBytes, args[1])
+				}
+				switch args[0] {
+				case Success:
+					ca.pbytes = n
+				case Denial:
+					ca.nbytes = n
+				default:
+					return nil, fmt.Errorf("cache type for max_bytes must be %q or %q", Success, Denial)
+				}
+			case "admission":
+				args := c.RemainingArgs()
+				if len(args) != 0 {
+					return nil, c.ArgErr()
+				}
+				admission = true
+			case "aggressive_nsec":
+				args := c.RemainingArgs()
+				if len(args) != 0 {
+					return nil, c.ArgErr()
+				}
+				aggressiveNSEC = true
+			case "ecs":
+				args := c.RemainingArgs()
+				if len(args) != 0 {
+					return nil, c.ArgErr()
+				}
+				ca.ecs = true
+			case "admin":
+				// admin ADDRESS TOKEN
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+				if _, _, err := net.SplitHostPort(args[0]); err != nil {
+					return nil, err
+				}
+				if args[1] == "" {
+					return nil, errors.New("admin token can not be empty")
+				}
+				ca.adminAddr, ca.adminToken = args[0], args[1]
+			case "backend":
+				// backend URL [TIMEOUT [NAMESPACE]]
+				args := c.RemainingArgs()
+				if len(args) < 1 || len(args) > 3 {
+					return nil, c.ArgErr()
+				}
+				b, err := newBackend(args[0])
+				if err != nil {
+					return nil, err
+				}
+				timeout := defaultBackendTimeout
+				if len(args) > 1 {
+					timeout, err = time.ParseDuration(args[1])
+					if err != nil {
+						return nil, err
+					}
+					if timeout <= 0 {
+						return nil, fmt.Errorf("backend timeout must be positive: %s", args[1])
+					}
+				}
+				WithBackend(b, timeout)(ca)
+				if len(args) > 2 {
+					WithBackendNamespace(args[2])(ca)
+				}
+			case "persist":
+				// persist FILE [INTERVAL]
+				args := c.RemainingArgs()
+				if len(args) < 1 || len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				ca.persistFile = args[0]
+				if !filepath.IsAbs(ca.persistFile) {
+					ca.persistFile = filepath.Join(dnsserver.GetConfig(c).Root, ca.persistFile)
+				}
+				ca.persistInterval = defaultPersistInterval
+				if len(args) > 1 {
+					d, err := time.ParseDuration(args[1])
+					if err != nil {
+						return nil, err
+					}
+					if d <= 0 {
+						return nil, fmt.Errorf("persist interval must be positive: %s", args[1])
+					}
+					ca.persistInterval = d
+				}
 			default:
 				return nil, c.ArgErr()
 			}
@@ -252,8 +420,16 @@ func cacheParse(c *caddy.Controller) (*Cache, error) {
 
 		ca.Zones = origins
 		ca.zonesMetricLabel = strings.Join(origins, ",")
-		ca.pcache = cache.New(ca.pcap)
-		ca.ncache = cache.New(ca.ncap)
+		if admission {
+			ca.admission = newTinyLFU(ca.pcap + ca.ncap)
+		}
+		ca.newCaches()
+		if ca.ecs {
+			ca.ecsScopes = cache.New(ca.pcap)
+		}
+		if aggressiveNSEC {
+			ca.nsec = newNSECCache(ca.ncap)
+		}
 	}
 
 	return ca, nil
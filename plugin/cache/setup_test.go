@@ -3,11 +3,18 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
 )
 
 func TestSetup(t *testing.T) {
@@ -234,6 +241,47 @@ func TestDisable(t *testing.T) {
 	}
 }
 
+// TestDisableClientAnswers tests a next plugin that answers depending on the client, as kubernetes does with
+// topology_aware: the answer for the first client is given to the others, unless the success cache is disabled.
+func TestDisableClientAnswers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"cache cluster.local", "172.0.1.1"},
+		{"cache cluster.local {\ndisable success cluster.local\n}", "172.0.1.2"},
+	}
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		ca, err := cacheParse(c)
+		if err != nil {
+			t.Fatalf("Test %v: Expected no error but found error: %v", i, err)
+		}
+		ca.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			state := request.Request{W: w, Req: r}
+			addr := "172.0.1.1"
+			if state.IP() == "10.240.0.2" {
+				addr = "172.0.1.2"
+			}
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Answer = []dns.RR{test.A(r.Question[0].Name + " 30 IN A " + addr)}
+			w.WriteMsg(m)
+			return dns.RcodeSuccess, nil
+		})
+
+		var answer string
+		for _, client := range []string{"10.240.0.1", "10.240.0.2"} {
+			rec := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: client})
+			ca.ServeDNS(context.TODO(), rec, nsecQuery("zones.testns.svc.cluster.local.", dns.TypeA, false))
+			answer = rec.Msg.Answer[0].(*dns.A).A.String()
+		}
+		if answer != tc.expected {
+			t.Errorf("Test %v: Expected %s for the second client, got %s", i, tc.expected, answer)
+		}
+	}
+}
+
 func TestKeepttl(t *testing.T) {
 	tests := []struct {
 		input     string
@@ -262,3 +310,257 @@ func TestKeepttl(t *testing.T) {
 		}
 	}
 }
+
+func TestSetupPersist(t *testing.T) {
+	tests := []struct {
+		input            string
+		shouldErr        bool
+		expectedFile     string
+		expectedInterval time.Duration
+	}{
+		{"persist /var/lib/coredns/cache", false, "/var/lib/coredns/cache", defaultPersistInterval},
+		{"persist /var/lib/coredns/cache 1m", false, "/var/lib/coredns/cache", time.Minute},
+		// negative
+		{"persist", true, "", 0},
+		{"persist /var/lib/coredns/cache 0s", true, "", 0},
+		{"persist /var/lib/coredns/cache -1m", true, "", 0},
+		{"persist /var/lib/coredns/cache 1m arg3", true, "", 0},
+		{"persist /var/lib/coredns/cache invalid", true, "", 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr {
+			continue
+		}
+		if ca.persistFile != test.expectedFile {
+			t.Errorf("Test %v: Expected file %q but found %q", i, test.expectedFile, ca.persistFile)
+		}
+		if ca.persistInterval != test.expectedInterval {
+			t.Errorf("Test %v: Expected interval %v but found %v", i, test.expectedInterval, ca.persistInterval)
+		}
+	}
+}
+
+func TestSetupECS(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+	}{
+		{"ecs", false},
+		// negative
+		{"ecs arg1", true},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr {
+			continue
+		}
+		if !ca.ecs || ca.ecsScopes == nil {
+			t.Errorf("Test %v: Expected ecs enabled but disabled", i)
+		}
+	}
+}
+
+func TestSetupAdmin(t *testing.T) {
+	tests := []struct {
+		input         string
+		shouldErr     bool
+		expectedAddr  string
+		expectedToken string
+	}{
+		{"admin :8182 s3cret", false, ":8182", "s3cret"},
+		{"admin 127.0.0.1:8182 s3cret", false, "127.0.0.1:8182", "s3cret"},
+		// negative
+		{"admin", true, "", ""},
+		{"admin :8182", true, "", ""},
+		{"admin 8182 s3cret", true, "", ""},
+		{"admin :8182 s3cret arg3", true, "", ""},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr {
+			continue
+		}
+		if ca.adminAddr != test.expectedAddr {
+			t.Errorf("Test %v: Expected address %q but found %q", i, test.expectedAddr, ca.adminAddr)
+		}
+		if ca.adminToken != test.expectedToken {
+			t.Errorf("Test %v: Expected token %q but found %q", i, test.expectedToken, ca.adminToken)
+		}
+	}
+}
+
+func TestSetupEarlyRefresh(t *testing.T) {
+	tests := []struct {
+		input        string
+		shouldErr    bool
+		expectedBeta float64
+	}{
+		{"early_refresh", false, 1},
+		{"early_refresh 2.5", false, 2.5},
+		// negative
+		{"early_refresh 0", true, 0},
+		{"early_refresh -1", true, 0},
+		{"early_refresh abc", true, 0},
+		{"early_refresh 1 2", true, 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr {
+			continue
+		}
+		if ca.earlyRefresh != test.expectedBeta {
+			t.Errorf("Test %v: Expected beta %v but found %v", i, test.expectedBeta, ca.earlyRefresh)
+		}
+	}
+}
+
+func TestSetupAggressiveNSEC(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		expected  bool
+	}{
+		{"", false, false},
+		{"aggressive_nsec", false, true},
+		// negative
+		{"aggressive_nsec yes", true, false},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr {
+			continue
+		}
+		if (ca.nsec != nil) != test.expected {
+			t.Errorf("Test %v: Expected aggressive NSEC %t but found %t", i, test.expected, ca.nsec != nil)
+		}
+	}
+}
+
+func TestSetupMaxBytes(t *testing.T) {
+	tests := []struct {
+		input          string
+		shouldErr      bool
+		expectedPbytes int
+		expectedNbytes int
+		admission      bool
+	}{
+		{"", false, 0, 0, false},
+		{"max_bytes success 64M", false, 64 << 20, 0, false},
+		{"max_bytes denial 2097152", false, 0, 2 << 20, false},
+		{"max_bytes success 1g\nmax_bytes denial 16m", false, 1 << 30, 16 << 20, false},
+		{"admission", false, 0, 0, true},
+		{"max_bytes success 8192K\nadmission", false, 8 << 20, 0, true},
+		// negative
+		{"max_bytes success", true, 0, 0, false},
+		{"max_bytes 64M", true, 0, 0, false},
+		{"max_bytes error 64M", true, 0, 0, false},
+		{"max_bytes success 64X", true, 0, 0, false},
+		{"max_bytes success -1M", true, 0, 0, false},
+		{"max_bytes success 1K", true, 0, 0, false},
+		{"admission tinylfu", true, 0, 0, false},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr {
+			continue
+		}
+		if ca.pbytes != test.expectedPbytes {
+			t.Errorf("Test %v: Expected success max bytes %d but found %d", i, test.expectedPbytes, ca.pbytes)
+		}
+		if ca.nbytes != test.expectedNbytes {
+			t.Errorf("Test %v: Expected denial max bytes %d but found %d", i, test.expectedNbytes, ca.nbytes)
+		}
+		if (ca.admission != nil) != test.admission {
+			t.Errorf("Test %v: Expected admission %t but found %t", i, test.admission, ca.admission != nil)
+		}
+	}
+}
+
+func TestSetupBackend(t *testing.T) {
+	tests := []struct {
+		input           string
+		shouldErr       bool
+		expectedTimeout time.Duration
+	}{
+		{"", false, 0},
+		{"backend redis://localhost:6379", false, defaultBackendTimeout},
+		{"backend redis://:pass@10.0.0.1/1 200ms", false, 200 * time.Millisecond},
+		{"backend redis://localhost 1s shared", false, time.Second},
+		// negative
+		{"backend", true, 0},
+		{"backend localhost:6379", true, 0},
+		{"backend memcache://localhost", true, 0},
+		{"backend redis://localhost 0s", true, 0},
+		{"backend redis://localhost abc", true, 0},
+		{"backend redis://localhost 1s ns 2s", true, 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr {
+			continue
+		}
+		if (ca.backend != nil) != (test.expectedTimeout > 0) {
+			t.Errorf("Test %v: Expected backend %t but found %t", i, test.expectedTimeout > 0, ca.backend != nil)
+		}
+		if ca.backendTimeout != test.expectedTimeout {
+			t.Errorf("Test %v: Expected timeout %v but found %v", i, test.expectedTimeout, ca.backendTimeout)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+)
+
+// tinyLFU is the admission policy of TinyLFU ("TinyLFU: A Highly Efficient Cache Admission Policy"): a reply
+// only gets into a full cache when its name is asked for more often than the one of the item it evicts. How
+// often a name is asked for while it is not in the cache is estimated with a count-min sketch of the misses,
+// for the items in the cache their frequency counters are used. This keeps one-hit wonders from pushing out
+// popular items.
+type tinyLFU struct {
+	mu     sync.Mutex
+	rows   [sketchDepth][]uint8
+	mask   uint64
+	adds   int
+	sample int // after this many misses the counters are halved, so old popularity fades
+}
+
+const (
+	sketchDepth = 4
+	sketchMax   = 15 // counters saturate here, as 4 bit counters would
+)
+
+// newTinyLFU returns a tinyLFU to keep track of the misses of a cache with size items.
+func newTinyLFU(size int) *tinyLFU {
+	width := 1024
+	for width < size {
+		width <<= 1
+	}
+	t := &tinyLFU{mask: uint64(width - 1), sample: 10 * width}
+	for i := range t.rows {
+		t.rows[i] = make([]uint8, width)
+	}
+	return t
+}
+
+// increment records a miss for key.
+func (t *tinyLFU) increment(key uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.rows {
+		if c := &t.rows[i][t.index(key, i)]; *c < sketchMax {
+			*c++
+		}
+	}
+	t.adds++
+	if t.adds >= t.sample {
+		for i := range t.rows {
+			for j := range t.rows[i] {
+				t.rows[i][j] >>= 1
+			}
+		}
+		t.adds /= 2
+	}
+}
+
+// estimate returns the estimated number of misses for key.
+func (t *tinyLFU) estimate(key uint64) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := uint8(sketchMax)
+	for i := range t.rows {
+		n = min(n, t.rows[i][t.index(key, i)])
+	}
+	return int(n)
+}
+
+// index returns the counter for key in row i. The key is already a hash, it is mixed with the row so each
+// row has its own collisions.
+func (t *tinyLFU) index(key uint64, i int) uint64 {
+	h := key + uint64(i+1)*0x9e3779b97f4a7c15
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return h & t.mask
+}
+
+// sketchKey returns the key under which misses for name and qtype are counted.
+func sketchKey(name string, qtype uint16) uint64 {
+	return hash(strings.ToLower(name), qtype, false, false)
+}
+
+// admit implements cache.AdmitFunc. The reply el may evict victim when it missed more often than victim was hit,
+// or when victim has expired and can't be served stale.
+func (c *Cache) admit(_ uint64, el any, _ uint64, victim any) bool {
+	i, v := el.(*item), victim.(*item)
+	now := c.now().UTC()
+	if ttl := v.ttl(now); ttl <= 0 && -ttl >= int(c.staleUpTo.Seconds()) {
+		return true
+	}
+	return c.admission.estimate(sketchKey(i.Name, i.QType)) > v.Recent(c.duration, now)
+}
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+func TestTinyLFUEstimate(t *testing.T) {
+	s := newTinyLFU(1024)
+	key := sketchKey("example.org.", dns.TypeA)
+	for range 3 {
+		s.increment(key)
+	}
+	if n := s.estimate(key); n != 3 {
+		t.Errorf("Expected estimate of 3, got %d", n)
+	}
+	if n := s.estimate(sketchKey("Example.Org.", dns.TypeA)); n != 3 {
+		t.Errorf("Expected estimate to ignore case, got %d", n)
+	}
+	if n := s.estimate(sketchKey("example.org.", dns.TypeAAAA)); n != 0 {
+		t.Errorf("Expected estimate of 0 for another type, got %d", n)
+	}
+
+	for range 2 * sketchMax {
+		s.increment(key)
+	}
+	if n := s.estimate(key); n != sketchMax {
+		t.Errorf("Expected estimate to saturate at %d, got %d", sketchMax, n)
+	}
+
+	// Other misses make the counters fade.
+	for i := range s.sample {
+		s.increment(uint64(i))
+	}
+	if n := s.estimate(key); n >= sketchMax {
+		t.Errorf("Expected estimate to be halved, got %d", n)
+	}
+}
+
+func TestTinyLFUAdmit(t *testing.T) {
+	c := New()
+	c.admission = newTinyLFU(defaultCap)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	m := new(dns.Msg)
+	m.SetQuestion("new.example.org.", dns.TypeA)
+	candidate := newItem(m, now, time.Minute)
+
+	m.SetQuestion("popular.example.org.", dns.TypeA)
+	victim := newItem(m, now, time.Minute)
+	for range 3 {
+		victim.Update(c.duration, now)
+	}
+
+	c.admission.increment(sketchKey(candidate.Name, candidate.QType))
+	if c.admit(0, candidate, 1, victim) {
+		t.Error("Expected a one-hit wonder not to evict a popular item")
+	}
+	for range 3 {
+		c.admission.increment(sketchKey(candidate.Name, candidate.QType))
+	}
+	if !c.admit(0, candidate, 1, victim) {
+		t.Error("Expected an item that missed more often to evict a less popular item")
+	}
+
+	c.admission = newTinyLFU(defaultCap)
+	if c.admit(0, candidate, 1, victim) {
+		t.Error("Expected an item without misses not to evict a popular item")
+	}
+	now = now.Add(2 * time.Minute)
+	if !c.admit(0, candidate, 1, victim) {
+		t.Error("Expected an expired item to be evicted")
+	}
+}
+
+// txtBackend replies with a TXT record of size bytes for every query.
+func txtBackend(size int) plugin.Handler {
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Response, m.RecursionAvailable = true, true
+		txt := &dns.TXT{Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300}}
+		for ; size > 0; size -= 250 {
+			txt.Txt = append(txt.Txt, strings.Repeat("a", min(size, 250)))
+		}
+		m.Answer = []dns.RR{txt}
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+}
+
+func TestCacheMaxBytes(t *testing.T) {
+	// Each shard holds 2048 bytes.
+	c := NewCache("", "", WithMaxBytes(2048*256, 0))
+
+	tests := []struct {
+		name   string
+		size   int
+		stored bool
+	}{
+		{"small.example.org.", 500, true},
+		{"big.example.org.", 4000, false},
+	}
+	for _, tc := range tests {
+		c.Next = txtBackend(tc.size)
+		req := new(dns.Msg)
+		req.SetQuestion(tc.name, dns.TypeTXT)
+		c.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+
+		state := request.Request{W: &test.ResponseWriter{}, Req: req}
+		if stored := c.exists(state) != nil; stored != tc.stored {
+			t.Errorf("%s: expected stored %t, got %t", tc.name, tc.stored, stored)
+		}
+	}
+	if b := c.pcache.Bytes(); b < 500 || b > 2048 {
+		t.Errorf("Expected bytes of the small reply to be accounted, got %d", b)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/etcd/msg"
 	"github.com/coredns/coredns/plugin/pkg/fall"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/plugin/pkg/upstream"
 	"github.com/coredns/coredns/request"
 
@@ -28,6 +29,8 @@ const (
 	etcdTimeout        = 5 * time.Second
 )
 
+var log = clog.NewWithPlugin("etcd")
+
 var errKeyNotFound = errors.New("key not found")
 
 // Etcd is a plugin talks to an etcd cluster.
@@ -41,7 +44,12 @@ type Etcd struct {
 	MinLeaseTTL uint32 // minimum TTL for lease-based records
 	MaxLeaseTTL uint32 // maximum TTL for lease-based records
 
+	Update     bool     // accept dynamic updates
+	UpdateKeys []string // TSIG keys that may update, any key when empty
+	tsigSecret map[string]string
+
 	endpoints []string // Stored here as well, to aid in testing.
+	index     *index   // When set, queries are answered from this instead of etcd.
 }
 
 // Services implements the ServiceBackend interface.
@@ -76,15 +84,19 @@ func (e *Etcd) Records(ctx context.Context, state request.Request, exact bool) (
 	name := state.Name()
 
 	path, star := msg.PathWithWildcard(name, e.PathPrefix)
-	r, err := e.get(ctx, path, !exact)
+	kvs, err := e.get(ctx, path, !exact)
 	if err != nil {
 		return nil, err
 	}
 	segments := strings.Split(msg.Path(name, e.PathPrefix), "/")
-	return e.loopNodes(r.Kvs, segments, star, state.QType())
+	return e.loopNodes(kvs, segments, star, state.QType())
 }
 
-func (e *Etcd) get(ctx context.Context, path string, recursive bool) (*etcdcv3.GetResponse, error) {
+func (e *Etcd) get(ctx context.Context, path string, recursive bool) ([]*mvccpb.KeyValue, error) {
+	if e.index != nil && e.index.ready() {
+		return e.index.get(path, recursive)
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, etcdTimeout)
 	defer cancel()
 	if recursive {
@@ -105,7 +117,7 @@ func (e *Etcd) get(ctx context.Context, path string, recursive bool) (*etcdcv3.G
 				return nil, errKeyNotFound
 			}
 		}
-		return r, nil
+		return r.Kvs, nil
 	}
 
 	r, err := e.Client.Get(ctx, path)
@@ -115,7 +127,7 @@ func (e *Etcd) get(ctx context.Context, path string, recursive bool) (*etcdcv3.G
 	if r.Count == 0 {
 		return nil, errKeyNotFound
 	}
-	return r, nil
+	return r.Kvs, nil
 }
 
 func (e *Etcd) loopNodes(kv []*mvccpb.KeyValue, nameParts []string, star bool, qType uint16) (sx []msg.Service, err error) {
@@ -217,8 +229,19 @@ func shouldInclude(serv *msg.Service, qType uint16) bool {
 	return (qType == dns.TypeTXT && serv.Text != "") || serv.Host != ""
 }
 
+// OnStartup starts the index when it is enabled.
+func (e *Etcd) OnStartup() error {
+	if e.index != nil {
+		e.index.start()
+	}
+	return nil
+}
+
 // OnShutdown shuts down etcd client when caddy instance restart
 func (e *Etcd) OnShutdown() error {
+	if e.index != nil {
+		e.index.stop()
+	}
 	if e.Client != nil {
 		e.Client.Close()
 	}
@@ -0,0 +1,244 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	etcdcv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	indexRetry    = 2 * time.Second // wait time before listing or watching again after an error
+	indexProgress = 5 * time.Second // interval of the progress requests on the watch
+)
+
+var errWatchClosed = errors.New("watch closed")
+
+// index is an in-memory copy of the keys under the path of the plugin. It is loaded with a single Get and kept up
+// to date with a Watch from the revision of that Get, so that queries are answered without going to etcd. When
+// etcd has compacted the revision the watch is at, the keys are loaded again.
+type index struct {
+	client indexClient
+	prefix string
+
+	mu       sync.RWMutex
+	kvs      []*mvccpb.KeyValue // sorted by key
+	revision int64              // revision of the last applied change, zero when not loaded yet
+	synced   time.Time          // last time the index was known to be up to date
+
+	cancel context.CancelFunc
+}
+
+// indexClient is the part of the etcd client used by the index.
+type indexClient interface {
+	Get(ctx context.Context, key string, opts ...etcdcv3.OpOption) (*etcdcv3.GetResponse, error)
+	Watch(ctx context.Context, key string, opts ...etcdcv3.OpOption) etcdcv3.WatchChan
+	RequestProgress(ctx context.Context) error
+}
+
+func newIndex(client indexClient, pathPrefix string) *index {
+	return &index{client: client, prefix: "/" + pathPrefix + "/"}
+}
+
+// start loads the index and starts the watch in the background.
+func (i *index) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	i.cancel = cancel
+	go i.run(ctx)
+}
+
+// stop stops the watch.
+func (i *index) stop() {
+	if i.cancel != nil {
+		i.cancel()
+	}
+}
+
+func (i *index) run(ctx context.Context) {
+	list := true
+	for {
+		if list {
+			if err := i.list(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Warningf("Failed to load %q: %s", i.prefix, err)
+				if !i.wait(ctx) {
+					return
+				}
+				continue
+			}
+		}
+
+		err := i.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		// Only a compaction requires a new list, for other errors the watch resumes from the current revision.
+		list = errors.Is(err, rpctypes.ErrCompacted)
+		log.Warningf("Watch on %q stopped at revision %d: %s", i.prefix, i.rev(), err)
+		if !list && !i.wait(ctx) {
+			return
+		}
+	}
+}
+
+// wait waits before the next attempt, it returns false if ctx is done.
+func (i *index) wait(ctx context.Context) bool {
+	i.updateLag()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(indexRetry):
+		return true
+	}
+}
+
+// list replaces the contents of the index with the keys under the prefix.
+func (i *index) list(ctx context.Context) error {
+	lctx, cancel := context.WithTimeout(ctx, etcdTimeout)
+	defer cancel()
+	r, err := i.client.Get(lctx, i.prefix, etcdcv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+
+	// etcd returns the keys sorted, this makes sure we don't depend on it.
+	kvs := slices.SortedFunc(slices.Values(r.Kvs), compareKeyValue)
+
+	i.mu.Lock()
+	i.kvs = kvs
+	i.revision = r.Header.Revision
+	i.synced = time.Now()
+	i.mu.Unlock()
+
+	indexListCount.Inc()
+	indexRevision.Set(float64(r.Header.Revision))
+	i.updateLag()
+	return nil
+}
+
+// watch applies the changes under the prefix after the revision of the index, until an error occurs.
+func (i *index) watch(ctx context.Context) error {
+	wctx, cancel := context.WithCancel(etcdcv3.WithRequireLeader(ctx))
+	defer cancel()
+	wch := i.client.Watch(wctx, i.prefix, etcdcv3.WithPrefix(), etcdcv3.WithRev(i.rev()+1), etcdcv3.WithProgressNotify())
+
+	tick := time.NewTicker(indexProgress)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+			i.updateLag()
+			// The response to this comes in on wch and tells us we've seen all changes up to its revision.
+			if err := i.client.RequestProgress(wctx); err != nil {
+				log.Debugf("Failed to request progress on %q: %s", i.prefix, err)
+			}
+		case wr, ok := <-wch:
+			if !ok {
+				return errWatchClosed
+			}
+			if err := wr.Err(); err != nil {
+				return err
+			}
+			i.apply(wr.Events, wr.Header.Revision)
+		}
+	}
+}
+
+// apply applies events to the index, rev is the revision of the response the events came in.
+func (i *index) apply(events []*etcdcv3.Event, rev int64) {
+	i.mu.Lock()
+	for _, ev := range events {
+		pos, found := i.search(string(ev.Kv.Key))
+		switch ev.Type {
+		case mvccpb.PUT:
+			if found {
+				i.kvs[pos] = ev.Kv
+				continue
+			}
+			i.kvs = slices.Insert(i.kvs, pos, ev.Kv)
+		case mvccpb.DELETE:
+			if found {
+				i.kvs = slices.Delete(i.kvs, pos, pos+1)
+			}
+		}
+	}
+	if rev > i.revision {
+		i.revision = rev
+	}
+	i.synced = time.Now()
+	rev = i.revision
+	i.mu.Unlock()
+
+	indexRevision.Set(float64(rev))
+	i.updateLag()
+}
+
+// rev returns the revision of the index.
+func (i *index) rev() int64 {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.revision
+}
+
+// ready returns true if the index has been loaded.
+func (i *index) ready() bool {
+	return i.rev() > 0
+}
+
+func (i *index) updateLag() {
+	i.mu.RLock()
+	synced := i.synced
+	i.mu.RUnlock()
+	if synced.IsZero() {
+		return
+	}
+	indexSyncLag.Set(time.Since(synced).Seconds())
+}
+
+// get returns the keys for path in the same way as Etcd.get does with etcd.
+func (i *index) get(path string, recursive bool) ([]*mvccpb.KeyValue, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if recursive {
+		prefix := path
+		if !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		start, _ := i.search(prefix)
+		end := start
+		for end < len(i.kvs) && strings.HasPrefix(string(i.kvs[end].Key), prefix) {
+			end++
+		}
+		if end > start {
+			return slices.Clone(i.kvs[start:end]), nil
+		}
+		path = strings.TrimSuffix(prefix, "/")
+	}
+
+	pos, found := i.search(path)
+	if !found {
+		return nil, errKeyNotFound
+	}
+	return []*mvccpb.KeyValue{i.kvs[pos]}, nil
+}
+
+// search returns the position of key in the index, or where it would be inserted, and if it was found.
+func (i *index) search(key string) (int, bool) {
+	return slices.BinarySearchFunc(i.kvs, key, func(kv *mvccpb.KeyValue, key string) int {
+		return strings.Compare(string(kv.Key), key)
+	})
+}
+
+func compareKeyValue(a, b *mvccpb.KeyValue) int { return bytes.Compare(a.Key, b.Key) }
@@ -0,0 +1,73 @@
+//go:build etcd
+
+package etcd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/etcd/msg"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestIndex(t *testing.T) {
+	etc := newEtcdPlugin()
+	set(t, etc, "a.index.skydns.test.", 0, &msg.Service{Host: "10.0.0.1", Key: "a.index.skydns.test."})
+	defer delete(t, etc, "a.index.skydns.test.")
+
+	etc.index = newIndex(etc.Client, etc.PathPrefix)
+	etc.OnStartup()
+	defer etc.index.stop()
+	waitFor(t, func() bool { return etc.index.ready() })
+
+	// Changes made after the index was loaded come in with the watch.
+	set(t, etc, "b.index.skydns.test.", 0, &msg.Service{Host: "10.0.0.2", Key: "b.index.skydns.test."})
+	defer delete(t, etc, "b.index.skydns.test.")
+	waitFor(t, func() bool {
+		_, err := etc.index.get(msg.Path("b.index.skydns.test.", etc.PathPrefix), false)
+		return err == nil
+	})
+
+	delete(t, etc, "a.index.skydns.test.")
+	waitFor(t, func() bool {
+		_, err := etc.index.get(msg.Path("a.index.skydns.test.", etc.PathPrefix), false)
+		return err != nil
+	})
+
+	tests := []test.Case{
+		{
+			Qname: "index.skydns.test.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("index.skydns.test.	300	IN	A	10.0.0.2")},
+		},
+		{
+			Qname: "b.index.skydns.test.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("b.index.skydns.test.	300	IN	A	10.0.0.2")},
+		},
+		{
+			Qname: "a.index.skydns.test.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{test.SOA("skydns.test.	30	IN	SOA	ns.dns.skydns.test. hostmaster.skydns.test. 0 0 0 0 0")},
+		},
+	}
+	for i, tc := range tests {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		etc.ServeDNS(ctxt, rec, tc.Msg())
+		if err := test.SortAndCheck(rec.Msg, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func waitFor(t *testing.T, f func() bool) {
+	t.Helper()
+	for range 100 {
+		if f() {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for the index")
+}
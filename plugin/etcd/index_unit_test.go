@@ -0,0 +1,180 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	etcdcv3 "go.etcd.io/etcd/client/v3"
+)
+
+// fakeIndexClient returns the responses in gets for successive calls to Get, and the channels in watches for
+// successive calls to Watch. Calls beyond those block until the context is done.
+type fakeIndexClient struct {
+	mu      sync.Mutex
+	gets    []*etcdcv3.GetResponse
+	watches []chan etcdcv3.WatchResponse
+	listed  int
+}
+
+func (f *fakeIndexClient) Get(ctx context.Context, _ string, _ ...etcdcv3.OpOption) (*etcdcv3.GetResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.listed >= len(f.gets) {
+		return nil, errors.New("no more responses")
+	}
+	f.listed++
+	return f.gets[f.listed-1], nil
+}
+
+func (f *fakeIndexClient) Watch(ctx context.Context, _ string, _ ...etcdcv3.OpOption) etcdcv3.WatchChan {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.watches) == 0 {
+		return make(chan etcdcv3.WatchResponse)
+	}
+	wch := f.watches[0]
+	f.watches = f.watches[1:]
+	return wch
+}
+
+func (f *fakeIndexClient) RequestProgress(context.Context) error { return nil }
+
+func (f *fakeIndexClient) lists() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.listed
+}
+
+func getResponse(rev int64, keys ...string) *etcdcv3.GetResponse {
+	r := &etcdcv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: rev}}
+	for _, k := range keys {
+		r.Kvs = append(r.Kvs, &mvccpb.KeyValue{Key: []byte(k), Value: []byte(k)})
+	}
+	return r
+}
+
+func event(typ mvccpb.Event_EventType, key, value string) *etcdcv3.Event {
+	return &etcdcv3.Event{Type: typ, Kv: &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value)}}
+}
+
+func indexKeys(i *index) []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	var keys []string
+	for _, kv := range i.kvs {
+		keys = append(keys, string(kv.Key)+"="+string(kv.Value))
+	}
+	return keys
+}
+
+func TestIndexApply(t *testing.T) {
+	i := newIndex(nil, "skydns")
+	i.apply([]*etcdcv3.Event{
+		event(mvccpb.PUT, "/skydns/test/b", "b1"),
+		event(mvccpb.PUT, "/skydns/test/a", "a1"),
+		event(mvccpb.PUT, "/skydns/test/c", "c1"),
+		event(mvccpb.DELETE, "/skydns/test/a", ""),
+		event(mvccpb.PUT, "/skydns/test/a", "a2"),
+		event(mvccpb.PUT, "/skydns/test/b", "b2"),
+		event(mvccpb.DELETE, "/skydns/test/c", ""),
+		event(mvccpb.DELETE, "/skydns/test/d", ""),
+	}, 10)
+
+	expected := []string{"/skydns/test/a=a2", "/skydns/test/b=b2"}
+	keys := indexKeys(i)
+	if len(keys) != len(expected) {
+		t.Fatalf("Expected keys %v, got %v", expected, keys)
+	}
+	for j := range expected {
+		if keys[j] != expected[j] {
+			t.Errorf("Expected keys %v, got %v", expected, keys)
+			break
+		}
+	}
+
+	// A response for an older revision doesn't move the revision back.
+	i.apply(nil, 5)
+	if rev := i.rev(); rev != 10 {
+		t.Errorf("Expected revision 10, got %d", rev)
+	}
+}
+
+func TestIndexGet(t *testing.T) {
+	i := newIndex(nil, "skydns")
+	var events []*etcdcv3.Event
+	for _, k := range []string{"/skydns/test/a", "/skydns/test/a/x", "/skydns/test/a/y", "/skydns/test/ab", "/skydns/test/b"} {
+		events = append(events, event(mvccpb.PUT, k, k))
+	}
+	i.apply(events, 1)
+
+	tests := []struct {
+		path      string
+		recursive bool
+		expected  []string
+	}{
+		{"/skydns/test/a", false, []string{"/skydns/test/a"}},
+		// The keys below a/, but not the key itself or its sibling ab.
+		{"/skydns/test/a", true, []string{"/skydns/test/a/x", "/skydns/test/a/y"}},
+		{"/skydns/test/a/", true, []string{"/skydns/test/a/x", "/skydns/test/a/y"}},
+		// Without keys below it, the key itself.
+		{"/skydns/test/b", true, []string{"/skydns/test/b"}},
+		{"/skydns/test/c", true, nil},
+		{"/skydns/test/c", false, nil},
+	}
+	for j, tc := range tests {
+		kvs, err := i.get(tc.path, tc.recursive)
+		if tc.expected == nil {
+			if !errors.Is(err, errKeyNotFound) {
+				t.Errorf("Test %d: expected %v, got %v", j, errKeyNotFound, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error, got %v", j, err)
+			continue
+		}
+		if len(kvs) != len(tc.expected) {
+			t.Errorf("Test %d: expected %v, got %d keys", j, tc.expected, len(kvs))
+			continue
+		}
+		for k := range kvs {
+			if string(kvs[k].Key) != tc.expected[k] {
+				t.Errorf("Test %d: expected %v, got %q at %d", j, tc.expected, kvs[k].Key, k)
+			}
+		}
+	}
+}
+
+func TestIndexCompacted(t *testing.T) {
+	compacted := make(chan etcdcv3.WatchResponse, 1)
+	compacted <- etcdcv3.WatchResponse{CompactRevision: 15}
+	client := &fakeIndexClient{
+		gets:    []*etcdcv3.GetResponse{getResponse(10, "/skydns/test/a"), getResponse(20, "/skydns/test/b")},
+		watches: []chan etcdcv3.WatchResponse{compacted},
+	}
+	i := newIndex(client, "skydns")
+	i.start()
+	defer i.stop()
+
+	// A compaction makes the index list the keys again right away, without waiting for indexRetry.
+	for range 100 {
+		if i.rev() == 20 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rev := i.rev(); rev != 20 {
+		t.Fatalf("Expected the index to be loaded again at revision 20, got %d", rev)
+	}
+	if n := client.lists(); n != 2 {
+		t.Errorf("Expected 2 lists, got %d", n)
+	}
+	if keys := indexKeys(i); len(keys) != 1 || keys[0] != "/skydns/test/b=/skydns/test/b" {
+		t.Errorf("Expected only the keys of the second list, got %v", keys)
+	}
+}
@@ -0,0 +1,32 @@
+package etcd
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// indexSyncLag is the time since the index was last known to be up to date with etcd.
+	indexSyncLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "etcd",
+		Name:      "index_sync_lag_seconds",
+		Help:      "Time since the in-memory index was last known to be up to date with etcd.",
+	})
+	// indexRevision is the etcd revision of the index.
+	indexRevision = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "etcd",
+		Name:      "index_revision",
+		Help:      "The etcd revision of the in-memory index.",
+	})
+	// indexListCount is the number of times the index was loaded from etcd.
+	indexListCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "etcd",
+		Name:      "index_lists_total",
+		Help:      "Counter of the number of times the in-memory index was loaded from etcd.",
+	})
+)
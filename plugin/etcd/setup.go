@@ -27,6 +27,7 @@ func setup(c *caddy.Controller) error {
 		return plugin.Error("etcd", err)
 	}
 
+	c.OnStartup(e.OnStartup)
 	c.OnShutdown(e.OnShutdown)
 
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
@@ -50,6 +51,7 @@ func etcdParse(c *caddy.Controller) (*Etcd, error) {
 		endpoints = []string{defaultEndpoint}
 		username  string
 		password  string
+		watch     bool
 	)
 
 	etc.Upstream = upstream.New()
@@ -116,18 +118,42 @@ func etcdParse(c *caddy.Controller) (*Etcd, error) {
 					return &Etcd{}, c.Errf("invalid max-lease-ttl value: %v", err)
 				}
 				etc.MaxLeaseTTL = maxLeaseTTL
+			case "update":
+				etc.Update = true
+				for _, k := range c.RemainingArgs() {
+					etc.UpdateKeys = append(etc.UpdateKeys, plugin.Name(k).Normalize())
+				}
+			case "watch":
+				if c.NextArg() {
+					return &Etcd{}, c.ArgErr()
+				}
+				watch = true
 			default:
 				if c.Val() != "}" {
 					return &Etcd{}, c.Errf("unknown property '%s'", c.Val())
 				}
 			}
 		}
+		if etc.Update {
+			if len(config.TsigSecret) == 0 {
+				return &Etcd{}, c.Err("update requires TSIG secrets, these are defined with the tsig plugin")
+			}
+			for _, k := range etc.UpdateKeys {
+				if _, ok := config.TsigSecret[k]; !ok {
+					return &Etcd{}, c.Errf("unknown TSIG key '%s'", k)
+				}
+			}
+			etc.tsigSecret = config.TsigSecret
+		}
 		client, err := newEtcdClient(endpoints, tlsConfig, username, password)
 		if err != nil {
 			return &Etcd{}, err
 		}
 		etc.Client = client
 		etc.endpoints = endpoints
+		if watch {
+			etc.index = newIndex(client, etc.PathPrefix)
+		}
 
 		return &etc, nil
 	}
@@ -3,10 +3,12 @@
 package etcd
 
 import (
+	"slices"
 	"strings"
 	"testing"
 
 	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
 )
 
 func TestSetupEtcd(t *testing.T) {
@@ -91,6 +93,22 @@ func TestSetupEtcd(t *testing.T) {
 		}
 			`, false, "skydns", []string{"http://localhost:2379"}, "", "", "",
 		},
+		// with the index
+		{
+			`etcd {
+			endpoint http://localhost:2379
+			watch
+		}
+			`, false, "skydns", []string{"http://localhost:2379"}, "", "", "",
+		},
+		// with an argument to watch
+		{
+			`etcd {
+			endpoint http://localhost:2379
+			watch yes
+		}
+			`, true, "skydns", []string{"http://localhost:2379"}, "Wrong argument count", "", "",
+		},
 	}
 
 	for i, test := range tests {
@@ -150,6 +168,9 @@ func TestSetupEtcd(t *testing.T) {
 					t.Errorf("MaxLeaseTTL not set correctly for input %s. Expected: 3600, actual: %d", test.input, etcd.MaxLeaseTTL)
 				}
 			}
+			if watch := strings.Contains(test.input, "watch"); watch != (etcd.index != nil) {
+				t.Errorf("Index not correctly set for input %s. Expected: %t, actual: %t", test.input, watch, etcd.index != nil)
+			}
 			if strings.Contains(test.input, "min-lease-ttl 120") && strings.Contains(test.input, "max-lease-ttl 7200") {
 				if etcd.MinLeaseTTL != 120 {
 					t.Errorf("MinLeaseTTL not set correctly for input %s. Expected: 120, actual: %d", test.input, etcd.MinLeaseTTL)
@@ -162,6 +183,45 @@ func TestSetupEtcd(t *testing.T) {
 	}
 }
 
+func TestSetupEtcdUpdate(t *testing.T) {
+	secrets := map[string]string{"key.example.org.": "NoTCJU+DMqFWywaPyxSijrDEA/eC3nK0xi3AMEZuPVk="}
+	tests := []struct {
+		input     string
+		secrets   map[string]string
+		shouldErr bool
+		keys      []string
+	}{
+		{"etcd {\n update\n}", secrets, false, nil},
+		{"etcd {\n update Key.Example.org\n}", secrets, false, []string{"key.example.org."}},
+		// no TSIG secrets
+		{"etcd {\n update\n}", nil, true, nil},
+		// unknown key
+		{"etcd {\n update other.example.org.\n}", secrets, true, nil},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		dnsserver.GetConfig(c).TsigSecret = test.secrets
+		etcd, err := etcdParse(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: Expected error but found none for input %s", i, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: Expected no error but found one for input %s. Error was: %v", i, test.input, err)
+			continue
+		}
+		if !etcd.Update {
+			t.Errorf("Test %d: Expected updates to be enabled", i)
+		}
+		if !slices.Equal(etcd.UpdateKeys, test.keys) {
+			t.Errorf("Test %d: Expected keys %v, got %v", i, test.keys, etcd.UpdateKeys)
+		}
+	}
+}
+
 func TestParseTTL(t *testing.T) {
 	tests := []struct {
 		input    string
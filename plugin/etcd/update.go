@@ -0,0 +1,522 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/etcd/msg"
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+
+	"github.com/miekg/dns"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	etcdcv3 "go.etcd.io/etcd/client/v3"
+)
+
+// updateRetries is the number of times an update is tried when the records it read are changed in etcd before
+// it could be applied.
+const updateRetries = 3
+
+var errUpdateConflict = errors.New("records changed in etcd during update")
+
+// serveUpdate applies the dynamic update (RFC 2136) in r to zone and writes the response.
+func (e *Etcd) serveUpdate(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, zone string) (int, error) {
+	rcode, err := e.update(ctx, w, r, zone)
+
+	m := new(dns.Msg)
+	m.SetRcode(r, rcode)
+	if t := r.IsTsig(); t != nil && w.TsigStatus() == nil {
+		m.SetTsig(t.Hdr.Name, t.Algorithm, t.Fudge, time.Now().Unix())
+	}
+	w.WriteMsg(m)
+	// Return success as the rcode to signal we have written to the client.
+	return dns.RcodeSuccess, err
+}
+
+func (e *Etcd) update(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, zone string) (int, error) {
+	if len(r.Question) != 1 || r.Question[0].Qtype != dns.TypeSOA {
+		return dns.RcodeFormatError, nil
+	}
+	if dns.CanonicalName(r.Question[0].Name) != zone {
+		return dns.RcodeNotAuth, nil
+	}
+	if !e.updateAllowed(w, r) {
+		return dns.RcodeRefused, nil
+	}
+	if rcode := updatePrescan(r.Answer, r.Ns, zone); rcode != dns.RcodeSuccess {
+		return rcode, nil
+	}
+
+	for range updateRetries {
+		rcode, err := e.updateTxn(ctx, r, zone)
+		if !errors.Is(err, errUpdateConflict) {
+			return rcode, err
+		}
+	}
+	return dns.RcodeServerFailure, errUpdateConflict
+}
+
+// updateAllowed returns true if r is signed with a valid TSIG key that may update the zones.
+func (e *Etcd) updateAllowed(w dns.ResponseWriter, r *dns.Msg) bool {
+	t := r.IsTsig()
+	if t == nil || w.TsigStatus() != nil {
+		return false
+	}
+	// Without secrets the server doesn't verify the TSIG at all.
+	if _, ok := e.tsigSecret[t.Hdr.Name]; !ok {
+		return false
+	}
+	return len(e.UpdateKeys) == 0 || slices.Contains(e.UpdateKeys, plugin.Name(t.Hdr.Name).Normalize())
+}
+
+// updatePrescan checks the prerequisite and update sections of an update, see sections 3.2 and 3.4.1 of RFC 2136.
+func updatePrescan(prereqs, updates []dns.RR, zone string) int {
+	for _, rr := range prereqs {
+		h := rr.Header()
+		if h.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		if !dns.IsSubDomain(zone, h.Name) {
+			return dns.RcodeNotZone
+		}
+		if h.Class != dns.ClassINET && h.Class != dns.ClassANY && h.Class != dns.ClassNONE {
+			return dns.RcodeFormatError
+		}
+	}
+	for _, rr := range updates {
+		h := rr.Header()
+		if !dns.IsSubDomain(zone, h.Name) {
+			return dns.RcodeNotZone
+		}
+		switch h.Class {
+		case dns.ClassINET:
+			if h.Rrtype == dns.TypeANY {
+				return dns.RcodeFormatError
+			}
+			if srv, ok := rr.(*dns.SRV); ok && srv.Port == 0 {
+				// Without a port the record is stored as a CNAME, see updateRR.
+				return dns.RcodeRefused
+			}
+		case dns.ClassANY:
+			if h.Ttl != 0 {
+				return dns.RcodeFormatError
+			}
+			if h.Rrtype == dns.TypeANY {
+				continue
+			}
+		case dns.ClassNONE:
+			if h.Ttl != 0 {
+				return dns.RcodeFormatError
+			}
+		default:
+			return dns.RcodeFormatError
+		}
+		if !updateSupported(h.Rrtype, dns.CanonicalName(h.Name), zone) {
+			return dns.RcodeRefused
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// updateSupported returns true if records of type qtype for name can be updated.
+func updateSupported(qtype uint16, name, zone string) bool {
+	if name == zone {
+		// Only addresses are looked up in the apex, see plugin.A.
+		return qtype == dns.TypeA || qtype == dns.TypeAAAA
+	}
+	switch qtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypeTXT, dns.TypeSRV, dns.TypeMX:
+		return true
+	case dns.TypePTR:
+		return dnsutil.IsReverse(name) > 0
+	case dns.TypeCNAME:
+		return dnsutil.IsReverse(name) == 0
+	}
+	return false
+}
+
+// updateTxn reads the records of the names in r, checks the prerequisites and applies the updates in a single
+// etcd transaction. If the records were changed in etcd in the meantime errUpdateConflict is returned.
+func (e *Etcd) updateTxn(ctx context.Context, r *dns.Msg, zone string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, etcdTimeout)
+	defer cancel()
+
+	set, err := e.readUpdateSet(ctx, r, zone)
+	if err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	if rcode := set.prerequisites(r.Answer); rcode != dns.RcodeSuccess {
+		return rcode, nil
+	}
+	for _, rr := range r.Ns {
+		if rcode := set.apply(rr); rcode != dns.RcodeSuccess {
+			return rcode, nil
+		}
+	}
+
+	cmps, ops, err := set.txn()
+	if err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	if len(ops) == 0 {
+		return dns.RcodeSuccess, nil
+	}
+	resp, err := e.Client.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	if !resp.Succeeded {
+		return dns.RcodeServerFailure, errUpdateConflict
+	}
+	return dns.RcodeSuccess, nil
+}
+
+// updateSet holds the records of the names in an update, as read from etcd at a single revision.
+type updateSet struct {
+	rev   int64
+	names map[string]*updateName
+}
+
+// updateName holds the records of a name. For an update these are the ones in the key of the name and in the keys
+// directly below it that are named like the ones the update creates, see updateLabel. Other keys below it are
+// names of their own.
+type updateName struct {
+	base    string
+	kvs     []*mvccpb.KeyValue // all keys read from etcd for this name
+	records []*updateRecord
+}
+
+// updateRecord is a record of a name. Keys with data that can't be expressed as a single record have a nil rr,
+// these are left alone.
+type updateRecord struct {
+	key     string
+	rr      dns.RR
+	serv    *msg.Service
+	kv      *mvccpb.KeyValue // nil for records added in this update
+	changed bool
+}
+
+// updatePath returns the path of the records of name for updates. The addresses of the zone itself are kept in the
+// apex.dns entry, as plugin.A looks for them there.
+func (e *Etcd) updatePath(name, zone string) string {
+	if name == zone {
+		name = dnsutil.Join("apex.dns", zone)
+	}
+	return msg.Path(name, e.PathPrefix)
+}
+
+func (e *Etcd) readUpdateSet(ctx context.Context, r *dns.Msg, zone string) (*updateSet, error) {
+	var names []string
+	for _, rr := range slices.Concat(r.Answer, r.Ns) {
+		if name := dns.CanonicalName(rr.Header().Name); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	bases := make([]string, len(names))
+	ops := make([]etcdcv3.Op, len(names))
+	for i, name := range names {
+		bases[i] = e.updatePath(name, zone)
+		ops[i] = etcdcv3.OpGet(bases[i], etcdcv3.WithPrefix())
+	}
+	resp, err := e.Client.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	set := &updateSet{rev: resp.Header.Revision, names: make(map[string]*updateName, len(names))}
+	for i, name := range names {
+		n := &updateName{base: bases[i]}
+		for _, kv := range resp.Responses[i].GetResponseRange().Kvs {
+			key := string(kv.Key)
+			if key != n.base && (!strings.HasPrefix(key, n.base+"/") || !isUpdateLabel(key[len(n.base)+1:])) {
+				// A sibling that shares the prefix, or a name below this one.
+				continue
+			}
+			if key != n.base && slices.Contains(bases, key) {
+				// The key of another name in this update.
+				continue
+			}
+			n.kvs = append(n.kvs, kv)
+			rec := &updateRecord{key: key, kv: kv, serv: new(msg.Service)}
+			if err := json.Unmarshal(kv.Value, rec.serv); err == nil {
+				rec.rr = updateRR(name, rec.serv)
+			}
+			n.records = append(n.records, rec)
+		}
+		set.names[name] = n
+	}
+	return set, nil
+}
+
+// updateRR returns the record serv is for updates, or nil if there is none.
+func updateRR(name string, serv *msg.Service) dns.RR {
+	ip := net.ParseIP(serv.Host)
+	switch {
+	case serv.Host == "" && serv.Text != "":
+		return serv.NewTXT(name)
+	case serv.Host == "":
+		return nil
+	case ip != nil && ip.To4() != nil:
+		return serv.NewA(name, ip.To4())
+	case ip != nil:
+		return serv.NewAAAA(name, ip)
+	case serv.Mail:
+		return serv.NewMX(name)
+	case dnsutil.IsReverse(name) > 0:
+		return serv.NewPTR(name, serv.Host)
+	case serv.Port != 0:
+		return serv.NewSRV(name, uint16(serv.Weight))
+	}
+	return serv.NewCNAME(name, serv.Host)
+}
+
+// updateService returns the service that holds rr.
+func updateService(rr dns.RR) *msg.Service {
+	serv := &msg.Service{TTL: rr.Header().Ttl}
+	switch rr := rr.(type) {
+	case *dns.A:
+		serv.Host = rr.A.String()
+	case *dns.AAAA:
+		serv.Host = rr.AAAA.String()
+	case *dns.CNAME:
+		serv.Host = strings.TrimSuffix(rr.Target, ".")
+	case *dns.PTR:
+		serv.Host = strings.TrimSuffix(rr.Ptr, ".")
+	case *dns.TXT:
+		serv.Text = strings.Join(rr.Txt, "")
+	case *dns.MX:
+		serv.Host = strings.TrimSuffix(rr.Mx, ".")
+		serv.Priority = int(rr.Preference)
+		serv.Mail = true
+	case *dns.SRV:
+		serv.Host = strings.TrimSuffix(rr.Target, ".")
+		serv.Port = int(rr.Port)
+		serv.Priority = int(rr.Priority)
+		serv.Weight = int(rr.Weight)
+	}
+	return serv
+}
+
+// updateLabel returns the label of the key of a record added with an update, it only depends on the rdata of rr.
+func updateLabel(rr dns.RR) string {
+	h := fnv.New32a()
+	h.Write([]byte(strings.TrimPrefix(rr.String(), rr.Header().String())))
+	return fmt.Sprintf("%s-%08x", strings.ToLower(dns.TypeToString[rr.Header().Rrtype]), h.Sum32())
+}
+
+// isUpdateLabel returns true if label is a label as returned by updateLabel.
+func isUpdateLabel(label string) bool {
+	typ, hash, ok := strings.Cut(label, "-")
+	if !ok || len(hash) != 8 || strings.ToLower(typ) != typ {
+		return false
+	}
+	if _, ok := dns.StringToType[strings.ToUpper(typ)]; !ok {
+		return false
+	}
+	_, err := strconv.ParseUint(hash, 16, 32)
+	return err == nil && strings.ToLower(hash) == hash
+}
+
+// rrset returns the records of type qtype, dns.TypeANY returns all records.
+func (n *updateName) rrset(qtype uint16) []dns.RR {
+	var rrs []dns.RR
+	for _, rec := range n.records {
+		if rec.rr != nil && (qtype == dns.TypeANY || rec.rr.Header().Rrtype == qtype) {
+			rrs = append(rrs, rec.rr)
+		}
+	}
+	return rrs
+}
+
+// prerequisites checks the prerequisites, see section 3.2.5 of RFC 2136.
+func (s *updateSet) prerequisites(prereqs []dns.RR) int {
+	type nameType struct {
+		name  string
+		qtype uint16
+	}
+	var (
+		keys   []nameType
+		rrsets = map[nameType][]dns.RR{}
+	)
+	for _, rr := range prereqs {
+		h := rr.Header()
+		n := s.names[dns.CanonicalName(h.Name)]
+		switch h.Class {
+		case dns.ClassANY:
+			if h.Rrtype == dns.TypeANY {
+				if len(n.records) == 0 {
+					return dns.RcodeNameError
+				}
+				continue
+			}
+			if len(n.rrset(h.Rrtype)) == 0 {
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if h.Rrtype == dns.TypeANY {
+				if len(n.records) > 0 {
+					return dns.RcodeYXDomain
+				}
+				continue
+			}
+			if len(n.rrset(h.Rrtype)) > 0 {
+				return dns.RcodeYXRrset
+			}
+		default:
+			k := nameType{dns.CanonicalName(h.Name), h.Rrtype}
+			if _, ok := rrsets[k]; !ok {
+				keys = append(keys, k)
+			}
+			rrsets[k] = append(rrsets[k], rr)
+		}
+	}
+	for _, k := range keys {
+		if !sameRRset(rrsets[k], s.names[k.name].rrset(k.qtype)) {
+			return dns.RcodeNXRrset
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// sameRRset returns true if a and b hold the same records, ignoring their TTL.
+func sameRRset(a, b []dns.RR) bool {
+	contains := func(rrs []dns.RR, rr dns.RR) bool {
+		return slices.ContainsFunc(rrs, func(x dns.RR) bool { return dns.IsDuplicate(x, rr) })
+	}
+	for _, rr := range a {
+		if !contains(b, rr) {
+			return false
+		}
+	}
+	for _, rr := range b {
+		if !contains(a, rr) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply applies an update, see section 3.4.2 of RFC 2136.
+func (s *updateSet) apply(rr dns.RR) int {
+	h := rr.Header()
+	name := dns.CanonicalName(h.Name)
+	n := s.names[name]
+	switch h.Class {
+	case dns.ClassINET:
+		rr = dns.Copy(rr)
+		rr.Header().Name = name
+		return n.add(rr)
+	case dns.ClassANY:
+		n.remove(func(x dns.RR) bool { return h.Rrtype == dns.TypeANY || x.Header().Rrtype == h.Rrtype })
+	case dns.ClassNONE:
+		rr = dns.Copy(rr)
+		rr.Header().Class = dns.ClassINET
+		n.remove(func(x dns.RR) bool { return dns.IsDuplicate(x, rr) })
+	}
+	return dns.RcodeSuccess
+}
+
+// add adds rr to the records of n. Records that conflict with a CNAME are ignored, as RFC 2136 says. Addresses
+// next to MX or SRV records are refused, as the plugin would answer the address queries for the name with the
+// targets of these records as CNAMEs.
+func (n *updateName) add(rr dns.RR) int {
+	qtype := rr.Header().Rrtype
+	cname := len(n.rrset(dns.TypeCNAME)) > 0
+	if qtype == dns.TypeCNAME && len(n.records) > 0 && !cname {
+		return dns.RcodeSuccess
+	}
+	if qtype != dns.TypeCNAME && cname {
+		return dns.RcodeSuccess
+	}
+	switch qtype {
+	case dns.TypeA, dns.TypeAAAA:
+		if len(n.rrset(dns.TypeMX)) > 0 || len(n.rrset(dns.TypeSRV)) > 0 {
+			return dns.RcodeRefused
+		}
+	case dns.TypeMX, dns.TypeSRV:
+		if len(n.rrset(dns.TypeA)) > 0 || len(n.rrset(dns.TypeAAAA)) > 0 {
+			return dns.RcodeRefused
+		}
+	}
+
+	for _, rec := range n.records {
+		if rec.rr == nil || !dns.IsDuplicate(rec.rr, rr) {
+			continue
+		}
+		if rec.serv.TTL != rr.Header().Ttl {
+			rec.serv.TTL = rr.Header().Ttl
+			rec.rr.Header().Ttl = rr.Header().Ttl
+			rec.changed = true
+		}
+		return dns.RcodeSuccess
+	}
+	if qtype == dns.TypeCNAME {
+		// A name has a single CNAME, a new one replaces it.
+		n.remove(func(x dns.RR) bool { return true })
+	}
+	n.records = append(n.records, &updateRecord{key: n.base + "/" + updateLabel(rr), rr: rr, serv: updateService(rr), changed: true})
+	return dns.RcodeSuccess
+}
+
+// remove removes the records for which f returns true.
+func (n *updateName) remove(f func(dns.RR) bool) {
+	n.records = slices.DeleteFunc(n.records, func(rec *updateRecord) bool { return rec.rr != nil && f(rec.rr) })
+}
+
+// txn returns the compares and operations of the etcd transaction that applies the update. The compares make sure
+// none of the keys that were read have been changed, deleted or added.
+func (s *updateSet) txn() ([]etcdcv3.Cmp, []etcdcv3.Op, error) {
+	var (
+		cmps []etcdcv3.Cmp
+		ops  []etcdcv3.Op
+	)
+	for _, n := range s.names {
+		cmps = append(cmps, etcdcv3.Compare(etcdcv3.ModRevision(n.base), "<", s.rev+1).WithPrefix())
+		for _, kv := range n.kvs {
+			cmps = append(cmps, etcdcv3.Compare(etcdcv3.CreateRevision(string(kv.Key)), "=", kv.CreateRevision))
+		}
+
+		// A key with children is no longer looked up on its own, see "Special Behaviour" in the README, so a
+		// record in the key of the name itself moves below it when there are more.
+		if len(n.records) > 1 {
+			for _, rec := range n.records {
+				if rec.key == n.base && rec.rr != nil {
+					rec.key = n.base + "/" + updateLabel(rec.rr)
+					rec.changed = true
+				}
+			}
+		}
+
+		keep := map[string]struct{}{}
+		for _, rec := range n.records {
+			keep[rec.key] = struct{}{}
+			if !rec.changed {
+				continue
+			}
+			b, err := json.Marshal(rec.serv)
+			if err != nil {
+				return nil, nil, err
+			}
+			var opts []etcdcv3.OpOption
+			if rec.kv != nil && rec.kv.Lease != 0 {
+				opts = append(opts, etcdcv3.WithLease(etcdcv3.LeaseID(rec.kv.Lease)))
+			}
+			ops = append(ops, etcdcv3.OpPut(rec.key, string(b), opts...))
+		}
+		for _, kv := range n.kvs {
+			if _, ok := keep[string(kv.Key)]; !ok {
+				ops = append(ops, etcdcv3.OpDelete(string(kv.Key)))
+			}
+		}
+	}
+	return cmps, ops, nil
+}
@@ -0,0 +1,78 @@
+package etcd
+
+import (
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestUpdatePrescan(t *testing.T) {
+	tests := []struct {
+		rr    dns.RR
+		rcode int
+	}{
+		{test.A("a.skydns.test. 300 IN A 10.0.0.1"), dns.RcodeSuccess},
+		{test.SRV("_x._tcp.skydns.test. 300 IN SRV 10 10 8080 a.skydns.test."), dns.RcodeSuccess},
+		{test.SRV("_x._tcp.skydns.test. 300 IN SRV 10 10 0 a.skydns.test."), dns.RcodeRefused},
+		{test.NS("a.skydns.test. 300 IN NS ns.skydns.test."), dns.RcodeRefused},
+		{test.A("a.example.org. 300 IN A 10.0.0.1"), dns.RcodeNotZone},
+	}
+	for i, tc := range tests {
+		if rcode := updatePrescan(nil, []dns.RR{tc.rr}, "skydns.test."); rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[rcode])
+		}
+	}
+}
+
+func TestUpdateNameAdd(t *testing.T) {
+	tests := []struct {
+		existing []dns.RR
+		rr       dns.RR
+		rcode    int
+		records  int
+	}{
+		{nil, test.A("a.skydns.test. 300 IN A 10.0.0.1"), dns.RcodeSuccess, 1},
+		{[]dns.RR{test.A("a.skydns.test. 300 IN A 10.0.0.1")}, test.TXT("a.skydns.test. 300 IN TXT \"hello\""), dns.RcodeSuccess, 2},
+		{[]dns.RR{test.A("a.skydns.test. 300 IN A 10.0.0.1")}, test.A("a.skydns.test. 300 IN A 10.0.0.1"), dns.RcodeSuccess, 1},
+		// Addresses and MX or SRV records don't mix.
+		{[]dns.RR{test.A("a.skydns.test. 300 IN A 10.0.0.1")}, test.MX("a.skydns.test. 300 IN MX 10 mx.skydns.test."), dns.RcodeRefused, 1},
+		{[]dns.RR{test.SRV("a.skydns.test. 300 IN SRV 10 10 8080 b.skydns.test.")}, test.AAAA("a.skydns.test. 300 IN AAAA ::1"), dns.RcodeRefused, 1},
+		// A CNAME conflict is silently ignored.
+		{[]dns.RR{test.A("a.skydns.test. 300 IN A 10.0.0.1")}, test.CNAME("a.skydns.test. 300 IN CNAME b.skydns.test."), dns.RcodeSuccess, 1},
+	}
+	for i, tc := range tests {
+		n := &updateName{base: "/skydns/test/skydns/a"}
+		for _, rr := range tc.existing {
+			n.records = append(n.records, &updateRecord{key: n.base + "/" + updateLabel(rr), rr: rr, serv: updateService(rr)})
+		}
+		if rcode := n.add(tc.rr); rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[rcode])
+		}
+		if len(n.records) != tc.records {
+			t.Errorf("Test %d: expected %d records, got %d", i, tc.records, len(n.records))
+		}
+	}
+}
+
+func TestIsUpdateLabel(t *testing.T) {
+	tests := []struct {
+		label    string
+		expected bool
+	}{
+		{updateLabel(test.A("a.skydns.test. 300 IN A 10.0.0.1")), true},
+		{"srv-0123abcd", true},
+		{"x", false},
+		{"a-0123", false},
+		{"a-0123ABCD", false},
+		{"a-0123abcx", false},
+		{"foo-0123abcd", false},
+		{"A-0123abcd", false},
+	}
+	for i, tc := range tests {
+		if x := isUpdateLabel(tc.label); x != tc.expected {
+			t.Errorf("Test %d: expected %t for %q, got %t", i, tc.expected, tc.label, x)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+//go:build etcd
+
+package etcd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/etcd/msg"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	etcdcv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestUpdate(t *testing.T) {
+	etc := newEtcdPlugin()
+	etc.Update = true
+	etc.tsigSecret = map[string]string{"key.skydns.test.": "NoTCJU+DMqFWywaPyxSijrDEA/eC3nK0xi3AMEZuPVk="}
+	defer etc.Client.Delete(ctxt, "/skydns/test/skydns/update/", etcdcv3.WithPrefix())
+
+	update := func(tsig bool, f func(m *dns.Msg)) int {
+		m := new(dns.Msg)
+		m.SetUpdate("skydns.test.")
+		f(m)
+		if tsig {
+			m.SetTsig("key.skydns.test.", dns.HmacSHA256, 300, time.Now().Unix())
+		}
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		etc.ServeDNS(ctxt, rec, m)
+		return rec.Msg.Rcode
+	}
+
+	tests := []struct {
+		tsig  bool
+		f     func(m *dns.Msg)
+		rcode int
+	}{
+		// Not signed.
+		{false, func(m *dns.Msg) { m.Insert([]dns.RR{test.A("a.update.skydns.test. 300 IN A 10.0.0.1")}) }, dns.RcodeRefused},
+		// Outside of the zone.
+		{true, func(m *dns.Msg) { m.Insert([]dns.RR{test.A("a.example.org. 300 IN A 10.0.0.1")}) }, dns.RcodeNotZone},
+		// Not a record type the plugin can store.
+		{true, func(m *dns.Msg) { m.Insert([]dns.RR{test.NS("a.update.skydns.test. 300 IN NS ns.skydns.test.")}) }, dns.RcodeRefused},
+		// SRV without a port.
+		{true, func(m *dns.Msg) {
+			m.Insert([]dns.RR{test.SRV("_x._tcp.update.skydns.test. 300 IN SRV 10 10 0 a.update.skydns.test.")})
+		}, dns.RcodeRefused},
+		// Addresses next to an MX record.
+		{true, func(m *dns.Msg) {
+			m.Insert([]dns.RR{
+				test.MX("c.update.skydns.test. 300 IN MX 10 a.update.skydns.test."),
+				test.A("c.update.skydns.test. 300 IN A 10.0.0.3"),
+			})
+		}, dns.RcodeRefused},
+		{true, func(m *dns.Msg) {
+			m.NameNotUsed([]dns.RR{test.A("a.update.skydns.test. 0 IN A 10.0.0.1")})
+			m.Insert([]dns.RR{
+				test.A("a.update.skydns.test. 300 IN A 10.0.0.1"),
+				test.A("a.update.skydns.test. 300 IN A 10.0.0.2"),
+				test.TXT("b.update.skydns.test. 300 IN TXT \"hello\""),
+			})
+		}, dns.RcodeSuccess},
+		// The name is in use now.
+		{true, func(m *dns.Msg) { m.NameNotUsed([]dns.RR{test.A("a.update.skydns.test. 0 IN A 10.0.0.1")}) }, dns.RcodeYXDomain},
+		// Value dependent prerequisite that doesn't match.
+		{true, func(m *dns.Msg) { m.Used([]dns.RR{test.A("a.update.skydns.test. 0 IN A 10.0.0.1")}) }, dns.RcodeNXRrset},
+		{true, func(m *dns.Msg) {
+			m.Used([]dns.RR{test.A("a.update.skydns.test. 0 IN A 10.0.0.1"), test.A("a.update.skydns.test. 0 IN A 10.0.0.2")})
+			m.Remove([]dns.RR{test.A("a.update.skydns.test. 300 IN A 10.0.0.2")})
+			m.RemoveRRset([]dns.RR{test.TXT("b.update.skydns.test. 0 IN TXT \"\"")})
+		}, dns.RcodeSuccess},
+	}
+	for i, tc := range tests {
+		if rcode := update(tc.tsig, tc.f); rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[rcode])
+		}
+	}
+
+	lookups := []test.Case{
+		{
+			Qname: "a.update.skydns.test.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("a.update.skydns.test. 300 IN A 10.0.0.1")},
+		},
+		// The refused update changed nothing.
+		{
+			Qname: "c.update.skydns.test.", Qtype: dns.TypeMX,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{test.SOA("skydns.test. 30 SOA ns.dns.skydns.test. hostmaster.skydns.test. 0 0 0 0 0")},
+		},
+		{
+			Qname: "b.update.skydns.test.", Qtype: dns.TypeTXT,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{test.SOA("skydns.test. 30 SOA ns.dns.skydns.test. hostmaster.skydns.test. 0 0 0 0 0")},
+		},
+	}
+	for i, tc := range lookups {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		etc.ServeDNS(ctxt, rec, tc.Msg())
+		if err := test.SortAndCheck(rec.Msg, tc); err != nil {
+			t.Errorf("Lookup %d: %v", i, err)
+		}
+	}
+}
+
+func TestUpdateDeleteName(t *testing.T) {
+	etc := newEtcdPlugin()
+	etc.Update = true
+	etc.tsigSecret = map[string]string{"key.skydns.test.": "NoTCJU+DMqFWywaPyxSijrDEA/eC3nK0xi3AMEZuPVk="}
+	defer etc.Client.Delete(ctxt, "/skydns/test/skydns/update/", etcdcv3.WithPrefix())
+
+	// A name below the one that is deleted, its key is directly below the key of that name.
+	set(t, etc, "x.a.update.skydns.test.", 0, &msg.Service{Host: "10.0.0.9", Key: "x.a.update.skydns.test."})
+
+	for _, f := range []func(m *dns.Msg){
+		func(m *dns.Msg) { m.Insert([]dns.RR{test.A("a.update.skydns.test. 300 IN A 10.0.0.1")}) },
+		func(m *dns.Msg) { m.RemoveName([]dns.RR{test.A("a.update.skydns.test. 0 IN A 10.0.0.1")}) },
+	} {
+		m := new(dns.Msg)
+		m.SetUpdate("skydns.test.")
+		f(m)
+		m.SetTsig("key.skydns.test.", dns.HmacSHA256, 300, time.Now().Unix())
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		etc.ServeDNS(ctxt, rec, m)
+		if rec.Msg.Rcode != dns.RcodeSuccess {
+			t.Fatalf("Expected update to succeed, got rcode %s", dns.RcodeToString[rec.Msg.Rcode])
+		}
+	}
+
+	tc := test.Case{
+		Qname: "x.a.update.skydns.test.", Qtype: dns.TypeA,
+		Answer: []dns.RR{test.A("x.a.update.skydns.test. 300 IN A 10.0.0.9")},
+	}
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	etc.ServeDNS(ctxt, rec, tc.Msg())
+	if err := test.SortAndCheck(rec.Msg, tc); err != nil {
+		t.Errorf("Expected the name below the deleted one to be left alone: %v", err)
+	}
+}
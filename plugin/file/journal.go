@@ -0,0 +1,98 @@
+package file
+
+import (
+	"slices"
+
+	"github.com/coredns/coredns/plugin/file/tree"
+
+	"github.com/miekg/dns"
+)
+
+// journalSize is the number of differences between successive loads of a zone that are kept for incremental
+// transfers.
+const journalSize = 10
+
+// diff holds the records that are deleted and added to go from the zone with SOA from to the one with SOA to.
+type diff struct {
+	from, to       *dns.SOA
+	deleted, added []dns.RR
+}
+
+// swap sets the records of z to the ones of zone, and adds the difference between them to the journal of z.
+// It must only be called from the goroutine that reloads z, as that is the only writer of z.
+func (z *Zone) swap(zone *Zone) {
+	// Walking both zones is slow for large zones, compute the difference under the read lock so queries
+	// are not blocked; no one else changes z in the meantime.
+	var d *diff
+	z.RLock()
+	if z.SOA != nil && zone.SOA != nil {
+		nd := newDiff(z, zone)
+		d = &nd
+	}
+	z.RUnlock()
+
+	z.Lock()
+	defer z.Unlock()
+
+	if d == nil {
+		z.journal = nil
+	} else {
+		z.journal = append(z.journal, *d)
+		if len(z.journal) > journalSize {
+			z.journal = slices.Delete(z.journal, 0, len(z.journal)-journalSize)
+		}
+	}
+
+	z.Apex = zone.Apex
+	z.Tree = zone.Tree
+}
+
+// ixfr returns the differences that take the zone from serial to its current serial, or nil if serial is not
+// in the journal. The caller must hold the lock of z.
+func (z *Zone) ixfr(serial uint32) []diff {
+	for i, d := range z.journal {
+		if d.from.Serial != serial {
+			continue
+		}
+		if z.SOA == nil || z.journal[len(z.journal)-1].to.Serial != z.SOA.Serial {
+			// The zone was changed without going through the journal.
+			return nil
+		}
+		return slices.Clone(z.journal[i:])
+	}
+	return nil
+}
+
+// newDiff returns the difference between the records of old and new, the SOA records are not part of it.
+func newDiff(old, new *Zone) diff {
+	oldRRs, newRRs := zoneRRs(old), zoneRRs(new)
+	d := diff{from: old.SOA, to: new.SOA}
+	d.deleted = without(oldRRs, newRRs)
+	d.added = without(newRRs, oldRRs)
+	return d
+}
+
+// zoneRRs returns all records of z, except the SOA.
+func zoneRRs(z *Zone) []dns.RR {
+	rrs := slices.Concat(z.SIGSOA, z.NS, z.SIGNS)
+	z.Walk(func(e *tree.Elem, _ map[uint16][]dns.RR) error {
+		rrs = append(rrs, e.All()...)
+		return nil
+	})
+	return rrs
+}
+
+// without returns the records in a that are not in b, a change in TTL counts as a different record.
+func without(a, b []dns.RR) []dns.RR {
+	seen := make(map[string]struct{}, len(b))
+	for _, rr := range b {
+		seen[rr.String()] = struct{}{}
+	}
+	var rrs []dns.RR
+	for _, rr := range a {
+		if _, ok := seen[rr.String()]; !ok {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
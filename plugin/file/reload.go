@@ -37,10 +37,7 @@ func (z *Zone) Reload(t *transfer.Transfer) error {
 				}
 
 				// copy elements we need
-				z.Lock()
-				z.Apex = zone.Apex
-				z.Tree = zone.Tree
-				z.Unlock()
+				z.swap(zone)
 
 				log.Infof("Successfully reloaded zone %q in %q with %d SOA serial", z.origin, zFile, z.SOA.Serial)
 				if t != nil {
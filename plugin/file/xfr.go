@@ -16,14 +16,25 @@ func (f File) Transfer(zone string, serial uint32) (<-chan []dns.RR, error) {
 	return z.Transfer(serial)
 }
 
-// Transfer transfers a zone with serial in the returned channel and implements IXFR fallback, by just
-// sending a single SOA record.
+// Transfer transfers a zone with serial in the returned channel. For an IXFR the zone is up to date
+// when serial is the current one, and only a single SOA record is sent. If the journal of the zone has
+// the differences since serial, these are sent as an incremental transfer (RFC 1995), otherwise it
+// falls back to a full transfer.
 func (z *Zone) Transfer(serial uint32) (<-chan []dns.RR, error) {
-	// get soa and apex
-	apex, err := z.ApexIfDefined()
+	// Get the apex, the differences and the records under a single lock, so they are all of the same load of
+	// the zone.
+	z.RLock()
+	apex, err := z.apexIfDefined()
 	if err != nil {
+		z.RUnlock()
 		return nil, err
 	}
+	var diffs []diff
+	if serial != 0 {
+		diffs = z.ixfr(serial)
+	}
+	t := z.Tree
+	z.RUnlock()
 
 	ch := make(chan []dns.RR)
 	go func() {
@@ -34,8 +45,20 @@ func (z *Zone) Transfer(serial uint32) (<-chan []dns.RR, error) {
 			return
 		}
 
+		if len(diffs) > 0 {
+			ch <- []dns.RR{apex[0]}
+			for _, d := range diffs {
+				ch <- append([]dns.RR{d.from}, d.deleted...)
+				ch <- append([]dns.RR{d.to}, d.added...)
+			}
+			ch <- []dns.RR{apex[0]}
+
+			close(ch)
+			return
+		}
+
 		ch <- apex
-		z.Walk(func(e *tree.Elem, _ map[uint16][]dns.RR) error { ch <- e.All(); return nil })
+		t.Walk(func(e *tree.Elem, _ map[uint16][]dns.RR) error { ch <- e.All(); return nil })
 		ch <- []dns.RR{apex[0]}
 
 		close(ch)
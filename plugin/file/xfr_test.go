@@ -70,3 +70,124 @@ func TestAXFRWithOutTransferPlugin(t *testing.T) {
 		t.Errorf("Expecting REFUSED, got %d", code)
 	}
 }
+
+func TestTransferIXFR(t *testing.T) {
+	z, err := Parse(strings.NewReader(ixfrZone(1, "127.0.0.1")), "example.org.", "stdin", 0)
+	if err != nil {
+		t.Fatalf("Expected no error when reading zone, got %q", err)
+	}
+	for serial := uint32(2); serial <= journalSize+2; serial++ {
+		zone, err := Parse(strings.NewReader(ixfrZone(serial, fmt.Sprintf("127.0.0.%d", serial))), "example.org.", "stdin", int64(serial-1))
+		if err != nil {
+			t.Fatalf("Expected no error when reading zone, got %q", err)
+		}
+		z.swap(zone)
+	}
+
+	// 2 is the oldest serial in the journal, 1 has been dropped.
+	tests := []struct {
+		serial uint32
+		rrs    int // number of records in the transfer
+		ixfr   bool
+	}{
+		{serial: journalSize + 2, rrs: 1},
+		{serial: journalSize + 1, rrs: 6, ixfr: true},
+		{serial: 2, rrs: 2 + 4*journalSize, ixfr: true},
+		{serial: 1, rrs: 4},
+		{serial: 0, rrs: 4},
+	}
+	for _, tc := range tests {
+		ch, err := z.Transfer(tc.serial)
+		if err != nil {
+			t.Fatalf("Serial %d: expected no error, got %q", tc.serial, err)
+		}
+		var rrs []dns.RR
+		for r := range ch {
+			rrs = append(rrs, r...)
+		}
+		if len(rrs) != tc.rrs {
+			t.Errorf("Serial %d: expected %d records, got %d", tc.serial, tc.rrs, len(rrs))
+			continue
+		}
+		if len(rrs) == 1 {
+			continue
+		}
+		// An incremental transfer has the old SOA as second record, a full one doesn't.
+		if _, soa := rrs[1].(*dns.SOA); soa != tc.ixfr {
+			t.Errorf("Serial %d: expected incremental transfer to be %t, got %t", tc.serial, tc.ixfr, !tc.ixfr)
+		}
+	}
+
+	ch, _ := z.Transfer(journalSize + 1)
+	var rrs []dns.RR
+	for r := range ch {
+		rrs = append(rrs, r...)
+	}
+	expected := []string{
+		"example.org.	3600	IN	SOA	ns.example.org. admin.example.org. 12 3600 600 86400 60",
+		"example.org.	3600	IN	SOA	ns.example.org. admin.example.org. 11 3600 600 86400 60",
+		"a.example.org.	3600	IN	A	127.0.0.11",
+		"example.org.	3600	IN	SOA	ns.example.org. admin.example.org. 12 3600 600 86400 60",
+		"a.example.org.	3600	IN	A	127.0.0.12",
+		"example.org.	3600	IN	SOA	ns.example.org. admin.example.org. 12 3600 600 86400 60",
+	}
+	for i, rr := range rrs {
+		if rr.String() != expected[i] {
+			t.Errorf("Record %d: expected %q, got %q", i, expected[i], rr.String())
+		}
+	}
+}
+
+func TestTransferIXFRReload(t *testing.T) {
+	z, err := Parse(strings.NewReader(ixfrZone(1, "127.0.0.1")), "example.org.", "stdin", 0)
+	if err != nil {
+		t.Fatalf("Expected no error when reading zone, got %q", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for serial := uint32(2); serial <= 200; serial++ {
+			zone, _ := Parse(strings.NewReader(ixfrZone(serial, fmt.Sprintf("127.0.0.%d", serial))), "example.org.", "stdin", int64(serial-1))
+			z.swap(zone)
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		apex, _ := z.ApexIfDefined()
+		ch, err := z.Transfer(apex[0].(*dns.SOA).Serial - 1)
+		if err != nil {
+			t.Fatalf("Expected no error, got %q", err)
+		}
+		var rrs []dns.RR
+		for r := range ch {
+			rrs = append(rrs, r...)
+		}
+		if _, ixfr := rrs[1].(*dns.SOA); !ixfr {
+			continue
+		}
+		// The SOA the transfer starts and ends with is the one the last difference leads to.
+		var soas []uint32
+		for _, rr := range rrs {
+			if soa, ok := rr.(*dns.SOA); ok {
+				soas = append(soas, soa.Serial)
+			}
+		}
+		if first, to := soas[0], soas[len(soas)-2]; first != to {
+			t.Fatalf("Expected incremental transfer to serial %d, got differences to %d", first, to)
+		}
+	}
+}
+
+func ixfrZone(serial uint32, a string) string {
+	return fmt.Sprintf(`$TTL 3600
+example.org.	IN	SOA	ns.example.org. admin.example.org. %d 3600 600 86400 60
+example.org.	IN	NS	ns.example.org.
+a.example.org.	IN	A	%s
+`, serial, a)
+}
@@ -29,6 +29,7 @@ type Zone struct {
 
 	ReloadInterval time.Duration
 	reloadShutdown chan bool
+	journal        []diff // differences between the last reloads, oldest first
 
 	Upstream *upstream.Upstream // Upstream for looking up external names during the resolution process.
 }
@@ -136,6 +137,11 @@ func (z *Zone) SetFile(path string) {
 func (z *Zone) ApexIfDefined() ([]dns.RR, error) {
 	z.RLock()
 	defer z.RUnlock()
+	return z.apexIfDefined()
+}
+
+// apexIfDefined is ApexIfDefined for callers that hold the lock of z.
+func (z *Zone) apexIfDefined() ([]dns.RR, error) {
 	if z.SOA == nil {
 		return nil, fmt.Errorf("no SOA")
 	}
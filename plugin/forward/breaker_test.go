@@ -0,0 +1,92 @@
+package forward
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/proxy"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// newSilentServer returns a server that never answers, and counts the queries it gets.
+func newSilentServer(count *uint32) *dnstest.Server {
+	return dnstest.NewMultipleServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddUint32(count, 1)
+	})
+}
+
+func newBreakerProxy(name, addr string) *proxy.Proxy {
+	p := proxy.NewProxy(name, addr, transport.DNS)
+	p.SetReadTimeout(20 * time.Millisecond)
+	p.SetBreaker(proxy.BreakerConfig{ErrorRate: 0.5, Window: 10 * time.Second, MinRequests: 1, OpenDuration: time.Hour, HalfOpenQueries: 1})
+	return p
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var silentCount, okCount uint32
+	silent := newSilentServer(&silentCount)
+	defer silent.Close()
+	ok := newRaceServer(0, dns.RcodeSuccess, &okCount)
+	defer ok.Close()
+
+	f := New()
+	f.p = &sequential{}
+	f.maxfails = 0
+	f.SetProxy(newBreakerProxy("TestCircuitBreaker", silent.Addr))
+	f.SetProxy(newBreakerProxy("TestCircuitBreaker", ok.Addr))
+	defer closeProxies(f)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+
+	for range 3 {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+		if rec.Msg == nil || len(rec.Msg.Answer) != 1 {
+			t.Fatalf("Expected an answer, got %v", rec.Msg)
+		}
+	}
+	// Only the first query should have been sent to the silent upstream, after that its circuit is open.
+	if n := atomic.LoadUint32(&silentCount); n != 1 {
+		t.Errorf("Expected 1 query to the silent upstream, got %d", n)
+	}
+}
+
+func TestCircuitBreakerAllOpen(t *testing.T) {
+	var count uint32
+	s1 := newSilentServer(&count)
+	defer s1.Close()
+	s2 := newSilentServer(&count)
+	defer s2.Close()
+
+	f := New()
+	f.p = &sequential{}
+	f.maxfails = 0
+	f.SetProxy(newBreakerProxy("TestCircuitBreakerAllOpen", s1.Addr))
+	f.SetProxy(newBreakerProxy("TestCircuitBreakerAllOpen", s2.Addr))
+	defer closeProxies(f)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+	start := time.Now()
+	rcode, err := f.ServeDNS(context.TODO(), rec, m)
+	if err == nil || rcode != dns.RcodeServerFailure {
+		t.Fatalf("Expected SERVFAIL and an error, got %d, %v", rcode, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Expected to give up quickly with all circuits open, took %s", d)
+	}
+	if n := atomic.LoadUint32(&count); n != 2 {
+		t.Errorf("Expected 2 queries, got %d", n)
+	}
+}
@@ -0,0 +1,277 @@
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/doh"
+	"github.com/coredns/coredns/plugin/pkg/parse"
+	proxyPkg "github.com/coredns/coredns/plugin/pkg/proxy"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultResolveInterval = 30 * time.Second
+	srvPrefix              = "srv+"
+)
+
+// resolver looks up the addresses of dynamic upstreams, it is implemented by *net.Resolver.
+type resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// dynamicUpstream is an upstream that is given as a name. The name is periodically resolved and a proxy
+// is kept for each of the addresses it resolves to.
+type dynamicUpstream struct {
+	trans      string
+	name       string // the SRV name or the host name
+	srv        bool
+	port       string // for host names, the port of SRV targets is in the SRV record
+	serverName string // TLS server name set with NAME%SERVERNAME
+
+	proxies    []*proxyPkg.Proxy      // sorted by address
+	tlsConfigs map[string]*tls.Config // per TLS server name, so session caches are reused
+}
+
+// target is an address a dynamic upstream resolved to.
+type target struct {
+	addr string // ip:port
+	host string // the host name the address belongs to
+}
+
+// parseDynamic parses s as a dynamic upstream: [TRANSPORT://]srv+NAME or [TRANSPORT://]HOST[:PORT], both
+// optionally followed by %SERVERNAME. It returns nil if s is an IP address or a file. A bare relative name,
+// without a transport, port or trailing dot, may as well be a file that doesn't exist and is an error.
+func parseDynamic(s string) (*dynamicUpstream, error) {
+	trans, rest := parse.Transport(s)
+	if trans == transport.HTTPS || trans == transport.HTTPS3 {
+		rest = strings.TrimSuffix(rest, doh.Path)
+	}
+
+	u := &dynamicUpstream{trans: trans}
+	if i := strings.LastIndex(rest, "%"); i >= 0 {
+		rest, u.serverName = rest[:i], rest[i+1:]
+	}
+
+	if strings.HasPrefix(rest, srvPrefix) {
+		u.srv = true
+		u.name = dns.Fqdn(rest[len(srvPrefix):])
+		if _, ok := dns.IsDomainName(u.name); !ok {
+			return nil, fmt.Errorf("invalid SRV name: %q", u.name)
+		}
+		return u, nil
+	}
+
+	host, port, err := net.SplitHostPort(rest)
+	bare := err != nil
+	if bare {
+		host, port = rest, defaultPort(trans)
+	}
+	if host == "" || net.ParseIP(host) != nil || strings.Contains(rest, "/") {
+		return nil, nil
+	}
+	if _, err := os.Stat(s); err == nil {
+		return nil, nil
+	}
+	if _, ok := dns.IsDomainName(host); !ok {
+		return nil, nil
+	}
+	// A top level domain is never all digits, this is more likely a mistyped IP address.
+	labels := dns.SplitDomainName(host)
+	if len(labels) == 0 || strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return nil, nil
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return nil, nil
+	}
+	if bare && !strings.Contains(s, "://") && !dns.IsFqdn(host) {
+		return nil, fmt.Errorf("%q is not a file, use %q or %q if it is a name to resolve", s, host+".", "dns://"+host)
+	}
+	u.name, u.port = host, port
+	return u, nil
+}
+
+// defaultPort returns the port used for trans when none is given.
+func defaultPort(trans string) string {
+	switch trans {
+	case transport.TLS:
+		return transport.TLSPort
+	case transport.QUIC:
+		return transport.QUICPort
+	case transport.HTTPS, transport.HTTPS3:
+		return transport.HTTPSPort
+	}
+	return transport.Port
+}
+
+// resolve returns the addresses u currently resolves to, sorted and without duplicates.
+func (u *dynamicUpstream) resolve(ctx context.Context, r resolver) ([]target, error) {
+	var targets []target
+	if u.srv {
+		_, srvs, err := r.LookupSRV(ctx, "", "", u.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvs {
+			host := strings.TrimSuffix(srv.Target, ".")
+			ips, err := r.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			for _, ip := range ips {
+				targets = append(targets, target{addr: net.JoinHostPort(ip.IP.String(), strconv.Itoa(int(srv.Port))), host: host})
+			}
+		}
+	} else {
+		ips, err := r.LookupIPAddr(ctx, u.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			targets = append(targets, target{addr: net.JoinHostPort(ip.IP.String(), u.port), host: u.name})
+		}
+	}
+
+	slices.SortFunc(targets, func(a, b target) int { return strings.Compare(a.addr, b.addr) })
+	return slices.CompactFunc(targets, func(a, b target) bool { return a.addr == b.addr }), nil
+}
+
+// tlsConfig returns the TLS config for the upstream at host. Unless a TLS server name is configured, the
+// name of the host is used.
+func (u *dynamicUpstream) tlsConfig(f *Forward, host string) *tls.Config {
+	serverName := u.serverName
+	if serverName == "" {
+		if f.tlsServerName != "" {
+			return f.tlsConfig
+		}
+		serverName = host
+	}
+	if cfg, ok := u.tlsConfigs[serverName]; ok {
+		return cfg
+	}
+	if u.tlsConfigs == nil {
+		u.tlsConfigs = make(map[string]*tls.Config)
+	}
+	cfg := f.tlsConfig.Clone()
+	cfg.ServerName = serverName
+	cfg.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	u.tlsConfigs[serverName] = cfg
+	return cfg
+}
+
+// startDiscovery resolves the dynamic upstreams, and keeps doing so every resolve interval until
+// stopDiscovery is called.
+func (f *Forward) startDiscovery() {
+	if len(f.dynamic) == 0 {
+		return
+	}
+	f.discoveryStop = make(chan struct{})
+	f.discoveryDone = make(chan struct{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	f.refresh(ctx)
+	cancel()
+
+	go func() {
+		defer close(f.discoveryDone)
+		tick := time.NewTicker(f.resolveInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+				f.refresh(ctx)
+				cancel()
+			case <-f.discoveryStop:
+				return
+			}
+		}
+	}()
+}
+
+// stopDiscovery stops the periodic resolving started by startDiscovery.
+func (f *Forward) stopDiscovery() {
+	if f.discoveryStop == nil {
+		return
+	}
+	close(f.discoveryStop)
+	<-f.discoveryDone
+	f.discoveryStop = nil
+}
+
+// refresh resolves the dynamic upstreams and updates the proxies in place: proxies are started for
+// new addresses, and the ones for addresses that are gone are drained. When a name can't be resolved,
+// the proxies it had are kept. New addresses are left out once there are max upstreams.
+func (f *Forward) refresh(ctx context.Context) {
+	changed := false
+	total := len(f.upstreams())
+	for _, u := range f.dynamic {
+		targets, err := u.resolve(ctx, f.resolver)
+		if err != nil {
+			log.Warningf("Failed to resolve upstream %s: %s", u.name, err)
+			continue
+		}
+		if len(targets) == 0 {
+			log.Warningf("Upstream %s resolved to no addresses, keeping the current ones", u.name)
+			continue
+		}
+
+		old := make(map[string]*proxyPkg.Proxy, len(u.proxies))
+		for _, p := range u.proxies {
+			old[p.Addr()] = p
+		}
+		proxies := make([]*proxyPkg.Proxy, 0, len(targets))
+		var added []target
+		for _, t := range targets {
+			if p, ok := old[t.addr]; ok {
+				proxies = append(proxies, p)
+				delete(old, t.addr)
+				continue
+			}
+			added = append(added, t)
+		}
+		total -= len(old)
+		for i, t := range added {
+			if total >= max {
+				log.Warningf("Upstream %s: not adding %d addresses, there are already %d upstreams", u.name, len(added)-i, max)
+				break
+			}
+			total++
+			p := f.newProxy(t.addr, u.trans, u.tlsConfig(f, t.host))
+			p.Start(f.hcInterval)
+			proxies = append(proxies, p)
+			log.Infof("Upstream %s: added %s", u.name, t.addr)
+			changed = true
+		}
+		for addr, p := range old {
+			// Queries may still be in flight, only close the proxy once these are done.
+			p.Stop()
+			time.AfterFunc(defaultTimeout, p.Close)
+			log.Infof("Upstream %s: removed %s", u.name, addr)
+			changed = true
+		}
+		slices.SortFunc(proxies, func(a, b *proxyPkg.Proxy) int { return strings.Compare(a.Addr(), b.Addr()) })
+		u.proxies = proxies
+	}
+	if !changed {
+		return
+	}
+
+	proxies := slices.Clone(f.static)
+	for _, u := range f.dynamic {
+		proxies = append(proxies, u.proxies...)
+	}
+	f.mu.Lock()
+	f.proxies = proxies
+	f.mu.Unlock()
+}
@@ -0,0 +1,220 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/proxy"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseDynamic(t *testing.T) {
+	tests := []struct {
+		in         string
+		dynamic    bool
+		trans      string
+		name       string
+		srv        bool
+		port       string
+		serverName string
+	}{
+		{"127.0.0.1", false, "", "", false, "", ""},
+		{"127.0.0.1:53", false, "", "", false, "", ""},
+		{"[::1]:53", false, "", "", false, "", ""},
+		{"/etc/resolv.conf", false, "", "", false, "", ""},
+		{"a27.0.0.1", false, "", "", false, "", ""},
+		{"resolvers.internal.", true, transport.DNS, "resolvers.internal.", false, "53", ""},
+		{"dns://resolvers.internal", true, transport.DNS, "resolvers.internal", false, "53", ""},
+		{"resolvers.internal:5353", true, transport.DNS, "resolvers.internal", false, "5353", ""},
+		{"tls://resolvers.internal", true, transport.TLS, "resolvers.internal", false, "853", ""},
+		{"tls://resolvers.internal%dns.example.org", true, transport.TLS, "resolvers.internal", false, "853", "dns.example.org"},
+		{"https://resolvers.internal/dns-query", true, transport.HTTPS, "resolvers.internal", false, "443", ""},
+		{"srv+_dns._udp.resolvers.internal", true, transport.DNS, "_dns._udp.resolvers.internal.", true, "", ""},
+		{"tls://srv+_dns-tls._tcp.resolvers.internal", true, transport.TLS, "_dns-tls._tcp.resolvers.internal.", true, "", ""},
+	}
+
+	for i, tc := range tests {
+		u, err := parseDynamic(tc.in)
+		if err != nil {
+			t.Fatalf("Test %d: expected no error for %q, got %v", i, tc.in, err)
+		}
+		if (u != nil) != tc.dynamic {
+			t.Errorf("Test %d: expected dynamic to be %t for %q", i, tc.dynamic, tc.in)
+			continue
+		}
+		if u == nil {
+			continue
+		}
+		if u.trans != tc.trans || u.name != tc.name || u.srv != tc.srv || u.port != tc.port || u.serverName != tc.serverName {
+			t.Errorf("Test %d: expected %s %s %t %s %s, got %s %s %t %s %s", i, tc.trans, tc.name, tc.srv, tc.port, tc.serverName, u.trans, u.name, u.srv, u.port, u.serverName)
+		}
+	}
+}
+
+func TestParseDynamicAmbiguous(t *testing.T) {
+	// These may be a mistyped file as well as a name.
+	for _, in := range []string{"resolvers.internal", "resolv.cnf", "tls.conf%dns.example.org"} {
+		if _, err := parseDynamic(in); err == nil {
+			t.Errorf("Expected error for %q", in)
+		}
+	}
+}
+
+// fakeResolver returns the SRV records and addresses that are set in it.
+type fakeResolver struct {
+	sync.Mutex
+	srv map[string][]*net.SRV
+	ips map[string][]net.IPAddr
+}
+
+func (r *fakeResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	r.Lock()
+	defer r.Unlock()
+	srvs, ok := r.srv[name]
+	if !ok {
+		return "", nil, errors.New("no such name")
+	}
+	return name, srvs, nil
+}
+
+func (r *fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	r.Lock()
+	defer r.Unlock()
+	ips, ok := r.ips[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return ips, nil
+}
+
+func (r *fakeResolver) setIPs(host string, ips ...string) {
+	r.Lock()
+	defer r.Unlock()
+	r.ips[host] = nil
+	for _, ip := range ips {
+		r.ips[host] = append(r.ips[host], net.IPAddr{IP: net.ParseIP(ip)})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	r := &fakeResolver{ips: map[string][]net.IPAddr{}}
+	r.setIPs("resolvers.internal", "10.0.0.1")
+
+	f := New()
+	f.resolver = r
+	f.dynamic = []*dynamicUpstream{{trans: transport.DNS, name: "resolvers.internal", port: "53"}}
+	defer func() {
+		for _, p := range f.upstreams() {
+			p.Close()
+		}
+	}()
+
+	f.refresh(context.TODO())
+	if f.Len() != 1 {
+		t.Fatalf("Expected 1 upstream, got %d", f.Len())
+	}
+	first := f.proxies[0]
+
+	r.setIPs("resolvers.internal", "10.0.0.2", "10.0.0.1", "10.0.0.1")
+	f.refresh(context.TODO())
+	if f.Len() != 2 {
+		t.Fatalf("Expected 2 upstreams, got %d", f.Len())
+	}
+	if f.proxies[0] != first {
+		t.Errorf("Expected the proxy for 10.0.0.1 to be kept")
+	}
+	if addr := f.proxies[1].Addr(); addr != "10.0.0.2:53" {
+		t.Errorf("Expected upstream 10.0.0.2:53, got %s", addr)
+	}
+
+	// Resolve errors keep the current upstreams.
+	delete(r.ips, "resolvers.internal")
+	f.refresh(context.TODO())
+	if f.Len() != 2 {
+		t.Fatalf("Expected 2 upstreams, got %d", f.Len())
+	}
+
+	r.setIPs("resolvers.internal", "10.0.0.2")
+	f.refresh(context.TODO())
+	if f.Len() != 1 || f.proxies[0].Addr() != "10.0.0.2:53" {
+		t.Fatalf("Expected only upstream 10.0.0.2:53, got %v", f.proxies)
+	}
+}
+
+func TestRefreshMax(t *testing.T) {
+	r := &fakeResolver{ips: map[string][]net.IPAddr{}}
+	var ips []string
+	for i := range max + 5 {
+		ips = append(ips, fmt.Sprintf("10.0.0.%d", i+1))
+	}
+	r.setIPs("resolvers.internal", ips...)
+
+	f := New()
+	f.resolver = r
+	f.static = []*proxy.Proxy{proxy.NewProxy("forward", "127.0.0.1:53", transport.DNS)}
+	f.proxies = f.static
+	f.dynamic = []*dynamicUpstream{{trans: transport.DNS, name: "resolvers.internal", port: "53"}}
+	defer func() {
+		for _, p := range f.upstreams() {
+			p.Close()
+		}
+	}()
+
+	f.refresh(context.TODO())
+	if f.Len() != max {
+		t.Errorf("Expected %d upstreams, got %d", max, f.Len())
+	}
+}
+
+func TestDiscoverySRV(t *testing.T) {
+	s := dnstest.NewMultipleServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		ret.Answer = append(ret.Answer, test.A("example.org. IN A 127.0.0.1"))
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+	host, port, _ := net.SplitHostPort(s.Addr)
+	p, _ := net.LookupPort("udp", port)
+
+	r := &fakeResolver{
+		srv: map[string][]*net.SRV{"_dns._udp.resolvers.internal.": {{Target: "ns1.resolvers.internal.", Port: uint16(p)}}},
+		ips: map[string][]net.IPAddr{},
+	}
+	r.setIPs("ns1.resolvers.internal", host)
+
+	f := New()
+	f.resolver = r
+	f.dynamic = []*dynamicUpstream{{trans: transport.DNS, name: "_dns._udp.resolvers.internal.", srv: true}}
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := f.ServeDNS(context.TODO(), rec, m); err != ErrNoHealthy {
+		t.Errorf("Expected %q before the upstreams are resolved, got %v", ErrNoHealthy, err)
+	}
+
+	f.startDiscovery()
+	defer func() {
+		f.stopDiscovery()
+		for _, p := range f.upstreams() {
+			p.Close()
+		}
+	}()
+
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if rec.Msg == nil || len(rec.Msg.Answer) != 1 {
+		t.Errorf("Expected an answer, got %v", rec.Msg)
+	}
+}
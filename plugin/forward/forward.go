@@ -8,6 +8,8 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -17,6 +19,7 @@ import (
 	"github.com/coredns/coredns/plugin/metadata"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
 	proxyPkg "github.com/coredns/coredns/plugin/pkg/proxy"
+	"github.com/coredns/coredns/plugin/pkg/transport"
 	"github.com/coredns/coredns/request"
 
 	"github.com/miekg/dns"
@@ -29,6 +32,8 @@ var log = clog.NewWithPlugin("forward")
 const (
 	defaultExpire = 10 * time.Second
 	hcInterval    = 500 * time.Millisecond
+
+	max = 15 // Maximum number of upstreams.
 )
 
 // Forward represents a plugin instance that can proxy requests to another (DNS) server. It has a list
@@ -36,9 +41,19 @@ const (
 type Forward struct {
 	concurrent int64 // atomic counters need to be first in struct for proper alignment
 
-	proxies    []*proxyPkg.Proxy
-	p          Policy
-	hcInterval time.Duration
+	mu      sync.RWMutex // protects proxies, which are updated in place when upstreams are discovered
+	proxies []*proxyPkg.Proxy
+	p       Policy
+	pools   []*pool
+	routes  []route
+
+	static          []*proxyPkg.Proxy // the proxies that are not discovered
+	dynamic         []*dynamicUpstream
+	resolver        resolver
+	resolveInterval time.Duration
+	discoveryStop   chan struct{}
+	discoveryDone   chan struct{}
+	hcInterval      time.Duration
 
 	from    string
 	ignored []string
@@ -53,6 +68,11 @@ type Forward struct {
 	failfastUnhealthyUpstreams bool
 	failoverRcodes             []int
 	maxConnectAttempts         uint32
+	race                       int
+	hedgeDelay                 time.Duration
+	cookies                    bool
+	randCase                   bool
+	breaker                    *proxyPkg.BreakerConfig // nil when circuit breakers are not used
 
 	opts proxyPkg.Options // also here for testing
 
@@ -67,16 +87,53 @@ type Forward struct {
 
 // New returns a new Forward.
 func New() *Forward {
-	f := &Forward{maxfails: 2, tlsConfig: new(tls.Config), expire: defaultExpire, p: new(random), from: ".", hcInterval: hcInterval, opts: proxyPkg.Options{ForceTCP: false, PreferUDP: false, HCRecursionDesired: true, HCDomain: "."}}
+	f := &Forward{maxfails: 2, tlsConfig: new(tls.Config), expire: defaultExpire, p: new(random), from: ".", hcInterval: hcInterval, resolver: net.DefaultResolver, resolveInterval: defaultResolveInterval, opts: proxyPkg.Options{ForceTCP: false, PreferUDP: false, HCRecursionDesired: true, HCDomain: "."}}
 	return f
 }
 
 // SetProxy appends p to the proxy list and starts healthchecking.
 func (f *Forward) SetProxy(p *proxyPkg.Proxy) {
+	f.mu.Lock()
 	f.proxies = append(f.proxies, p)
+	f.mu.Unlock()
 	p.Start(f.hcInterval)
 }
 
+// newProxy returns a proxy for the upstream at addr using transport trans, configured with the options in f.
+// The tlsConfig is only used for transports that run over TLS.
+func (f *Forward) newProxy(addr, trans string, tlsConfig *tls.Config) *proxyPkg.Proxy {
+	p := proxyPkg.NewProxy("forward", addr, trans)
+	if isTLSTransport(trans) {
+		p.SetTLSConfig(tlsConfig)
+	}
+	if f.breaker != nil {
+		p.SetBreaker(*f.breaker)
+	}
+	if f.cookies && !isTLSTransport(trans) {
+		p.EnableCookies()
+	}
+	if f.randCase && !isTLSTransport(trans) {
+		p.EnableRandCase()
+	}
+	p.SetExpire(f.expire)
+	p.GetHealthchecker().SetRecursionDesired(f.opts.HCRecursionDesired)
+	// when TLS is used, checks are set to tcp-tls
+	if f.opts.ForceTCP && !isTLSTransport(trans) {
+		p.GetHealthchecker().SetTCPTransport()
+	}
+	p.GetHealthchecker().SetDomain(f.opts.HCDomain)
+	return p
+}
+
+// isTLSTransport returns true if trans is a transport that runs over TLS (DoT, DoH, DoH3 and DoQ).
+func isTLSTransport(trans string) bool {
+	switch trans {
+	case transport.TLS, transport.HTTPS, transport.HTTPS3, transport.QUIC:
+		return true
+	}
+	return false
+}
+
 // SetProxyOptions setup proxy options
 func (f *Forward) SetProxyOptions(opts proxyPkg.Options) {
 	f.opts = opts
@@ -91,7 +148,11 @@ func (f *Forward) SetTapPlugin(tapPlugin *dnstap.Dnstap) {
 }
 
 // Len returns the number of configured proxies.
-func (f *Forward) Len() int { return len(f.proxies) }
+func (f *Forward) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.proxies)
+}
 
 // Name implements plugin.Handler.
 func (f *Forward) Name() string { return "forward" }
@@ -112,12 +173,41 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 		}
 	}
 
+	proxies, poolName := f.routeRequest(ctx, state)
+	if len(f.routes) > 0 {
+		metadata.SetValueFunc(ctx, "forward/pool", func() string {
+			return poolName
+		})
+	}
+	list := f.listRequest(state, proxies)
+	if len(list) == 0 {
+		// All upstreams are discovered, and none have been found (yet).
+		return dns.RcodeServerFailure, ErrNoHealthy
+	}
+
+	if f.race > 1 {
+		ret, raced, err := f.raceUpstreams(ctx, state, list)
+		if raced {
+			if err != nil {
+				return dns.RcodeServerFailure, err
+			}
+			if !state.Match(ret) {
+				debug.Hexdumpf(ret, "Wrong reply for id: %d, %s %d", ret.Id, state.QName(), state.QType())
+
+				formerr := new(dns.Msg)
+				formerr.SetRcode(state.Req, dns.RcodeFormatError)
+				w.WriteMsg(formerr)
+				return 0, nil
+			}
+			return f.writeReply(ctx, w, r, ret)
+		}
+	}
+
 	fails := 0
 	var span, child ot.Span
 	var upstreamErr error
 	span = ot.SpanFromContext(ctx)
 	i := 0
-	list := f.List()
 	deadline := time.Now().Add(defaultTimeout)
 	start := time.Now()
 	connectAttempts := uint32(0)
@@ -133,7 +223,7 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 		i++
 		if proxy.Down(f.maxfails) {
 			fails++
-			if fails < len(f.proxies) {
+			if fails < len(list) {
 				continue
 			}
 
@@ -145,7 +235,7 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 			// assume healthcheck is completely broken and randomly
 			// select an upstream to connect to.
 			r := new(random)
-			proxy = r.List(f.proxies)[0]
+			proxy = r.List(list)[0]
 		}
 
 		if span != nil {
@@ -158,25 +248,7 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 			return proxy.Addr()
 		})
 
-		var (
-			ret *dns.Msg
-			err error
-		)
-		opts := f.opts
-
-		for {
-			ret, err = proxy.Connect(ctx, state, opts)
-
-			if err == proxyPkg.ErrCachedClosed { // Remote side closed conn, can only happen with TCP.
-				continue
-			}
-			// Retry with TCP if truncated and prefer_udp configured.
-			if ret != nil && ret.Truncated && !opts.ForceTCP && opts.PreferUDP {
-				opts.ForceTCP = true
-				continue
-			}
-			break
-		}
+		ret, opts, err := f.connect(ctx, proxy, state)
 
 		if child != nil {
 			child.Finish()
@@ -189,8 +261,11 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 		upstreamErr = err
 
 		if err != nil {
-			// Kick off health check to see if *our* upstream is broken.
-			if f.maxfails != 0 {
+			if err == proxyPkg.ErrCircuitOpen {
+				// Count it as a down upstream, so we don't spin when all circuits are open.
+				fails++
+			} else if f.maxfails != 0 {
+				// Kick off health check to see if *our* upstream is broken.
 				proxy.Healthcheck()
 			}
 
@@ -203,7 +278,7 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 				}
 			}
 
-			if fails < len(f.proxies) {
+			if fails < len(list) {
 				continue
 			}
 			break
@@ -224,7 +299,7 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 		for _, failoverRcode := range f.failoverRcodes {
 			// if we match, we continue to the next upstream in the list
 			if failoverRcode == ret.Rcode {
-				if fails < len(f.proxies) {
+				if fails < len(list) {
 					tryNext = true
 				}
 			}
@@ -234,17 +309,7 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 			continue
 		}
 
-		// Check if we have an alternate Rcode defined, check if we match on the code
-		for _, alternateRcode := range f.nextAlternateRcodes {
-			if alternateRcode == ret.Rcode && f.Next != nil { // In case we do not have a Next handler, just continue normally
-				if _, ok := f.Next.(*Forward); ok { // Only continue if the next forwarder is also a Forworder
-					return plugin.NextOrFailure(f.Name(), f.Next, ctx, w, r)
-				}
-			}
-		}
-
-		w.WriteMsg(ret)
-		return 0, nil
+		return f.writeReply(ctx, w, r, ret)
 	}
 
 	if upstreamErr != nil {
@@ -254,6 +319,40 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 	return dns.RcodeServerFailure, ErrNoHealthy
 }
 
+// connect sends the request to proxy. It retries when a cached connection was closed by the upstream, and
+// over TCP when the reply is truncated and prefer_udp is configured. The options that were used are returned.
+func (f *Forward) connect(ctx context.Context, proxy *proxyPkg.Proxy, state request.Request) (*dns.Msg, proxyPkg.Options, error) {
+	opts := f.opts
+	for {
+		ret, err := proxy.Connect(ctx, state, opts)
+
+		if err == proxyPkg.ErrCachedClosed { // Remote side closed conn, can only happen with TCP.
+			continue
+		}
+		// Retry with TCP if truncated and prefer_udp configured.
+		if ret != nil && ret.Truncated && !opts.ForceTCP && opts.PreferUDP {
+			opts.ForceTCP = true
+			continue
+		}
+		return ret, opts, err
+	}
+}
+
+// writeReply writes ret to the client, unless its rcode is one for which the next forward plugin should be tried.
+func (f *Forward) writeReply(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, ret *dns.Msg) (int, error) {
+	// Check if we have an alternate Rcode defined, check if we match on the code
+	for _, alternateRcode := range f.nextAlternateRcodes {
+		if alternateRcode == ret.Rcode && f.Next != nil { // In case we do not have a Next handler, just continue normally
+			if _, ok := f.Next.(*Forward); ok { // Only continue if the next forwarder is also a Forworder
+				return plugin.NextOrFailure(f.Name(), f.Next, ctx, w, r)
+			}
+		}
+	}
+
+	w.WriteMsg(ret)
+	return 0, nil
+}
+
 func (f *Forward) match(state request.Request) bool {
 	if !plugin.Name(f.from).Matches(state.Name()) || !f.isAllowedDomain(state.Name()) {
 		return false
@@ -282,7 +381,20 @@ func (f *Forward) ForceTCP() bool { return f.opts.ForceTCP }
 func (f *Forward) PreferUDP() bool { return f.opts.PreferUDP }
 
 // List returns a set of proxies to be used for this client depending on the policy in f.
-func (f *Forward) List() []*proxyPkg.Proxy { return f.p.List(f.proxies) }
+func (f *Forward) List() []*proxyPkg.Proxy {
+	f.mu.RLock()
+	proxies := f.proxies
+	f.mu.RUnlock()
+	return f.p.List(proxies)
+}
+
+// listRequest returns a set of proxies from proxies to be used for the request in state depending on the policy in f.
+func (f *Forward) listRequest(state request.Request, proxies []*proxyPkg.Proxy) []*proxyPkg.Proxy {
+	if rp, ok := f.p.(requestPolicy); ok {
+		return rp.ListRequest(state, proxies)
+	}
+	return f.p.List(proxies)
+}
 
 var (
 	// ErrNoHealthy means no healthy proxies left.
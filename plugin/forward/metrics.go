@@ -22,4 +22,18 @@ var (
 		Name:      "max_concurrent_rejects_total",
 		Help:      "Counter of the number of queries rejected because the concurrent queries were at maximum.",
 	})
+
+	raceWinsCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "race_wins_total",
+		Help:      "Counter of the number of raced queries won per upstream.",
+	}, []string{"to"})
+
+	hedgedRequestsCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "hedged_requests_total",
+		Help:      "Counter of the number of queries sent to an extra upstream after the hedge delay passed.",
+	})
 )
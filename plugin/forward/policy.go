@@ -1,11 +1,18 @@
 package forward
 
 import (
+	"hash/fnv"
+	"net"
+	"sort"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/coredns/coredns/plugin/pkg/proxy"
 	"github.com/coredns/coredns/plugin/pkg/rand"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
 )
 
 // Policy defines a policy we use for selecting upstreams.
@@ -14,6 +21,12 @@ type Policy interface {
 	String() string
 }
 
+// requestPolicy is a Policy that selects upstreams based on the request.
+type requestPolicy interface {
+	Policy
+	ListRequest(request.Request, []*proxy.Proxy) []*proxy.Proxy
+}
+
 // random is a policy that implements random upstream selection.
 type random struct{}
 
@@ -66,4 +79,157 @@ func (r *sequential) List(p []*proxy.Proxy) []*proxy.Proxy {
 	return p
 }
 
+// fastest is a policy that orders hosts by their average round trip time, fastest first. For
+// fastestExplore percent of the queries a random ordering is used instead, so the round trip times of
+// the slower hosts keep being measured.
+type fastest struct{}
+
+func (r *fastest) String() string { return "fastest" }
+
+func (r *fastest) List(p []*proxy.Proxy) []*proxy.Proxy {
+	if len(p) < 2 {
+		return p
+	}
+	if rn.Int()%100 < fastestExplore {
+		return (&random{}).List(p)
+	}
+
+	rtts := make(map[*proxy.Proxy]time.Duration, len(p))
+	for _, p1 := range p {
+		rtts[p1] = p1.AvgRTT()
+	}
+
+	fast := make([]*proxy.Proxy, len(p))
+	copy(fast, p)
+	sort.SliceStable(fast, func(i, j int) bool { return rtts[fast[i]] < rtts[fast[j]] })
+
+	return fast
+}
+
+// weighted is a policy that selects hosts randomly, in proportion to their weight. The weights are
+// in the same order as the hosts.
+type weighted struct {
+	weights []int
+}
+
+func (r *weighted) String() string { return "weighted" }
+
+func (r *weighted) List(p []*proxy.Proxy) []*proxy.Proxy {
+	if len(p) < 2 {
+		return p
+	}
+
+	left := make([]int, len(p))
+	total := 0
+	for i := range p {
+		left[i] = 1 // hosts without a weight get the smallest one
+		if i < len(r.weights) {
+			left[i] = r.weights[i]
+		}
+		total += left[i]
+	}
+
+	// Weighted random sampling without replacement.
+	w := make([]*proxy.Proxy, 0, len(p))
+	for range p {
+		n := rn.Int() % total
+		for i := range left {
+			if left[i] == 0 {
+				continue
+			}
+			if n < left[i] {
+				w = append(w, p[i])
+				total -= left[i]
+				left[i] = 0
+				break
+			}
+			n -= left[i]
+		}
+	}
+
+	return w
+}
+
+// Keys that can be used to hash requests in the hash policy.
+const (
+	hashQName = iota
+	hashClientIP
+	hashClientSubnet
+)
+
+// hash is a policy that maps every request to the same ordering of hosts, based on a key
+// taken from the request, using rendezvous (highest random weight) hashing. When a host is down,
+// forward moves on to the next host in the ordering, so only the keys that were mapped to that
+// host are moved to other hosts.
+type hash struct {
+	key int
+}
+
+func (r *hash) String() string { return "hash" }
+
+// List is used when there is no request to take a key from, it returns the hosts in random order.
+func (r *hash) List(p []*proxy.Proxy) []*proxy.Proxy { return (&random{}).List(p) }
+
+func (r *hash) ListRequest(state request.Request, p []*proxy.Proxy) []*proxy.Proxy {
+	if len(p) < 2 {
+		return p
+	}
+
+	key := r.hashKey(state)
+	scores := make(map[*proxy.Proxy]uint64, len(p))
+	for _, p1 := range p {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte(p1.Addr()))
+		scores[p1] = h.Sum64()
+	}
+
+	hrw := make([]*proxy.Proxy, len(p))
+	copy(hrw, p)
+	sort.SliceStable(hrw, func(i, j int) bool { return scores[hrw[i]] > scores[hrw[j]] })
+
+	return hrw
+}
+
+// hashKey returns the key of the request that is hashed.
+func (r *hash) hashKey(state request.Request) string {
+	switch r.key {
+	case hashClientIP:
+		return state.IP()
+	case hashClientSubnet:
+		return clientSubnet(state)
+	}
+	return strings.ToLower(state.Name())
+}
+
+// clientSubnet returns the EDNS0 client subnet of the request, or when there is none, the subnet
+// of the client address using a /24 (IPv4) or /56 (IPv6) mask.
+func clientSubnet(state request.Request) string {
+	if opt := state.Req.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+				bits := 32
+				if e.Family == 2 {
+					bits = 128
+				}
+				n := net.IPNet{IP: e.Address.Mask(net.CIDRMask(int(e.SourceNetmask), bits)), Mask: net.CIDRMask(int(e.SourceNetmask), bits)}
+				return n.String()
+			}
+		}
+	}
+
+	ip := net.ParseIP(state.IP())
+	if ip == nil {
+		return state.IP()
+	}
+	if ip.To4() != nil {
+		n := net.IPNet{IP: ip.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+		return n.String()
+	}
+	n := net.IPNet{IP: ip.Mask(net.CIDRMask(56, 128)), Mask: net.CIDRMask(56, 128)}
+	return n.String()
+}
+
+const fastestExplore = 5 // percentage of queries that use a random ordering in the fastest policy.
+
 var rn = rand.New(time.Now().UnixNano())
@@ -0,0 +1,192 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/proxy"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+func TestFastestPolicy(t *testing.T) {
+	slow := dnstest.NewMultipleServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		time.Sleep(20 * time.Millisecond)
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		w.WriteMsg(ret)
+	})
+	defer slow.Close()
+	fast := dnstest.NewMultipleServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		w.WriteMsg(ret)
+	})
+	defer fast.Close()
+
+	ps := []*proxy.Proxy{
+		proxy.NewProxy("TestFastestPolicy", slow.Addr, transport.DNS),
+		proxy.NewProxy("TestFastestPolicy", fast.Addr, transport.DNS),
+	}
+	for _, p := range ps {
+		p.Start(5 * time.Second)
+		defer p.Close()
+
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		req := request.Request{Req: m, W: &test.ResponseWriter{}}
+		if _, err := p.Connect(context.Background(), req, proxy.Options{}); err != nil {
+			t.Fatalf("Failed to connect to %s: %s", p.Addr(), err)
+		}
+	}
+
+	f := &fastest{}
+	first := 0
+	for range 100 {
+		if f.List(ps)[0] == ps[1] {
+			first++
+		}
+	}
+	// 5% of the lists are random, so the fast upstream should be first most of the time.
+	if first < 80 {
+		t.Errorf("Expected fastest upstream to be first in at least 80 lists, got %d", first)
+	}
+}
+
+func TestFastestPolicyBlackhole(t *testing.T) {
+	// An upstream that never answers.
+	blackhole, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer blackhole.Close()
+	healthy := dnstest.NewMultipleServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		time.Sleep(5 * time.Millisecond)
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		w.WriteMsg(ret)
+	})
+	defer healthy.Close()
+
+	ps := []*proxy.Proxy{
+		proxy.NewProxy("TestFastestPolicyBlackhole", blackhole.LocalAddr().String(), transport.DNS),
+		proxy.NewProxy("TestFastestPolicyBlackhole", healthy.Addr, transport.DNS),
+	}
+	for _, p := range ps {
+		p.SetReadTimeout(100 * time.Millisecond)
+		p.Start(5 * time.Second)
+		defer p.Close()
+
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		req := request.Request{Req: m, W: &test.ResponseWriter{}}
+		p.Connect(context.Background(), req, proxy.Options{})
+	}
+
+	if rtt := ps[0].AvgRTT(); rtt != 100*time.Millisecond {
+		t.Errorf("Expected the timeout to count as a round trip time of %s, got %s", 100*time.Millisecond, rtt)
+	}
+
+	f := &fastest{}
+	first := 0
+	for range 100 {
+		if f.List(ps)[0] == ps[1] {
+			first++
+		}
+	}
+	if first < 80 {
+		t.Errorf("Expected healthy upstream to be first in at least 80 lists, got %d", first)
+	}
+}
+
+func TestWeightedPolicy(t *testing.T) {
+	ps := []*proxy.Proxy{
+		proxy.NewProxy("TestWeightedPolicy", "127.0.0.1:53", transport.DNS),
+		proxy.NewProxy("TestWeightedPolicy", "127.0.0.2:53", transport.DNS),
+		proxy.NewProxy("TestWeightedPolicy", "127.0.0.3:53", transport.DNS),
+	}
+
+	w := &weighted{weights: []int{8, 1, 1}}
+	first := map[*proxy.Proxy]int{}
+	for range 1000 {
+		l := w.List(ps)
+		if len(l) != len(ps) {
+			t.Fatalf("Expected %d upstreams, got %d", len(ps), len(l))
+		}
+		seen := map[*proxy.Proxy]bool{}
+		for _, p := range l {
+			if seen[p] {
+				t.Fatalf("Expected every upstream once, got %s twice", p.Addr())
+			}
+			seen[p] = true
+		}
+		first[l[0]]++
+	}
+
+	if first[ps[0]] < 700 {
+		t.Errorf("Expected upstream with weight 8 to be first in about 800 of 1000 lists, got %d", first[ps[0]])
+	}
+	if first[ps[1]] == 0 || first[ps[2]] == 0 {
+		t.Errorf("Expected upstreams with weight 1 to be first sometimes, got %d and %d", first[ps[1]], first[ps[2]])
+	}
+}
+
+func TestHashPolicy(t *testing.T) {
+	ps := []*proxy.Proxy{
+		proxy.NewProxy("TestHashPolicy", "127.0.0.1:53", transport.DNS),
+		proxy.NewProxy("TestHashPolicy", "127.0.0.2:53", transport.DNS),
+		proxy.NewProxy("TestHashPolicy", "127.0.0.3:53", transport.DNS),
+	}
+
+	h := &hash{key: hashQName}
+	stateFor := func(name string) request.Request {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		return request.Request{Req: m, W: &test.ResponseWriter{}}
+	}
+
+	// The same name maps to the same ordering, regardless of case.
+	first := h.ListRequest(stateFor("example.org."), ps)
+	for _, name := range []string{"example.org.", "EXAMPLE.org."} {
+		l := h.ListRequest(stateFor(name), ps)
+		for i := range l {
+			if l[i] != first[i] {
+				t.Fatalf("Expected the same ordering for %s", name)
+			}
+		}
+	}
+
+	// Removing an upstream only moves the names that mapped to it.
+	names := []string{"a.example.", "b.example.", "c.example.", "d.example.", "e.example.", "f.example.", "g.example.", "h.example."}
+	for _, name := range names {
+		before := h.ListRequest(stateFor(name), ps)[0]
+		if before == ps[2] {
+			continue
+		}
+		after := h.ListRequest(stateFor(name), ps[:2])[0]
+		if before != after {
+			t.Errorf("Expected %s to stay on %s, moved to %s", name, before.Addr(), after.Addr())
+		}
+	}
+}
+
+func TestClientSubnet(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	state := request.Request{Req: m, W: &test.ResponseWriter{}} // client is 10.240.0.1
+	if s := clientSubnet(state); s != "10.240.0.0/24" {
+		t.Errorf("Expected %s, got %s", "10.240.0.0/24", s)
+	}
+
+	m.SetEdns0(4096, false)
+	m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 16, Address: net.ParseIP("192.168.12.1").To4()})
+	if s := clientSubnet(state); s != "192.168.0.0/16" {
+		t.Errorf("Expected %s, got %s", "192.168.0.0/16", s)
+	}
+}
@@ -0,0 +1,132 @@
+package forward
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	proxyPkg "github.com/coredns/coredns/plugin/pkg/proxy"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// raceResult is the outcome of one of the queries sent in race mode.
+type raceResult struct {
+	proxy *proxyPkg.Proxy
+	ret   *dns.Msg
+	opts  proxyPkg.Options
+	err   error
+}
+
+// raceUpstreams sends the request to the first f.race healthy upstreams in list at the same time and
+// returns the first acceptable reply. When a hedge delay is configured, the query is only sent to the
+// next upstream when no acceptable reply came in within the delay. The queries still in flight are
+// cancelled once there is a winner. When no reply is acceptable, the last reply (or error) is returned.
+// raced is false when there are less than two healthy upstreams, nothing has been sent in that case.
+func (f *Forward) raceUpstreams(ctx context.Context, state request.Request, list []*proxyPkg.Proxy) (ret *dns.Msg, raced bool, err error) {
+	racers := make([]*proxyPkg.Proxy, 0, f.race)
+	for _, p := range list {
+		if len(racers) == f.race {
+			break
+		}
+		if !p.Down(f.maxfails) {
+			racers = append(racers, p)
+		}
+	}
+	if len(racers) < 2 {
+		return nil, false, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := time.Now()
+	results := make(chan raceResult, len(racers))
+	sent := 0
+	send := func() {
+		p := racers[sent]
+		sent++
+		// Connect changes the message ID while in flight, so each racer needs its own copy.
+		st := state
+		st.Req = state.Req.Copy()
+		go func() {
+			ret, opts, err := f.connect(ctx, p, st)
+			results <- raceResult{proxy: p, ret: ret, opts: opts, err: err}
+		}()
+	}
+
+	send()
+	if f.hedgeDelay == 0 {
+		for sent < len(racers) {
+			send()
+		}
+	}
+
+	var (
+		hedge <-chan time.Time
+		timer *time.Timer
+	)
+	if sent < len(racers) {
+		timer = time.NewTimer(f.hedgeDelay)
+		defer timer.Stop()
+		hedge = timer.C
+	}
+	// sendHedge sends the query to the next upstream and rearms the timer if there are more upstreams left.
+	sendHedge := func() {
+		send()
+		hedgedRequestsCount.Add(1)
+		if sent < len(racers) {
+			timer.Reset(f.hedgeDelay)
+			return
+		}
+		hedge = nil
+	}
+
+	var last raceResult
+	for done := 0; done < sent; {
+		select {
+		case <-hedge:
+			sendHedge()
+
+		case r := <-results:
+			done++
+			if len(f.tapPlugins) != 0 {
+				toDnstap(ctx, f, r.proxy.Addr(), state, r.opts, r.ret, start)
+			}
+			if r.err != nil && r.err != proxyPkg.ErrCircuitOpen && f.maxfails != 0 {
+				r.proxy.Healthcheck()
+			}
+			if f.acceptable(state, r.ret, r.err) {
+				raceWinsCount.WithLabelValues(r.proxy.Addr()).Add(1)
+				return r.ret, true, nil
+			}
+			last = r
+
+			// Everything that was sent failed, don't wait for the hedge delay to pass.
+			if done == sent && hedge != nil {
+				timer.Stop()
+				sendHedge()
+			}
+
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+	}
+
+	if last.err == nil && last.ret == nil {
+		return nil, true, ErrNoHealthy
+	}
+	return last.ret, true, last.err
+}
+
+// acceptable returns true if ret is a reply to the request in state that can be given to the client.
+func (f *Forward) acceptable(state request.Request, ret *dns.Msg, err error) bool {
+	if err != nil || ret == nil {
+		return false
+	}
+	if !state.Match(ret) {
+		return false
+	}
+	return !slices.Contains(f.failoverRcodes, ret.Rcode)
+}
@@ -0,0 +1,130 @@
+package forward
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/proxy"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// newRaceServer returns a server that answers with rcode after delay, and counts the queries it gets.
+func newRaceServer(delay time.Duration, rcode int, count *uint32) *dnstest.Server {
+	return dnstest.NewMultipleServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddUint32(count, 1)
+		time.Sleep(delay)
+		ret := new(dns.Msg)
+		ret.SetRcode(r, rcode)
+		if rcode == dns.RcodeSuccess {
+			ret.Answer = append(ret.Answer, test.A("example.org. IN A 127.0.0.1"))
+		}
+		w.WriteMsg(ret)
+	})
+}
+
+func TestRace(t *testing.T) {
+	var slowCount, fastCount uint32
+	slow := newRaceServer(500*time.Millisecond, dns.RcodeSuccess, &slowCount)
+	defer slow.Close()
+	fast := newRaceServer(0, dns.RcodeSuccess, &fastCount)
+	defer fast.Close()
+
+	f := New()
+	f.p = &sequential{}
+	f.race = 2
+	f.SetProxy(proxy.NewProxy("TestRace", slow.Addr, transport.DNS))
+	f.SetProxy(proxy.NewProxy("TestRace", fast.Addr, transport.DNS))
+	defer closeProxies(f)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+	start := time.Now()
+	if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if d := time.Since(start); d > 250*time.Millisecond {
+		t.Errorf("Expected the fast upstream to win, took %s", d)
+	}
+	if rec.Msg == nil || len(rec.Msg.Answer) != 1 {
+		t.Fatalf("Expected an answer, got %v", rec.Msg)
+	}
+	if atomic.LoadUint32(&fastCount) != 1 {
+		t.Errorf("Expected the fast upstream to get the query")
+	}
+}
+
+func TestRaceHedge(t *testing.T) {
+	var firstCount, secondCount uint32
+	first := newRaceServer(0, dns.RcodeSuccess, &firstCount)
+	defer first.Close()
+	second := newRaceServer(0, dns.RcodeSuccess, &secondCount)
+	defer second.Close()
+
+	f := New()
+	f.p = &sequential{}
+	f.race = 2
+	f.hedgeDelay = 200 * time.Millisecond
+	f.SetProxy(proxy.NewProxy("TestRaceHedge", first.Addr, transport.DNS))
+	f.SetProxy(proxy.NewProxy("TestRaceHedge", second.Addr, transport.DNS))
+	defer closeProxies(f)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if x := atomic.LoadUint32(&firstCount); x != 1 {
+		t.Errorf("Expected first upstream to get %d query, got %d", 1, x)
+	}
+	if x := atomic.LoadUint32(&secondCount); x != 0 {
+		t.Errorf("Expected no hedged query when the first upstream answers within the delay, got %d", x)
+	}
+}
+
+func TestRaceFailover(t *testing.T) {
+	var failCount, okCount uint32
+	fail := newRaceServer(0, dns.RcodeServerFailure, &failCount)
+	defer fail.Close()
+	ok := newRaceServer(50*time.Millisecond, dns.RcodeSuccess, &okCount)
+	defer ok.Close()
+
+	f := New()
+	f.p = &sequential{}
+	f.race = 2
+	f.hedgeDelay = time.Second
+	f.failoverRcodes = []int{dns.RcodeServerFailure}
+	f.SetProxy(proxy.NewProxy("TestRaceFailover", fail.Addr, transport.DNS))
+	f.SetProxy(proxy.NewProxy("TestRaceFailover", ok.Addr, transport.DNS))
+	defer closeProxies(f)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+	start := time.Now()
+	if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Expected a failed query to fire the hedge immediately, took %s", d)
+	}
+	if rec.Msg == nil || rec.Msg.Rcode != dns.RcodeSuccess {
+		t.Fatalf("Expected NOERROR from the second upstream, got %v", rec.Msg)
+	}
+}
+
+func closeProxies(f *Forward) {
+	for _, p := range f.proxies {
+		p.Close()
+	}
+}
@@ -0,0 +1,100 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"slices"
+
+	proxyPkg "github.com/coredns/coredns/plugin/pkg/proxy"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// pool is a named set of upstreams that queries can be routed to.
+type pool struct {
+	name    string
+	to      []string // the upstreams as configured, the proxies are created from these during setup
+	proxies []*proxyPkg.Proxy
+}
+
+// matchFunc returns true if the request in state should be routed.
+type matchFunc func(ctx context.Context, state request.Request) bool
+
+// route sends the queries that match to a pool.
+type route struct {
+	pool  *pool
+	match matchFunc
+}
+
+// routeRequest returns the upstreams for the request in state: the ones of the pool of the first route that
+// matches, or the default upstreams when no route matches. The name of the pool is empty for the latter.
+func (f *Forward) routeRequest(ctx context.Context, state request.Request) ([]*proxyPkg.Proxy, string) {
+	for _, r := range f.routes {
+		if r.match(ctx, state) {
+			return r.pool.proxies, r.pool.name
+		}
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.proxies, ""
+}
+
+// upstreams returns all proxies of f, the default ones and the ones only used in pools. A proxy
+// that is used more than once is returned once, so it is health checked once.
+func (f *Forward) upstreams() []*proxyPkg.Proxy {
+	f.mu.RLock()
+	all := slices.Clone(f.proxies)
+	f.mu.RUnlock()
+	for _, pl := range f.pools {
+		for _, p := range pl.proxies {
+			if !slices.Contains(all, p) {
+				all = append(all, p)
+			}
+		}
+	}
+	return all
+}
+
+// matchType returns a matchFunc that matches on the query type.
+func matchType(types []uint16) matchFunc {
+	return func(_ context.Context, state request.Request) bool {
+		return slices.Contains(types, state.QType())
+	}
+}
+
+// matchClient returns a matchFunc that matches when the client address is in one of nets.
+func matchClient(nets []*net.IPNet) matchFunc {
+	return func(_ context.Context, state request.Request) bool {
+		return containsIP(nets, net.ParseIP(state.IP()))
+	}
+}
+
+// matchECS returns a matchFunc that matches when the address in the EDNS0 client subnet option is in one
+// of nets. Requests without the option don't match.
+func matchECS(nets []*net.IPNet) matchFunc {
+	return func(_ context.Context, state request.Request) bool {
+		opt := state.Req.IsEdns0()
+		if opt == nil {
+			return false
+		}
+		for _, o := range opt.Option {
+			if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+				return containsIP(nets, e.Address)
+			}
+		}
+		return false
+	}
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/proxy"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+func mustCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func TestRouteMatch(t *testing.T) {
+	office := []*net.IPNet{mustCIDR("10.1.0.0/16")}
+
+	ecs := new(dns.Msg)
+	ecs.SetQuestion("example.org.", dns.TypeA)
+	ecs.SetEdns0(4096, false)
+	ecs.IsEdns0().Option = append(ecs.IsEdns0().Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("10.1.2.0").To4()})
+
+	tests := []struct {
+		match    matchFunc
+		qtype    uint16
+		remote   string
+		msg      *dns.Msg
+		expected bool
+	}{
+		{matchType([]uint16{dns.TypeAAAA}), dns.TypeAAAA, "10.240.0.1", nil, true},
+		{matchType([]uint16{dns.TypeAAAA}), dns.TypeA, "10.240.0.1", nil, false},
+		{matchClient(office), dns.TypeA, "10.1.0.1", nil, true},
+		{matchClient(office), dns.TypeA, "10.240.0.1", nil, false},
+		{matchECS(office), dns.TypeA, "10.240.0.1", nil, false},
+		{matchECS(office), dns.TypeA, "10.240.0.1", ecs, true},
+	}
+
+	for i, tc := range tests {
+		m := tc.msg
+		if m == nil {
+			m = new(dns.Msg)
+			m.SetQuestion("example.org.", tc.qtype)
+		}
+		state := request.Request{Req: m, W: &test.ResponseWriter{RemoteIP: tc.remote}}
+		if got := tc.match(context.TODO(), state); got != tc.expected {
+			t.Errorf("Test %d: expected %t, got %t", i, tc.expected, got)
+		}
+	}
+}
+
+func TestRoutePool(t *testing.T) {
+	var defCount, v6Count uint32
+	def := newRaceServer(0, dns.RcodeSuccess, &defCount)
+	defer def.Close()
+	v6 := newRaceServer(0, dns.RcodeSuccess, &v6Count)
+	defer v6.Close()
+
+	f := New()
+	f.SetProxy(proxy.NewProxy("TestRoutePool", def.Addr, transport.DNS))
+	v6Pool := &pool{name: "v6", proxies: []*proxy.Proxy{proxy.NewProxy("TestRoutePool", v6.Addr, transport.DNS)}}
+	v6Pool.proxies[0].Start(f.hcInterval)
+	f.pools = []*pool{v6Pool}
+	f.routes = []route{{pool: v6Pool, match: matchType([]uint16{dns.TypeAAAA})}}
+	defer func() {
+		for _, p := range f.upstreams() {
+			p.Close()
+		}
+	}()
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeAAAA} {
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+	}
+	if n := atomic.LoadUint32(&defCount); n != 1 {
+		t.Errorf("Expected 1 query to the default upstreams, got %d", n)
+	}
+	if n := atomic.LoadUint32(&v6Count); n != 2 {
+		t.Errorf("Expected 2 queries to the v6 pool, got %d", n)
+	}
+}
+
+func TestUpstreams(t *testing.T) {
+	a := proxy.NewProxy("TestUpstreams", "10.0.0.1:53", transport.DNS)
+	b := proxy.NewProxy("TestUpstreams", "10.0.0.2:53", transport.DNS)
+	c := proxy.NewProxy("TestUpstreams", "10.0.0.3:53", transport.DNS)
+
+	f := New()
+	f.proxies = []*proxy.Proxy{a, b}
+	f.pools = []*pool{{name: "x", proxies: []*proxy.Proxy{b, c}}, {name: "y", proxies: []*proxy.Proxy{c}}}
+
+	if got := f.upstreams(); len(got) != 3 || got[0] != a || got[1] != b || got[2] != c {
+		t.Errorf("Expected each upstream once, got %v", got)
+	}
+}
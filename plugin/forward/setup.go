@@ -3,10 +3,13 @@
 package forward
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -15,11 +18,15 @@ import (
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/dnstap"
+	"github.com/coredns/coredns/plugin/pkg/doh"
+	"github.com/coredns/coredns/plugin/pkg/expression"
 	"github.com/coredns/coredns/plugin/pkg/parse"
 	"github.com/coredns/coredns/plugin/pkg/proxy"
 	pkgtls "github.com/coredns/coredns/plugin/pkg/tls"
 	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/request"
 
+	"github.com/expr-lang/expr"
 	"github.com/miekg/dns"
 )
 
@@ -34,9 +41,6 @@ func setup(c *caddy.Controller) error {
 	}
 	for i := range fs {
 		f := fs[i]
-		if f.Len() > max {
-			return plugin.Error("forward", fmt.Errorf("more than %d TOs configured: %d", max, f.Len()))
-		}
 
 		if i == len(fs)-1 {
 			// last forward: point next to next plugin
@@ -73,15 +77,17 @@ func setup(c *caddy.Controller) error {
 
 // OnStartup starts a goroutines for all proxies.
 func (f *Forward) OnStartup() (err error) {
-	for _, p := range f.proxies {
+	for _, p := range f.upstreams() {
 		p.Start(f.hcInterval)
 	}
+	f.startDiscovery()
 	return nil
 }
 
 // OnShutdown stops all configured proxies.
 func (f *Forward) OnShutdown() error {
-	for _, p := range f.proxies {
+	f.stopDiscovery()
+	for _, p := range f.upstreams() {
 		p.Stop()
 	}
 	return nil
@@ -136,10 +142,25 @@ func parseStanza(c *caddy.Controller) (*Forward, error) {
 	if len(to) == 0 {
 		return f, c.ArgErr()
 	}
-
-	toHosts, err := parse.HostPortOrFile(to...)
-	if err != nil {
-		return f, err
+	// Upstreams given as a name are resolved when the server starts, and periodically after that.
+	var static []string
+	for _, t := range to {
+		u, err := parseDynamic(t)
+		if err != nil {
+			return f, err
+		}
+		if u == nil {
+			static = append(static, t)
+			continue
+		}
+		f.dynamic = append(f.dynamic, u)
+	}
+	var toHosts []string
+	if len(static) > 0 {
+		var err error
+		if toHosts, err = upstreamHosts(static); err != nil {
+			return f, err
+		}
 	}
 
 	for c.NextBlock() {
@@ -148,18 +169,51 @@ func parseStanza(c *caddy.Controller) (*Forward, error) {
 		}
 	}
 
-	tlsServerNames := make([]string, len(toHosts))
+	for _, r := range f.routes {
+		if r.pool.to == nil {
+			return f, fmt.Errorf("route to unknown pool '%s'", r.pool.name)
+		}
+	}
+
+	// The upstreams of the pools are set up together with the default ones. An upstream that is already
+	// configured is shared, so every upstream has a single health check loop.
+	hosts := slices.Clone(toHosts)
+	index := make(map[string]int)
+	for i, h := range hosts {
+		index[h] = i
+	}
+	for _, pl := range f.pools {
+		poolHosts, err := upstreamHosts(pl.to)
+		if err != nil {
+			return f, err
+		}
+		pl.to = poolHosts
+		for _, h := range poolHosts {
+			if _, ok := index[h]; !ok {
+				index[h] = len(hosts)
+				hosts = append(hosts, h)
+			}
+		}
+	}
+
+	// The upstreams of the pools count as well, an upstream that is shared counts once.
+	if n := len(hosts) + len(f.dynamic); n > max {
+		return f, fmt.Errorf("more than %d TOs configured: %d", max, n)
+	}
+
+	tlsServerNames := make([]string, len(hosts))
 	perServerNameProxyCount := make(map[string]int)
-	transports := make([]string, len(toHosts))
-	allowedTrans := map[string]bool{"dns": true, "tls": true}
-	for i, hostWithZone := range toHosts {
+	transports := make([]string, len(hosts))
+	addrs := make([]string, len(hosts))
+	allowedTrans := map[string]bool{"dns": true, "tls": true, "https": true, "https3": true, "quic": true}
+	for i, hostWithZone := range hosts {
 		host, serverName := splitZone(hostWithZone)
 		trans, h := parse.Transport(host)
 
 		if !allowedTrans[trans] {
 			return f, fmt.Errorf("'%s' is not supported as a destination protocol in forward: %s", trans, host)
 		}
-		if trans == transport.TLS && serverName != "" {
+		if isTLSTransport(trans) && serverName != "" {
 			if f.tlsServerName != "" {
 				return f, fmt.Errorf("both forward ('%s') and proxy level ('%s') TLS servernames are set for upstream proxy '%s'", f.tlsServerName, serverName, host)
 			}
@@ -167,10 +221,29 @@ func parseStanza(c *caddy.Controller) (*Forward, error) {
 			tlsServerNames[i] = serverName
 			perServerNameProxyCount[serverName]++
 		}
-		p := proxy.NewProxy("forward", h, trans)
-		f.proxies = append(f.proxies, p)
+		addrs[i] = h
 		transports[i] = trans
 	}
+	for _, u := range f.dynamic {
+		if !allowedTrans[u.trans] {
+			return f, fmt.Errorf("'%s' is not supported as a destination protocol in forward: %s", u.trans, u.name)
+		}
+		if isTLSTransport(u.trans) && u.serverName != "" && f.tlsServerName != "" {
+			return f, fmt.Errorf("both forward ('%s') and proxy level ('%s') TLS servernames are set for upstream proxy '%s'", f.tlsServerName, u.serverName, u.name)
+		}
+	}
+
+	if w, ok := f.p.(*weighted); ok {
+		if len(f.pools) > 0 {
+			return f, errors.New("policy weighted can't be used together with pools")
+		}
+		if len(f.dynamic) > 0 {
+			return f, errors.New("policy weighted can't be used together with upstreams that are resolved")
+		}
+		if len(w.weights) != len(toHosts) {
+			return f, fmt.Errorf("policy weighted: %d weights configured for %d upstreams", len(w.weights), len(toHosts))
+		}
+	}
 
 	perServerNameTlsConfig := make(map[string]*tls.Config)
 	if f.tlsServerName != "" {
@@ -186,29 +259,38 @@ func parseStanza(c *caddy.Controller) (*Forward, error) {
 
 	// Initialize ClientSessionCache in tls.Config. This may speed up a TLS handshake
 	// in upcoming connections to the same TLS server.
-	f.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(len(f.proxies))
+	f.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(len(hosts))
 
-	for i := range f.proxies {
-		// Only set this for proxies that need it.
-		if transports[i] == transport.TLS {
-			if tlsConfig, ok := perServerNameTlsConfig[tlsServerNames[i]]; ok {
-				f.proxies[i].SetTLSConfig(tlsConfig)
-			} else {
-				f.proxies[i].SetTLSConfig(f.tlsConfig)
-			}
+	proxies := make([]*proxy.Proxy, len(hosts))
+	for i := range hosts {
+		tlsConfig := f.tlsConfig
+		if cfg, ok := perServerNameTlsConfig[tlsServerNames[i]]; ok {
+			tlsConfig = cfg
 		}
-		f.proxies[i].SetExpire(f.expire)
-		f.proxies[i].GetHealthchecker().SetRecursionDesired(f.opts.HCRecursionDesired)
-		// when TLS is used, checks are set to tcp-tls
-		if f.opts.ForceTCP && transports[i] != transport.TLS {
-			f.proxies[i].GetHealthchecker().SetTCPTransport()
+		proxies[i] = f.newProxy(addrs[i], transports[i], tlsConfig)
+	}
+	f.proxies = proxies[:len(toHosts)]
+	f.static = f.proxies
+	for _, pl := range f.pools {
+		for _, h := range pl.to {
+			pl.proxies = append(pl.proxies, proxies[index[h]])
 		}
-		f.proxies[i].GetHealthchecker().SetDomain(f.opts.HCDomain)
 	}
 
 	return f, nil
 }
 
+// upstreamHosts returns the host:port strings of the upstreams in to, which may also name resolv.conf like files.
+func upstreamHosts(to []string) ([]string, error) {
+	for i := range to {
+		// DoH upstreams may be written as a URL, the path is always doh.Path.
+		if trans, _ := parse.Transport(to[i]); trans == transport.HTTPS || trans == transport.HTTPS3 {
+			to[i] = strings.TrimSuffix(to[i], doh.Path)
+		}
+	}
+	return parse.HostPortOrFile(to...)
+}
+
 func parseBlock(c *caddy.Controller, f *Forward) error {
 	config := dnsserver.GetConfig(c)
 	switch c.Val() {
@@ -275,6 +357,16 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 			return c.ArgErr()
 		}
 		f.opts.ForceTCP = true
+	case "cookie":
+		if c.NextArg() {
+			return c.ArgErr()
+		}
+		f.cookies = true
+	case "case_randomization":
+		if c.NextArg() {
+			return c.ArgErr()
+		}
+		f.randCase = true
 	case "prefer_udp":
 		if c.NextArg() {
 			return c.ArgErr()
@@ -324,9 +416,99 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 			f.p = &roundRobin{}
 		case "sequential":
 			f.p = &sequential{}
+		case "fastest":
+			f.p = &fastest{}
+		case "hash":
+			if !c.NextArg() {
+				return c.ArgErr()
+			}
+			switch k := c.Val(); k {
+			case "qname":
+				f.p = &hash{key: hashQName}
+			case "client_ip":
+				f.p = &hash{key: hashClientIP}
+			case "client_subnet":
+				f.p = &hash{key: hashClientSubnet}
+			default:
+				return c.Errf("unknown hash key '%s'", k)
+			}
+		case "weighted":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return c.ArgErr()
+			}
+			w := &weighted{}
+			for _, a := range args {
+				n, err := strconv.Atoi(a)
+				if err != nil {
+					return err
+				}
+				if n <= 0 {
+					return fmt.Errorf("policy weighted: weight must be positive: %d", n)
+				}
+				w.weights = append(w.weights, n)
+			}
+			f.p = w
 		default:
 			return c.Errf("unknown policy '%s'", x)
 		}
+	case "race":
+		args := c.RemainingArgs()
+		if len(args) == 0 || len(args) > 2 {
+			return c.ArgErr()
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		if n < 2 {
+			return fmt.Errorf("race needs at least 2 upstreams: %d", n)
+		}
+		f.race = n
+		if len(args) == 2 {
+			dur, err := time.ParseDuration(args[1])
+			if err != nil {
+				return err
+			}
+			if dur < 0 {
+				return fmt.Errorf("race hedge delay can't be negative: %s", dur)
+			}
+			f.hedgeDelay = dur
+		}
+	case "circuit_breaker":
+		cfg, err := parseBreaker(c)
+		if err != nil {
+			return err
+		}
+		f.breaker = &cfg
+	case "pool":
+		args := c.RemainingArgs()
+		if len(args) < 2 {
+			return c.ArgErr()
+		}
+		pl := f.pool(args[0])
+		if pl.to != nil {
+			return fmt.Errorf("pool '%s' is defined more than once", pl.name)
+		}
+		pl.to = args[1:]
+	case "route":
+		r, err := parseRoute(c, f)
+		if err != nil {
+			return err
+		}
+		f.routes = append(f.routes, r)
+	case "resolve_interval":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		dur, err := time.ParseDuration(c.Val())
+		if err != nil {
+			return err
+		}
+		if dur <= 0 {
+			return fmt.Errorf("resolve_interval must be positive: %s", dur)
+		}
+		f.resolveInterval = dur
 	case "max_concurrent":
 		if !c.NextArg() {
 			return c.ArgErr()
@@ -387,4 +569,125 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 	return nil
 }
 
-const max = 15 // Maximum number of upstreams.
+// parseBreaker parses the arguments of the circuit_breaker option, which are keyword value pairs that
+// override the defaults.
+func parseBreaker(c *caddy.Controller) (proxy.BreakerConfig, error) {
+	cfg := proxy.DefaultBreakerConfig()
+	args := c.RemainingArgs()
+	if len(args)%2 != 0 {
+		return cfg, c.ArgErr()
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		key, val := args[i], args[i+1]
+		switch key {
+		case "error_rate":
+			rate, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return cfg, err
+			}
+			if rate <= 0 || rate > 1 {
+				return cfg, fmt.Errorf("circuit_breaker: error_rate must be in (0, 1]: %s", val)
+			}
+			cfg.ErrorRate = rate
+		case "latency", "window", "open":
+			dur, err := time.ParseDuration(val)
+			if err != nil {
+				return cfg, err
+			}
+			if dur < 0 || (key != "latency" && dur == 0) {
+				return cfg, fmt.Errorf("circuit_breaker: invalid %s: %s", key, dur)
+			}
+			switch key {
+			case "latency":
+				cfg.Latency = dur
+			case "window":
+				cfg.Window = dur
+			case "open":
+				cfg.OpenDuration = dur
+			}
+		case "min_requests", "half_open":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return cfg, err
+			}
+			if n <= 0 {
+				return cfg, fmt.Errorf("circuit_breaker: %s must be positive: %d", key, n)
+			}
+			if key == "min_requests" {
+				cfg.MinRequests = n
+			} else {
+				cfg.HalfOpenQueries = n
+			}
+		default:
+			return cfg, c.Errf("circuit_breaker: unknown option '%s'", key)
+		}
+	}
+	return cfg, nil
+}
+
+// pool returns the pool called name, creating it when it does not exist yet. Routes may refer to
+// pools that are defined further down.
+func (f *Forward) pool(name string) *pool {
+	for _, pl := range f.pools {
+		if pl.name == name {
+			return pl
+		}
+	}
+	pl := &pool{name: name}
+	f.pools = append(f.pools, pl)
+	return pl
+}
+
+// parseRoute parses a route rule: route POOL type TYPE...|client CIDR...|ecs CIDR...|expr EXPRESSION.
+func parseRoute(c *caddy.Controller, f *Forward) (route, error) {
+	args := c.RemainingArgs()
+	if len(args) < 3 {
+		return route{}, c.ArgErr()
+	}
+	r := route{pool: f.pool(args[0])}
+
+	switch kind, vals := args[1], args[2:]; kind {
+	case "type":
+		types := make([]uint16, len(vals))
+		for i, v := range vals {
+			t, ok := dns.StringToType[strings.ToUpper(v)]
+			if !ok {
+				return r, fmt.Errorf("route: invalid type '%s'", v)
+			}
+			types[i] = t
+		}
+		r.match = matchType(types)
+	case "client", "ecs":
+		nets := make([]*net.IPNet, len(vals))
+		for i, v := range vals {
+			_, n, err := net.ParseCIDR(v)
+			if err != nil {
+				return r, fmt.Errorf("route: invalid CIDR '%s': %w", v, err)
+			}
+			nets[i] = n
+		}
+		if kind == "client" {
+			r.match = matchClient(nets)
+		} else {
+			r.match = matchECS(nets)
+		}
+	case "expr":
+		prog, err := expr.Compile(strings.Join(vals, " "), expr.Env(expression.DefaultEnv(context.Background(), nil)), expr.DisableBuiltin("type"))
+		if err != nil {
+			return r, err
+		}
+		r.match = func(ctx context.Context, state request.Request) bool {
+			result, err := expr.Run(prog, expression.DefaultEnv(ctx, &state))
+			if err != nil {
+				return false
+			}
+			// anything other than a boolean true result is considered false
+			b, ok := result.(bool)
+			return ok && b
+		}
+	default:
+		return r, c.Errf("unknown route match '%s'", kind)
+	}
+	return r, nil
+}
@@ -20,8 +20,17 @@ func TestSetupPolicy(t *testing.T) {
 		{"forward . 127.0.0.1 {\npolicy random\n}\n", false, "random", ""},
 		{"forward . 127.0.0.1 {\npolicy round_robin\n}\n", false, "round_robin", ""},
 		{"forward . 127.0.0.1 {\npolicy sequential\n}\n", false, "sequential", ""},
+		{"forward . 127.0.0.1 {\npolicy fastest\n}\n", false, "fastest", ""},
+		{"forward . 127.0.0.1 {\npolicy hash qname\n}\n", false, "hash", ""},
+		{"forward . 127.0.0.1 {\npolicy hash client_subnet\n}\n", false, "hash", ""},
+		{"forward . 127.0.0.1 127.0.0.2 {\npolicy weighted 3 1\n}\n", false, "weighted", ""},
 		// negative
 		{"forward . 127.0.0.1 {\npolicy random2\n}\n", true, "random", "unknown policy"},
+		{"forward . 127.0.0.1 {\npolicy weighted\n}\n", true, "weighted", "Wrong argument count"},
+		{"forward . 127.0.0.1 {\npolicy hash\n}\n", true, "hash", "Wrong argument count"},
+		{"forward . 127.0.0.1 {\npolicy hash qtype\n}\n", true, "hash", "unknown hash key"},
+		{"forward . 127.0.0.1 {\npolicy weighted 0\n}\n", true, "weighted", "weight must be positive"},
+		{"forward . 127.0.0.1 127.0.0.2 {\npolicy weighted 1\n}\n", true, "weighted", "1 weights configured for 2 upstreams"},
 	}
 
 	for i, test := range tests {
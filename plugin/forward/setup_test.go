@@ -43,6 +43,10 @@ func TestSetup(t *testing.T) {
 		{"forward . [::1]:53", false, ".", nil, 2, proxy.Options{HCRecursionDesired: true, HCDomain: "."}, ""},
 		{"forward . [2003::1]:53", false, ".", nil, 2, proxy.Options{HCRecursionDesired: true, HCDomain: "."}, ""},
 		{"forward . 127.0.0.1 \n", false, ".", nil, 2, proxy.Options{HCRecursionDesired: true, HCDomain: "."}, ""},
+		{"forward . https://127.0.0.1", false, ".", nil, 2, proxy.Options{HCRecursionDesired: true, HCDomain: "."}, ""},
+		{"forward . https://127.0.0.1/dns-query", false, ".", nil, 2, proxy.Options{HCRecursionDesired: true, HCDomain: "."}, ""},
+		{"forward . quic://127.0.0.1", false, ".", nil, 2, proxy.Options{HCRecursionDesired: true, HCDomain: "."}, ""},
+		{"forward . https3://127.0.0.1:8443", false, ".", nil, 2, proxy.Options{HCRecursionDesired: true, HCDomain: "."}, ""},
 		{"forward 10.9.3.0/18 127.0.0.1", false, "0.9.10.in-addr.arpa.", nil, 2, proxy.Options{HCRecursionDesired: true, HCDomain: "."}, ""},
 		{`forward . ::1
 		forward com ::2`, false, ".", nil, 2, proxy.Options{HCRecursionDesired: true, HCDomain: "."}, "plugin"},
@@ -50,7 +54,7 @@ func TestSetup(t *testing.T) {
 		{"forward . a27.0.0.1", true, "", nil, 0, proxy.Options{HCRecursionDesired: true, HCDomain: "."}, "not an IP"},
 		{"forward . 127.0.0.1 {\nblaatl\n}\n", true, "", nil, 0, proxy.Options{HCRecursionDesired: true, HCDomain: "."}, "unknown property"},
 		{"forward . 127.0.0.1 {\nhealth_check 0.5s domain\n}\n", true, "", nil, 0, proxy.Options{HCRecursionDesired: true, HCDomain: "."}, "Wrong argument count or unexpected line ending after 'domain'"},
-		{"forward . https://127.0.0.1 \n", true, ".", nil, 2, proxy.Options{HCRecursionDesired: true, HCDomain: "."}, "'https' is not supported as a destination protocol in forward: https://127.0.0.1"},
+		{"forward . grpc://127.0.0.1 \n", true, ".", nil, 2, proxy.Options{HCRecursionDesired: true, HCDomain: "."}, "'grpc' is not supported as a destination protocol in forward: grpc://127.0.0.1"},
 		{"forward xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx 127.0.0.1 \n", true, ".", nil, 2, proxy.Options{HCRecursionDesired: true, HCDomain: "."}, "unable to normalize 'xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx'"},
 	}
 
@@ -326,6 +330,243 @@ func TestSetupMaxConcurrent(t *testing.T) {
 	}
 }
 
+func TestSetupDynamic(t *testing.T) {
+	c := caddy.NewTestController("dns", "forward . 127.0.0.1 resolvers.internal. srv+_dns._udp.resolvers.internal {\nresolve_interval 10s\n}\n")
+	fs, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	f := fs[0]
+	if f.Len() != 1 || len(f.static) != 1 {
+		t.Errorf("Expected 1 static upstream, got %d", f.Len())
+	}
+	if len(f.dynamic) != 2 {
+		t.Fatalf("Expected 2 dynamic upstreams, got %d", len(f.dynamic))
+	}
+	if f.dynamic[0].srv || !f.dynamic[1].srv {
+		t.Errorf("Expected a host name and an SRV name, got %+v and %+v", *f.dynamic[0], *f.dynamic[1])
+	}
+	if f.resolveInterval != 10*time.Second {
+		t.Errorf("Expected resolve interval of 10s, got %s", f.resolveInterval)
+	}
+
+	for _, input := range []string{
+		"forward . resolvers.internal. {\nresolve_interval 0s\n}\n",
+		"forward . resolvers.internal. {\npolicy weighted 1\n}\n",
+		"forward . resolv.cnf\n",
+		"forward . grpc://resolvers.internal\n",
+		"forward . srv+_dns._udp..internal\n",
+	} {
+		c := caddy.NewTestController("dns", input)
+		if _, err := parseForward(c); err == nil {
+			t.Errorf("Expected error for input %s", input)
+		}
+	}
+}
+
+func TestSetupPools(t *testing.T) {
+	tests := []struct {
+		input         string
+		shouldErr     bool
+		expectedPools map[string][]string
+		expectedErr   string
+	}{
+		{"forward . 127.0.0.1 {\npool v6 127.0.0.2 127.0.0.3\nroute v6 type AAAA\n}\n", false, map[string][]string{"v6": {"127.0.0.2:53", "127.0.0.3:53"}}, ""},
+		{"forward . 127.0.0.1 {\nroute office client 10.0.0.0/8\npool office 127.0.0.1 127.0.0.2\n}\n", false, map[string][]string{"office": {"127.0.0.1:53", "127.0.0.2:53"}}, ""},
+		{"forward . 127.0.0.1 {\npool a 127.0.0.2\nroute a ecs 10.0.0.0/8\nroute a expr type() == 'MX'\n}\n", false, map[string][]string{"a": {"127.0.0.2:53"}}, ""},
+		{"forward . 127.0.0.1 {\nroute v6 type AAAA\n}\n", true, nil, "unknown pool"},
+		{"forward . 127.0.0.1 {\npool v6\n}\n", true, nil, "Wrong argument count"},
+		{"forward . 127.0.0.1 {\npool a 127.0.0.2\npool a 127.0.0.3\n}\n", true, nil, "more than once"},
+		{"forward . 127.0.0.1 {\npool a 127.0.0.2\nroute a type BOGUS\n}\n", true, nil, "invalid type"},
+		{"forward . 127.0.0.1 {\npool a 127.0.0.2\nroute a client 10.0.0.0\n}\n", true, nil, "invalid CIDR"},
+		{"forward . 127.0.0.1 {\npool a 127.0.0.2\nroute a qname example.org\n}\n", true, nil, "unknown route match"},
+		{"forward . 127.0.0.1 {\npool a 127.0.0.2\nroute a expr (\n}\n", true, nil, ""},
+		{"forward . 127.0.0.1 {\npool a 127.0.0.2\npolicy weighted 1\n}\n", true, nil, "can't be used together with pools"},
+		{"forward . 127.0.0.1 127.0.0.2 127.0.0.3 127.0.0.4 127.0.0.5 127.0.0.6 127.0.0.7 127.0.0.8 {\npool a 127.0.0.1 127.0.1.2 127.0.1.3 127.0.1.4\npool b 127.0.2.1 127.0.2.2 127.0.2.3 127.0.2.4 127.0.2.5\n}\n", true, nil, "more than 15 TOs configured: 16"},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		fs, err := parseForward(c)
+
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+		}
+		if err != nil {
+			if !test.shouldErr {
+				t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			}
+			if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+			continue
+		}
+
+		f := fs[0]
+		if len(f.pools) != len(test.expectedPools) {
+			t.Fatalf("Test %d: expected %d pools, got %d", i, len(test.expectedPools), len(f.pools))
+		}
+		for _, pl := range f.pools {
+			var addrs []string
+			for _, p := range pl.proxies {
+				addrs = append(addrs, p.Addr())
+			}
+			if !reflect.DeepEqual(addrs, test.expectedPools[pl.name]) {
+				t.Errorf("Test %d: expected pool %s to be %v, got %v", i, pl.name, test.expectedPools[pl.name], addrs)
+			}
+		}
+	}
+
+	// An upstream that is in the default set and in a pool is shared.
+	c := caddy.NewTestController("dns", "forward . 127.0.0.1 {\npool a 127.0.0.1 127.0.0.2\nroute a type AAAA\n}\n")
+	fs, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	f := fs[0]
+	if f.pools[0].proxies[0] != f.proxies[0] {
+		t.Errorf("Expected the upstream to be shared between the default set and the pool")
+	}
+	if n := len(f.upstreams()); n != 2 {
+		t.Errorf("Expected 2 upstreams, got %d", n)
+	}
+}
+
+func TestSetupCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		input       string
+		shouldErr   bool
+		expected    *proxy.BreakerConfig
+		expectedErr string
+	}{
+		{"forward . 127.0.0.1\n", false, nil, ""},
+		{"forward . 127.0.0.1 {\ncircuit_breaker\n}\n", false, &proxy.BreakerConfig{ErrorRate: 0.5, Window: 10 * time.Second, MinRequests: 20, OpenDuration: 5 * time.Second, HalfOpenQueries: 3}, ""},
+		{"forward . 127.0.0.1 {\ncircuit_breaker error_rate 0.25 latency 500ms window 30s min_requests 5 open 10s half_open 1\n}\n", false, &proxy.BreakerConfig{ErrorRate: 0.25, Latency: 500 * time.Millisecond, Window: 30 * time.Second, MinRequests: 5, OpenDuration: 10 * time.Second, HalfOpenQueries: 1}, ""},
+		{"forward . 127.0.0.1 {\ncircuit_breaker error_rate\n}\n", true, nil, "Wrong argument count"},
+		{"forward . 127.0.0.1 {\ncircuit_breaker error_rate 2\n}\n", true, nil, "error_rate must be in"},
+		{"forward . 127.0.0.1 {\ncircuit_breaker window 0s\n}\n", true, nil, "invalid window"},
+		{"forward . 127.0.0.1 {\ncircuit_breaker half_open 0\n}\n", true, nil, "must be positive"},
+		{"forward . 127.0.0.1 {\ncircuit_breaker speed 1\n}\n", true, nil, "unknown option"},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		fs, err := parseForward(c)
+
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+		}
+		if err != nil {
+			if !test.shouldErr {
+				t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			}
+			if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+			continue
+		}
+
+		f := fs[0]
+		if test.expected == nil {
+			if f.breaker != nil {
+				t.Errorf("Test %d: expected no circuit breaker, got %+v", i, *f.breaker)
+			}
+			continue
+		}
+		if f.breaker == nil || *f.breaker != *test.expected {
+			t.Errorf("Test %d: expected circuit breaker %+v, got %+v", i, *test.expected, f.breaker)
+		}
+	}
+}
+
+func TestSetupCaseRandomization(t *testing.T) {
+	c := caddy.NewTestController("dns", "forward . 127.0.0.1 tls://127.0.0.2 {\ncase_randomization\n}\n")
+	fs, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	f := fs[0]
+	if !f.randCase {
+		t.Fatalf("Expected case randomization to be enabled")
+	}
+	if !f.proxies[0].RandCase() {
+		t.Errorf("Expected case randomization for the plain DNS upstream")
+	}
+	if f.proxies[1].RandCase() {
+		t.Errorf("Expected no case randomization for the TLS upstream")
+	}
+
+	c = caddy.NewTestController("dns", "forward . 127.0.0.1 {\ncase_randomization yes\n}\n")
+	if _, err := parseForward(c); err == nil {
+		t.Errorf("Expected error for case_randomization with an argument")
+	}
+}
+
+func TestSetupCookie(t *testing.T) {
+	c := caddy.NewTestController("dns", "forward . 127.0.0.1 {\ncookie\n}\n")
+	fs, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !fs[0].cookies {
+		t.Errorf("Expected cookies to be enabled")
+	}
+
+	c = caddy.NewTestController("dns", "forward . 127.0.0.1 {\ncookie yes\n}\n")
+	if _, err := parseForward(c); err == nil {
+		t.Errorf("Expected error for cookie with an argument")
+	}
+}
+
+func TestSetupRace(t *testing.T) {
+	tests := []struct {
+		input         string
+		shouldErr     bool
+		expectedRace  int
+		expectedHedge time.Duration
+		expectedErr   string
+	}{
+		// positive
+		{"forward . 127.0.0.1 127.0.0.2 {\nrace 2\n}\n", false, 2, 0, ""},
+		{"forward . 127.0.0.1 127.0.0.2 {\nrace 2 50ms\n}\n", false, 2, 50 * time.Millisecond, ""},
+		// negative
+		{"forward . 127.0.0.1 {\nrace\n}\n", true, 0, 0, "Wrong argument count"},
+		{"forward . 127.0.0.1 {\nrace 1\n}\n", true, 0, 0, "at least 2"},
+		{"forward . 127.0.0.1 {\nrace 2 -1s\n}\n", true, 0, 0, "negative"},
+		{"forward . 127.0.0.1 {\nrace 2 1s 3\n}\n", true, 0, 0, "Wrong argument count"},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		fs, err := parseForward(c)
+
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error but found %s for input %s", i, err, test.input)
+		}
+
+		if err != nil {
+			if !test.shouldErr {
+				t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			}
+
+			if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+		}
+
+		if test.shouldErr {
+			continue
+		}
+		f := fs[0]
+		if f.race != test.expectedRace {
+			t.Errorf("Test %d: expected race: %d, got: %d", i, test.expectedRace, f.race)
+		}
+		if f.hedgeDelay != test.expectedHedge {
+			t.Errorf("Test %d: expected hedge delay: %s, got: %s", i, test.expectedHedge, f.hedgeDelay)
+		}
+	}
+}
+
 func TestSetupMaxConnectAttempts(t *testing.T) {
 	tests := []struct {
 		input       string
@@ -291,6 +291,11 @@ func (external) Modified(kubernetes.ModifiedMode) int64    { return 0 }
 func (external) SvcImportIndex(s string) []*object.ServiceImport                    { return nil }
 func (external) ServiceImportList() []*object.ServiceImport                         { return nil }
 func (external) McEpIndex(s string) []*object.MultiClusterEndpoints                 { return nil }
+func (external) IngressIndex(string) []*object.Ingress                              { return nil }
+func (external) GatewayIndex(string) []*object.Gateway                              { return nil }
+func (external) GatewayHostIndex(string) []*object.Gateway                          { return nil }
+func (external) HTTPRouteIndex(string) []*object.HTTPRoute                          { return nil }
+func (external) NodeZone(string) string                                             { return "" }
 func (external) MultiClusterEndpointsList(s string) []*object.MultiClusterEndpoints { return nil }
 
 func (external) EpIndex(s string) []*object.Endpoints {
@@ -308,6 +313,7 @@ func (external) GetNodeByName(ctx context.Context, name string) (*api.Node, erro
 func (external) SvcIndex(s string) []*object.Service                               { return svcIndexExternal[s] }
 func (external) PodIndex(string) []*object.Pod                                     { return nil }
 
+func (external) SvcAliasIndex(string) []*object.Service { return nil }
 func (external) SvcExtIndexReverse(ip string) (result []*object.Service) {
 	for _, svcs := range svcIndexExternal {
 		for _, svc := range svcs {
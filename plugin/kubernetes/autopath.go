@@ -9,6 +9,9 @@ import (
 // AutoPath implements the AutoPathFunc call from the autopath plugin.
 // It returns a per-query search path or nil indicating no searchpathing should happen.
 func (k *Kubernetes) AutoPath(state request.Request) []string {
+	if c := k.cluster(state.Name()); c != nil {
+		return c.AutoPath(state)
+	}
 	// Check if the query falls in a zone we are actually authoritative for and thus if we want autopath.
 	zone := plugin.Zones(k.Zones).Matches(state.Name())
 	if zone == "" {
@@ -33,11 +33,17 @@ func (m *mockAPIConnector) EndpointsList() []*object.Endpoints                 {
 func (m *mockAPIConnector) ServiceImportList() []*object.ServiceImport         { return nil }
 func (m *mockAPIConnector) SvcIndex(s string) []*object.Service                { return nil }
 func (m *mockAPIConnector) SvcIndexReverse(s string) []*object.Service         { return nil }
+func (m *mockAPIConnector) SvcAliasIndex(string) []*object.Service             { return nil }
 func (m *mockAPIConnector) SvcExtIndexReverse(s string) []*object.Service      { return nil }
 func (m *mockAPIConnector) SvcImportIndex(s string) []*object.ServiceImport    { return nil }
 func (m *mockAPIConnector) EpIndex(s string) []*object.Endpoints               { return nil }
 func (m *mockAPIConnector) EpIndexReverse(s string) []*object.Endpoints        { return nil }
 func (m *mockAPIConnector) McEpIndex(s string) []*object.MultiClusterEndpoints { return nil }
+func (m *mockAPIConnector) IngressIndex(string) []*object.Ingress              { return nil }
+func (m *mockAPIConnector) GatewayIndex(string) []*object.Gateway              { return nil }
+func (m *mockAPIConnector) GatewayHostIndex(string) []*object.Gateway          { return nil }
+func (m *mockAPIConnector) HTTPRouteIndex(string) []*object.HTTPRoute          { return nil }
+func (m *mockAPIConnector) NodeZone(string) string                             { return "" }
 func (m *mockAPIConnector) GetNodeByName(ctx context.Context, name string) (*api.Node, error) {
 	return nil, nil
 }
@@ -0,0 +1,109 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/coredns/coredns/plugin"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// newCluster returns a copy of k that serves zone from the cluster that config connects to. All other
+// settings are the ones of k.
+func (k *Kubernetes) newCluster(zone string, config clientcmd.ClientConfig) *Kubernetes {
+	c := *k
+	c.Zones = []string{zone}
+	c.primaryZoneIndex = 0
+	c.ClientConfig = config
+	c.APIServerList = nil
+	c.APICertAuth, c.APIClientCert, c.APIClientKey = "", "", ""
+	c.APIConn = nil
+	c.clusters = nil
+	return &c
+}
+
+// cluster returns the cluster that serves name, or nil if name is not in the zone of a cluster.
+func (k *Kubernetes) cluster(name string) *Kubernetes {
+	if len(k.clusters) == 0 {
+		return nil
+	}
+	zone := plugin.Zones(k.Zones).Matches(name)
+	for _, c := range k.clusters {
+		if c.Zones[0] == zone {
+			return c
+		}
+	}
+	return nil
+}
+
+// ownZones returns true if k serves zones that are not the zone of a cluster, these are served from the
+// connection of k itself.
+func (k *Kubernetes) ownZones() bool {
+	for _, z := range k.Zones {
+		if k.cluster(z) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// initClusters initializes the caches of the clusters of k. The returned onStart waits for all of them,
+// concurrently.
+func (k *Kubernetes) initClusters(ctx context.Context) (onStart func() error, onShut func() error, err error) {
+	var starts, shuts []func() error
+	for _, c := range k.clusters {
+		start, shut, err := c.InitKubeCache(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cluster %s: %s", c.Zones[0], err)
+		}
+		clusterSynced.WithLabelValues(c.Zones[0]).Set(0)
+		starts = append(starts, func() error {
+			err := start()
+			clusterReady(c)
+			return err
+		})
+		shuts = append(shuts, shut)
+	}
+
+	onStart = func() error {
+		errs := make([]error, len(starts))
+		var wg sync.WaitGroup
+		for i, start := range starts {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = start()
+			}()
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	onShut = func() error {
+		var err error
+		for _, shut := range shuts {
+			if e := shut(); e != nil {
+				err = e
+			}
+		}
+		return err
+	}
+	return onStart, onShut, nil
+}
+
+// clusterReady returns true if the caches of cluster c have synced, and records that in the metrics.
+func clusterReady(c *Kubernetes) bool {
+	ok := c.APIConn.HasSynced()
+	synced := 0.0
+	if ok {
+		synced = 1
+	}
+	clusterSynced.WithLabelValues(c.Zones[0]).Set(synced)
+	return ok
+}
@@ -0,0 +1,92 @@
+package kubernetes
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	api "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestClusters(t *testing.T) {
+	ctx := context.Background()
+	k := New([]string{"cluster.local.", "cluster-a.local.", "cluster-b.local."})
+	k.APIConn = &APIConnServeTest{}
+	k.Namespaces = map[string]struct{}{"testns": {}}
+	k.clusters = []*Kubernetes{k.newCluster("cluster-a.local.", nil), k.newCluster("cluster-b.local.", nil)}
+	k.clusters[0].APIConn = &APIConnServeTest{}
+
+	client := fake.NewSimpleClientset()
+	client.CoreV1().Namespaces().Create(ctx, &api.Namespace{ObjectMeta: meta.ObjectMeta{Name: "testns"}}, meta.CreateOptions{})
+	client.CoreV1().Services("testns").Create(ctx, &api.Service{
+		ObjectMeta: meta.ObjectMeta{Name: "other", Namespace: "testns"},
+		Spec:       api.ServiceSpec{ClusterIP: "10.1.0.1", ClusterIPs: []string{"10.1.0.1"}, Ports: []api.ServicePort{{Name: "http", Protocol: "tcp", Port: 80}}},
+	}, meta.CreateOptions{})
+	controller := newdnsController(ctx, client, nil, nil, dnsControlOpts{initEndpointsCache: true, zones: []string{"cluster-b.local."}})
+	k.clusters[1].APIConn = controller
+	for _, c := range k.clusters {
+		c.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	}
+	k.Next = test.NextHandler(dns.RcodeSuccess, nil)
+
+	if k.Ready() {
+		t.Error("Expected not to be ready before cluster-b has synced")
+	}
+	if notReady := k.NotReady(); !slices.Equal(notReady, []string{"cluster-b.local."}) {
+		t.Errorf("Expected cluster-b.local. not to be ready, got %v", notReady)
+	}
+
+	go controller.Run()
+	defer controller.Stop()
+	for !k.Ready() {
+		time.Sleep(time.Millisecond)
+	}
+	for range 100 {
+		if len(controller.SvcIndex("other.testns")) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []test.Case{
+		{
+			Qname: "svc1.testns.svc.cluster-a.local.", Qtype: dns.TypeA,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("svc1.testns.svc.cluster-a.local.	5	IN	A	10.0.0.1")},
+		},
+		{
+			Qname: "other.testns.svc.cluster-b.local.", Qtype: dns.TypeA,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("other.testns.svc.cluster-b.local.	5	IN	A	10.1.0.1")},
+		},
+		// Each cluster only has its own services.
+		{
+			Qname: "svc1.testns.svc.cluster-b.local.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{test.SOA("cluster-b.local.	5	IN	SOA	ns.dns.cluster-b.local. hostmaster.cluster-b.local. 1499347823 7200 1800 86400 5")},
+		},
+		// A zone without a cluster uses the connection of the plugin itself.
+		{
+			Qname: "svc1.testns.svc.cluster.local.", Qtype: dns.TypeA,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("svc1.testns.svc.cluster.local.	5	IN	A	10.0.0.1")},
+		},
+	}
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := k.ServeDNS(ctx, w, tc.Msg()); err != nil {
+			t.Errorf("Test %d: expected no error, got %v", i, err)
+			continue
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d, %v", i, err)
+		}
+	}
+}
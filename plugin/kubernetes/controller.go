@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,11 +14,14 @@ import (
 
 	api "k8s.io/api/core/v1"
 	discovery "k8s.io/api/discovery/v1"
+	networking "k8s.io/api/networking/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	mcs "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
@@ -28,10 +33,15 @@ const (
 	svcNameNamespaceIndex       = "ServiceNameNamespace"
 	svcIPIndex                  = "ServiceIP"
 	svcExtIPIndex               = "ServiceExternalIP"
+	svcAliasIndex               = "ServiceAlias"
 	epNameNamespaceIndex        = "EndpointNameNamespace"
 	epIPIndex                   = "EndpointsIP"
 	svcImportNameNamespaceIndex = "ServiceImportNameNamespace"
 	mcEpNameNamespaceIndex      = "MultiClusterEndpointsImportNameNamespace"
+	ingressHostIndex            = "IngressHost"
+	gatewayNameNamespaceIndex   = "GatewayNameNamespace"
+	gatewayHostIndex            = "GatewayHost"
+	httpRouteHostIndex          = "HTTPRouteHost"
 )
 
 type ModifiedMode int
@@ -49,11 +59,17 @@ type dnsController interface {
 	SvcIndex(string) []*object.Service
 	SvcIndexReverse(string) []*object.Service
 	SvcExtIndexReverse(string) []*object.Service
+	SvcAliasIndex(string) []*object.Service
 	SvcImportIndex(string) []*object.ServiceImport
 	PodIndex(string) []*object.Pod
 	EpIndex(string) []*object.Endpoints
 	EpIndexReverse(string) []*object.Endpoints
 	McEpIndex(string) []*object.MultiClusterEndpoints
+	IngressIndex(string) []*object.Ingress
+	GatewayIndex(string) []*object.Gateway
+	GatewayHostIndex(string) []*object.Gateway
+	HTTPRouteIndex(string) []*object.HTTPRoute
+	NodeZone(string) string
 
 	GetNodeByName(context.Context, string) (*api.Node, error)
 	GetNamespaceByName(string) (*object.Namespace, error)
@@ -78,8 +94,9 @@ type dnsControl struct {
 	// services with external facing IP addresses
 	extModified int64
 
-	client    kubernetes.Interface
-	mcsClient mcsClientset.MulticlusterV1alpha1Interface
+	client        kubernetes.Interface
+	mcsClient     mcsClientset.MulticlusterV1alpha1Interface
+	gatewayClient dynamic.Interface
 
 	selector          labels.Selector
 	namespaceSelector labels.Selector
@@ -90,6 +107,10 @@ type dnsControl struct {
 	nsController        cache.Controller
 	svcImportController cache.Controller
 	mcEpController      cache.Controller
+	ingressController   cache.Controller
+	gatewayController   cache.Controller
+	httpRouteController cache.Controller
+	nodeController      cache.Controller
 
 	svcLister       cache.Indexer
 	podLister       cache.Indexer
@@ -97,6 +118,10 @@ type dnsControl struct {
 	nsLister        cache.Store
 	svcImportLister cache.Indexer
 	mcEpLister      cache.Indexer
+	ingressLister   cache.Indexer
+	gatewayLister   cache.Indexer
+	httpRouteLister cache.Indexer
+	nodeLister      cache.Store
 
 	// stopLock is used to enforce only a single call to Stop is active.
 	// Needed because we allow stopping through an http endpoint and
@@ -114,6 +139,11 @@ type dnsControlOpts struct {
 	initPodCache       bool
 	initEndpointsCache bool
 	ignoreEmptyService bool
+	topologyAware      bool
+
+	// DNS-SD handling, dnssdAnnotations are the Service annotations that are added to the TXT records.
+	dnssd            bool
+	dnssdAnnotations []string
 
 	// Label handling.
 	labelSelector          *meta.LabelSelector
@@ -124,13 +154,16 @@ type dnsControlOpts struct {
 	zones             []string
 	endpointNameMode  bool
 	multiclusterZones []string
+	ingressZones      []string
+	gatewayZones      []string
 }
 
-// newdnsController creates a controller for CoreDNS.
-func newdnsController(ctx context.Context, kubeClient kubernetes.Interface, mcsClient mcsClientset.MulticlusterV1alpha1Interface, opts dnsControlOpts) *dnsControl {
+// newdnsController creates a controller for CoreDNS. The gatewayClient is only used when there are gateway zones.
+func newdnsController(ctx context.Context, kubeClient kubernetes.Interface, mcsClient mcsClientset.MulticlusterV1alpha1Interface, gatewayClient dynamic.Interface, opts dnsControlOpts) *dnsControl {
 	dns := dnsControl{
 		client:            kubeClient,
 		mcsClient:         mcsClient,
+		gatewayClient:     gatewayClient,
 		selector:          opts.selector,
 		namespaceSelector: opts.namespaceSelector,
 		stopCh:            make(chan struct{}),
@@ -139,6 +172,10 @@ func newdnsController(ctx context.Context, kubeClient kubernetes.Interface, mcsC
 		multiclusterZones: opts.multiclusterZones,
 	}
 
+	toService := object.ToService
+	if len(opts.dnssdAnnotations) > 0 {
+		toService = object.ToServiceWithAnnotations(opts.dnssdAnnotations)
+	}
 	dns.svcLister, dns.svcController = object.NewIndexerInformer(
 		&cache.ListWatch{
 			ListFunc:  serviceListFunc(ctx, dns.client, api.NamespaceAll, dns.selector),
@@ -146,8 +183,8 @@ func newdnsController(ctx context.Context, kubeClient kubernetes.Interface, mcsC
 		},
 		&api.Service{},
 		cache.ResourceEventHandlerFuncs{AddFunc: dns.Add, UpdateFunc: dns.Update, DeleteFunc: dns.Delete},
-		cache.Indexers{svcNameNamespaceIndex: svcNameNamespaceIndexFunc, svcIPIndex: svcIPIndexFunc, svcExtIPIndex: svcExtIPIndexFunc},
-		object.DefaultProcessor(object.ToService, nil),
+		cache.Indexers{svcNameNamespaceIndex: svcNameNamespaceIndexFunc, svcIPIndex: svcIPIndexFunc, svcExtIPIndex: svcExtIPIndexFunc, svcAliasIndex: svcAliasIndexFunc},
+		object.DefaultProcessor(toService, nil),
 	)
 
 	podLister, podController := object.NewIndexerInformer(
@@ -186,11 +223,24 @@ func newdnsController(ctx context.Context, kubeClient kubernetes.Interface, mcsC
 			WatchFunc: namespaceWatchFunc(ctx, dns.client, dns.namespaceSelector),
 		},
 		&api.Namespace{},
-		cache.ResourceEventHandlerFuncs{},
+		cache.ResourceEventHandlerFuncs{AddFunc: dns.Add, UpdateFunc: dns.Update, DeleteFunc: dns.Delete},
 		cache.Indexers{},
 		object.DefaultProcessor(object.ToNamespace, nil),
 	)
 
+	if opts.topologyAware {
+		dns.nodeLister, dns.nodeController = object.NewIndexerInformer(
+			&cache.ListWatch{
+				ListFunc:  nodeListFunc(ctx, dns.client),
+				WatchFunc: nodeWatchFunc(ctx, dns.client),
+			},
+			&api.Node{},
+			cache.ResourceEventHandlerFuncs{},
+			cache.Indexers{},
+			object.DefaultProcessor(object.ToNode, nil),
+		)
+	}
+
 	if len(opts.multiclusterZones) > 0 {
 		mcsEpReq, _ := labels.NewRequirement(mcs.LabelServiceName, selection.Exists, []string{})
 		mcsEpSelector := dns.selector
@@ -220,6 +270,44 @@ func newdnsController(ctx context.Context, kubeClient kubernetes.Interface, mcsC
 		)
 	}
 
+	if len(opts.ingressZones) > 0 {
+		dns.ingressLister, dns.ingressController = object.NewIndexerInformer(
+			&cache.ListWatch{
+				ListFunc:  ingressListFunc(ctx, dns.client, api.NamespaceAll, dns.selector),
+				WatchFunc: ingressWatchFunc(ctx, dns.client, api.NamespaceAll, dns.selector),
+			},
+			&networking.Ingress{},
+			cache.ResourceEventHandlerFuncs{AddFunc: dns.Add, UpdateFunc: dns.Update, DeleteFunc: dns.Delete},
+			cache.Indexers{ingressHostIndex: ingressHostIndexFunc},
+			object.DefaultProcessor(object.ToIngress, nil),
+		)
+	}
+
+	if len(opts.gatewayZones) > 0 {
+		gateways := dns.gatewayClient.Resource(object.GatewayResource)
+		dns.gatewayLister, dns.gatewayController = object.NewIndexerInformer(
+			&cache.ListWatch{
+				ListFunc:  dynamicListFunc(ctx, gateways, api.NamespaceAll, dns.selector),
+				WatchFunc: dynamicWatchFunc(ctx, gateways, api.NamespaceAll, dns.selector),
+			},
+			&unstructured.Unstructured{},
+			cache.ResourceEventHandlerFuncs{AddFunc: dns.Add, UpdateFunc: dns.Update, DeleteFunc: dns.Delete},
+			cache.Indexers{gatewayNameNamespaceIndex: gatewayNameNamespaceIndexFunc, gatewayHostIndex: gatewayHostIndexFunc},
+			object.DefaultProcessor(object.ToGateway, nil),
+		)
+		routes := dns.gatewayClient.Resource(object.HTTPRouteResource)
+		dns.httpRouteLister, dns.httpRouteController = object.NewIndexerInformer(
+			&cache.ListWatch{
+				ListFunc:  dynamicListFunc(ctx, routes, api.NamespaceAll, dns.selector),
+				WatchFunc: dynamicWatchFunc(ctx, routes, api.NamespaceAll, dns.selector),
+			},
+			&unstructured.Unstructured{},
+			cache.ResourceEventHandlerFuncs{AddFunc: dns.Add, UpdateFunc: dns.Update, DeleteFunc: dns.Delete},
+			cache.Indexers{httpRouteHostIndex: httpRouteHostIndexFunc},
+			object.DefaultProcessor(object.ToHTTPRoute, nil),
+		)
+	}
+
 	return &dns
 }
 
@@ -275,6 +363,18 @@ func svcNameNamespaceIndexFunc(obj any) ([]string, error) {
 	return []string{s.Index}, nil
 }
 
+func svcAliasIndexFunc(obj any) ([]string, error) {
+	s, ok := obj.(*object.Service)
+	if !ok {
+		return nil, errObj
+	}
+	idx := make([]string, len(s.Aliases))
+	for i, a := range s.Aliases {
+		idx[i] = object.ServiceKey(a, s.Namespace)
+	}
+	return idx, nil
+}
+
 func epNameNamespaceIndexFunc(obj any) ([]string, error) {
 	s, ok := obj.(*object.Endpoints)
 	if !ok {
@@ -307,6 +407,38 @@ func mcEpNameNamespaceIndexFunc(obj any) ([]string, error) {
 	return []string{mcEp.Index}, nil
 }
 
+func ingressHostIndexFunc(obj any) ([]string, error) {
+	i, ok := obj.(*object.Ingress)
+	if !ok {
+		return nil, errObj
+	}
+	return i.Hostnames, nil
+}
+
+func gatewayNameNamespaceIndexFunc(obj any) ([]string, error) {
+	g, ok := obj.(*object.Gateway)
+	if !ok {
+		return nil, errObj
+	}
+	return []string{g.Index}, nil
+}
+
+func gatewayHostIndexFunc(obj any) ([]string, error) {
+	g, ok := obj.(*object.Gateway)
+	if !ok {
+		return nil, errObj
+	}
+	return g.Hostnames, nil
+}
+
+func httpRouteHostIndexFunc(obj any) ([]string, error) {
+	r, ok := obj.(*object.HTTPRoute)
+	if !ok {
+		return nil, errObj
+	}
+	return r.Hostnames, nil
+}
+
 func serviceListFunc(ctx context.Context, c kubernetes.Interface, ns string, s labels.Selector) func(meta.ListOptions) (runtime.Object, error) {
 	return func(opts meta.ListOptions) (runtime.Object, error) {
 		if s != nil {
@@ -356,6 +488,30 @@ func serviceImportListFunc(ctx context.Context, c mcsClientset.MulticlusterV1alp
 	}
 }
 
+func nodeListFunc(ctx context.Context, c kubernetes.Interface) func(meta.ListOptions) (runtime.Object, error) {
+	return func(opts meta.ListOptions) (runtime.Object, error) {
+		return c.CoreV1().Nodes().List(ctx, opts)
+	}
+}
+
+func ingressListFunc(ctx context.Context, c kubernetes.Interface, ns string, s labels.Selector) func(meta.ListOptions) (runtime.Object, error) {
+	return func(opts meta.ListOptions) (runtime.Object, error) {
+		if s != nil {
+			opts.LabelSelector = s.String()
+		}
+		return c.NetworkingV1().Ingresses(ns).List(ctx, opts)
+	}
+}
+
+func dynamicListFunc(ctx context.Context, c dynamic.NamespaceableResourceInterface, ns string, s labels.Selector) func(meta.ListOptions) (runtime.Object, error) {
+	return func(opts meta.ListOptions) (runtime.Object, error) {
+		if s != nil {
+			opts.LabelSelector = s.String()
+		}
+		return c.Namespace(ns).List(ctx, opts)
+	}
+}
+
 func serviceWatchFunc(ctx context.Context, c kubernetes.Interface, ns string, s labels.Selector) func(options meta.ListOptions) (watch.Interface, error) {
 	return func(options meta.ListOptions) (watch.Interface, error) {
 		if s != nil {
@@ -405,6 +561,30 @@ func serviceImportWatchFunc(ctx context.Context, c mcsClientset.MulticlusterV1al
 	}
 }
 
+func nodeWatchFunc(ctx context.Context, c kubernetes.Interface) func(options meta.ListOptions) (watch.Interface, error) {
+	return func(options meta.ListOptions) (watch.Interface, error) {
+		return c.CoreV1().Nodes().Watch(ctx, options)
+	}
+}
+
+func ingressWatchFunc(ctx context.Context, c kubernetes.Interface, ns string, s labels.Selector) func(options meta.ListOptions) (watch.Interface, error) {
+	return func(options meta.ListOptions) (watch.Interface, error) {
+		if s != nil {
+			options.LabelSelector = s.String()
+		}
+		return c.NetworkingV1().Ingresses(ns).Watch(ctx, options)
+	}
+}
+
+func dynamicWatchFunc(ctx context.Context, c dynamic.NamespaceableResourceInterface, ns string, s labels.Selector) func(options meta.ListOptions) (watch.Interface, error) {
+	return func(options meta.ListOptions) (watch.Interface, error) {
+		if s != nil {
+			options.LabelSelector = s.String()
+		}
+		return c.Namespace(ns).Watch(ctx, options)
+	}
+}
+
 // Stop stops the  controller.
 func (dns *dnsControl) Stop() error {
 	dns.stopLock.Lock()
@@ -439,6 +619,16 @@ func (dns *dnsControl) Run() {
 	if dns.mcEpController != nil {
 		go dns.mcEpController.Run(dns.stopCh)
 	}
+	if dns.nodeController != nil {
+		go dns.nodeController.Run(dns.stopCh)
+	}
+	if dns.ingressController != nil {
+		go dns.ingressController.Run(dns.stopCh)
+	}
+	if dns.gatewayController != nil {
+		go dns.gatewayController.Run(dns.stopCh)
+		go dns.httpRouteController.Run(dns.stopCh)
+	}
 	<-dns.stopCh
 }
 
@@ -462,7 +652,19 @@ func (dns *dnsControl) HasSynced() bool {
 	if dns.mcEpController != nil {
 		f = dns.mcEpController.HasSynced()
 	}
-	return a && b && c && d && e && f
+	g := true
+	if dns.ingressController != nil {
+		g = dns.ingressController.HasSynced()
+	}
+	h := true
+	if dns.gatewayController != nil {
+		h = dns.gatewayController.HasSynced() && dns.httpRouteController.HasSynced()
+	}
+	i := true
+	if dns.nodeController != nil {
+		i = dns.nodeController.HasSynced()
+	}
+	return a && b && c && d && e && f && g && h && i
 }
 
 func (dns *dnsControl) ServiceList() (svcs []*object.Service) {
@@ -563,6 +765,22 @@ func (dns *dnsControl) SvcExtIndexReverse(ip string) (svcs []*object.Service) {
 	return svcs
 }
 
+// SvcAliasIndex returns the services that have the alias in idx, see object.ServiceKey.
+func (dns *dnsControl) SvcAliasIndex(idx string) (svcs []*object.Service) {
+	os, err := dns.svcLister.ByIndex(svcAliasIndex, idx)
+	if err != nil {
+		return nil
+	}
+	for _, o := range os {
+		s, ok := o.(*object.Service)
+		if !ok {
+			continue
+		}
+		svcs = append(svcs, s)
+	}
+	return svcs
+}
+
 func (dns *dnsControl) SvcImportIndex(idx string) (svcs []*object.ServiceImport) {
 	os, err := dns.svcImportLister.ByIndex(svcImportNameNamespaceIndex, idx)
 	if err != nil {
@@ -623,6 +841,66 @@ func (dns *dnsControl) McEpIndex(idx string) (ep []*object.MultiClusterEndpoints
 	return ep
 }
 
+func (dns *dnsControl) IngressIndex(host string) (ings []*object.Ingress) {
+	os, err := dns.ingressLister.ByIndex(ingressHostIndex, host)
+	if err != nil {
+		return nil
+	}
+	for _, o := range os {
+		i, ok := o.(*object.Ingress)
+		if !ok {
+			continue
+		}
+		ings = append(ings, i)
+	}
+	return ings
+}
+
+func (dns *dnsControl) GatewayIndex(idx string) (gws []*object.Gateway) {
+	os, err := dns.gatewayLister.ByIndex(gatewayNameNamespaceIndex, idx)
+	if err != nil {
+		return nil
+	}
+	for _, o := range os {
+		g, ok := o.(*object.Gateway)
+		if !ok {
+			continue
+		}
+		gws = append(gws, g)
+	}
+	return gws
+}
+
+func (dns *dnsControl) GatewayHostIndex(host string) (gws []*object.Gateway) {
+	os, err := dns.gatewayLister.ByIndex(gatewayHostIndex, host)
+	if err != nil {
+		return nil
+	}
+	for _, o := range os {
+		g, ok := o.(*object.Gateway)
+		if !ok {
+			continue
+		}
+		gws = append(gws, g)
+	}
+	return gws
+}
+
+func (dns *dnsControl) HTTPRouteIndex(host string) (routes []*object.HTTPRoute) {
+	os, err := dns.httpRouteLister.ByIndex(httpRouteHostIndex, host)
+	if err != nil {
+		return nil
+	}
+	for _, o := range os {
+		r, ok := o.(*object.HTTPRoute)
+		if !ok {
+			continue
+		}
+		routes = append(routes, r)
+	}
+	return routes
+}
+
 // GetNodeByName return the node by name. If nothing is found an error is
 // returned. This query causes a round trip to the k8s API server, so use
 // sparingly. Currently, this is only used for Federation.
@@ -647,6 +925,22 @@ func (dns *dnsControl) GetNamespaceByName(name string) (*object.Namespace, error
 	return ns, nil
 }
 
+// NodeZone returns the zone of the node with name, or the empty string if it is not known.
+func (dns *dnsControl) NodeZone(name string) string {
+	if dns.nodeLister == nil {
+		return ""
+	}
+	o, exists, err := dns.nodeLister.GetByKey(name)
+	if err != nil || !exists {
+		return ""
+	}
+	n, ok := o.(*object.Node)
+	if !ok {
+		return ""
+	}
+	return n.Zone
+}
+
 func (dns *dnsControl) Add(obj any)               { dns.updateModified() }
 func (dns *dnsControl) Delete(obj any)            { dns.updateModified() }
 func (dns *dnsControl) Update(oldObj, newObj any) { dns.detectChanges(oldObj, newObj) }
@@ -674,12 +968,18 @@ func (dns *dnsControl) detectChanges(oldObj, newObj any) {
 		if !serviceImportEquivalent(oldObj, newObj) {
 			dns.updateMultiClusterModified()
 		}
+	case *object.Namespace:
+		if namespaceModified(oldObj, newObj) {
+			dns.updateModified()
+		}
 	case *object.Pod:
 		dns.updateModified()
 	case *object.Endpoints:
 		if !endpointsEquivalent(oldObj.(*object.Endpoints), newObj.(*object.Endpoints)) {
 			dns.updateModified()
 		}
+	case *object.Ingress, *object.Gateway, *object.HTTPRoute:
+		dns.updateModified()
 	case *object.MultiClusterEndpoints:
 		if !multiclusterEndpointsEquivalent(oldObj.(*object.MultiClusterEndpoints), newObj.(*object.MultiClusterEndpoints)) {
 			dns.updateMultiClusterModified()
@@ -693,35 +993,45 @@ func (dns *dnsControl) detectChanges(oldObj, newObj any) {
 // I.e. that they have the same ready addresses, host names, ports (including protocol
 // and service names for SRV)
 func subsetsEquivalent(sa, sb object.EndpointSubset) bool {
-	if len(sa.Addresses) != len(sb.Addresses) {
+	if !addressesEquivalent(sa.Addresses, sb.Addresses) || !addressesEquivalent(sa.NotReadyAddresses, sb.NotReadyAddresses) {
 		return false
 	}
 	if len(sa.Ports) != len(sb.Ports) {
 		return false
 	}
 
-	// in Addresses and Ports, we should be able to rely on
-	// these being sorted and able to be compared
-	// they are supposed to be in a canonical format
-	for addr, aaddr := range sa.Addresses {
-		baddr := sb.Addresses[addr]
-		if aaddr.IP != baddr.IP {
+	for port, aport := range sa.Ports {
+		bport := sb.Ports[port]
+		if aport.Name != bport.Name {
 			return false
 		}
-		if aaddr.Hostname != baddr.Hostname {
+		if aport.Port != bport.Port {
+			return false
+		}
+		if aport.Protocol != bport.Protocol {
 			return false
 		}
 	}
+	return true
+}
 
-	for port, aport := range sa.Ports {
-		bport := sb.Ports[port]
-		if aport.Name != bport.Name {
+// addressesEquivalent checks if the endpoint addresses a and b have the same IPs, host names and zones.
+func addressesEquivalent(a, b []object.EndpointAddress) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	// we should be able to rely on these being sorted and able to be compared
+	// they are supposed to be in a canonical format
+	for addr, aaddr := range a {
+		baddr := b[addr]
+		if aaddr.IP != baddr.IP {
 			return false
 		}
-		if aport.Port != bport.Port {
+		if aaddr.Hostname != baddr.Hostname {
 			return false
 		}
-		if aport.Protocol != bport.Protocol {
+		if aaddr.Zone != baddr.Zone || !slices.Equal(aaddr.ForZones, baddr.ForZones) {
 			return false
 		}
 	}
@@ -797,8 +1107,14 @@ func serviceModified(oldObj, newObj any) (intSvc, extSvc bool) {
 		}
 	}
 
-	// ExternalName is mutable, affecting internal zone records
-	intSvc = oldSvc.ExternalName != newSvc.ExternalName
+	// ExternalName and the annotations (used in DNS-SD TXT records) are mutable, affecting internal zone records
+	intSvc = oldSvc.ExternalName != newSvc.ExternalName || !maps.Equal(oldSvc.Annotations, newSvc.Annotations)
+
+	// The policy set with annotations is mutable, affecting both internal/external zone records
+	if !ttlEqual(oldSvc.TTL, newSvc.TTL) || oldSvc.Hide != newSvc.Hide || oldSvc.PublishNotReady != newSvc.PublishNotReady ||
+		!slices.Equal(oldSvc.Aliases, newSvc.Aliases) {
+		return true, true
+	}
 
 	if intSvc && extSvc {
 		return intSvc, extSvc
@@ -823,6 +1139,20 @@ func serviceModified(oldObj, newObj any) (intSvc, extSvc bool) {
 	return intSvc, extSvc
 }
 
+// namespaceModified returns true if the policy of the namespace, set with annotations, changed.
+func namespaceModified(oldObj, newObj any) bool {
+	oldNs, newNs := oldObj.(*object.Namespace), newObj.(*object.Namespace)
+	return !ttlEqual(oldNs.TTL, newNs.TTL) || oldNs.Hide != newNs.Hide
+}
+
+// ttlEqual returns true if the TTLs a and b, set with annotations, are equal.
+func ttlEqual(a, b *uint32) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
 // serviceImportEquivalent checks if the update to a ServiceImport is something
 // that matters to us or if they are effectively equivalent.
 func serviceImportEquivalent(oldObj, newObj any) bool {
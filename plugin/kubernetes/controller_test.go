@@ -40,7 +40,7 @@ func kubernetesWithFakeClient(ctx context.Context, cidr string, initEndpointsCac
 		multiclusterZones:  []string{"clusterset.local."},
 		initEndpointsCache: initEndpointsCache,
 	}
-	controller := newdnsController(ctx, client, mcsClient.MulticlusterV1alpha1(), dco)
+	controller := newdnsController(ctx, client, mcsClient.MulticlusterV1alpha1(), nil, dco)
 
 	// Add resources
 	_, err := client.CoreV1().Namespaces().Create(ctx, &api.Namespace{ObjectMeta: meta.ObjectMeta{Name: "testns"}}, meta.CreateOptions{})
@@ -346,6 +346,18 @@ func TestServiceModified(t *testing.T) {
 			ichanged: true,
 			echanged: true,
 		},
+		{
+			oldSvc:   &object.Service{},
+			newSvc:   &object.Service{Hide: true},
+			ichanged: true,
+			echanged: true,
+		},
+		{
+			oldSvc:   &object.Service{Aliases: []string{"www"}},
+			newSvc:   &object.Service{Aliases: []string{"www"}},
+			ichanged: false,
+			echanged: false,
+		},
 	}
 
 	for i, test := range tests {
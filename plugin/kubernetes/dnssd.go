@@ -0,0 +1,187 @@
+package kubernetes
+
+import (
+	"strings"
+
+	"github.com/coredns/coredns/plugin/etcd/msg"
+	"github.com/coredns/coredns/plugin/kubernetes/object"
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+	api "k8s.io/api/core/v1"
+)
+
+// dnssdServices is the name under which the service types of a domain are enumerated, see RFC 6763, section 9.
+const dnssdServices = "_services._dns-sd._udp"
+
+// dnssdRequest is a parsed DNS-SD query name. Port and protocol are set for a service type, instance is set to the
+// name of the Service for a service instance.
+type dnssdRequest struct {
+	namespace string // Empty for the service types of all namespaces.
+	port      string
+	protocol  string
+	instance  string
+}
+
+// dnssdRequest returns the DNS-SD request for the query name in state, and false if it isn't a DNS-SD name, or DNS-SD
+// is not enabled for the zone.
+func (k *Kubernetes) dnssdRequest(state request.Request) (dnssdRequest, bool) {
+	if !k.opts.dnssd || dnsutil.IsReverse(state.Zone) > 0 || k.isMultiClusterZone(state.Zone) {
+		return dnssdRequest{}, false
+	}
+	return parseDNSSD(state.Name(), state.Zone)
+}
+
+// parseDNSSD parses the DNS-SD names, 4 cases are possible:
+// 1. (service types): _services._dns-sd._udp.zone
+// 2. (service types): _services._dns-sd._udp.namespace.svc.zone
+// 3. (service instances): _port._protocol.namespace.svc.zone
+// 4. (service instance): service._port._protocol.namespace.svc.zone
+func parseDNSSD(name, zone string) (r dnssdRequest, ok bool) {
+	base, _ := dnsutil.TrimZone(strings.ToLower(name), strings.ToLower(zone))
+	if base == dnssdServices {
+		return r, true
+	}
+
+	segs := dns.SplitDomainName(base)
+	last := len(segs) - 1
+	if last < 2 || segs[last] != Svc {
+		return r, false
+	}
+	r.namespace = segs[last-1]
+	segs = segs[:last-1]
+
+	switch len(segs) {
+	case 2:
+		if !strings.HasPrefix(segs[0], "_") || !strings.HasPrefix(segs[1], "_") {
+			return r, false
+		}
+		r.port, r.protocol = stripUnderscore(segs[0]), stripUnderscore(segs[1])
+		return r, true
+	case 3:
+		if strings.Join(segs, ".") == dnssdServices {
+			return r, true
+		}
+		if strings.HasPrefix(segs[0], "_") || !strings.HasPrefix(segs[1], "_") || !strings.HasPrefix(segs[2], "_") {
+			return r, false
+		}
+		r.instance, r.port, r.protocol = segs[0], stripUnderscore(segs[1]), stripUnderscore(segs[2])
+		return r, true
+	}
+	return r, false
+}
+
+// findDNSSD returns the records for the DNS-SD request r. The service types and instances are returned as PTR
+// targets, a service instance as the addresses of the Service, from which the SRV record is made. Other query
+// types get no records. Only named ports of Services that are not of type ExternalName are announced.
+func (k *Kubernetes) findDNSSD(r dnssdRequest, state request.Request) ([]msg.Service, error) {
+	if r.namespace != "" && !k.namespaceExposed(r.namespace) {
+		return nil, errNsNotExposed
+	}
+
+	var services []msg.Service
+	dup := make(map[string]struct{})
+	for _, svc := range k.APIConn.ServiceList() {
+		if svc.Type == api.ServiceTypeExternalName || (r.namespace != "" && svc.Namespace != r.namespace) {
+			continue
+		}
+		if r.instance != "" && svc.Name != r.instance {
+			continue
+		}
+		if r.namespace == "" && !k.namespaceExposed(svc.Namespace) {
+			continue
+		}
+		ttl, publish := k.servicePolicy(svc)
+		if !publish {
+			continue
+		}
+
+		domain := strings.Join([]string{svc.Namespace, Svc, state.Zone}, ".")
+		for _, p := range svc.Ports {
+			protocol := strings.ToLower(string(p.Protocol))
+			if p.Name == "" || (r.port != "" && (p.Name != r.port || protocol != r.protocol)) {
+				continue
+			}
+			typ := "_" + p.Name + "._" + protocol
+
+			var targets []msg.Service
+			switch {
+			case r.instance != "":
+				target := svc.Name + "." + domain
+				for _, addr := range k.dnssdAddresses(svc) {
+					targets = append(targets, msg.Service{Host: addr, Port: int(p.Port), TTL: ttl, Key: msg.Path(target, coredns)})
+				}
+			case r.port != "":
+				target := strings.Join([]string{svc.Name, typ, domain}, ".")
+				targets = append(targets, msg.Service{Host: target, TTL: ttl, Key: msg.Path(target, coredns)})
+			default:
+				target := typ + "." + domain
+				targets = append(targets, msg.Service{Host: target, TTL: ttl, Key: msg.Path(target, coredns)})
+			}
+			for _, s := range targets {
+				if _, ok := dup[s.Host]; ok {
+					continue
+				}
+				dup[s.Host] = struct{}{}
+				services = append(services, s)
+			}
+		}
+	}
+
+	if len(services) == 0 {
+		return nil, errNoItems
+	}
+	if (r.instance == "" && state.QType() == dns.TypePTR) || (r.instance != "" && state.QType() == dns.TypeSRV) {
+		return services, nil
+	}
+	return nil, nil
+}
+
+// dnssdAddresses returns the cluster IPs of svc, or the addresses of its endpoints if it's headless.
+func (k *Kubernetes) dnssdAddresses(svc *object.Service) []string {
+	if !svc.Headless() {
+		return svc.ClusterIPs
+	}
+	var addrs []string
+	for _, ep := range k.APIConn.EpIndex(object.EndpointsKey(svc.Name, svc.Namespace)) {
+		for _, sub := range ep.Subsets {
+			for _, addr := range serviceAddresses(svc, sub) {
+				addrs = append(addrs, addr.IP)
+			}
+		}
+	}
+	return addrs
+}
+
+// dnssdTXT returns the TXT record of the service instance r. It holds a key=value string for each of the configured
+// annotations that is set on the Service, where the key is the name of the annotation without its prefix. Per RFC 6763,
+// section 6.1, the record holds a single empty string when no annotation is set.
+func (k *Kubernetes) dnssdTXT(r dnssdRequest, state request.Request) ([]dns.RR, error) {
+	if _, err := k.findDNSSD(r, state); err != nil {
+		return nil, err
+	}
+
+	var txt []string
+	ttl := k.ttl
+	for _, svc := range k.APIConn.SvcIndex(object.ServiceKey(r.instance, r.namespace)) {
+		ttl, _ = k.servicePolicy(svc)
+		for _, key := range k.opts.dnssdAnnotations {
+			value, ok := svc.Annotations[key]
+			if !ok {
+				continue
+			}
+			if i := strings.LastIndex(key, "/"); i >= 0 {
+				key = key[i+1:]
+			}
+			// A string in a TXT record can't be longer than 255 octets.
+			if s := key + "=" + value; len(s) <= 255 {
+				txt = append(txt, s)
+			}
+		}
+	}
+	if len(txt) == 0 {
+		txt = []string{""}
+	}
+	return []dns.RR{&dns.TXT{Hdr: dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl}, Txt: txt}}, nil
+}
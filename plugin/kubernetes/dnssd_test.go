@@ -0,0 +1,199 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	api "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDNSSD(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	dco := dnsControlOpts{
+		zones:              []string{"cluster.local."},
+		initEndpointsCache: true,
+		dnssd:              true,
+		dnssdAnnotations:   []string{"example.com/path", "example.com/version"},
+	}
+	controller := newdnsController(ctx, client, nil, nil, dco)
+
+	for _, ns := range []string{"testns", "otherns"} {
+		client.CoreV1().Namespaces().Create(ctx, &api.Namespace{ObjectMeta: meta.ObjectMeta{Name: ns}}, meta.CreateOptions{})
+	}
+	services := []*api.Service{
+		{
+			ObjectMeta: meta.ObjectMeta{Name: "web", Namespace: "testns", Annotations: map[string]string{"example.com/path": "/api", "example.com/other": "x"}},
+			Spec: api.ServiceSpec{ClusterIP: "10.0.0.1", ClusterIPs: []string{"10.0.0.1"}, Ports: []api.ServicePort{
+				{Name: "http", Protocol: "TCP", Port: 80},
+				{Name: "metrics", Protocol: "TCP", Port: 9090},
+			}},
+		},
+		{
+			ObjectMeta: meta.ObjectMeta{Name: "dns", Namespace: "testns"},
+			Spec:       api.ServiceSpec{ClusterIP: "10.0.0.2", ClusterIPs: []string{"10.0.0.2"}, Ports: []api.ServicePort{{Name: "dns", Protocol: "UDP", Port: 53}}},
+		},
+		{
+			ObjectMeta: meta.ObjectMeta{Name: "db", Namespace: "testns"},
+			Spec:       api.ServiceSpec{ClusterIP: api.ClusterIPNone, Ports: []api.ServicePort{{Name: "postgres", Protocol: "TCP", Port: 5432}}},
+		},
+		// Services that are not announced.
+		{
+			ObjectMeta: meta.ObjectMeta{Name: "unnamed", Namespace: "testns"},
+			Spec:       api.ServiceSpec{ClusterIP: "10.0.0.3", ClusterIPs: []string{"10.0.0.3"}, Ports: []api.ServicePort{{Protocol: "TCP", Port: 8080}}},
+		},
+		{
+			ObjectMeta: meta.ObjectMeta{Name: "ext", Namespace: "testns"},
+			Spec:       api.ServiceSpec{Type: api.ServiceTypeExternalName, ExternalName: "example.org", Ports: []api.ServicePort{{Name: "http", Protocol: "TCP", Port: 80}}},
+		},
+		{
+			ObjectMeta: meta.ObjectMeta{Name: "web", Namespace: "otherns"},
+			Spec:       api.ServiceSpec{ClusterIP: "10.0.1.1", ClusterIPs: []string{"10.0.1.1"}, Ports: []api.ServicePort{{Name: "http", Protocol: "TCP", Port: 80}}},
+		},
+	}
+	for _, s := range services {
+		client.CoreV1().Services(s.Namespace).Create(ctx, s, meta.CreateOptions{})
+	}
+
+	client.DiscoveryV1().EndpointSlices("testns").Create(ctx, &discovery.EndpointSlice{
+		ObjectMeta: meta.ObjectMeta{Name: "db-1", Namespace: "testns", Labels: map[string]string{discovery.LabelServiceName: "db"}},
+		Endpoints:  []discovery.Endpoint{{Addresses: []string{"172.0.0.1"}}, {Addresses: []string{"172.0.0.2"}}},
+	}, meta.CreateOptions{})
+
+	k := New([]string{"cluster.local."})
+	k.APIConn = controller
+	k.opts = dco
+	k.Namespaces = map[string]struct{}{"testns": {}}
+	k.Next = test.NextHandler(dns.RcodeSuccess, nil)
+
+	go k.APIConn.Run()
+	defer k.APIConn.Stop()
+	for !k.APIConn.HasSynced() {
+		time.Sleep(time.Millisecond)
+	}
+	for range 100 {
+		if len(controller.ServiceList()) == len(services) && len(controller.EpIndex("db.testns")) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []test.Case{
+		{
+			Qname: "_services._dns-sd._udp.cluster.local.", Qtype: dns.TypePTR,
+			Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.PTR("_services._dns-sd._udp.cluster.local.	5	IN	PTR	_dns._udp.testns.svc.cluster.local."),
+				test.PTR("_services._dns-sd._udp.cluster.local.	5	IN	PTR	_http._tcp.testns.svc.cluster.local."),
+				test.PTR("_services._dns-sd._udp.cluster.local.	5	IN	PTR	_metrics._tcp.testns.svc.cluster.local."),
+				test.PTR("_services._dns-sd._udp.cluster.local.	5	IN	PTR	_postgres._tcp.testns.svc.cluster.local."),
+			},
+		},
+		{
+			Qname: "_services._dns-sd._udp.testns.svc.cluster.local.", Qtype: dns.TypePTR,
+			Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.PTR("_services._dns-sd._udp.testns.svc.cluster.local.	5	IN	PTR	_dns._udp.testns.svc.cluster.local."),
+				test.PTR("_services._dns-sd._udp.testns.svc.cluster.local.	5	IN	PTR	_http._tcp.testns.svc.cluster.local."),
+				test.PTR("_services._dns-sd._udp.testns.svc.cluster.local.	5	IN	PTR	_metrics._tcp.testns.svc.cluster.local."),
+				test.PTR("_services._dns-sd._udp.testns.svc.cluster.local.	5	IN	PTR	_postgres._tcp.testns.svc.cluster.local."),
+			},
+		},
+		{
+			Qname: "_services._dns-sd._udp.otherns.svc.cluster.local.", Qtype: dns.TypePTR,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{test.SOA("cluster.local.	5	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1499347823 7200 1800 86400 5")},
+		},
+		{
+			Qname: "_http._tcp.testns.svc.cluster.local.", Qtype: dns.TypePTR,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.PTR("_http._tcp.testns.svc.cluster.local.	5	IN	PTR	web._http._tcp.testns.svc.cluster.local.")},
+		},
+		{
+			Qname: "_ftp._tcp.testns.svc.cluster.local.", Qtype: dns.TypePTR,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{test.SOA("cluster.local.	5	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1499347823 7200 1800 86400 5")},
+		},
+		{
+			Qname: "web._http._tcp.testns.svc.cluster.local.", Qtype: dns.TypeSRV,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.SRV("web._http._tcp.testns.svc.cluster.local.	5	IN	SRV	0 100 80 web.testns.svc.cluster.local.")},
+			Extra:  []dns.RR{test.A("web.testns.svc.cluster.local.	5	IN	A	10.0.0.1")},
+		},
+		// A headless instance has the addresses of its endpoints.
+		{
+			Qname: "db._postgres._tcp.testns.svc.cluster.local.", Qtype: dns.TypeSRV,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.SRV("db._postgres._tcp.testns.svc.cluster.local.	5	IN	SRV	0 50 5432 db.testns.svc.cluster.local.")},
+			Extra: []dns.RR{
+				test.A("db.testns.svc.cluster.local.	5	IN	A	172.0.0.1"),
+				test.A("db.testns.svc.cluster.local.	5	IN	A	172.0.0.2"),
+			},
+		},
+		{
+			Qname: "web._http._tcp.testns.svc.cluster.local.", Qtype: dns.TypeTXT,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.TXT(`web._http._tcp.testns.svc.cluster.local.	5	IN	TXT	"path=/api"`)},
+		},
+		// An instance without annotations has a TXT record with an empty string.
+		{
+			Qname: "dns._dns._udp.testns.svc.cluster.local.", Qtype: dns.TypeTXT,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.TXT(`dns._dns._udp.testns.svc.cluster.local.	5	IN	TXT	""`)},
+		},
+		{
+			Qname: "web._http._tcp.testns.svc.cluster.local.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeSuccess,
+			Ns:    []dns.RR{test.SOA("cluster.local.	5	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1499347823 7200 1800 86400 5")},
+		},
+		{
+			Qname: "ext._http._tcp.testns.svc.cluster.local.", Qtype: dns.TypeSRV,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{test.SOA("cluster.local.	5	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1499347823 7200 1800 86400 5")},
+		},
+	}
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := k.ServeDNS(ctx, w, tc.Msg()); err != nil {
+			t.Errorf("Test %d: expected no error, got %v", i, err)
+			continue
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d, %v", i, err)
+		}
+	}
+}
+
+func TestParseDNSSD(t *testing.T) {
+	tests := []struct {
+		name     string
+		ok       bool
+		expected dnssdRequest
+	}{
+		{"_services._dns-sd._udp.cluster.local.", true, dnssdRequest{}},
+		{"_Services._DNS-SD._udp.testns.svc.cluster.local.", true, dnssdRequest{namespace: "testns"}},
+		{"_http._tcp.testns.svc.cluster.local.", true, dnssdRequest{namespace: "testns", port: "http", protocol: "tcp"}},
+		{"web._http._tcp.testns.svc.cluster.local.", true, dnssdRequest{namespace: "testns", port: "http", protocol: "tcp", instance: "web"}},
+		{"web.testns.svc.cluster.local.", false, dnssdRequest{}},
+		{"_http._tcp.web.testns.svc.cluster.local.", false, dnssdRequest{}},
+		{"_services._dns-sd._udp.testns.pod.cluster.local.", false, dnssdRequest{}},
+	}
+	for i, tc := range tests {
+		r, ok := parseDNSSD(tc.name, "cluster.local.")
+		if ok != tc.ok {
+			t.Errorf("Test %d: expected %t for %s, got %t", i, tc.ok, tc.name, ok)
+			continue
+		}
+		if ok && r != tc.expected {
+			t.Errorf("Test %d: expected %v for %s, got %v", i, tc.expected, tc.name, r)
+		}
+	}
+}
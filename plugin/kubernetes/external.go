@@ -96,6 +96,9 @@ func (k *Kubernetes) External(state request.Request, headless bool) ([]msg.Servi
 		if service != svc.Name {
 			continue
 		}
+		if _, publish := k.servicePolicy(svc); !publish {
+			continue
+		}
 
 		if headless && len(svc.ExternalIPs) == 0 && (svc.Headless() || endpoint != "") {
 			if endpointsList == nil {
@@ -108,7 +111,7 @@ func (k *Kubernetes) External(state request.Request, headless bool) ([]msg.Servi
 				}
 
 				for _, eps := range ep.Subsets {
-					for _, addr := range eps.Addresses {
+					for _, addr := range serviceAddresses(svc, eps) {
 						if endpoint != "" && !match(endpoint, endpointHostname(addr, k.endpointNameMode)) {
 							continue
 						}
@@ -163,6 +166,9 @@ func (k *Kubernetes) ExternalServices(zone string, headless bool) (services []ms
 	zonePath := msg.Path(zone, coredns)
 	headlessServices = make(map[string][]msg.Service)
 	for _, svc := range k.APIConn.ServiceList() {
+		if _, publish := k.servicePolicy(svc); !publish {
+			continue
+		}
 		// Endpoints and headless services
 		if headless && len(svc.ExternalIPs) == 0 && svc.Headless() {
 			idx := object.ServiceKey(svc.Name, svc.Namespace)
@@ -170,7 +176,7 @@ func (k *Kubernetes) ExternalServices(zone string, headless bool) (services []ms
 
 			for _, ep := range endpointsList {
 				for _, eps := range ep.Subsets {
-					for _, addr := range eps.Addresses {
+					for _, addr := range serviceAddresses(svc, eps) {
 						// we need to have some answers grouped together
 						// 1. for endpoint requests eg. endpoint-0.service.example.com - will always have one endpoint
 						// 2. for service requests eg. service.example.com - can have multiple endpoints
@@ -231,6 +237,9 @@ func (k *Kubernetes) serviceRecordForExternalIP(ip string) []msg.Service {
 		if len(k.Namespaces) > 0 && !k.namespaceExposed(service.Namespace) {
 			continue
 		}
+		if _, publish := k.servicePolicy(service); !publish {
+			continue
+		}
 		domain := strings.Join([]string{service.Name, service.Namespace}, ".")
 		svcs = append(svcs, msg.Service{Host: domain, TTL: k.ttl})
 	}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
 	"github.com/coredns/coredns/request"
 
 	"github.com/miekg/dns"
@@ -13,6 +14,11 @@ import (
 func (k Kubernetes) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 	state := request.Request{W: w, Req: r}
 
+	if c := k.cluster(state.Name()); c != nil {
+		clusterRequests.WithLabelValues(metrics.WithServer(ctx), c.Zones[0]).Inc()
+		return c.ServeDNS(ctx, w, r)
+	}
+
 	qname := state.QName()
 	zone := plugin.Zones(k.Zones).Matches(qname)
 	if zone == "" {
@@ -34,6 +40,10 @@ func (k Kubernetes) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.M
 	case dns.TypeAAAA:
 		records, truncated, err = plugin.AAAA(ctx, &k, zone, state, nil, plugin.Options{})
 	case dns.TypeTXT:
+		if dr, ok := k.dnssdRequest(state); ok && dr.instance != "" {
+			records, err = k.dnssdTXT(dr, state)
+			break
+		}
 		records, truncated, err = plugin.TXT(ctx, &k, zone, state, nil, plugin.Options{})
 	case dns.TypeCNAME:
 		records, err = plugin.CNAME(ctx, &k, zone, state, plugin.Options{})
@@ -707,6 +707,7 @@ func (APIConnServeTest) Run()                                        {}
 func (APIConnServeTest) Stop() error                                 { return nil }
 func (APIConnServeTest) EpIndexReverse(string) []*object.Endpoints   { return nil }
 func (APIConnServeTest) SvcIndexReverse(string) []*object.Service    { return nil }
+func (APIConnServeTest) SvcAliasIndex(string) []*object.Service      { return nil }
 func (APIConnServeTest) SvcExtIndexReverse(string) []*object.Service { return nil }
 func (APIConnServeTest) Modified(ModifiedMode) int64                 { return int64(3) }
 
@@ -1117,6 +1118,26 @@ func (APIConnServeTest) McEpIndex(s string) []*object.MultiClusterEndpoints {
 	return mcEpsIndex[s]
 }
 
+func (APIConnServeTest) IngressIndex(string) []*object.Ingress {
+	return nil
+}
+
+func (APIConnServeTest) GatewayIndex(string) []*object.Gateway {
+	return nil
+}
+
+func (APIConnServeTest) GatewayHostIndex(string) []*object.Gateway {
+	return nil
+}
+
+func (APIConnServeTest) HTTPRouteIndex(string) []*object.HTTPRoute {
+	return nil
+}
+
+func (APIConnServeTest) NodeZone(string) string {
+	return ""
+}
+
 func (APIConnServeTest) MultiClusterEndpointsList() []*object.MultiClusterEndpoints {
 	var eps []*object.MultiClusterEndpoints
 	for _, ep := range mcEpsIndex {
@@ -0,0 +1,85 @@
+package kubernetes
+
+import (
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/etcd/msg"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// isHostnameZone returns true if Ingress or Gateway API hostnames are served in zone.
+func (k *Kubernetes) isHostnameZone(zone string) bool {
+	return plugin.Zones(k.opts.ingressZones).Matches(zone) != "" || plugin.Zones(k.opts.gatewayZones).Matches(zone) != ""
+}
+
+// findHostnames returns the addresses of the Ingresses and Gateways that have the query name in state as a hostname.
+// An exact hostname takes precedence over a wildcard one. If an object matches, but it doesn't have an address (yet),
+// no records and no error are returned.
+func (k *Kubernetes) findHostnames(state request.Request) ([]msg.Service, error) {
+	name := strings.ToLower(dns.Fqdn(state.Name()))
+	hosts := []string{strings.TrimSuffix(name, ".")}
+	if i, end := dns.NextLabel(name, 0); !end {
+		hosts = append(hosts, "*."+strings.TrimSuffix(name[i:], "."))
+	}
+
+	for _, host := range hosts {
+		found, addrs := k.hostnameAddresses(state.Zone, host)
+		if !found {
+			continue
+		}
+		var services []msg.Service
+		for _, addr := range addrs {
+			services = append(services, msg.Service{Host: addr, TTL: k.ttl, Key: msg.Path(name, coredns)})
+		}
+		return services, nil
+	}
+	return nil, errNoItems
+}
+
+// hostnameAddresses returns true if an Ingress, Gateway or HTTPRoute in an exposed namespace has host as a hostname,
+// and the addresses of them.
+func (k *Kubernetes) hostnameAddresses(zone, host string) (found bool, addrs []string) {
+	dup := make(map[string]struct{})
+	add := func(as []string) {
+		found = true
+		for _, a := range as {
+			if _, ok := dup[a]; ok {
+				continue
+			}
+			dup[a] = struct{}{}
+			addrs = append(addrs, a)
+		}
+	}
+
+	if plugin.Zones(k.opts.ingressZones).Matches(zone) != "" {
+		for _, ing := range k.APIConn.IngressIndex(host) {
+			if k.namespaceExposed(ing.Namespace) {
+				add(ing.Addresses)
+			}
+		}
+	}
+	if plugin.Zones(k.opts.gatewayZones).Matches(zone) != "" {
+		for _, gw := range k.APIConn.GatewayHostIndex(host) {
+			if k.namespaceExposed(gw.Namespace) {
+				add(gw.Addresses)
+			}
+		}
+		for _, route := range k.APIConn.HTTPRouteIndex(host) {
+			if !k.namespaceExposed(route.Namespace) {
+				continue
+			}
+			found = true
+			for _, key := range route.Gateways {
+				for _, gw := range k.APIConn.GatewayIndex(key) {
+					if k.namespaceExposed(gw.Namespace) {
+						add(gw.Addresses)
+					}
+				}
+			}
+		}
+	}
+	return found, addrs
+}
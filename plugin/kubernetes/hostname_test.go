@@ -0,0 +1,260 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/kubernetes/object"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var hostnameCases = []test.Case{
+	{
+		Qname: "www.example.org.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("www.example.org.	5	IN	A	192.0.2.10"),
+		},
+	},
+	{
+		Qname: "WWW.Example.Org.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("WWW.Example.Org.	5	IN	A	192.0.2.10"),
+		},
+	},
+	// Wildcard host in the Ingress.
+	{
+		Qname: "blog.apps.example.org.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("blog.apps.example.org.	5	IN	A	192.0.2.10"),
+		},
+	},
+	// The wildcard only covers one label.
+	{
+		Qname: "a.blog.apps.example.org.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeNameError,
+		Ns:    []dns.RR{test.SOA("example.org.	5	IN	SOA	ns.dns.example.org. hostmaster.example.org. 1499347823 7200 1800 86400 5")},
+	},
+	// Load-balancer with a host name.
+	{
+		Qname: "alias.example.org.", Qtype: dns.TypeCNAME,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.CNAME("alias.example.org.	5	IN	CNAME	lb.example.net."),
+		},
+	},
+	// Ingress without an address in its status.
+	{
+		Qname: "pending.example.org.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Ns:    []dns.RR{test.SOA("example.org.	5	IN	SOA	ns.dns.example.org. hostmaster.example.org. 1499347823 7200 1800 86400 5")},
+	},
+	// Ingress in a namespace that is not exposed.
+	{
+		Qname: "hidden.example.org.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeNameError,
+		Ns:    []dns.RR{test.SOA("example.org.	5	IN	SOA	ns.dns.example.org. hostmaster.example.org. 1499347823 7200 1800 86400 5")},
+	},
+	// Gateway listener host name.
+	{
+		Qname: "gw.example.org.", Qtype: dns.TypeAAAA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.AAAA("gw.example.org.	5	IN	AAAA	2001:db8::20"),
+		},
+	},
+	// HTTPRoute host name, with the addresses of the parent Gateway.
+	{
+		Qname: "shop.example.org.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("shop.example.org.	5	IN	A	192.0.2.20"),
+		},
+	},
+	// HTTPRoute with a Gateway in a namespace that is not exposed.
+	{
+		Qname: "cross.example.org.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Ns:    []dns.RR{test.SOA("example.org.	5	IN	SOA	ns.dns.example.org. hostmaster.example.org. 1499347823 7200 1800 86400 5")},
+	},
+	// HTTPRoute that the Gateway did not accept.
+	{
+		Qname: "rejected.example.org.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Ns:    []dns.RR{test.SOA("example.org.	5	IN	SOA	ns.dns.example.org. hostmaster.example.org. 1499347823 7200 1800 86400 5")},
+	},
+	// HTTPRoute that is not attached to a Gateway.
+	{
+		Qname: "mesh.example.org.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Ns:    []dns.RR{test.SOA("example.org.	5	IN	SOA	ns.dns.example.org. hostmaster.example.org. 1499347823 7200 1800 86400 5")},
+	},
+	{
+		Qname: "nothing.example.org.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeNameError,
+		Ns:    []dns.RR{test.SOA("example.org.	5	IN	SOA	ns.dns.example.org. hostmaster.example.org. 1499347823 7200 1800 86400 5")},
+	},
+	// Services are still served from the cluster zone.
+	{
+		Qname: "svc1.testns.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("svc1.testns.svc.cluster.local.	5	IN	A	10.0.0.1"),
+		},
+	},
+}
+
+func TestHostnames(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	scheme := runtime.NewScheme()
+	gatewayClient := dynamicFake.NewSimpleDynamicClientWithCustomListKinds(scheme, map[schema.GroupVersionResource]string{
+		object.GatewayResource:   "GatewayList",
+		object.HTTPRouteResource: "HTTPRouteList",
+	})
+	dco := dnsControlOpts{
+		zones:        []string{"cluster.local.", "example.org."},
+		ingressZones: []string{"example.org."},
+		gatewayZones: []string{"example.org."},
+	}
+	controller := newdnsController(ctx, client, nil, gatewayClient, dco)
+
+	client.CoreV1().Namespaces().Create(ctx, &api.Namespace{ObjectMeta: meta.ObjectMeta{Name: "testns"}}, meta.CreateOptions{})
+	client.CoreV1().Services("testns").Create(ctx, &api.Service{
+		ObjectMeta: meta.ObjectMeta{Name: "svc1", Namespace: "testns"},
+		Spec:       api.ServiceSpec{ClusterIP: "10.0.0.1", ClusterIPs: []string{"10.0.0.1"}, Ports: []api.ServicePort{{Name: "http", Protocol: "tcp", Port: 80}}},
+	}, meta.CreateOptions{})
+
+	ingresses := []*networking.Ingress{
+		ingress("web", "testns", []string{"www.example.org", "*.apps.example.org"}, networking.IngressLoadBalancerIngress{IP: "192.0.2.10"}),
+		ingress("alias", "testns", []string{"alias.example.org"}, networking.IngressLoadBalancerIngress{Hostname: "lb.example.net"}),
+		ingress("pending", "testns", []string{"pending.example.org"}),
+		ingress("hidden", "hidden", []string{"hidden.example.org"}, networking.IngressLoadBalancerIngress{IP: "192.0.2.11"}),
+	}
+	for _, ing := range ingresses {
+		if _, err := client.NetworkingV1().Ingresses(ing.Namespace).Create(ctx, ing, meta.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gateways := gatewayClient.Resource(object.GatewayResource)
+	routes := gatewayClient.Resource(object.HTTPRouteResource)
+	gw := gatewayAPIObject("Gateway", "gw", "testns", map[string]any{
+		"spec": map[string]any{
+			"listeners": []any{map[string]any{"name": "http", "hostname": "gw.example.org"}, map[string]any{"name": "any"}},
+		},
+		"status": map[string]any{
+			"addresses": []any{map[string]any{"type": "IPAddress", "value": "192.0.2.20"}, map[string]any{"type": "IPAddress", "value": "2001:db8::20"}},
+		},
+	})
+	shop := gatewayAPIObject("HTTPRoute", "shop", "testns", map[string]any{
+		"spec": map[string]any{
+			"hostnames":  []any{"shop.example.org"},
+			"parentRefs": []any{map[string]any{"name": "gw"}},
+		},
+	})
+	mesh := gatewayAPIObject("HTTPRoute", "mesh", "testns", map[string]any{
+		"spec": map[string]any{
+			"hostnames":  []any{"mesh.example.org"},
+			"parentRefs": []any{map[string]any{"group": "", "kind": "Service", "name": "svc1"}},
+		},
+	})
+	hiddenGw := gatewayAPIObject("Gateway", "gw", "hidden", map[string]any{
+		"status": map[string]any{
+			"addresses": []any{map[string]any{"type": "IPAddress", "value": "192.0.2.30"}},
+		},
+	})
+	cross := gatewayAPIObject("HTTPRoute", "cross", "testns", map[string]any{
+		"spec": map[string]any{
+			"hostnames":  []any{"cross.example.org"},
+			"parentRefs": []any{map[string]any{"name": "gw", "namespace": "hidden"}},
+		},
+	})
+	rejected := gatewayAPIObject("HTTPRoute", "rejected", "testns", map[string]any{
+		"spec": map[string]any{
+			"hostnames":  []any{"rejected.example.org"},
+			"parentRefs": []any{map[string]any{"name": "gw"}},
+		},
+		"status": map[string]any{
+			"parents": []any{map[string]any{
+				"parentRef":      map[string]any{"name": "gw"},
+				"controllerName": "example.org/gateway",
+				"conditions":     []any{map[string]any{"type": "Accepted", "status": "False", "reason": "NotAllowedByListeners"}},
+			}},
+		},
+	})
+	for _, g := range []*unstructured.Unstructured{gw, hiddenGw} {
+		if _, err := gateways.Namespace(g.GetNamespace()).Create(ctx, g, meta.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range []*unstructured.Unstructured{shop, mesh, cross, rejected} {
+		if _, err := routes.Namespace("testns").Create(ctx, r, meta.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	k := New([]string{"cluster.local.", "example.org."})
+	k.APIConn = controller
+	k.opts = dco
+	k.Next = test.NextHandler(dns.RcodeSuccess, nil)
+
+	go k.APIConn.Run()
+	defer k.APIConn.Stop()
+	for !k.APIConn.HasSynced() {
+		time.Sleep(time.Millisecond)
+	}
+	// The informers have synced, but the objects may not have been seen yet.
+	for range 100 {
+		if len(controller.HTTPRouteIndex("rejected.example.org")) > 0 && len(controller.GatewayIndex("gw.hidden")) > 0 && len(controller.IngressIndex("hidden.example.org")) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i, tc := range hostnameCases {
+		r := tc.Msg()
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := k.ServeDNS(ctx, w, r); err != nil {
+			t.Errorf("Test %d: expected no error, got %v", i, err)
+			continue
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d, %v", i, err)
+		}
+	}
+}
+
+func ingress(name, namespace string, hosts []string, lb ...networking.IngressLoadBalancerIngress) *networking.Ingress {
+	ing := &networking.Ingress{
+		ObjectMeta: meta.ObjectMeta{Name: name, Namespace: namespace},
+		Status:     networking.IngressStatus{LoadBalancer: networking.IngressLoadBalancerStatus{Ingress: lb}},
+	}
+	for _, h := range hosts {
+		ing.Spec.Rules = append(ing.Spec.Rules, networking.IngressRule{Host: h})
+	}
+	return ing
+}
+
+func gatewayAPIObject(kind, name, namespace string, fields map[string]any) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: fields}
+	u.SetAPIVersion("gateway.networking.k8s.io/v1")
+	u.SetKind(kind)
+	u.SetName(name)
+	u.SetNamespace(namespace)
+	return u
+}
@@ -23,6 +23,7 @@ import (
 	api "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -54,6 +55,7 @@ type Kubernetes struct {
 	apiQPS           float32       // Maximum queries per second from the client to the API server
 	apiBurst         int           // Maximum burst for throttle
 	apiMaxInflight   int           // Maximum number of concurrent requests in flight to the API server
+	clusters         []*Kubernetes // Clusters that each serve one of the zones, from their own API server.
 }
 
 // Upstreamer is used to resolve CNAME or other external targets
@@ -231,8 +233,46 @@ func (k *Kubernetes) getClientConfig() (*rest.Config, error) {
 	return cc, err
 }
 
-// InitKubeCache initializes a new Kubernetes cache.
+// InitKubeCache initializes a new Kubernetes cache, and the caches of the clusters.
 func (k *Kubernetes) InitKubeCache(ctx context.Context) (onStart func() error, onShut func() error, err error) {
+	if len(k.clusters) == 0 {
+		return k.initKubeCache(ctx)
+	}
+	clusterStart, clusterShut, err := k.initClusters(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !k.ownZones() {
+		// All zones are served by the clusters, the first one is used by the external plugins.
+		k.APIConn = k.clusters[0].APIConn
+		return clusterStart, clusterShut, nil
+	}
+
+	start, shut, err := k.initKubeCache(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	onStart = func() error {
+		errc := make(chan error, 1)
+		go func() { errc <- clusterStart() }()
+		err := start()
+		if e := <-errc; e != nil {
+			err = e
+		}
+		return err
+	}
+	onShut = func() error {
+		err := shut()
+		if e := clusterShut(); e != nil {
+			err = e
+		}
+		return err
+	}
+	return onStart, onShut, nil
+}
+
+// initKubeCache initializes the Kubernetes cache of k.
+func (k *Kubernetes) initKubeCache(ctx context.Context) (onStart func() error, onShut func() error, err error) {
 	config, err := k.getClientConfig()
 	if err != nil {
 		return nil, nil, err
@@ -251,6 +291,14 @@ func (k *Kubernetes) InitKubeCache(ctx context.Context) (onStart func() error, o
 		}
 	}
 
+	var gatewayClient dynamic.Interface
+	if len(k.opts.gatewayZones) > 0 {
+		gatewayClient, err = dynamic.NewForConfig(config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create kubernetes gateway notification controller: %q", err)
+		}
+	}
+
 	if k.opts.labelSelector != nil {
 		var selector labels.Selector
 		selector, err = meta.LabelSelectorAsSelector(k.opts.labelSelector)
@@ -287,12 +335,13 @@ func (k *Kubernetes) InitKubeCache(ctx context.Context) (onStart func() error, o
 		}
 	}
 
-	k.opts.initPodCache = k.podMode == podModeVerified
+	// Topology aware answers need the pods, to find the zone of the client.
+	k.opts.initPodCache = k.podMode == podModeVerified || k.opts.topologyAware
 
 	k.opts.zones = k.Zones
 	k.opts.endpointNameMode = k.endpointNameMode
 
-	k.APIConn = newdnsController(ctx, kubeClient, mcsClient, k.opts)
+	k.APIConn = newdnsController(ctx, kubeClient, mcsClient, gatewayClient, k.opts)
 
 	onStart = func() error {
 		go func() {
@@ -330,6 +379,16 @@ func (k *Kubernetes) InitKubeCache(ctx context.Context) (onStart func() error, o
 
 // Records looks up services in kubernetes.
 func (k *Kubernetes) Records(ctx context.Context, state request.Request, exact bool) ([]msg.Service, error) {
+	if k.isHostnameZone(state.Zone) {
+		if services, err := k.findHostnames(state); err != errNoItems {
+			return services, err
+		}
+	}
+
+	if r, ok := k.dnssdRequest(state); ok {
+		return k.findDNSSD(r, state)
+	}
+
 	multicluster := k.isMultiClusterZone(state.Zone)
 	r, e := parseRequest(state.Name(), state.Zone, multicluster)
 	if e != nil {
@@ -355,7 +414,7 @@ func (k *Kubernetes) Records(ctx context.Context, state request.Request, exact b
 	var services []msg.Service
 	var err error
 	if !multicluster {
-		services, err = k.findServices(r, state.Zone)
+		services, err = k.findServices(r, state.Zone, k.clientZone(state))
 	} else {
 		services, err = k.findMultiClusterServices(r, state.Zone)
 	}
@@ -441,8 +500,9 @@ func (k *Kubernetes) findPods(r recordRequest, zone string) (pods []msg.Service,
 	return pods, err
 }
 
-// findServices returns the services matching r from the cache.
-func (k *Kubernetes) findServices(r recordRequest, zone string) (services []msg.Service, err error) {
+// findServices returns the services matching r from the cache. If clientZone is not empty, the endpoints of a
+// headless service are limited to the ones for that zone, when there are any.
+func (k *Kubernetes) findServices(r recordRequest, zone, clientZone string) (services []msg.Service, err error) {
 	if !k.namespaceExposed(r.namespace) {
 		return nil, errNoItems
 	}
@@ -474,6 +534,10 @@ func (k *Kubernetes) findServices(r recordRequest, zone string) (services []msg.
 		if !match(r.namespace, svc.Namespace) || !match(r.service, svc.Name) {
 			continue
 		}
+		ttl, publish := k.servicePolicy(svc)
+		if !publish {
+			continue
+		}
 
 		// If "ignore empty_service" option is set and no endpoints exist, return NXDOMAIN unless
 		// it's a headless or externalName service (covered below).
@@ -496,7 +560,7 @@ func (k *Kubernetes) findServices(r recordRequest, zone string) (services []msg.
 			if r.endpoint != "" || r.port != "" || r.protocol != "" {
 				continue
 			}
-			s := msg.Service{Key: strings.Join([]string{zonePath, Svc, svc.Namespace, svc.Name}, "/"), Host: svc.ExternalName, TTL: k.ttl}
+			s := msg.Service{Key: strings.Join([]string{zonePath, Svc, svc.Namespace, svc.Name}, "/"), Host: svc.ExternalName, TTL: ttl}
 			if t, _ := s.HostType(); t == dns.TypeCNAME {
 				s.Key = strings.Join([]string{zonePath, Svc, svc.Namespace, svc.Name}, "/")
 				services = append(services, s)
@@ -512,25 +576,34 @@ func (k *Kubernetes) findServices(r recordRequest, zone string) (services []msg.
 				endpointsList = endpointsListFunc()
 			}
 
+			// Only a query for the service itself is topology aware, an endpoint is always answered.
+			topologyZone := ""
+			if r.endpoint == "" && clientZone != "" && hasZoneEndpoints(endpointsList, svc, clientZone) {
+				topologyZone = clientZone
+			}
+
 			for _, ep := range endpointsList {
 				if object.EndpointsKey(svc.Name, svc.Namespace) != ep.Index {
 					continue
 				}
 
 				for _, eps := range ep.Subsets {
-					for _, addr := range eps.Addresses {
+					for _, addr := range serviceAddresses(svc, eps) {
 						// See comments in parse.go parseRequest about the endpoint handling.
 						if r.endpoint != "" {
 							if !match(r.endpoint, endpointHostname(addr, k.endpointNameMode)) {
 								continue
 							}
 						}
+						if topologyZone != "" && !endpointForZone(addr, topologyZone) {
+							continue
+						}
 
 						for _, p := range eps.Ports {
 							if !(matchPortAndProtocol(r.port, p.Name, r.protocol, p.Protocol)) {
 								continue
 							}
-							s := msg.Service{Host: addr.IP, Port: int(p.Port), TTL: k.ttl}
+							s := msg.Service{Host: addr.IP, Port: int(p.Port), TTL: ttl}
 							s.Key = strings.Join([]string{zonePath, Svc, svc.Namespace, svc.Name, endpointHostname(addr, k.endpointNameMode)}, "/")
 
 							err = nil
@@ -552,12 +625,19 @@ func (k *Kubernetes) findServices(r recordRequest, zone string) (services []msg.
 			err = nil
 
 			for _, ip := range svc.ClusterIPs {
-				s := msg.Service{Host: ip, Port: int(p.Port), TTL: k.ttl}
+				s := msg.Service{Host: ip, Port: int(p.Port), TTL: ttl}
 				s.Key = strings.Join([]string{zonePath, Svc, svc.Namespace, svc.Name}, "/")
 				services = append(services, s)
 			}
 		}
 	}
+
+	// A name that isn't a service can be an alias of one.
+	if len(serviceList) == 0 && r.endpoint == "" && r.port == "" && r.protocol == "" {
+		if aliases := k.findAliases(r, zone); len(aliases) > 0 {
+			return aliases, nil
+		}
+	}
 	return services, err
 }
 
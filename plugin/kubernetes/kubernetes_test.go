@@ -48,6 +48,7 @@ func (APIConnServiceTest) Run()                                        {}
 func (APIConnServiceTest) Stop() error                                 { return nil }
 func (APIConnServiceTest) PodIndex(string) []*object.Pod               { return nil }
 func (APIConnServiceTest) SvcIndexReverse(string) []*object.Service    { return nil }
+func (APIConnServiceTest) SvcAliasIndex(string) []*object.Service      { return nil }
 func (APIConnServiceTest) SvcExtIndexReverse(string) []*object.Service { return nil }
 func (APIConnServiceTest) EpIndexReverse(string) []*object.Endpoints   { return nil }
 func (APIConnServiceTest) Modified(ModifiedMode) int64                 { return 0 }
@@ -330,6 +331,26 @@ func (APIConnServiceTest) McEpIndex(string) []*object.MultiClusterEndpoints {
 	return eps
 }
 
+func (APIConnServiceTest) IngressIndex(string) []*object.Ingress {
+	return nil
+}
+
+func (APIConnServiceTest) GatewayIndex(string) []*object.Gateway {
+	return nil
+}
+
+func (APIConnServiceTest) GatewayHostIndex(string) []*object.Gateway {
+	return nil
+}
+
+func (APIConnServiceTest) HTTPRouteIndex(string) []*object.HTTPRoute {
+	return nil
+}
+
+func (APIConnServiceTest) NodeZone(string) string {
+	return ""
+}
+
 func (APIConnServiceTest) GetNodeByName(ctx context.Context, name string) (*api.Node, error) {
 	return &api.Node{
 		ObjectMeta: meta.ObjectMeta{
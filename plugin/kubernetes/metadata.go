@@ -10,6 +10,9 @@ import (
 
 // Metadata implements the metadata.Provider interface.
 func (k *Kubernetes) Metadata(ctx context.Context, state request.Request) context.Context {
+	if c := k.cluster(state.Name()); c != nil {
+		return c.Metadata(ctx, state)
+	}
 	pod := k.podWithIP(state.IP())
 	if pod != nil {
 		metadata.SetValueFunc(ctx, "kubernetes/client-namespace", func() string {
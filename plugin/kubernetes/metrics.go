@@ -49,6 +49,28 @@ var (
 		},
 		[]string{"code", "method", "host"},
 	)
+
+	// clusterSynced reports whether the caches of a cluster have synced.
+	clusterSynced = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: plugin.Namespace,
+			Subsystem: "kubernetes",
+			Name:      "cluster_synced",
+			Help:      "Whether the caches of a cluster have synced (1) or not (0).",
+		},
+		[]string{"cluster"},
+	)
+
+	// clusterRequests counts the queries answered from a cluster.
+	clusterRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: plugin.Namespace,
+			Subsystem: "kubernetes",
+			Name:      "cluster_requests_total",
+			Help:      "Counter of queries answered from a cluster.",
+		},
+		[]string{"server", "cluster"},
+	)
 )
 
 func init() {
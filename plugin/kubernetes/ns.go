@@ -26,6 +26,10 @@ func (k *Kubernetes) nsAddrs(external, headless bool, zone string) []dns.RR {
 
 		// Collect IPs for all Services of the Endpoints
 		for _, endpoint := range endpoints {
+			// Addresses that are not ready are in the index as well.
+			if !k.endpointsPublishIP(endpoint, localIP.String()) {
+				continue
+			}
 			foundEndpoint = true
 			svcs := k.APIConn.SvcIndex(endpoint.Index)
 			for _, svc := range svcs {
@@ -34,7 +38,7 @@ func (k *Kubernetes) nsAddrs(external, headless bool, zone string) []dns.RR {
 
 					if headless && svc.Headless() {
 						for _, s := range endpoint.Subsets {
-							for _, a := range s.Addresses {
+							for _, a := range serviceAddresses(svc, s) {
 								svcNames = append(svcNames, endpointHostname(a, k.endpointNameMode)+"."+svcName)
 								svcIPs = append(svcIPs, net.ParseIP(a.IP))
 							}
@@ -52,7 +56,7 @@ func (k *Kubernetes) nsAddrs(external, headless bool, zone string) []dns.RR {
 				if svc.Headless() {
 					// For a headless service, use the endpoints IPs
 					for _, s := range endpoint.Subsets {
-						for _, a := range s.Addresses {
+						for _, a := range serviceAddresses(svc, s) {
 							svcNames = append(svcNames, endpointHostname(a, k.endpointNameMode)+"."+svcName)
 							svcIPs = append(svcIPs, net.ParseIP(a.IP))
 						}
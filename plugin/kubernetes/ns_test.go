@@ -19,11 +19,17 @@ func (APIConnTest) Run()                                             {}
 func (APIConnTest) Stop() error                                      { return nil }
 func (APIConnTest) PodIndex(string) []*object.Pod                    { return nil }
 func (APIConnTest) SvcIndexReverse(string) []*object.Service         { return nil }
+func (APIConnTest) SvcAliasIndex(string) []*object.Service           { return nil }
 func (APIConnTest) SvcExtIndexReverse(string) []*object.Service      { return nil }
 func (APIConnTest) ServiceImportList() []*object.ServiceImport       { return nil }
 func (APIConnTest) SvcImportIndex(string) []*object.ServiceImport    { return nil }
 func (APIConnTest) EpIndex(string) []*object.Endpoints               { return nil }
 func (APIConnTest) McEpIndex(string) []*object.MultiClusterEndpoints { return nil }
+func (APIConnTest) IngressIndex(string) []*object.Ingress            { return nil }
+func (APIConnTest) GatewayIndex(string) []*object.Gateway            { return nil }
+func (APIConnTest) GatewayHostIndex(string) []*object.Gateway        { return nil }
+func (APIConnTest) HTTPRouteIndex(string) []*object.HTTPRoute        { return nil }
+func (APIConnTest) NodeZone(string) string                           { return "" }
 func (APIConnTest) EndpointsList() []*object.Endpoints               { return nil }
 func (APIConnTest) Modified(ModifiedMode) int64                      { return 0 }
 
@@ -0,0 +1,70 @@
+package object
+
+import (
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// The annotations on Services and Namespaces that change how the records of the Services are served. Values that
+// can't be parsed are ignored.
+const (
+	// AnnotationTTL sets the TTL, in seconds, of the records of a Service, or of the Services in a Namespace.
+	AnnotationTTL = "coredns.io/ttl"
+	// AnnotationHide set to "true" doesn't publish a Service, or the Services in a Namespace.
+	AnnotationHide = "coredns.io/hide"
+	// AnnotationPublishNotReady set to "true" publishes the endpoints of a headless Service that are not ready.
+	AnnotationPublishNotReady = "coredns.io/publish-not-ready-addresses"
+	// AnnotationAliases is a comma separated list of names that are a CNAME for the Service, in its Namespace.
+	AnnotationAliases = "coredns.io/aliases"
+)
+
+// maxTTL is the largest TTL that can be set with AnnotationTTL, this is the same as the ttl option of the plugin.
+const maxTTL = 3600
+
+// annotationTTL returns the TTL in annotations, or nil if none is set.
+func annotationTTL(annotations map[string]string) *uint32 {
+	v, ok := annotations[AnnotationTTL]
+	if !ok {
+		return nil
+	}
+	ttl, err := strconv.ParseUint(v, 10, 32)
+	if err != nil || ttl > maxTTL {
+		return nil
+	}
+	t := uint32(ttl)
+	return &t
+}
+
+// annotationBool returns true if the annotation key is set to true in annotations.
+func annotationBool(annotations map[string]string, key string) bool {
+	b, _ := strconv.ParseBool(annotations[key])
+	return b
+}
+
+// annotationAliases returns the aliases in annotations, names that are not a valid DNS label are left out.
+func annotationAliases(annotations map[string]string) []string {
+	v, ok := annotations[AnnotationAliases]
+	if !ok {
+		return nil
+	}
+	var aliases []string
+	for a := range strings.SplitSeq(v, ",") {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if len(validation.IsDNS1123Label(a)) > 0 {
+			continue
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases
+}
+
+// copyTTL returns a copy of ttl.
+func copyTTL(ttl *uint32) *uint32 {
+	if ttl == nil {
+		return nil
+	}
+	t := *ttl
+	return &t
+}
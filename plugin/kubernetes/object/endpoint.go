@@ -23,9 +23,11 @@ type Endpoints struct {
 
 // EndpointSubset is a group of addresses with a common set of ports. The
 // expanded set of endpoints is the Cartesian product of Addresses x Ports.
+// NotReadyAddresses are only published for Services that ask for it.
 type EndpointSubset struct {
-	Addresses []EndpointAddress
-	Ports     []EndpointPort
+	Addresses         []EndpointAddress
+	NotReadyAddresses []EndpointAddress
+	Ports             []EndpointPort
 }
 
 // EndpointAddress is a tuple that describes single IP address.
@@ -34,6 +36,9 @@ type EndpointAddress struct {
 	Hostname      string
 	NodeName      string
 	TargetRefName string
+	// Zone is the zone of the endpoint, and ForZones are the zones it should be used for, from the hints.
+	Zone     string
+	ForZones []string
 }
 
 // EndpointPort is a tuple that describes a single port.
@@ -84,9 +89,7 @@ func EndpointSliceToEndpoints(obj meta.Object) (meta.Object, error) {
 	}
 
 	for _, end := range ends.Endpoints {
-		if !endpointsliceReady(end.Conditions.Ready) {
-			continue
-		}
+		ready := endpointsliceReady(end.Conditions.Ready)
 		for _, a := range end.Addresses {
 			ea := EndpointAddress{IP: a}
 			if end.Hostname != nil {
@@ -99,8 +102,21 @@ func EndpointSliceToEndpoints(obj meta.Object) (meta.Object, error) {
 			if end.NodeName != nil {
 				ea.NodeName = *end.NodeName
 			}
-			e.Subsets[0].Addresses = append(e.Subsets[0].Addresses, ea)
+			if end.Zone != nil {
+				ea.Zone = *end.Zone
+			}
+			if end.Hints != nil {
+				for _, z := range end.Hints.ForZones {
+					ea.ForZones = append(ea.ForZones, z.Name)
+				}
+			}
+			// Addresses that are not ready are indexed too, the Service decides whether they are published.
 			e.IndexIP = append(e.IndexIP, a)
+			if !ready {
+				e.Subsets[0].NotReadyAddresses = append(e.Subsets[0].NotReadyAddresses, ea)
+				continue
+			}
+			e.Subsets[0].Addresses = append(e.Subsets[0].Addresses, ea)
 		}
 	}
 
@@ -151,8 +167,13 @@ func (e *Endpoints) DeepCopyObject() runtime.Object {
 			Ports:     make([]EndpointPort, len(eps.Ports)),
 		}
 		for j, a := range eps.Addresses {
-			ea := EndpointAddress{IP: a.IP, Hostname: a.Hostname, NodeName: a.NodeName, TargetRefName: a.TargetRefName}
-			sub.Addresses[j] = ea
+			sub.Addresses[j] = a.deepCopy()
+		}
+		if len(eps.NotReadyAddresses) > 0 {
+			sub.NotReadyAddresses = make([]EndpointAddress, len(eps.NotReadyAddresses))
+			for j, a := range eps.NotReadyAddresses {
+				sub.NotReadyAddresses[j] = a.deepCopy()
+			}
 		}
 		for k, p := range eps.Ports {
 			ep := EndpointPort{Port: p.Port, Name: p.Name, Protocol: p.Protocol}
@@ -163,6 +184,16 @@ func (e *Endpoints) DeepCopyObject() runtime.Object {
 	return e1
 }
 
+// deepCopy returns a copy of a.
+func (a EndpointAddress) deepCopy() EndpointAddress {
+	ea := EndpointAddress{IP: a.IP, Hostname: a.Hostname, NodeName: a.NodeName, TargetRefName: a.TargetRefName, Zone: a.Zone}
+	if len(a.ForZones) > 0 {
+		ea.ForZones = make([]string, len(a.ForZones))
+		copy(ea.ForZones, a.ForZones)
+	}
+	return ea
+}
+
 // GetNamespace implements the metav1.Object interface.
 func (e *Endpoints) GetNamespace() string { return e.Namespace }
 
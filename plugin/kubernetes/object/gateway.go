@@ -0,0 +1,246 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// The Gateway API resources are watched with a dynamic client, so we don't depend on the Gateway API module.
+var (
+	GatewayResource   = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "gateways"}
+	HTTPRouteResource = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"}
+)
+
+// Gateway is a stripped down Gateway API Gateway with only the items we need for CoreDNS.
+type Gateway struct {
+	Version   string
+	Name      string
+	Namespace string
+	Index     string
+	// Hostnames are the lowercased hostnames of the listeners, these may be wildcards.
+	Hostnames []string
+	// Addresses are the IP addresses and host names in the status.
+	Addresses []string
+
+	*Empty
+}
+
+// HTTPRoute is a stripped down Gateway API HTTPRoute with only the items we need for CoreDNS.
+type HTTPRoute struct {
+	Version   string
+	Name      string
+	Namespace string
+	// Hostnames are the lowercased hostnames of the route, these may be wildcards.
+	Hostnames []string
+	// Gateways are the index keys of the parent Gateways, without the ones that did not accept the route.
+	Gateways []string
+
+	*Empty
+}
+
+// GatewayKey returns a string using for the index.
+func GatewayKey(name, namespace string) string { return name + "." + namespace }
+
+// gatewayObject holds the fields of a Gateway we convert from the unstructured object.
+type gatewayObject struct {
+	Spec struct {
+		Listeners []struct {
+			Hostname string `json:"hostname"`
+		} `json:"listeners"`
+	} `json:"spec"`
+	Status struct {
+		Addresses []struct {
+			Value string `json:"value"`
+		} `json:"addresses"`
+	} `json:"status"`
+}
+
+// parentRef is a reference from an HTTPRoute to its parent.
+type parentRef struct {
+	Group     *string `json:"group"`
+	Kind      *string `json:"kind"`
+	Namespace *string `json:"namespace"`
+	Name      string  `json:"name"`
+}
+
+// gatewayKey returns the index key of the Gateway p refers to from namespace, or the empty string if p is not a
+// Gateway. Group and kind default to a Gateway, other parents (such as a Service for GAMMA) have no addresses.
+func (p parentRef) gatewayKey(namespace string) string {
+	if p.Group != nil && *p.Group != GatewayResource.Group {
+		return ""
+	}
+	if p.Kind != nil && *p.Kind != "Gateway" {
+		return ""
+	}
+	if p.Namespace != nil && *p.Namespace != "" {
+		namespace = *p.Namespace
+	}
+	return GatewayKey(p.Name, namespace)
+}
+
+// httpRouteObject holds the fields of an HTTPRoute we convert from the unstructured object.
+type httpRouteObject struct {
+	Spec struct {
+		ParentRefs []parentRef `json:"parentRefs"`
+		Hostnames  []string    `json:"hostnames"`
+	} `json:"spec"`
+	Status struct {
+		Parents []struct {
+			ParentRef  parentRef `json:"parentRef"`
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"conditions"`
+		} `json:"parents"`
+	} `json:"status"`
+}
+
+// ToGateway converts an unstructured Gateway to a *Gateway.
+func ToGateway(obj meta.Object) (meta.Object, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %v", obj)
+	}
+	var gw gatewayObject
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &gw); err != nil {
+		return nil, err
+	}
+	g := &Gateway{
+		Version:   u.GetResourceVersion(),
+		Name:      u.GetName(),
+		Namespace: u.GetNamespace(),
+		Index:     GatewayKey(u.GetName(), u.GetNamespace()),
+	}
+	for _, l := range gw.Spec.Listeners {
+		if l.Hostname != "" {
+			g.Hostnames = append(g.Hostnames, strings.ToLower(l.Hostname))
+		}
+	}
+	for _, a := range gw.Status.Addresses {
+		if a.Value != "" {
+			g.Addresses = append(g.Addresses, a.Value)
+		}
+	}
+
+	u.Object = nil
+	return g, nil
+}
+
+// ToHTTPRoute converts an unstructured HTTPRoute to a *HTTPRoute.
+func ToHTTPRoute(obj meta.Object) (meta.Object, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %v", obj)
+	}
+	var route httpRouteObject
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &route); err != nil {
+		return nil, err
+	}
+	r := &HTTPRoute{
+		Version:   u.GetResourceVersion(),
+		Name:      u.GetName(),
+		Namespace: u.GetNamespace(),
+	}
+	for _, h := range route.Spec.Hostnames {
+		r.Hostnames = append(r.Hostnames, strings.ToLower(h))
+	}
+	// A Gateway that has not (yet) set the status of the route is used, one that only reports the route as
+	// not accepted is not.
+	accepted := make(map[string]bool)
+	for _, p := range route.Status.Parents {
+		key := p.ParentRef.gatewayKey(u.GetNamespace())
+		for _, c := range p.Conditions {
+			if c.Type == "Accepted" {
+				accepted[key] = accepted[key] || c.Status == "True"
+			}
+		}
+	}
+	for _, p := range route.Spec.ParentRefs {
+		key := p.gatewayKey(u.GetNamespace())
+		if key == "" {
+			continue
+		}
+		if ok, seen := accepted[key]; seen && !ok {
+			continue
+		}
+		r.Gateways = append(r.Gateways, key)
+	}
+
+	u.Object = nil
+	return r, nil
+}
+
+var (
+	_ runtime.Object = &Gateway{}
+	_ runtime.Object = &HTTPRoute{}
+)
+
+// DeepCopyObject implements the ObjectKind interface.
+func (g *Gateway) DeepCopyObject() runtime.Object {
+	g1 := &Gateway{
+		Version:   g.Version,
+		Name:      g.Name,
+		Namespace: g.Namespace,
+		Index:     g.Index,
+		Hostnames: make([]string, len(g.Hostnames)),
+		Addresses: make([]string, len(g.Addresses)),
+	}
+	copy(g1.Hostnames, g.Hostnames)
+	copy(g1.Addresses, g.Addresses)
+	return g1
+}
+
+// GetNamespace implements the metav1.Object interface.
+func (g *Gateway) GetNamespace() string { return g.Namespace }
+
+// SetNamespace implements the metav1.Object interface.
+func (g *Gateway) SetNamespace(namespace string) {}
+
+// GetName implements the metav1.Object interface.
+func (g *Gateway) GetName() string { return g.Name }
+
+// SetName implements the metav1.Object interface.
+func (g *Gateway) SetName(name string) {}
+
+// GetResourceVersion implements the metav1.Object interface.
+func (g *Gateway) GetResourceVersion() string { return g.Version }
+
+// SetResourceVersion implements the metav1.Object interface.
+func (g *Gateway) SetResourceVersion(version string) {}
+
+// DeepCopyObject implements the ObjectKind interface.
+func (r *HTTPRoute) DeepCopyObject() runtime.Object {
+	r1 := &HTTPRoute{
+		Version:   r.Version,
+		Name:      r.Name,
+		Namespace: r.Namespace,
+		Hostnames: make([]string, len(r.Hostnames)),
+		Gateways:  make([]string, len(r.Gateways)),
+	}
+	copy(r1.Hostnames, r.Hostnames)
+	copy(r1.Gateways, r.Gateways)
+	return r1
+}
+
+// GetNamespace implements the metav1.Object interface.
+func (r *HTTPRoute) GetNamespace() string { return r.Namespace }
+
+// SetNamespace implements the metav1.Object interface.
+func (r *HTTPRoute) SetNamespace(namespace string) {}
+
+// GetName implements the metav1.Object interface.
+func (r *HTTPRoute) GetName() string { return r.Name }
+
+// SetName implements the metav1.Object interface.
+func (r *HTTPRoute) SetName(name string) {}
+
+// GetResourceVersion implements the metav1.Object interface.
+func (r *HTTPRoute) GetResourceVersion() string { return r.Version }
+
+// SetResourceVersion implements the metav1.Object interface.
+func (r *HTTPRoute) SetResourceVersion(version string) {}
@@ -13,6 +13,9 @@ type Namespace struct {
 	// Don't add new fields to this struct without talking to the CoreDNS maintainers.
 	Version string
 	Name    string
+	// TTL and Hide are set from the annotations of the Namespace, the ones of a Service take precedence.
+	TTL  *uint32
+	Hide bool
 
 	*Empty
 }
@@ -26,6 +29,8 @@ func ToNamespace(obj meta.Object) (meta.Object, error) {
 	n := &Namespace{
 		Version: ns.GetResourceVersion(),
 		Name:    ns.GetName(),
+		TTL:     annotationTTL(ns.GetAnnotations()),
+		Hide:    annotationBool(ns.GetAnnotations(), AnnotationHide),
 	}
 	*ns = api.Namespace{}
 	return n, nil
@@ -38,6 +43,8 @@ func (n *Namespace) DeepCopyObject() runtime.Object {
 	n1 := &Namespace{
 		Version: n.Version,
 		Name:    n.Name,
+		TTL:     copyTTL(n.TTL),
+		Hide:    n.Hide,
 	}
 	return n1
 }
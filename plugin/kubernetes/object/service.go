@@ -2,6 +2,8 @@ package object
 
 import (
 	"fmt"
+	"maps"
+	"slices"
 
 	api "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -23,6 +25,15 @@ type Service struct {
 	// ExternalIPs we may want to export.
 	ExternalIPs []string
 
+	// Annotations holds the annotations selected for DNS-SD TXT records, see ToServiceWithAnnotations.
+	Annotations map[string]string
+
+	// TTL, Hide, PublishNotReady and Aliases are set from the annotations of the Service, see AnnotationTTL.
+	TTL             *uint32
+	Hide            bool
+	PublishNotReady bool
+	Aliases         []string
+
 	*Empty
 }
 
@@ -44,6 +55,11 @@ func ToService(obj meta.Object) (meta.Object, error) {
 		ExternalName: svc.Spec.ExternalName,
 
 		ExternalIPs: make([]string, len(svc.Status.LoadBalancer.Ingress)+len(svc.Spec.ExternalIPs)),
+
+		TTL:             annotationTTL(svc.GetAnnotations()),
+		Hide:            annotationBool(svc.GetAnnotations(), AnnotationHide),
+		PublishNotReady: annotationBool(svc.GetAnnotations(), AnnotationPublishNotReady),
+		Aliases:         annotationAliases(svc.GetAnnotations()),
 	}
 
 	if len(svc.Spec.ClusterIPs) > 0 {
@@ -75,6 +91,32 @@ func ToService(obj meta.Object) (meta.Object, error) {
 	return s, nil
 }
 
+// ToServiceWithAnnotations returns a ToFunc that converts an api.Service to a *Service like ToService does, but
+// also keeps the annotations in keys.
+func ToServiceWithAnnotations(keys []string) ToFunc {
+	return func(obj meta.Object) (meta.Object, error) {
+		var annotations map[string]string
+		if svc, ok := obj.(*api.Service); ok {
+			for _, key := range keys {
+				v, ok := svc.GetAnnotations()[key]
+				if !ok {
+					continue
+				}
+				if annotations == nil {
+					annotations = make(map[string]string)
+				}
+				annotations[key] = v
+			}
+		}
+		s, err := ToService(obj)
+		if err != nil {
+			return nil, err
+		}
+		s.(*Service).Annotations = annotations
+		return s, nil
+	}
+}
+
 // Headless returns true if the service is headless
 func (s *Service) Headless() bool {
 	return s.ClusterIPs[0] == api.ClusterIPNone
@@ -94,10 +136,20 @@ func (s *Service) DeepCopyObject() runtime.Object {
 		ClusterIPs:   make([]string, len(s.ClusterIPs)),
 		Ports:        make([]api.ServicePort, len(s.Ports)),
 		ExternalIPs:  make([]string, len(s.ExternalIPs)),
+
+		TTL:             copyTTL(s.TTL),
+		Hide:            s.Hide,
+		PublishNotReady: s.PublishNotReady,
 	}
 	copy(s1.ClusterIPs, s.ClusterIPs)
 	copy(s1.Ports, s.Ports)
 	copy(s1.ExternalIPs, s.ExternalIPs)
+	if s.Annotations != nil {
+		s1.Annotations = maps.Clone(s.Annotations)
+	}
+	if s.Aliases != nil {
+		s1.Aliases = slices.Clone(s.Aliases)
+	}
 	return s1
 }
 
@@ -0,0 +1,89 @@
+package kubernetes
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/etcd/msg"
+	"github.com/coredns/coredns/plugin/kubernetes/object"
+)
+
+// servicePolicy returns the TTL of the records of svc, and false if svc should not be published. They are set with
+// annotations on svc and its namespace, the ones on svc take precedence. See object.AnnotationTTL.
+func (k *Kubernetes) servicePolicy(svc *object.Service) (ttl uint32, publish bool) {
+	if svc.Hide {
+		return 0, false
+	}
+	ttl = k.ttl
+	if svc.TTL != nil {
+		ttl = *svc.TTL
+	}
+	ns, err := k.APIConn.GetNamespaceByName(svc.Namespace)
+	if err != nil || ns == nil {
+		return ttl, true
+	}
+	if ns.Hide {
+		return 0, false
+	}
+	if svc.TTL == nil && ns.TTL != nil {
+		ttl = *ns.TTL
+	}
+	return ttl, true
+}
+
+// endpointsPolicy returns the policy of the service of ep, see servicePolicy.
+func (k *Kubernetes) endpointsPolicy(ep *object.Endpoints) (ttl uint32, publish bool) {
+	if svcs := k.APIConn.SvcIndex(ep.Index); len(svcs) > 0 {
+		return k.servicePolicy(svcs[0])
+	}
+	return k.ttl, true
+}
+
+// serviceAddresses returns the addresses in the endpoint subset of svc, with the ones that are not ready if svc
+// publishes those.
+func serviceAddresses(svc *object.Service, subset object.EndpointSubset) []object.EndpointAddress {
+	if !svc.PublishNotReady || len(subset.NotReadyAddresses) == 0 {
+		return subset.Addresses
+	}
+	return slices.Concat(subset.Addresses, subset.NotReadyAddresses)
+}
+
+// endpointsAddresses returns the addresses in the endpoint subset of ep, see serviceAddresses.
+func (k *Kubernetes) endpointsAddresses(ep *object.Endpoints, subset object.EndpointSubset) []object.EndpointAddress {
+	if svcs := k.APIConn.SvcIndex(ep.Index); len(svcs) > 0 {
+		return serviceAddresses(svcs[0], subset)
+	}
+	return subset.Addresses
+}
+
+// endpointsPublishIP returns true if ip is one of the published addresses of ep.
+func (k *Kubernetes) endpointsPublishIP(ep *object.Endpoints, ip string) bool {
+	for _, sub := range ep.Subsets {
+		if slices.ContainsFunc(k.endpointsAddresses(ep, sub), func(a object.EndpointAddress) bool { return a.IP == ip }) {
+			return true
+		}
+	}
+	return false
+}
+
+// findAliases returns a CNAME to the service that has the service in r as an alias. When more services claim
+// the same alias, the one with the lowest name gets it.
+func (k *Kubernetes) findAliases(r recordRequest, zone string) []msg.Service {
+	var (
+		target *object.Service
+		ttl    uint32
+	)
+	for _, svc := range k.APIConn.SvcAliasIndex(object.ServiceKey(r.service, r.namespace)) {
+		t, publish := k.servicePolicy(svc)
+		if !publish || (target != nil && target.Name <= svc.Name) {
+			continue
+		}
+		target, ttl = svc, t
+	}
+	if target == nil {
+		return nil
+	}
+	s := msg.Service{Host: strings.Join([]string{target.Name, target.Namespace, Svc, zone}, "."), TTL: ttl}
+	s.Key = strings.Join([]string{msg.Path(zone, coredns), Svc, target.Namespace, r.service}, "/")
+	return []msg.Service{s}
+}
@@ -0,0 +1,203 @@
+package kubernetes
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/kubernetes/object"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	api "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAnnotations(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	dco := dnsControlOpts{
+		zones:              []string{"cluster.local."},
+		initEndpointsCache: true,
+	}
+	controller := newdnsController(ctx, client, nil, nil, dco)
+
+	namespaces := []*api.Namespace{
+		{ObjectMeta: meta.ObjectMeta{Name: "testns", Annotations: map[string]string{object.AnnotationTTL: "30"}}},
+		{ObjectMeta: meta.ObjectMeta{Name: "hidden", Annotations: map[string]string{object.AnnotationHide: "true"}}},
+	}
+	for _, ns := range namespaces {
+		client.CoreV1().Namespaces().Create(ctx, ns, meta.CreateOptions{})
+	}
+
+	clusterIP := func(ip string) api.ServiceSpec {
+		return api.ServiceSpec{ClusterIP: ip, ClusterIPs: []string{ip}, Ports: []api.ServicePort{{Name: "http", Protocol: "TCP", Port: 80}}}
+	}
+	headless := api.ServiceSpec{ClusterIP: api.ClusterIPNone, Ports: []api.ServicePort{{Name: "http", Protocol: "TCP", Port: 80}}}
+	services := []*api.Service{
+		{ObjectMeta: meta.ObjectMeta{Name: "nsttl", Namespace: "testns"}, Spec: clusterIP("10.0.0.1")},
+		{ObjectMeta: meta.ObjectMeta{Name: "ttl", Namespace: "testns", Annotations: map[string]string{object.AnnotationTTL: "60"}}, Spec: clusterIP("10.0.0.2")},
+		{ObjectMeta: meta.ObjectMeta{Name: "badttl", Namespace: "testns", Annotations: map[string]string{object.AnnotationTTL: "1d"}}, Spec: clusterIP("10.0.0.3")},
+		{ObjectMeta: meta.ObjectMeta{Name: "hide", Namespace: "testns", Annotations: map[string]string{object.AnnotationHide: "true"}}, Spec: clusterIP("10.0.0.4")},
+		{ObjectMeta: meta.ObjectMeta{Name: "web", Namespace: "testns", Annotations: map[string]string{object.AnnotationAliases: "www, Frontend, not_a_label"}}, Spec: clusterIP("10.0.0.5")},
+		{ObjectMeta: meta.ObjectMeta{Name: "web", Namespace: "hidden"}, Spec: clusterIP("10.0.1.1")},
+		// An alias that is claimed twice, and one that is the name of a Service.
+		{ObjectMeta: meta.ObjectMeta{Name: "zweb", Namespace: "testns", Annotations: map[string]string{object.AnnotationAliases: "www"}}, Spec: clusterIP("10.0.0.6")},
+		{ObjectMeta: meta.ObjectMeta{Name: "shadow", Namespace: "testns", Annotations: map[string]string{object.AnnotationAliases: "ready"}}, Spec: clusterIP("10.0.0.7")},
+		{ObjectMeta: meta.ObjectMeta{Name: "notready", Namespace: "testns", Annotations: map[string]string{object.AnnotationPublishNotReady: "true"}}, Spec: headless},
+		{ObjectMeta: meta.ObjectMeta{Name: "ready", Namespace: "testns"}, Spec: headless},
+	}
+	for _, s := range services {
+		client.CoreV1().Services(s.Namespace).Create(ctx, s, meta.CreateOptions{})
+	}
+
+	notReady := false
+	for _, name := range []string{"notready", "ready"} {
+		client.DiscoveryV1().EndpointSlices("testns").Create(ctx, &discovery.EndpointSlice{
+			ObjectMeta: meta.ObjectMeta{Name: name + "-1", Namespace: "testns", Labels: map[string]string{discovery.LabelServiceName: name}},
+			Endpoints: []discovery.Endpoint{
+				{Addresses: []string{"172.0.0.1"}},
+				{Addresses: []string{"172.0.0.2"}, Conditions: discovery.EndpointConditions{Ready: &notReady}},
+			},
+		}, meta.CreateOptions{})
+	}
+
+	k := New([]string{"cluster.local.", "0.10.in-addr.arpa.", "172.in-addr.arpa."})
+	k.APIConn = controller
+	k.opts = dco
+	k.Next = test.NextHandler(dns.RcodeSuccess, nil)
+
+	go k.APIConn.Run()
+	defer k.APIConn.Stop()
+	for !k.APIConn.HasSynced() {
+		time.Sleep(time.Millisecond)
+	}
+	for range 100 {
+		if len(controller.ServiceList()) == len(services) && len(controller.EpIndex("ready.testns")) > 0 && len(controller.EpIndex("notready.testns")) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []test.Case{
+		// The TTL of the namespace, of the service itself, and the one of the plugin for an invalid annotation.
+		{
+			Qname: "nsttl.testns.svc.cluster.local.", Qtype: dns.TypeA,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("nsttl.testns.svc.cluster.local.	30	IN	A	10.0.0.1")},
+		},
+		{
+			Qname: "ttl.testns.svc.cluster.local.", Qtype: dns.TypeA,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("ttl.testns.svc.cluster.local.	60	IN	A	10.0.0.2")},
+		},
+		{
+			Qname: "badttl.testns.svc.cluster.local.", Qtype: dns.TypeA,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("badttl.testns.svc.cluster.local.	30	IN	A	10.0.0.3")},
+		},
+		{
+			Qname: "hide.testns.svc.cluster.local.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{test.SOA("cluster.local.	5	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1499347823 7200 1800 86400 5")},
+		},
+		{
+			Qname: "web.hidden.svc.cluster.local.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{test.SOA("cluster.local.	5	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1499347823 7200 1800 86400 5")},
+		},
+		{
+			Qname: "2.0.0.10.in-addr.arpa.", Qtype: dns.TypePTR,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.PTR("2.0.0.10.in-addr.arpa.	60	IN	PTR	ttl.testns.svc.cluster.local.")},
+		},
+		{
+			Qname: "4.0.0.10.in-addr.arpa.", Qtype: dns.TypePTR,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{test.SOA("0.10.in-addr.arpa.	5	IN	SOA	ns.dns.0.10.in-addr.arpa. hostmaster.0.10.in-addr.arpa. 1502782828 7200 1800 86400 5")},
+		},
+		{
+			Qname: "notready.testns.svc.cluster.local.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.A("notready.testns.svc.cluster.local.	30	IN	A	172.0.0.1"),
+				test.A("notready.testns.svc.cluster.local.	30	IN	A	172.0.0.2"),
+			},
+		},
+		{
+			Qname: "ready.testns.svc.cluster.local.", Qtype: dns.TypeA,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.A("ready.testns.svc.cluster.local.	30	IN	A	172.0.0.1")},
+		},
+		// The address that is not ready is only published by the service that asks for it.
+		{
+			Qname: "2.0.0.172.in-addr.arpa.", Qtype: dns.TypePTR,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{test.PTR("2.0.0.172.in-addr.arpa.	30	IN	PTR	172-0-0-2.notready.testns.svc.cluster.local.")},
+		},
+		{
+			Qname: "1.0.0.172.in-addr.arpa.", Qtype: dns.TypePTR,
+			Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.PTR("1.0.0.172.in-addr.arpa.	30	IN	PTR	172-0-0-1.notready.testns.svc.cluster.local."),
+				test.PTR("1.0.0.172.in-addr.arpa.	30	IN	PTR	172-0-0-1.ready.testns.svc.cluster.local."),
+			},
+		},
+		{
+			Qname: "www.testns.svc.cluster.local.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.A("web.testns.svc.cluster.local.	30	IN	A	10.0.0.5"),
+				test.CNAME("www.testns.svc.cluster.local.	30	IN	CNAME	web.testns.svc.cluster.local."),
+			},
+		},
+		{
+			Qname: "frontend.testns.svc.cluster.local.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.CNAME("frontend.testns.svc.cluster.local.	30	IN	CNAME	web.testns.svc.cluster.local."),
+				test.A("web.testns.svc.cluster.local.	30	IN	A	10.0.0.5"),
+			},
+		},
+		{
+			Qname: "www.hidden.svc.cluster.local.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{test.SOA("cluster.local.	5	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1499347823 7200 1800 86400 5")},
+		},
+	}
+	for i, tc := range tests {
+		w := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := k.ServeDNS(ctx, w, tc.Msg()); err != nil {
+			t.Errorf("Test %d: expected no error, got %v", i, err)
+			continue
+		}
+		if err := test.SortAndCheck(w.Msg, tc); err != nil {
+			t.Errorf("Test %d, %v", i, err)
+		}
+	}
+
+	// The transfer has the same aliases: one CNAME for www, and none for ready that is a Service.
+	k.localIPs = []net.IP{net.ParseIP("10.0.0.10")}
+	ch, err := k.Transfer("cluster.local.", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnames := map[string][]string{}
+	for rrs := range ch {
+		for _, rr := range rrs {
+			if c, ok := rr.(*dns.CNAME); ok {
+				cnames[c.Hdr.Name] = append(cnames[c.Hdr.Name], c.Target)
+			}
+		}
+	}
+	if x := cnames["www.testns.svc.cluster.local."]; len(x) != 1 || x[0] != "web.testns.svc.cluster.local." {
+		t.Errorf("Expected a single CNAME for www to web, got %v", x)
+	}
+	if x := cnames["ready.testns.svc.cluster.local."]; len(x) != 0 {
+		t.Errorf("Expected no CNAME for ready, got %v", x)
+	}
+}
@@ -1,4 +1,24 @@
 package kubernetes
 
 // Ready implements the ready.Readiness interface.
-func (k *Kubernetes) Ready() bool { return k.APIConn.HasSynced() }
+func (k *Kubernetes) Ready() bool {
+	if len(k.clusters) == 0 {
+		return k.APIConn.HasSynced()
+	}
+	if k.ownZones() && !k.APIConn.HasSynced() {
+		return false
+	}
+	return len(k.NotReady()) == 0
+}
+
+// NotReady implements the ready.PartialReadiness interface. It returns the zones of the clusters that have
+// not synced.
+func (k *Kubernetes) NotReady() []string {
+	var zones []string
+	for _, c := range k.clusters {
+		if !clusterReady(c) {
+			zones = append(zones, c.Zones[0])
+		}
+	}
+	return zones
+}
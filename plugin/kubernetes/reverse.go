@@ -14,7 +14,10 @@ import (
 func (k *Kubernetes) Reverse(ctx context.Context, state request.Request, exact bool, opt plugin.Options) ([]msg.Service, error) {
 	ip := dnsutil.ExtractAddressFromReverse(state.Name())
 	if ip == "" {
-		_, e := k.Records(ctx, state, exact)
+		services, e := k.Records(ctx, state, exact)
+		if _, ok := k.dnssdRequest(state); ok {
+			return services, e
+		}
 		return nil, e
 	}
 
@@ -33,8 +36,12 @@ func (k *Kubernetes) serviceRecordForIP(ip, name string) []msg.Service {
 		if len(k.Namespaces) > 0 && !k.namespaceExposed(service.Namespace) {
 			continue
 		}
+		ttl, publish := k.servicePolicy(service)
+		if !publish {
+			continue
+		}
 		domain := strings.Join([]string{service.Name, service.Namespace, Svc, k.primaryZone()}, ".")
-		return []msg.Service{{Host: domain, TTL: k.ttl}}
+		return []msg.Service{{Host: domain, TTL: ttl}}
 	}
 	// If no cluster ips match, search endpoints
 	var svcs []msg.Service
@@ -42,11 +49,15 @@ func (k *Kubernetes) serviceRecordForIP(ip, name string) []msg.Service {
 		if len(k.Namespaces) > 0 && !k.namespaceExposed(ep.Namespace) {
 			continue
 		}
+		ttl, publish := k.endpointsPolicy(ep)
+		if !publish {
+			continue
+		}
 		for _, eps := range ep.Subsets {
-			for _, addr := range eps.Addresses {
+			for _, addr := range k.endpointsAddresses(ep, eps) {
 				if addr.IP == ip {
 					domain := strings.Join([]string{endpointHostname(addr, k.endpointNameMode), ep.Index, Svc, k.primaryZone()}, ".")
-					svcs = append(svcs, msg.Service{Host: domain, TTL: k.ttl})
+					svcs = append(svcs, msg.Service{Host: domain, TTL: ttl})
 				}
 			}
 		}
@@ -21,10 +21,16 @@ func (APIConnReverseTest) Stop() error                                      { re
 func (APIConnReverseTest) PodIndex(string) []*object.Pod                    { return nil }
 func (APIConnReverseTest) EpIndex(string) []*object.Endpoints               { return nil }
 func (APIConnReverseTest) McEpIndex(string) []*object.MultiClusterEndpoints { return nil }
+func (APIConnReverseTest) IngressIndex(string) []*object.Ingress            { return nil }
+func (APIConnReverseTest) GatewayIndex(string) []*object.Gateway            { return nil }
+func (APIConnReverseTest) GatewayHostIndex(string) []*object.Gateway        { return nil }
+func (APIConnReverseTest) HTTPRouteIndex(string) []*object.HTTPRoute        { return nil }
+func (APIConnReverseTest) NodeZone(string) string                           { return "" }
 func (APIConnReverseTest) EndpointsList() []*object.Endpoints               { return nil }
 func (APIConnReverseTest) ServiceList() []*object.Service                   { return nil }
 func (APIConnReverseTest) ServiceImportList() []*object.ServiceImport       { return nil }
 func (APIConnReverseTest) SvcImportIndex(string) []*object.ServiceImport    { return nil }
+func (APIConnReverseTest) SvcAliasIndex(string) []*object.Service           { return nil }
 func (APIConnReverseTest) SvcExtIndexReverse(string) []*object.Service      { return nil }
 func (APIConnReverseTest) Modified(ModifiedMode) int64                      { return 0 }
 
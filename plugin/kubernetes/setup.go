@@ -52,12 +52,18 @@ func setup(c *caddy.Controller) error {
 
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		k.Next = next
+		for _, cl := range k.clusters {
+			cl.Next = next
+		}
 		return k
 	})
 
 	// get locally bound addresses
 	c.OnStartup(func() error {
 		k.localIPs = boundIPs(c)
+		for _, cl := range k.clusters {
+			cl.localIPs = k.localIPs
+		}
 		return nil
 	})
 
@@ -113,6 +119,12 @@ func ParseStanza(c *caddy.Controller) (*Kubernetes, error) {
 
 	k8s.Upstream = upstream.New()
 
+	type clusterConfig struct {
+		zone   string
+		config clientcmd.ClientConfig
+	}
+	var clusters []clusterConfig
+
 	k8s.startupTimeout = time.Second * 5
 	for c.NextBlock() {
 		switch c.Val() {
@@ -231,8 +243,38 @@ func ParseStanza(c *caddy.Controller) (*Kubernetes, error) {
 				overrides,
 			)
 			k8s.ClientConfig = config
+		case "cluster":
+			args := c.RemainingArgs()
+			if len(args) != 2 && len(args) != 3 {
+				return nil, c.ArgErr()
+			}
+			overrides := &clientcmd.ConfigOverrides{}
+			if len(args) == 3 {
+				overrides.CurrentContext = args[2]
+			}
+			config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+				&clientcmd.ClientConfigLoadingRules{ExplicitPath: args[1]},
+				overrides,
+			)
+			zones := plugin.Host(args[0]).NormalizeExact()
+			if len(zones) != 1 {
+				return nil, c.Errf("invalid cluster zone %q", args[0])
+			}
+			clusters = append(clusters, clusterConfig{zone: zones[0], config: config})
 		case "multicluster":
 			k8s.opts.multiclusterZones = plugin.OriginsFromArgsOrServerBlock(c.RemainingArgs(), []string{})
+		case "topology_aware":
+			if len(c.RemainingArgs()) != 0 {
+				return nil, c.ArgErr()
+			}
+			k8s.opts.topologyAware = true
+		case "dnssd":
+			k8s.opts.dnssd = true
+			k8s.opts.dnssdAnnotations = c.RemainingArgs()
+		case "ingress":
+			k8s.opts.ingressZones = plugin.OriginsFromArgsOrServerBlock(c.RemainingArgs(), k8s.Zones)
+		case "gateway":
+			k8s.opts.gatewayZones = plugin.OriginsFromArgsOrServerBlock(c.RemainingArgs(), k8s.Zones)
 		case "startup_timeout":
 			args := c.RemainingArgs()
 			if len(args) == 0 {
@@ -299,6 +341,32 @@ func ParseStanza(c *caddy.Controller) (*Kubernetes, error) {
 		}
 	}
 
+	for _, zone := range append(slices.Clone(k8s.opts.ingressZones), k8s.opts.gatewayZones...) {
+		if !slices.Contains(k8s.Zones, zone) {
+			return nil, c.Errf("is not authoritative for the hostname zone %s", zone)
+		}
+		if plugin.Zones(k8s.opts.multiclusterZones).Matches(zone) != "" {
+			return nil, c.Errf("hostname zone %s can not be a multicluster zone", zone)
+		}
+	}
+
+	for i, cl := range clusters {
+		if !slices.Contains(k8s.Zones, cl.zone) {
+			return nil, c.Errf("is not authoritative for the cluster zone %s", cl.zone)
+		}
+		if dnsutil.IsReverse(cl.zone) > 0 {
+			return nil, c.Errf("cluster zone %s can not be a reverse zone", cl.zone)
+		}
+		for _, prev := range clusters[:i] {
+			if prev.zone == cl.zone {
+				return nil, c.Errf("cluster zone %s is used more than once", cl.zone)
+			}
+		}
+	}
+	for _, cl := range clusters {
+		k8s.clusters = append(k8s.clusters, k8s.newCluster(cl.zone, cl.config))
+	}
+
 	return k8s, nil
 }
 
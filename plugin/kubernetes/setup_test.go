@@ -661,6 +661,113 @@ func TestKubernetesParseIgnoreEmptyService(t *testing.T) {
 	}
 }
 
+func TestKubernetesParseTopologyAware(t *testing.T) {
+	tests := []struct {
+		input                 string // Corefile data as string
+		shouldErr             bool   // true if test case is expected to produce an error.
+		expectedErrContent    string // substring from the expected error. Empty for positive cases.
+		expectedTopologyAware bool
+	}{
+		// valid
+		{
+			`kubernetes coredns.local {
+	topology_aware
+}`,
+			false,
+			"",
+			true,
+		},
+		// invalid
+		{
+			`kubernetes coredns.local {
+	topology_aware zone-a
+}`,
+			true,
+			"Wrong argument count",
+			false,
+		},
+		// not set
+		{
+			`kubernetes coredns.local {
+}`,
+			false,
+			"",
+			false,
+		},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		k8sController, err := kubernetesParse(c)
+
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %d: Expected error, but did not find error for input '%s'. Error was: '%v'", i, test.input, err)
+		}
+
+		if err != nil {
+			if !test.shouldErr {
+				t.Errorf("Test %d: Expected no error but found one for input %s. Error was: %v", i, test.input, err)
+				continue
+			}
+
+			if !strings.Contains(err.Error(), test.expectedErrContent) {
+				t.Errorf("Test %d: Expected error to contain: %v, found error: %v, input: %s", i, test.expectedErrContent, err, test.input)
+			}
+			continue
+		}
+
+		if k8sController.opts.topologyAware != test.expectedTopologyAware {
+			t.Errorf("Test %d: Expected topology_aware %t, found %t for input '%s'", i, test.expectedTopologyAware, k8sController.opts.topologyAware, test.input)
+		}
+	}
+}
+
+func TestKubernetesParseDNSSD(t *testing.T) {
+	tests := []struct {
+		input               string // Corefile data as string
+		expectedDNSSD       bool
+		expectedAnnotations []string
+	}{
+		{
+			`kubernetes coredns.local {
+	dnssd
+}`,
+			true,
+			nil,
+		},
+		{
+			`kubernetes coredns.local {
+	dnssd example.com/path example.com/version
+}`,
+			true,
+			[]string{"example.com/path", "example.com/version"},
+		},
+		// not set
+		{
+			`kubernetes coredns.local {
+}`,
+			false,
+			nil,
+		},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		k8sController, err := kubernetesParse(c)
+		if err != nil {
+			t.Errorf("Test %d: Expected no error but found one for input %s. Error was: %v", i, test.input, err)
+			continue
+		}
+
+		if k8sController.opts.dnssd != test.expectedDNSSD {
+			t.Errorf("Test %d: Expected dnssd %t, found %t for input '%s'", i, test.expectedDNSSD, k8sController.opts.dnssd, test.input)
+		}
+		if !slices.Equal(k8sController.opts.dnssdAnnotations, test.expectedAnnotations) {
+			t.Errorf("Test %d: Expected dnssd annotations %v, found %v for input '%s'", i, test.expectedAnnotations, k8sController.opts.dnssdAnnotations, test.input)
+		}
+	}
+}
+
 func TestKubernetesParseMulticluster(t *testing.T) {
 	tests := []struct {
 		input                     string // Corefile data as string
@@ -732,6 +839,171 @@ func TestKubernetesParseMulticluster(t *testing.T) {
 	}
 }
 
+func TestKubernetesParseHostnames(t *testing.T) {
+	tests := []struct {
+		input                string // Corefile data as string
+		shouldErr            bool   // true if test case is expected to produce an error.
+		expectedErrContent   string // substring from the expected error. Empty for positive cases.
+		expectedIngressZones []string
+		expectedGatewayZones []string
+	}{
+		// valid
+		{
+			`kubernetes coredns.local example.org {
+	ingress example.org
+	gateway example.org
+}`,
+			false,
+			"",
+			[]string{"example.org."},
+			[]string{"example.org."},
+		},
+		{
+			`kubernetes coredns.local example.org {
+	ingress
+}`,
+			false,
+			"",
+			[]string{"coredns.local.", "example.org."},
+			nil,
+		},
+		// invalid
+		{
+			`kubernetes coredns.local {
+	ingress example.org
+}`,
+			true,
+			"Error during parsing: is not authoritative for the hostname zone example.org.",
+			nil,
+			nil,
+		},
+		{
+			`kubernetes coredns.local clusterset.local {
+	multicluster clusterset.local
+	gateway clusterset.local
+}`,
+			true,
+			"Error during parsing: hostname zone clusterset.local. can not be a multicluster zone",
+			nil,
+			nil,
+		},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		k8sController, err := kubernetesParse(c)
+
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %d: Expected error, but did not find error for input '%s'. Error was: '%v'", i, test.input, err)
+		}
+
+		if err != nil {
+			if !test.shouldErr {
+				t.Errorf("Test %d: Expected no error but found one for input %s. Error was: %v", i, test.input, err)
+				continue
+			}
+
+			if !strings.Contains(err.Error(), test.expectedErrContent) {
+				t.Errorf("Test %d: Expected error to contain: %v, found error: %v, input: %s", i, test.expectedErrContent, err, test.input)
+			}
+			continue
+		}
+
+		if !slices.Equal(k8sController.opts.ingressZones, test.expectedIngressZones) {
+			t.Errorf("Test %d: Expected ingress zones '%v', found '%v' for input '%s'", i, test.expectedIngressZones, k8sController.opts.ingressZones, test.input)
+		}
+		if !slices.Equal(k8sController.opts.gatewayZones, test.expectedGatewayZones) {
+			t.Errorf("Test %d: Expected gateway zones '%v', found '%v' for input '%s'", i, test.expectedGatewayZones, k8sController.opts.gatewayZones, test.input)
+		}
+	}
+}
+
+func TestKubernetesParseClusters(t *testing.T) {
+	tests := []struct {
+		input              string // Corefile data as string
+		shouldErr          bool   // true if test case is expected to produce an error.
+		expectedErrContent string // substring from the expected error. Empty for positive cases.
+		expectedClusters   []string
+	}{
+		// valid
+		{
+			`kubernetes cluster-a.local cluster-b.local {
+	cluster cluster-a.local /etc/kubeconfig context-a
+	cluster cluster-b.local /etc/kubeconfig
+}`,
+			false,
+			"",
+			[]string{"cluster-a.local.", "cluster-b.local."},
+		},
+		{
+			`kubernetes cluster.local cluster-a.local 10.0.0.0/24 {
+	cluster cluster-a.local /etc/kubeconfig context-a
+}`,
+			false,
+			"",
+			[]string{"cluster-a.local."},
+		},
+		// invalid
+		{
+			`kubernetes cluster-a.local {
+	cluster cluster-a.local
+}`,
+			true,
+			"Wrong argument count",
+			nil,
+		},
+		{
+			`kubernetes cluster-a.local {
+	cluster cluster-b.local /etc/kubeconfig
+}`,
+			true,
+			"is not authoritative for the cluster zone cluster-b.local.",
+			nil,
+		},
+		{
+			`kubernetes cluster-a.local {
+	cluster cluster-a.local /etc/kubeconfig context-a
+	cluster cluster-a.local /etc/kubeconfig context-b
+}`,
+			true,
+			"cluster zone cluster-a.local. is used more than once",
+			nil,
+		},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		k8sController, err := kubernetesParse(c)
+
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %d: Expected error, but did not find error for input '%s'. Error was: '%v'", i, test.input, err)
+		}
+
+		if err != nil {
+			if !test.shouldErr {
+				t.Errorf("Test %d: Expected no error but found one for input %s. Error was: %v", i, test.input, err)
+				continue
+			}
+
+			if !strings.Contains(err.Error(), test.expectedErrContent) {
+				t.Errorf("Test %d: Expected error to contain: %v, found error: %v, input: %s", i, test.expectedErrContent, err, test.input)
+			}
+			continue
+		}
+
+		var clusters []string
+		for _, cl := range k8sController.clusters {
+			clusters = append(clusters, cl.Zones...)
+			if cl.ClientConfig == nil {
+				t.Errorf("Test %d: Expected cluster %s to have a client config", i, cl.Zones[0])
+			}
+		}
+		if !slices.Equal(clusters, test.expectedClusters) {
+			t.Errorf("Test %d: Expected clusters '%v', found '%v' for input '%s'", i, test.expectedClusters, clusters, test.input)
+		}
+	}
+}
+
 func TestKubernetesParseAPIRateLimiting(t *testing.T) {
 	tests := []struct {
 		input              string
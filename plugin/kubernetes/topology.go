@@ -0,0 +1,51 @@
+package kubernetes
+
+import (
+	"slices"
+
+	"github.com/coredns/coredns/plugin/kubernetes/object"
+	"github.com/coredns/coredns/request"
+)
+
+// clientZone returns the zone of the node of the pod that sent the query in state, or the empty string if
+// answers are not topology aware or the zone is not known.
+func (k *Kubernetes) clientZone(state request.Request) string {
+	if !k.opts.topologyAware {
+		return ""
+	}
+	ip := state.IP()
+	for _, p := range k.APIConn.PodIndex(ip) {
+		if p.PodIP != ip || p.NodeName == "" {
+			continue
+		}
+		return k.APIConn.NodeZone(p.NodeName)
+	}
+	return ""
+}
+
+// endpointForZone returns true if addr should be used by clients in zone. The hints of the EndpointSlice are
+// used when they are set, otherwise the zone of the endpoint itself.
+func endpointForZone(addr object.EndpointAddress, zone string) bool {
+	if len(addr.ForZones) > 0 {
+		return slices.Contains(addr.ForZones, zone)
+	}
+	return addr.Zone == zone
+}
+
+// hasZoneEndpoints returns true if svc has an endpoint for zone in eps.
+func hasZoneEndpoints(eps []*object.Endpoints, svc *object.Service, zone string) bool {
+	key := object.EndpointsKey(svc.Name, svc.Namespace)
+	for _, ep := range eps {
+		if ep.Index != key {
+			continue
+		}
+		for _, sub := range ep.Subsets {
+			for _, addr := range serviceAddresses(svc, sub) {
+				if endpointForZone(addr, zone) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
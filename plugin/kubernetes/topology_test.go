@@ -0,0 +1,188 @@
+package kubernetes
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/cache"
+	"github.com/coredns/coredns/plugin/kubernetes/object"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	api "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTopologyAware(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	dco := dnsControlOpts{
+		zones:              []string{"cluster.local."},
+		initEndpointsCache: true,
+		initPodCache:       true,
+		topologyAware:      true,
+	}
+	controller := newdnsController(ctx, client, nil, nil, dco)
+
+	client.CoreV1().Namespaces().Create(ctx, &api.Namespace{ObjectMeta: meta.ObjectMeta{Name: "testns"}}, meta.CreateOptions{})
+	for node, zone := range map[string]string{"node-a": "zone-a", "node-b": "zone-b", "node-c": "zone-c"} {
+		client.CoreV1().Nodes().Create(ctx, &api.Node{ObjectMeta: meta.ObjectMeta{Name: node, Labels: map[string]string{api.LabelTopologyZone: zone}}}, meta.CreateOptions{})
+	}
+	for ip, node := range map[string]string{"10.240.0.1": "node-a", "10.240.0.2": "node-b", "10.240.0.3": "node-c", "10.240.0.4": ""} {
+		client.CoreV1().Pods("testns").Create(ctx, &api.Pod{
+			ObjectMeta: meta.ObjectMeta{Name: "client-" + ip, Namespace: "testns"},
+			Spec:       api.PodSpec{NodeName: node},
+			Status:     api.PodStatus{PodIP: ip, Phase: api.PodRunning},
+		}, meta.CreateOptions{})
+	}
+
+	for _, name := range []string{"hints", "zones"} {
+		client.CoreV1().Services("testns").Create(ctx, &api.Service{
+			ObjectMeta: meta.ObjectMeta{Name: name, Namespace: "testns"},
+			Spec:       api.ServiceSpec{ClusterIP: api.ClusterIPNone},
+		}, meta.CreateOptions{})
+	}
+	zoneA, zoneB := "zone-a", "zone-b"
+	endpointSlices := []*discovery.EndpointSlice{
+		// Endpoints with hints, zone-b has no endpoint of its own but gets one assigned.
+		{
+			ObjectMeta: meta.ObjectMeta{Name: "hints-1", Namespace: "testns", Labels: map[string]string{discovery.LabelServiceName: "hints"}},
+			Endpoints: []discovery.Endpoint{
+				{Addresses: []string{"172.0.0.1"}, Zone: &zoneA, Hints: &discovery.EndpointHints{ForZones: []discovery.ForZone{{Name: "zone-a"}}}},
+				{Addresses: []string{"172.0.0.2"}, Zone: &zoneA, Hints: &discovery.EndpointHints{ForZones: []discovery.ForZone{{Name: "zone-b"}}}},
+			},
+		},
+		// Endpoints without hints, only with their zone.
+		{
+			ObjectMeta: meta.ObjectMeta{Name: "zones-1", Namespace: "testns", Labels: map[string]string{discovery.LabelServiceName: "zones"}},
+			Endpoints: []discovery.Endpoint{
+				{Addresses: []string{"172.0.1.1"}, Zone: &zoneA},
+				{Addresses: []string{"172.0.1.2"}, Zone: &zoneB},
+			},
+		},
+	}
+	for _, s := range endpointSlices {
+		client.DiscoveryV1().EndpointSlices("testns").Create(ctx, s, meta.CreateOptions{})
+	}
+
+	k := New([]string{"cluster.local."})
+	k.APIConn = controller
+	k.opts = dco
+	k.Next = test.NextHandler(dns.RcodeSuccess, nil)
+
+	go k.APIConn.Run()
+	defer k.APIConn.Stop()
+	for !k.APIConn.HasSynced() {
+		time.Sleep(time.Millisecond)
+	}
+	for range 100 {
+		if len(controller.EpIndex("zones.testns")) > 0 && len(controller.PodIndex("10.240.0.4")) > 0 && controller.NodeZone("node-c") != "" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []struct {
+		client string
+		qname  string
+		answer []string
+	}{
+		{"10.240.0.1", "hints.testns.svc.cluster.local.", []string{"172.0.0.1"}},
+		{"10.240.0.2", "hints.testns.svc.cluster.local.", []string{"172.0.0.2"}},
+		{"10.240.0.1", "zones.testns.svc.cluster.local.", []string{"172.0.1.1"}},
+		{"10.240.0.2", "zones.testns.svc.cluster.local.", []string{"172.0.1.2"}},
+		// No endpoint for zone-c, all endpoints are returned.
+		{"10.240.0.3", "zones.testns.svc.cluster.local.", []string{"172.0.1.1", "172.0.1.2"}},
+		// Pod that is not scheduled, and a client that is not a pod.
+		{"10.240.0.4", "zones.testns.svc.cluster.local.", []string{"172.0.1.1", "172.0.1.2"}},
+		{"10.0.0.1", "zones.testns.svc.cluster.local.", []string{"172.0.1.1", "172.0.1.2"}},
+	}
+	for i, tc := range tests {
+		if answer := topologyQuery(t, k, tc.client, tc.qname); !sameAddresses(answer, tc.answer) {
+			t.Errorf("Test %d: expected %v for client %s, got %v", i, tc.answer, tc.client, answer)
+		}
+	}
+
+	// With cache in front, the answer for the first client is given to the clients in other zones. Disabling the
+	// success cache for the zone prevents that, see TestDisableClientAnswers in the cache plugin.
+	c := cache.NewCache("cluster.local.", "")
+	c.Next = k
+	topologyQuery(t, c, "10.240.0.1", "zones.testns.svc.cluster.local.")
+	if answer := topologyQuery(t, c, "10.240.0.2", "zones.testns.svc.cluster.local."); !sameAddresses(answer, []string{"172.0.1.1"}) {
+		t.Errorf("Expected the cached answer for zone-a for the client in zone-b, got %v", answer)
+	}
+}
+
+// topologyQuery sends an A query for qname from client to h, and returns the addresses in the answer.
+func topologyQuery(t *testing.T, h plugin.Handler, client, qname string) []string {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(qname, dns.TypeA)
+	w := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: client})
+	if _, err := h.ServeDNS(context.Background(), w, m); err != nil {
+		t.Errorf("Expected no error for client %s, got %v", client, err)
+		return nil
+	}
+	var answer []string
+	for _, rr := range w.Msg.Answer {
+		answer = append(answer, rr.(*dns.A).A.String())
+	}
+	return answer
+}
+
+func sameAddresses(a, b []string) bool {
+	return len(a) == len(b) && !slices.ContainsFunc(b, func(x string) bool { return !slices.Contains(a, x) })
+}
+
+func TestEndpointForZone(t *testing.T) {
+	tests := []struct {
+		zone     string
+		forZones []string
+		expected bool
+	}{
+		{"zone-a", nil, true},
+		{"zone-b", nil, false},
+		{"", nil, false},
+		// Hints take precedence over the zone of the endpoint.
+		{"zone-a", []string{"zone-b"}, false},
+		{"zone-b", []string{"zone-a"}, true},
+		{"zone-b", []string{"zone-b", "zone-a"}, true},
+	}
+	for i, tc := range tests {
+		addr := object.EndpointAddress{IP: "172.0.0.1", Zone: tc.zone, ForZones: tc.forZones}
+		if got := endpointForZone(addr, "zone-a"); got != tc.expected {
+			t.Errorf("Test %d: expected %t for zone %q and hints %v, got %t", i, tc.expected, tc.zone, tc.forZones, got)
+		}
+	}
+}
+
+func TestHasZoneEndpoints(t *testing.T) {
+	eps := []*object.Endpoints{{
+		Index: object.EndpointsKey("svc", "testns"),
+		Subsets: []object.EndpointSubset{{
+			Addresses:         []object.EndpointAddress{{IP: "172.0.0.1", Zone: "zone-a"}},
+			NotReadyAddresses: []object.EndpointAddress{{IP: "172.0.0.2", Zone: "zone-b"}},
+		}},
+	}}
+	tests := []struct {
+		publishNotReady bool
+		zone            string
+		expected        bool
+	}{
+		{false, "zone-a", true},
+		{false, "zone-b", false},
+		{true, "zone-b", true},
+	}
+	for i, tc := range tests {
+		svc := &object.Service{Name: "svc", Namespace: "testns", PublishNotReady: tc.publishNotReady}
+		if got := hasZoneEndpoints(eps, svc, tc.zone); got != tc.expected {
+			t.Errorf("Test %d: expected %t for zone %q, got %t", i, tc.expected, tc.zone, got)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/etcd/msg"
+	"github.com/coredns/coredns/plugin/kubernetes/object"
 	"github.com/coredns/coredns/plugin/transfer"
 	"github.com/coredns/coredns/request"
 
@@ -18,6 +19,9 @@ import (
 
 // Transfer implements the transfer.Transfer interface.
 func (k *Kubernetes) Transfer(zone string, serial uint32) (<-chan []dns.RR, error) {
+	if c := k.cluster(zone); c != nil {
+		return c.Transfer(zone, serial)
+	}
 	match := plugin.Zones(k.Zones).Matches(zone)
 	if match == "" {
 		return nil, transfer.ErrNotAuthoritative
@@ -68,18 +72,34 @@ func (k *Kubernetes) transferServices(ch chan []dns.RR, zonePath string) {
 		return serviceList[i].Name < serviceList[j].Name
 	})
 
+	// The aliases that are in the transfer, a Service with the same name, or with a lower name that claims
+	// the same alias, takes precedence; see findAliases.
+	aliases := make(map[string]struct{})
 	for _, svc := range serviceList {
 		if !k.namespaceExposed(svc.Namespace) {
 			continue
 		}
+		ttl, publish := k.servicePolicy(svc)
+		if !publish {
+			continue
+		}
 		svcBase := []string{zonePath, Svc, svc.Namespace, svc.Name}
+		for _, alias := range svc.Aliases {
+			key := object.ServiceKey(alias, svc.Namespace)
+			if _, ok := aliases[key]; ok || len(k.APIConn.SvcIndex(key)) > 0 {
+				continue
+			}
+			aliases[key] = struct{}{}
+			s := msg.Service{Key: strings.Join([]string{zonePath, Svc, svc.Namespace, alias}, "/"), TTL: ttl}
+			ch <- []dns.RR{s.NewCNAME(msg.Domain(s.Key), msg.Domain(strings.Join(svcBase, "/")))}
+		}
 		switch svc.Type {
 		case api.ServiceTypeClusterIP, api.ServiceTypeNodePort, api.ServiceTypeLoadBalancer:
 			clusterIP := net.ParseIP(svc.ClusterIPs[0])
 			if clusterIP != nil {
 				var host string
 				for _, ip := range svc.ClusterIPs {
-					s := msg.Service{Host: ip, TTL: k.ttl}
+					s := msg.Service{Host: ip, TTL: ttl}
 					s.Key = strings.Join(svcBase, "/")
 
 					// Change host from IP to Name for SRV records
@@ -87,7 +107,7 @@ func (k *Kubernetes) transferServices(ch chan []dns.RR, zonePath string) {
 				}
 
 				for _, p := range svc.Ports {
-					s := msg.Service{Host: host, Port: int(p.Port), TTL: k.ttl}
+					s := msg.Service{Host: host, Port: int(p.Port), TTL: ttl}
 					s.Key = strings.Join(svcBase, "/")
 
 					// Need to generate this to handle use cases for peer-finder
@@ -113,9 +133,10 @@ func (k *Kubernetes) transferServices(ch chan []dns.RR, zonePath string) {
 
 			for _, ep := range endpointsList {
 				for _, eps := range ep.Subsets {
-					srvWeight := calcSRVWeight(len(eps.Addresses))
-					for _, addr := range eps.Addresses {
-						s := msg.Service{Host: addr.IP, TTL: k.ttl}
+					addrs := serviceAddresses(svc, eps)
+					srvWeight := calcSRVWeight(len(addrs))
+					for _, addr := range addrs {
+						s := msg.Service{Host: addr.IP, TTL: ttl}
 						s.Key = strings.Join(svcBase, "/")
 						// We don't need to change the msg.Service host from IP to Name yet
 						// so disregard the return value here
@@ -144,7 +165,7 @@ func (k *Kubernetes) transferServices(ch chan []dns.RR, zonePath string) {
 
 		case api.ServiceTypeExternalName:
 
-			s := msg.Service{Key: strings.Join(svcBase, "/"), Host: svc.ExternalName, TTL: k.ttl}
+			s := msg.Service{Key: strings.Join(svcBase, "/"), Host: svc.ExternalName, TTL: ttl}
 			if t, _ := s.HostType(); t == dns.TypeCNAME {
 				ch <- []dns.RR{s.NewCNAME(msg.Domain(s.Key), s.Host)}
 			}
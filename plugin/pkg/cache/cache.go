@@ -1,6 +1,8 @@
 // Package cache implements a cache. The cache hold 256 shards, each shard
 // holds a cache: a map with a mutex. There is no fancy expunge algorithm, it
-// just randomly evicts elements when it gets full.
+// just randomly evicts elements when it gets full. The cache can be limited
+// in the number of elements, and in the number of bytes they use, see Sizer.
+// An AdmitFunc can keep new elements from evicting more valuable ones.
 package cache
 
 import (
@@ -15,6 +17,16 @@ func Hash(what []byte) uint64 {
 	return h.Sum64()
 }
 
+// Sizer is implemented by elements that know how many bytes they use. In a cache
+// with a byte limit, elements that don't implement it have a size of 0.
+type Sizer interface {
+	Size() int
+}
+
+// AdmitFunc decides if the element el, to be added under key, may evict the element
+// victim, stored under victimKey. If it returns false, el is not added.
+type AdmitFunc func(key uint64, el any, victimKey uint64, victim any) bool
+
 // Cache is cache.
 type Cache struct {
 	shards [shardSize]*shard
@@ -22,14 +34,24 @@ type Cache struct {
 
 // shard is a cache with random eviction.
 type shard struct {
-	items map[uint64]any
-	size  int
+	items    map[uint64]any
+	size     int
+	bytes    int // bytes used by the items, only tracked when maxBytes > 0
+	maxBytes int
+	admit    AdmitFunc
 
 	sync.RWMutex
 }
 
 // New returns a new cache.
 func New(size int) *Cache {
+	return NewBytes(size, 0)
+}
+
+// NewBytes returns a new cache that holds at most size elements, that use at most maxBytes bytes
+// together. A maxBytes of 0 means no byte limit. The limits are spread evenly over the shards, so
+// an element bigger than maxBytes/256 is never added.
+func NewBytes(size, maxBytes int) *Cache {
 	ssize := max(size/shardSize, 4)
 
 	c := &Cache{}
@@ -37,15 +59,33 @@ func New(size int) *Cache {
 	// Initialize all the shards
 	for i := range shardSize {
 		c.shards[i] = newShard(ssize)
+		if maxBytes > 0 {
+			c.shards[i].maxBytes = max(maxBytes/shardSize, 1)
+		}
 	}
 	return c
 }
 
+// SetAdmit sets the admission policy, that is consulted for every element that has to be
+// evicted to make room for a new one. It must be called before the cache is used.
+func (c *Cache) SetAdmit(f AdmitFunc) {
+	for _, s := range &c.shards {
+		s.admit = f
+	}
+}
+
 // Add adds a new element to the cache. If the element already exists it is overwritten.
 // Returns true if an existing element was evicted to make room for this element.
 func (c *Cache) Add(key uint64, el any) bool {
+	_, evicted := c.Put(key, el)
+	return evicted > 0
+}
+
+// Put adds a new element to the cache, like Add. It returns if the element was added, and
+// the number of elements that were evicted to make room for it.
+func (c *Cache) Put(key uint64, el any) (bool, int) {
 	shard := key & (shardSize - 1)
-	return c.shards[shard].Add(key, el)
+	return c.shards[shard].Put(key, el)
 }
 
 // Get looks up element index under key.
@@ -69,6 +109,18 @@ func (c *Cache) Len() int {
 	return l
 }
 
+// Bytes returns the number of bytes used by the elements in the cache. It is 0 for a cache
+// without a byte limit.
+func (c *Cache) Bytes() int {
+	b := 0
+	for _, s := range &c.shards {
+		s.RLock()
+		b += s.bytes
+		s.RUnlock()
+	}
+	return b
+}
+
 // Walk walks each shard in the cache.
 func (c *Cache) Walk(f func(map[uint64]any, uint64) bool) {
 	for _, s := range &c.shards {
@@ -82,26 +134,74 @@ func newShard(size int) *shard { return &shard{items: make(map[uint64]any), size
 // Add adds element indexed by key into the cache. Any existing element is overwritten
 // Returns true if an existing element was evicted to make room for this element.
 func (s *shard) Add(key uint64, el any) bool {
-	eviction := false
+	_, evicted := s.Put(key, el)
+	return evicted > 0
+}
+
+// Put adds element indexed by key into the cache. Any existing element is overwritten.
+// Random elements are evicted until el fits, unless the admission policy rejects one of
+// them. It returns if el was added and the number of evicted elements.
+func (s *shard) Put(key uint64, el any) (bool, int) {
+	size := s.sizeOf(el)
+	if s.maxBytes > 0 && size > s.maxBytes {
+		return false, 0
+	}
+
 	s.Lock()
-	if len(s.items) >= s.size {
-		if _, ok := s.items[key]; !ok {
-			for k := range s.items {
-				delete(s.items, k)
-				eviction = true
-				break
-			}
+	defer s.Unlock()
+
+	n, bytes := len(s.items), s.bytes+size
+	if old, ok := s.items[key]; ok {
+		n--
+		bytes -= s.sizeOf(old)
+	}
+	var victims []uint64
+	for k, v := range s.items {
+		if n < s.size && (s.maxBytes == 0 || bytes <= s.maxBytes) {
+			break
 		}
+		if k == key {
+			continue
+		}
+		if s.admit != nil && !s.admit(key, el, k, v) {
+			return false, 0
+		}
+		victims = append(victims, k)
+		n--
+		bytes -= s.sizeOf(v)
 	}
+	for _, k := range victims {
+		s.delete(k)
+	}
+	s.delete(key)
 	s.items[key] = el
-	s.Unlock()
-	return eviction
+	s.bytes += size
+	return true, len(victims)
+}
+
+// sizeOf returns the size of el, it is 0 if the shard has no byte limit.
+func (s *shard) sizeOf(el any) int {
+	if s.maxBytes == 0 {
+		return 0
+	}
+	if sz, ok := el.(Sizer); ok {
+		return sz.Size()
+	}
+	return 0
+}
+
+// delete deletes the element indexed by key, the shard's lock must be held.
+func (s *shard) delete(key uint64) {
+	if el, ok := s.items[key]; ok {
+		s.bytes -= s.sizeOf(el)
+		delete(s.items, key)
+	}
 }
 
 // Remove removes the element indexed by key from the cache.
 func (s *shard) Remove(key uint64) {
 	s.Lock()
-	delete(s.items, key)
+	s.delete(key)
 	s.Unlock()
 }
 
@@ -109,7 +209,7 @@ func (s *shard) Remove(key uint64) {
 func (s *shard) Evict() {
 	s.Lock()
 	for k := range s.items {
-		delete(s.items, k)
+		s.delete(k)
 		break
 	}
 	s.Unlock()
@@ -143,7 +243,15 @@ func (s *shard) Walk(f func(map[uint64]any, uint64) bool) {
 	s.RUnlock()
 	for _, k := range items {
 		s.Lock()
+		el, found := s.items[k]
 		ok := f(s.items, k)
+		if found && s.maxBytes > 0 {
+			// f may have deleted or replaced the element.
+			s.bytes -= s.sizeOf(el)
+			if el, found := s.items[k]; found {
+				s.bytes += s.sizeOf(el)
+			}
+		}
 		s.Unlock()
 		if !ok {
 			return
@@ -158,6 +266,7 @@ func (c *Cache) Clear() {
 	for _, s := range &c.shards {
 		s.Lock()
 		clear(s.items)
+		s.bytes = 0
 		s.Unlock()
 	}
 }
@@ -83,3 +83,73 @@ func BenchmarkCache(b *testing.B) {
 		c.Get(1)
 	}
 }
+
+type sized int
+
+func (s sized) Size() int { return int(s) }
+
+func TestCacheBytes(t *testing.T) {
+	c := NewBytes(shardSize*100, shardSize*100)
+
+	// All in shard 0, that holds 100 bytes.
+	for i := range 4 {
+		if added, _ := c.Put(uint64(i*shardSize), sized(20)); !added {
+			t.Fatalf("Expected element %d to be added", i)
+		}
+	}
+	if b := c.Bytes(); b != 80 {
+		t.Fatalf("Expected 80 bytes, got %d", b)
+	}
+
+	// Needs two elements evicted.
+	added, evicted := c.Put(uint64(4*shardSize), sized(50))
+	if !added || evicted != 2 {
+		t.Fatalf("Expected element to be added with 2 evictions, got %t and %d", added, evicted)
+	}
+	if b := c.Bytes(); b != 90 {
+		t.Fatalf("Expected 90 bytes, got %d", b)
+	}
+
+	// Overwriting only accounts the difference.
+	if _, evicted := c.Put(uint64(4*shardSize), sized(60)); evicted != 0 {
+		t.Fatalf("Expected no evictions when overwriting, got %d", evicted)
+	}
+	if b := c.Bytes(); b != 100 {
+		t.Fatalf("Expected 100 bytes, got %d", b)
+	}
+
+	if added, _ := c.Put(uint64(5*shardSize), sized(101)); added {
+		t.Fatal("Expected element bigger than the shard not to be added")
+	}
+
+	c.Walk(func(items map[uint64]any, key uint64) bool {
+		delete(items, key)
+		return true
+	})
+	if b := c.Bytes(); b != 0 {
+		t.Fatalf("Expected 0 bytes after deleting all elements, got %d", b)
+	}
+}
+
+func TestCacheAdmit(t *testing.T) {
+	c := New(shardSize * 4)
+	// Only admit elements that are bigger than the victim.
+	c.SetAdmit(func(_ uint64, el any, _ uint64, victim any) bool { return el.(int) > victim.(int) })
+	for i := range 4 {
+		c.Put(uint64(i*shardSize), 10)
+	}
+
+	if added, _ := c.Put(uint64(4*shardSize), 5); added {
+		t.Error("Expected element not to be admitted")
+	}
+	if l := c.Len(); l != 4 {
+		t.Errorf("Expected no evictions when not admitted, got %d elements", l)
+	}
+	if added, evicted := c.Put(uint64(4*shardSize), 20); !added || evicted != 1 {
+		t.Errorf("Expected element to be admitted with 1 eviction, got %t and %d", added, evicted)
+	}
+	// Overwriting needs no room.
+	if added, _ := c.Put(uint64(4*shardSize), 1); !added {
+		t.Error("Expected existing element to be overwritten")
+	}
+}
@@ -68,6 +68,16 @@ func TestHostPortOrFile(t *testing.T) {
 			"",
 			true,
 		},
+		{
+			"https://8.8.8.8",
+			"https://8.8.8.8:443",
+			false,
+		},
+		{
+			"https3://8.8.8.8",
+			"https3://8.8.8.8:443",
+			false,
+		},
 	}
 
 	err := os.WriteFile("resolv.conf", []byte("nameserver 127.0.0.1\n"), 0600)
@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/log"
+)
+
+// BreakerConfig configures the circuit breaker of a proxy.
+type BreakerConfig struct {
+	// ErrorRate opens the circuit when the fraction of failed queries in the window is at least this.
+	ErrorRate float64
+	// Latency opens the circuit when the p99 latency in the window is above it, zero disables this.
+	Latency time.Duration
+	// Window is the duration of the sliding window in which queries are counted.
+	Window time.Duration
+	// MinRequests is the minimum number of queries in the window before the circuit can open.
+	MinRequests int
+	// OpenDuration is how long the circuit stays open before queries are let through again.
+	OpenDuration time.Duration
+	// HalfOpenQueries is the number of queries let through in the half-open state; when they all
+	// succeed, the circuit closes.
+	HalfOpenQueries int
+}
+
+// DefaultBreakerConfig returns the circuit breaker configuration used when nothing is specified.
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		ErrorRate:       0.5,
+		Window:          10 * time.Second,
+		MinRequests:     20,
+		OpenDuration:    5 * time.Second,
+		HalfOpenQueries: 3,
+	}
+}
+
+// breakerState is the state of a circuit breaker, the values are exported in the circuit_state metric.
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+const breakerBuckets = 10 // the window is divided in this many buckets, the oldest is dropped as time moves on
+
+// breakerBucket holds the outcome of the queries in a slice of the window.
+type breakerBucket struct {
+	epoch     int64 // start of the bucket, in units of the bucket duration
+	total     int
+	errors    int
+	latencies [len(latencyBounds) + 1]int
+}
+
+// latencyBounds are the upper bounds of the latency histogram used to estimate the p99 latency.
+var latencyBounds = [...]time.Duration{
+	1 * time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	20 * time.Millisecond, 30 * time.Millisecond, 50 * time.Millisecond, 75 * time.Millisecond,
+	100 * time.Millisecond, 150 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond,
+	500 * time.Millisecond, 750 * time.Millisecond, 1 * time.Second, 1500 * time.Millisecond,
+	2 * time.Second, 3 * time.Second, 5 * time.Second,
+}
+
+// breaker is a circuit breaker for a single upstream. It opens when too many queries fail or are too
+// slow, after which no queries are sent for a while. It then goes half-open and lets a few queries
+// through; if those succeed the circuit closes, otherwise it opens again.
+type breaker struct {
+	cfg       BreakerConfig
+	proxyName string
+	addr      string
+
+	mu       sync.Mutex
+	state    breakerState
+	openedAt time.Time
+	inflight int // queries sent while half-open
+	passed   int // queries that succeeded while half-open
+	buckets  [breakerBuckets]breakerBucket
+
+	now func() time.Time // for testing
+}
+
+func newBreaker(proxyName, addr string, cfg BreakerConfig) *breaker {
+	b := &breaker{cfg: cfg, proxyName: proxyName, addr: addr, now: time.Now}
+	circuitState.WithLabelValues(proxyName, addr).Set(float64(breakerClosed))
+	return b
+}
+
+// open returns true if no queries may be sent to the upstream right now.
+func (b *breaker) open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		return b.now().Sub(b.openedAt) < b.cfg.OpenDuration
+	case breakerHalfOpen:
+		return b.inflight >= b.cfg.HalfOpenQueries
+	}
+	return false
+}
+
+// allow returns true if a query may be sent to the upstream. Every allowed query must be followed
+// by a call to done.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		if b.now().Sub(b.openedAt) < b.cfg.OpenDuration {
+			return false
+		}
+		b.transition(breakerHalfOpen)
+	}
+	if b.state == breakerHalfOpen {
+		if b.inflight >= b.cfg.HalfOpenQueries {
+			return false
+		}
+		b.inflight++
+	}
+	return true
+}
+
+// done records the outcome of a query that was allowed. Errors that say nothing about the health of
+// the upstream, such as a cancelled query or a cached connection that was closed, are not counted.
+func (b *breaker) done(err error, rtt time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ignore := errors.Is(err, context.Canceled) || errors.Is(err, ErrCachedClosed)
+	failed := err != nil || (b.cfg.Latency > 0 && rtt > b.cfg.Latency)
+
+	if b.state == breakerHalfOpen {
+		b.inflight--
+		switch {
+		case ignore:
+		case failed:
+			b.transition(breakerOpen)
+		default:
+			b.passed++
+			if b.passed >= b.cfg.HalfOpenQueries {
+				b.transition(breakerClosed)
+			}
+		}
+		return
+	}
+	if ignore || b.state != breakerClosed {
+		return
+	}
+
+	bu := b.bucket()
+	bu.total++
+	if err != nil {
+		bu.errors++
+	}
+	bu.latencies[latencyIndex(rtt)]++
+
+	if b.trip() {
+		b.transition(breakerOpen)
+	}
+}
+
+// bucket returns the bucket for the current time, it is reset when it held an older slice of the window.
+func (b *breaker) bucket() *breakerBucket {
+	epoch := b.now().UnixNano() / int64(b.bucketDuration())
+	bu := &b.buckets[epoch%breakerBuckets]
+	if bu.epoch != epoch {
+		*bu = breakerBucket{epoch: epoch}
+	}
+	return bu
+}
+
+func (b *breaker) bucketDuration() time.Duration {
+	return max(b.cfg.Window/breakerBuckets, time.Millisecond)
+}
+
+// trip returns true if the queries in the window warrant opening the circuit.
+func (b *breaker) trip() bool {
+	now := b.now().UnixNano() / int64(b.bucketDuration())
+
+	var total, errs int
+	var latencies [len(latencyBounds) + 1]int
+	for i := range b.buckets {
+		bu := &b.buckets[i]
+		if now-bu.epoch >= breakerBuckets {
+			continue
+		}
+		total += bu.total
+		errs += bu.errors
+		for j, n := range bu.latencies {
+			latencies[j] += n
+		}
+	}
+	if total == 0 || total < b.cfg.MinRequests {
+		return false
+	}
+	if float64(errs)/float64(total) >= b.cfg.ErrorRate {
+		return true
+	}
+	if b.cfg.Latency == 0 {
+		return false
+	}
+
+	// Find the histogram bucket the p99 latency falls in, and compare its lower bound with the threshold.
+	rank := total - total/100
+	seen := 0
+	for j, n := range latencies {
+		seen += n
+		if seen < rank {
+			continue
+		}
+		return j > 0 && latencyBounds[j-1] >= b.cfg.Latency
+	}
+	return false
+}
+
+// latencyIndex returns the index of the histogram bucket rtt falls in.
+func latencyIndex(rtt time.Duration) int {
+	for i, bound := range latencyBounds {
+		if rtt <= bound {
+			return i
+		}
+	}
+	return len(latencyBounds)
+}
+
+// transition moves the breaker to state s. The caller must hold b.mu.
+func (b *breaker) transition(s breakerState) {
+	from := b.state
+	b.state = s
+	b.inflight, b.passed = 0, 0
+
+	switch s {
+	case breakerOpen:
+		b.openedAt = b.now()
+		log.Warningf("Circuit breaker for upstream %s: %s -> %s", b.addr, from, s)
+	case breakerClosed:
+		b.buckets = [breakerBuckets]breakerBucket{}
+		log.Infof("Circuit breaker for upstream %s: %s -> %s", b.addr, from, s)
+	default:
+		log.Infof("Circuit breaker for upstream %s: %s -> %s", b.addr, from, s)
+	}
+	circuitState.WithLabelValues(b.proxyName, b.addr).Set(float64(s))
+}
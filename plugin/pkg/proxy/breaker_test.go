@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// testBreaker returns a breaker with a clock that only moves when the returned function is called.
+func testBreaker(cfg BreakerConfig) (*breaker, func(time.Duration)) {
+	b := newBreaker("test", "127.0.0.1:53", cfg)
+	now := time.Unix(1000, 0)
+	b.now = func() time.Time { return now }
+	return b, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestBreakerErrorRate(t *testing.T) {
+	b, _ := testBreaker(BreakerConfig{ErrorRate: 0.5, Window: 10 * time.Second, MinRequests: 4, OpenDuration: time.Second, HalfOpenQueries: 1})
+	errFail := errors.New("fail")
+
+	for range 3 {
+		if !b.allow() {
+			t.Fatal("Expected query to be allowed")
+		}
+		b.done(errFail, time.Millisecond)
+	}
+	if b.open() {
+		t.Fatal("Expected circuit to stay closed below min requests")
+	}
+
+	b.allow()
+	b.done(nil, time.Millisecond)
+	if !b.open() {
+		t.Fatal("Expected circuit to be open")
+	}
+	if b.allow() {
+		t.Error("Expected query to be refused while open")
+	}
+}
+
+func TestBreakerLatency(t *testing.T) {
+	b, _ := testBreaker(BreakerConfig{ErrorRate: 1, Latency: 100 * time.Millisecond, Window: 10 * time.Second, MinRequests: 10, OpenDuration: time.Second, HalfOpenQueries: 1})
+
+	for range 9 {
+		b.allow()
+		b.done(nil, 10*time.Millisecond)
+	}
+	b.allow()
+	b.done(nil, 10*time.Millisecond)
+	if b.open() {
+		t.Fatal("Expected circuit to be closed with fast queries")
+	}
+
+	for range 10 {
+		b.allow()
+		b.done(nil, 300*time.Millisecond)
+	}
+	if !b.open() {
+		t.Fatal("Expected circuit to be open with a slow p99")
+	}
+}
+
+func TestBreakerWindow(t *testing.T) {
+	b, advance := testBreaker(BreakerConfig{ErrorRate: 0.5, Window: 10 * time.Second, MinRequests: 4, OpenDuration: time.Second, HalfOpenQueries: 1})
+	errFail := errors.New("fail")
+
+	for range 3 {
+		b.allow()
+		b.done(errFail, time.Millisecond)
+	}
+	// The failures move out of the window.
+	advance(11 * time.Second)
+	b.allow()
+	b.done(errFail, time.Millisecond)
+	if b.open() {
+		t.Fatal("Expected circuit to be closed, old failures should not count")
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	b, advance := testBreaker(BreakerConfig{ErrorRate: 0.5, Window: 10 * time.Second, MinRequests: 1, OpenDuration: time.Second, HalfOpenQueries: 2})
+	errFail := errors.New("fail")
+
+	b.allow()
+	b.done(errFail, time.Millisecond)
+	if b.state != breakerOpen {
+		t.Fatalf("Expected state %s, got %s", breakerOpen, b.state)
+	}
+
+	advance(time.Second)
+	if b.open() {
+		t.Fatal("Expected circuit to let queries through after the open duration")
+	}
+	if !b.allow() || !b.allow() {
+		t.Fatal("Expected two half-open queries to be allowed")
+	}
+	if b.state != breakerHalfOpen {
+		t.Fatalf("Expected state %s, got %s", breakerHalfOpen, b.state)
+	}
+	if b.allow() {
+		t.Fatal("Expected third half-open query to be refused")
+	}
+
+	// A failing half-open query opens the circuit again.
+	b.done(nil, time.Millisecond)
+	b.done(errFail, time.Millisecond)
+	if b.state != breakerOpen {
+		t.Fatalf("Expected state %s, got %s", breakerOpen, b.state)
+	}
+
+	advance(time.Second)
+	b.allow()
+	b.allow()
+	b.done(nil, time.Millisecond)
+	b.done(context.Canceled, time.Millisecond) // does not count
+	if b.state != breakerHalfOpen {
+		t.Fatalf("Expected state %s, got %s", breakerHalfOpen, b.state)
+	}
+	b.allow()
+	b.done(nil, time.Millisecond)
+	if b.state != breakerClosed {
+		t.Fatalf("Expected state %s, got %s", breakerClosed, b.state)
+	}
+}
+
+func TestProxyBreaker(t *testing.T) {
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		// Don't reply, so every query times out.
+	})
+	defer s.Close()
+
+	p := NewProxy("TestProxyBreaker", s.Addr, transport.DNS)
+	p.readTimeout = 10 * time.Millisecond
+	p.SetBreaker(BreakerConfig{ErrorRate: 0.5, Window: 10 * time.Second, MinRequests: 2, OpenDuration: time.Hour, HalfOpenQueries: 1})
+	p.Start(time.Hour)
+	defer p.Close()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	state := request.Request{Req: m, W: &test.ResponseWriter{}}
+
+	for range 2 {
+		if _, err := p.Connect(context.Background(), state, Options{}); err == nil {
+			t.Fatal("Expected timeout error")
+		}
+	}
+	if !p.Down(0) {
+		t.Error("Expected proxy to be down with an open circuit")
+	}
+	if _, err := p.Connect(context.Background(), state, Options{}); err != ErrCircuitOpen {
+		t.Errorf("Expected %q, got %v", ErrCircuitOpen, err)
+	}
+}
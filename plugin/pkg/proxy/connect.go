@@ -103,10 +103,70 @@ func (t *Transport) Dial(proto string) (*persistConn, bool, error) {
 	}
 }
 
-// Connect selects an upstream, sends the request and waits for a response.
+// managesConns returns true if the transport manages its own (multiplexed) connections instead of
+// using the persistConn cache, this is the case for DoH, DoH3 and DoQ.
+func (t *Transport) managesConns() bool { return t.isDoH() || t.isDoQ() }
+
+// exchange sends m to the upstream over a transport that manages its own connections.
+func (t *Transport) exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	if t.isDoQ() {
+		return t.exchangeDoQ(ctx, m)
+	}
+	return t.exchangeDoH(ctx, m)
+}
+
+// connectManaged sends the request in state to the upstream over a transport that manages its own
+// connections.
+func (p *Proxy) connectManaged(ctx context.Context, state request.Request) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.readTimeout)
+	defer cancel()
+
+	// DoQ requires a DNS ID of 0 (RFC 9250, Section 4.2.1) and DoH recommends it, as
+	// it makes the responses more cache friendly (RFC 8484, Section 4.1).
+	originId := state.Req.Id
+	state.Req.Id = 0
+	defer func() {
+		state.Req.Id = originId
+	}()
+
+	ret, err := p.transport.exchange(ctx, state.Req)
+	if err != nil {
+		return nil, err
+	}
+	ret.Id = originId
+
+	return ret, nil
+}
+
+// Connect selects an upstream, sends the request and waits for a response. When the circuit breaker
+// of the proxy is open, ErrCircuitOpen is returned without sending anything.
 func (p *Proxy) Connect(ctx context.Context, state request.Request, opts Options) (*dns.Msg, error) {
+	if p.breaker == nil {
+		return p.connect(ctx, state, opts)
+	}
+	if !p.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	start := time.Now()
+	ret, err := p.connect(ctx, state, opts)
+	p.breaker.done(err, time.Since(start))
+	return ret, err
+}
+
+func (p *Proxy) connect(ctx context.Context, state request.Request, opts Options) (*dns.Msg, error) {
 	start := time.Now()
 
+	if p.transport.managesConns() {
+		ret, err := p.connectManaged(ctx, state)
+		if err != nil {
+			p.observeError(ctx, err)
+			return ret, err
+		}
+		p.observeRequestDuration(ret, start)
+		return ret, nil
+	}
+
 	var proto string
 	switch {
 	case opts.ForceTCP: // TCP flag has precedence over UDP flag
@@ -117,6 +177,36 @@ func (p *Proxy) Connect(ctx context.Context, state request.Request, opts Options
 		proto = state.Proto()
 	}
 
+	send := p.send
+	if p.cookie != nil && p.transport.tlsConfig == nil {
+		send = p.sendWithCookie
+	}
+
+	var (
+		ret *dns.Msg
+		err error
+	)
+	if p.transport.tlsConfig == nil && p.RandCase() {
+		ret, err = p.sendWithRandCase(ctx, state, proto, send)
+	} else {
+		ret, err = send(ctx, state, proto)
+	}
+	if err != nil {
+		p.observeError(ctx, err)
+		return ret, err
+	}
+
+	p.observeRequestDuration(ret, start)
+
+	return ret, nil
+}
+
+// send sends the request in state to the upstream using proto and waits for the reply. The wait ends
+// when ctx is done, e.g. when the query lost a race to another upstream.
+func (p *Proxy) send(ctx context.Context, state request.Request, proto string) (*dns.Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	pc, cached, err := p.transport.Dial(proto)
 	if err != nil {
 		return nil, err
@@ -143,9 +233,15 @@ func (p *Proxy) Connect(ctx context.Context, state request.Request, opts Options
 
 	var ret *dns.Msg
 	pc.c.SetReadDeadline(time.Now().Add(p.readTimeout))
+	stop := context.AfterFunc(ctx, func() { pc.c.SetReadDeadline(time.Now()) })
 	for {
 		ret, err = pc.c.ReadMsg()
 		if err != nil {
+			if ctx.Err() != nil {
+				stop()
+				pc.c.Close() // not giving it back
+				return nil, ctx.Err()
+			}
 			if ret != nil && (state.Req.Id == ret.Id) && p.transport.transportTypeFromConn(pc) == typeUDP && shouldTruncateResponse(err) {
 				// For UDP, if the error is an overflow, we probably have an upstream misbehaving in some way.
 				// (e.g. sending >512 byte responses without an eDNS0 OPT RR).
@@ -158,6 +254,7 @@ func (p *Proxy) Connect(ctx context.Context, state request.Request, opts Options
 				break
 			}
 
+			stop()
 			pc.c.Close() // not giving it back
 			if err == io.EOF && cached {
 				return nil, ErrCachedClosed
@@ -176,16 +273,37 @@ func (p *Proxy) Connect(ctx context.Context, state request.Request, opts Options
 	// recovery the origin Id after upstream.
 	ret.Id = originId
 
+	if !stop() {
+		// ctx was done while the reply came in, the read deadline may be in the past now.
+		pc.c.Close() // not giving it back
+		return ret, nil
+	}
 	p.transport.Yield(pc)
 
+	return ret, nil
+}
+
+// observeRequestDuration records the duration of the exchange that returned ret and started at start,
+// both in the request duration histogram and in the average round trip time of p.
+func (p *Proxy) observeRequestDuration(ret *dns.Msg, start time.Time) {
 	rc, ok := dns.RcodeToString[ret.Rcode]
 	if !ok {
 		rc = strconv.Itoa(ret.Rcode)
 	}
 
-	requestDuration.WithLabelValues(p.proxyName, p.addr, rc).Observe(time.Since(start).Seconds())
+	rtt := time.Since(start)
+	requestDuration.WithLabelValues(p.proxyName, p.addr, rc).Observe(rtt.Seconds())
+	p.updateRTT(rtt)
+}
 
-	return ret, nil
+// observeError counts the failed exchange that returned err as a penalty in the average round trip time
+// of p. Errors from a cancelled ctx, or from a cached connection that was closed, say nothing about the
+// upstream and are not counted.
+func (p *Proxy) observeError(ctx context.Context, err error) {
+	if ctx.Err() != nil || errors.Is(err, ErrCachedClosed) {
+		return
+	}
+	p.failedRTT()
 }
 
 const cumulativeAvgWeight = 4
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"sync"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	clientCookieLen    = 8 // in bytes
+	minServerCookieLen = 8
+	maxServerCookieLen = 32
+)
+
+// cookie holds the DNS cookie (RFC 7873) state for an upstream. The client cookie is generated once
+// per upstream, the server cookie is learned from its replies.
+type cookie struct {
+	client string // hex encoded
+
+	mu     sync.RWMutex
+	server string // hex encoded, empty until learned
+}
+
+func newCookie() *cookie {
+	b := make([]byte, clientCookieLen)
+	rand.Read(b)
+	return &cookie{client: hex.EncodeToString(b)}
+}
+
+// Server returns the server cookie that was learned from the upstream, if any.
+func (c *cookie) Server() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.server
+}
+
+func (c *cookie) setServer(server string) {
+	c.mu.Lock()
+	c.server = server
+	c.mu.Unlock()
+}
+
+// add returns a copy of m carrying our cookie instead of any cookie of the client.
+func (c *cookie) add(m *dns.Msg) *dns.Msg {
+	m = m.Copy()
+	stripCookie(m)
+	opt := m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(dns.MinMsgSize, false)
+		opt = m.IsEdns0()
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: c.client + c.Server()})
+	return m
+}
+
+// cookieResult is the outcome of checking the cookie in a reply.
+type cookieResult int
+
+const (
+	cookieOK       cookieResult = iota // no cookie, or a cookie matching ours
+	cookieMismatch                     // malformed or missing cookie, or a client cookie that is not ours
+	cookieBad                          // BADCOOKIE rcode with our client cookie, retry with the new server cookie
+)
+
+// replyCookie returns the cookie in m, or nil if it has none.
+func replyCookie(m *dns.Msg) *dns.EDNS0_COOKIE {
+	if opt := m.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if e, ok := o.(*dns.EDNS0_COOKIE); ok {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// check verifies the cookie in the reply ret and learns the server cookie from it.
+func (c *cookie) check(ret *dns.Msg) cookieResult {
+	rc := replyCookie(ret)
+	if rc == nil {
+		if c.Server() != "" {
+			// The upstream sent us a server cookie before, a reply without one may be spoofed.
+			return cookieMismatch
+		}
+		// The upstream does not do cookies.
+		return cookieOK
+	}
+
+	n := len(rc.Cookie) / 2
+	if len(rc.Cookie)%2 != 0 || n < clientCookieLen+minServerCookieLen || n > clientCookieLen+maxServerCookieLen {
+		return cookieMismatch
+	}
+	if rc.Cookie[:2*clientCookieLen] != c.client {
+		return cookieMismatch
+	}
+
+	c.setServer(rc.Cookie[2*clientCookieLen:])
+	if ret.Rcode == dns.RcodeBadCookie {
+		return cookieBad
+	}
+	return cookieOK
+}
+
+// stripCookie removes the cookie option from m.
+func stripCookie(m *dns.Msg) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return
+	}
+	options := opt.Option[:0]
+	for _, o := range opt.Option {
+		if o.Option() != dns.EDNS0COOKIE {
+			options = append(options, o)
+		}
+	}
+	opt.Option = options
+}
+
+// sendWithCookie sends the request in state with our cookie. Replies over UDP with a cookie that
+// does not match ours are treated as spoofed and the query is retried over TCP. On BADCOOKIE the
+// query is retried once with the server cookie that came with it, and then over TCP.
+func (p *Proxy) sendWithCookie(ctx context.Context, state request.Request, proto string) (*dns.Msg, error) {
+	st := state
+	st.Req = p.cookie.add(state.Req)
+
+	ret, err := p.send(ctx, st, proto)
+	if err != nil {
+		return ret, err
+	}
+
+	switch p.cookie.check(ret) {
+	case cookieMismatch:
+		cookieFailureCount.WithLabelValues(p.proxyName, p.addr, "mismatch").Add(1)
+		if proto != "udp" {
+			break
+		}
+		ret, err = p.send(ctx, st, "tcp")
+		if err == nil && replyCookie(ret) == nil && p.cookie.Server() != "" {
+			// A reply over TCP is not spoofed, the upstream stopped sending cookies: forget its server cookie,
+			// or every query would be retried over TCP.
+			p.cookie.setServer("")
+		}
+
+	case cookieBad:
+		cookieFailureCount.WithLabelValues(p.proxyName, p.addr, "badcookie").Add(1)
+		st.Req = p.cookie.add(state.Req)
+		ret, err = p.send(ctx, st, proto)
+		if err != nil || proto != "udp" || p.cookie.check(ret) == cookieOK {
+			break
+		}
+		ret, err = p.send(ctx, st, "tcp")
+	}
+	if err != nil {
+		return ret, err
+	}
+
+	stripCookie(ret)
+	if state.Req.IsEdns0() == nil {
+		// We added the OPT record, the client did not ask for it.
+		ret.Extra = slices.DeleteFunc(ret.Extra, func(rr dns.RR) bool { return rr.Header().Rrtype == dns.TypeOPT })
+	}
+	return ret, nil
+}
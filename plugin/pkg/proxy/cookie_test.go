@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+const testServerCookie = "0102030405060708"
+
+// requestCookie returns the cookie in m, or the empty string.
+func requestCookie(m *dns.Msg) string {
+	if opt := m.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if e, ok := o.(*dns.EDNS0_COOKIE); ok {
+				return e.Cookie
+			}
+		}
+	}
+	return ""
+}
+
+// cookieReply returns a reply to r carrying cookie c.
+func cookieReply(r *dns.Msg, c string) *dns.Msg {
+	ret := new(dns.Msg)
+	ret.SetReply(r)
+	ret.SetEdns0(4096, false)
+	ret.IsEdns0().Option = append(ret.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: c})
+	return ret
+}
+
+func cookieTestQuery() request.Request {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	return request.Request{Req: m, W: dnstest.NewRecorder(&test.ResponseWriter{})}
+}
+
+func TestProxyCookie(t *testing.T) {
+	var withServerCookie uint32
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		c := requestCookie(r)
+		if len(c) == 2*clientCookieLen+len(testServerCookie) {
+			atomic.AddUint32(&withServerCookie, 1)
+		}
+		w.WriteMsg(cookieReply(r, c[:2*clientCookieLen]+testServerCookie))
+	})
+	defer s.Close()
+
+	p := NewProxy("TestProxyCookie", s.Addr, transport.DNS)
+	p.EnableCookies()
+	p.Start(5 * time.Second)
+	defer p.Close()
+
+	for range 2 {
+		req := cookieTestQuery()
+		ret, err := p.Connect(context.Background(), req, Options{})
+		if err != nil {
+			t.Fatalf("Failed to connect: %s", err)
+		}
+		if ret.IsEdns0() != nil {
+			t.Errorf("Expected the OPT record to be removed, as the client did not send one")
+		}
+		if req.Req.IsEdns0() != nil {
+			t.Errorf("Expected the request of the client to be left untouched")
+		}
+	}
+
+	if x := p.cookie.Server(); x != testServerCookie {
+		t.Errorf("Expected server cookie %q to be learned, got %q", testServerCookie, x)
+	}
+	if x := atomic.LoadUint32(&withServerCookie); x != 1 {
+		t.Errorf("Expected the second query to carry the server cookie, got %d queries with it", x)
+	}
+}
+
+func TestProxyCookieMismatch(t *testing.T) {
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		c := requestCookie(r)
+		if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+			// A spoofed reply, with a client cookie that is not ours.
+			w.WriteMsg(cookieReply(r, "ffffffffffffffff"+testServerCookie))
+			return
+		}
+		ret := cookieReply(r, c[:2*clientCookieLen]+testServerCookie)
+		ret.Answer = append(ret.Answer, test.A("example.org. IN A 127.0.0.1"))
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	p := NewProxy("TestProxyCookieMismatch", s.Addr, transport.DNS)
+	p.EnableCookies()
+	p.Start(5 * time.Second)
+	defer p.Close()
+
+	ret, err := p.Connect(context.Background(), cookieTestQuery(), Options{})
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	if len(ret.Answer) != 1 {
+		t.Errorf("Expected the reply to be retried over TCP, got %v", ret)
+	}
+}
+
+func TestProxyCookieMissing(t *testing.T) {
+	var queries uint32
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		c := requestCookie(r)
+		if _, udp := w.RemoteAddr().(*net.UDPAddr); udp && atomic.AddUint32(&queries, 1) > 1 {
+			// A spoofed reply, without a cookie after the server cookie was learned.
+			ret := new(dns.Msg)
+			ret.SetReply(r)
+			w.WriteMsg(ret)
+			return
+		}
+		ret := cookieReply(r, c[:2*clientCookieLen]+testServerCookie)
+		ret.Answer = append(ret.Answer, test.A("example.org. IN A 127.0.0.1"))
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	p := NewProxy("TestProxyCookieMissing", s.Addr, transport.DNS)
+	p.EnableCookies()
+	p.Start(5 * time.Second)
+	defer p.Close()
+
+	for i := range 2 {
+		ret, err := p.Connect(context.Background(), cookieTestQuery(), Options{})
+		if err != nil {
+			t.Fatalf("Test %d: failed to connect: %s", i, err)
+		}
+		if len(ret.Answer) != 1 {
+			t.Errorf("Test %d: expected the reply without a cookie to be retried over TCP, got %v", i, ret)
+		}
+	}
+}
+
+func TestProxyCookieDropped(t *testing.T) {
+	var queries, tcp uint32
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		if _, udp := w.RemoteAddr().(*net.UDPAddr); !udp {
+			atomic.AddUint32(&tcp, 1)
+		}
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		if atomic.AddUint32(&queries, 1) == 1 {
+			// Only the first reply has a cookie, then the upstream is replaced by one that does not do cookies.
+			ret = cookieReply(r, requestCookie(r)[:2*clientCookieLen]+testServerCookie)
+		}
+		ret.Answer = append(ret.Answer, test.A("example.org. IN A 127.0.0.1"))
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	p := NewProxy("TestProxyCookieDropped", s.Addr, transport.DNS)
+	p.EnableCookies()
+	p.Start(5 * time.Second)
+	defer p.Close()
+
+	for i := range 3 {
+		ret, err := p.Connect(context.Background(), cookieTestQuery(), Options{})
+		if err != nil {
+			t.Fatalf("Test %d: failed to connect: %s", i, err)
+		}
+		if len(ret.Answer) != 1 {
+			t.Errorf("Test %d: expected an answer, got %v", i, ret)
+		}
+	}
+	if x := p.cookie.Server(); x != "" {
+		t.Errorf("Expected the server cookie to be forgotten, got %q", x)
+	}
+	if x := atomic.LoadUint32(&tcp); x != 1 {
+		t.Errorf("Expected a single query over TCP, got %d", x)
+	}
+}
+
+func TestProxyBadCookie(t *testing.T) {
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		c := requestCookie(r)
+		ret := cookieReply(r, c[:2*clientCookieLen]+testServerCookie)
+		if c[2*clientCookieLen:] != testServerCookie {
+			ret.Rcode = dns.RcodeBadCookie
+		}
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	p := NewProxy("TestProxyBadCookie", s.Addr, transport.DNS)
+	p.EnableCookies()
+	p.Start(5 * time.Second)
+	defer p.Close()
+
+	ret, err := p.Connect(context.Background(), cookieTestQuery(), Options{})
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	if ret.Rcode != dns.RcodeSuccess {
+		t.Errorf("Expected the query to be retried with the new server cookie, got rcode %s", dns.RcodeToString[ret.Rcode])
+	}
+}
+
+func TestCookieCheck(t *testing.T) {
+	c := &cookie{client: "0011223344556677"}
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+
+	tests := []struct {
+		cookie   string
+		expected cookieResult
+	}{
+		{"", cookieOK},
+		{"0011223344556677" + testServerCookie, cookieOK},
+		{"0011223344556677", cookieMismatch},    // no server cookie
+		{"0011223344556677abc", cookieMismatch}, // odd length
+		{"ffffffffffffffff" + testServerCookie, cookieMismatch},
+		{"", cookieMismatch}, // no cookie, after the server cookie was learned
+	}
+	for i, tc := range tests {
+		ret := new(dns.Msg)
+		ret.SetReply(m)
+		if tc.cookie != "" {
+			ret = cookieReply(m, tc.cookie)
+		}
+		if x := c.check(ret); x != tc.expected {
+			t.Errorf("Test %d: expected %d, got %d", i, tc.expected, x)
+		}
+	}
+}
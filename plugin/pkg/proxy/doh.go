@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/doh"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// isDoH returns true if this transport speaks DNS-over-HTTPS (over HTTP/2 or HTTP/3).
+func (t *Transport) isDoH() bool {
+	return t.trans == transport.HTTPS || t.trans == transport.HTTPS3
+}
+
+// newDoHClient returns a http.Client for the DoH transport trans. Connections are kept open and reused
+// by the underlying http.Transport (or http3.Transport) until they have been idle for expire.
+func newDoHClient(trans string, cfg *tls.Config, expire time.Duration) *http.Client {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	cfg = cfg.Clone()
+
+	if trans == transport.HTTPS3 {
+		cfg.NextProtos = []string{http3.NextProtoH3}
+		return &http.Client{
+			Transport: &http3.Transport{
+				TLSClientConfig: cfg,
+				QUICConfig:      &quic.Config{MaxIdleTimeout: expire},
+			},
+		}
+	}
+
+	cfg.NextProtos = []string{"h2", "http/1.1"}
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:     cfg,
+			ForceAttemptHTTP2:   true,
+			IdleConnTimeout:     expire,
+			MaxIdleConnsPerHost: maxDoHIdleConns,
+		},
+	}
+}
+
+// setupDoH (re)creates the http.Client used for DoH, closing any idle connections of the old one.
+func (t *Transport) setupDoH() {
+	if t.httpClient != nil {
+		t.httpClient.CloseIdleConnections()
+	}
+	t.httpClient = newDoHClient(t.trans, t.tlsConfig, t.expire)
+}
+
+// exchangeDoH sends m to the upstream using a DoH POST request and returns the reply.
+func (t *Transport) exchangeDoH(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	req, err := doh.NewRequest(http.MethodPost, t.addr, m)
+	if err != nil {
+		return nil, err
+	}
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				connCacheHitsCount.WithLabelValues(t.proxyName, t.addr, t.trans).Add(1)
+				return
+			}
+			connCacheMissesCount.WithLabelValues(t.proxyName, t.addr, t.trans).Add(1)
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected HTTP status from %s: %d", t.addr, resp.StatusCode)
+	}
+
+	return doh.ResponseToMsg(resp)
+}
+
+const maxDoHIdleConns = 2
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/doh"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+func newDoHTestServer(t *testing.T, status int) *httptest.Server {
+	t.Helper()
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		m, err := doh.RequestToMsg(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ret := new(dns.Msg)
+		ret.SetReply(m)
+		if m.Question[0].Qtype == dns.TypeA {
+			ret.Answer = append(ret.Answer, test.A("example.org. IN A 127.0.0.1"))
+		}
+		buf, _ := ret.Pack()
+		w.Header().Set("Content-Type", doh.MimeType)
+		w.Write(buf)
+	}))
+	s.EnableHTTP2 = true
+	s.StartTLS()
+	return s
+}
+
+func TestProxyDoH(t *testing.T) {
+	s := newDoHTestServer(t, http.StatusOK)
+	defer s.Close()
+
+	p := NewProxy("TestProxyDoH", s.Listener.Addr().String(), transport.HTTPS)
+	p.SetTLSConfig(&tls.Config{InsecureSkipVerify: true})
+	p.Start(5 * time.Second)
+	defer p.Close()
+
+	for range 2 {
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		m.Id = 1234
+
+		req := request.Request{Req: m, W: dnstest.NewRecorder(&test.ResponseWriter{})}
+		resp, err := p.Connect(context.Background(), req, Options{})
+		if err != nil {
+			t.Fatalf("Failed to connect to DoH server: %s", err)
+		}
+		if resp.Id != 1234 {
+			t.Errorf("Expected reply ID to be restored to %d, got %d", 1234, resp.Id)
+		}
+		if m.Id != 1234 {
+			t.Errorf("Expected request ID to be restored to %d, got %d", 1234, m.Id)
+		}
+		if x := resp.Answer[0].Header().Name; x != "example.org." {
+			t.Errorf("Expected %s, got %s", "example.org.", x)
+		}
+	}
+}
+
+func TestProxyDoHBadStatus(t *testing.T) {
+	s := newDoHTestServer(t, http.StatusInternalServerError)
+	defer s.Close()
+
+	p := NewProxy("TestProxyDoHBadStatus", s.Listener.Addr().String(), transport.HTTPS)
+	p.SetTLSConfig(&tls.Config{InsecureSkipVerify: true})
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	req := request.Request{Req: m, W: dnstest.NewRecorder(&test.ResponseWriter{})}
+	if _, err := p.Connect(context.Background(), req, Options{}); err == nil {
+		t.Fatal("Expected error for non 200 HTTP status, got none")
+	}
+}
+
+func TestHealthDoH(t *testing.T) {
+	s := newDoHTestServer(t, http.StatusOK)
+	defer s.Close()
+
+	p := NewProxy("TestHealthDoH", s.Listener.Addr().String(), transport.HTTPS)
+	p.SetTLSConfig(&tls.Config{InsecureSkipVerify: true})
+
+	if err := p.GetHealthchecker().Check(p); err != nil {
+		t.Errorf("check failed: %v", err)
+	}
+	if fails := atomic.LoadUint32(&p.fails); fails != 0 {
+		t.Errorf("Expected fails to be %d, got %d", 0, fails)
+	}
+
+	s.Close()
+	if err := p.GetHealthchecker().Check(p); err == nil {
+		t.Error("Expected check to fail after the server has been closed")
+	}
+	if fails := atomic.LoadUint32(&p.fails); fails != 1 {
+		t.Errorf("Expected fails to be %d, got %d", 1, fails)
+	}
+}
@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/transport"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// doqALPN is the ALPN token for DNS-over-QUIC, RFC 9250, Section 4.1.1.
+	doqALPN = "doq"
+
+	// doqCodeNoError is used to close a connection when there is no error to signal.
+	doqCodeNoError quic.ApplicationErrorCode = 0
+)
+
+// isDoQ returns true if this transport speaks DNS-over-QUIC.
+func (t *Transport) isDoQ() bool { return t.trans == transport.QUIC }
+
+// setupDoQ (re)creates the TLS config used to dial QUIC connections. A session cache is always
+// present as it is needed for session resumption and 0-RTT.
+func (t *Transport) setupDoQ() {
+	cfg := &tls.Config{}
+	if t.tlsConfig != nil {
+		cfg = t.tlsConfig.Clone()
+	}
+	cfg.NextProtos = []string{doqALPN}
+	if cfg.ClientSessionCache == nil {
+		cfg.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	}
+	t.quicTLSConfig = cfg
+}
+
+// dialQUIC returns the QUIC connection to the upstream. All queries are multiplexed as streams
+// on a single connection, which is reused until it has not been used for t.expire. The boolean is
+// true when the connection was cached.
+func (t *Transport) dialQUIC(ctx context.Context) (*quic.Conn, bool, error) {
+	t.quicMu.Lock()
+	defer t.quicMu.Unlock()
+
+	select {
+	case <-t.stop:
+		return nil, false, errors.New(ErrTransportStopped)
+	default:
+	}
+
+	if c := t.quicConn; c != nil {
+		if c.Context().Err() == nil && time.Since(t.quicUsed) < t.expire {
+			t.quicUsed = time.Now()
+			connCacheHitsCount.WithLabelValues(t.proxyName, t.addr, transport.QUIC).Add(1)
+			return c, true, nil
+		}
+		go c.CloseWithError(doqCodeNoError, "")
+		t.quicConn = nil
+	}
+	connCacheMissesCount.WithLabelValues(t.proxyName, t.addr, transport.QUIC).Add(1)
+
+	reqTime := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, t.dialTimeout())
+	defer cancel()
+
+	// Dial "early", so idempotent queries can be sent as 0-RTT data on resumed sessions.
+	c, err := quic.DialAddrEarly(ctx, t.addr, t.quicTLSConfig, &quic.Config{MaxIdleTimeout: t.expire})
+	t.updateDialTimeout(time.Since(reqTime))
+	if err != nil {
+		return nil, false, err
+	}
+
+	t.quicConn = c
+	t.quicUsed = time.Now()
+	return c, false, nil
+}
+
+// replaceQUIC swaps the cached connection old for c. If c is nil the connection is dropped.
+func (t *Transport) replaceQUIC(old, c *quic.Conn) {
+	t.quicMu.Lock()
+	defer t.quicMu.Unlock()
+
+	if t.quicConn != old {
+		return
+	}
+	t.quicConn = c
+	if c == nil {
+		go old.CloseWithError(doqCodeNoError, "")
+	}
+}
+
+// closeQUIC closes the cached connection, if any.
+func (t *Transport) closeQUIC() {
+	t.quicMu.Lock()
+	defer t.quicMu.Unlock()
+
+	if t.quicConn != nil {
+		t.quicConn.CloseWithError(doqCodeNoError, "")
+		t.quicConn = nil
+	}
+}
+
+// exchangeDoQ sends m to the upstream on a new QUIC stream and returns the reply. The ID of m must be zero.
+func (t *Transport) exchangeDoQ(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	m = stripTCPKeepalive(m)
+
+	c, cached, err := t.dialQUIC(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := exchangeQUICStream(ctx, c, m)
+	if errors.Is(err, quic.Err0RTTRejected) {
+		// The server rejected our 0-RTT data, wait for the handshake to finish and try once more.
+		next, nerr := c.NextConnection(ctx)
+		if nerr != nil {
+			t.replaceQUIC(c, nil)
+			return nil, nerr
+		}
+		t.replaceQUIC(c, next)
+		c = next
+		ret, err = exchangeQUICStream(ctx, c, m)
+	}
+	if err != nil {
+		// Only drop the connection if it is dead, a single failing stream says nothing about the others.
+		if c.Context().Err() != nil {
+			t.replaceQUIC(c, nil)
+			if cached {
+				return nil, ErrCachedClosed
+			}
+		}
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// exchangeQUICStream writes m on a new stream of c and reads the reply, as described in RFC 9250, Section 4.2.
+func exchangeQUICStream(ctx context.Context, c *quic.Conn, m *dns.Msg) (*dns.Msg, error) {
+	// Only idempotent queries may be sent in 0-RTT data, RFC 9250, Section 4.5.
+	if !zeroRTTSafe(m) {
+		select {
+		case <-c.HandshakeComplete():
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	buf, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := c.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	msg := make([]byte, 2+len(buf))
+	binary.BigEndian.PutUint16(msg, uint16(len(buf)))
+	copy(msg[2:], buf)
+
+	if _, err := stream.Write(msg); err != nil {
+		stream.CancelRead(0)
+		return nil, err
+	}
+	// Close the write direction of the stream, this sends the STREAM FIN that signals the end of the query.
+	stream.Close()
+
+	sizeBuf := make([]byte, 2)
+	if _, err := io.ReadFull(stream, sizeBuf); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint16(sizeBuf)
+	if size == 0 {
+		return nil, fmt.Errorf("empty DoQ message from %s", c.RemoteAddr())
+	}
+
+	buf = make([]byte, size)
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		return nil, err
+	}
+
+	ret := new(dns.Msg)
+	if err := ret.Unpack(buf); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// zeroRTTSafe returns true if m can be replayed without side effects, i.e. it is a normal query.
+func zeroRTTSafe(m *dns.Msg) bool {
+	if m.Opcode != dns.OpcodeQuery || len(m.Question) != 1 {
+		return false
+	}
+	switch m.Question[0].Qtype {
+	case dns.TypeAXFR, dns.TypeIXFR:
+		return false
+	}
+	return true
+}
+
+// stripTCPKeepalive returns m without the EDNS0 TCP keepalive option, which must not be sent
+// over DoQ (RFC 9250, Section 5.5.2). If m does not have the option, it is returned as is.
+func stripTCPKeepalive(m *dns.Msg) *dns.Msg {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return m
+	}
+
+	keepalive := false
+	for _, o := range opt.Option {
+		if o.Option() == dns.EDNS0TCPKEEPALIVE {
+			keepalive = true
+			break
+		}
+	}
+	if !keepalive {
+		return m
+	}
+
+	m = m.Copy()
+	opt = m.IsEdns0()
+	options := opt.Option[:0]
+	for _, o := range opt.Option {
+		if o.Option() != dns.EDNS0TCPKEEPALIVE {
+			options = append(options, o)
+		}
+	}
+	opt.Option = options
+	return m
+}
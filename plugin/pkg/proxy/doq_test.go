@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	ctls "github.com/coredns/coredns/plugin/pkg/tls"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// doqTestServer is a minimal DNS-over-QUIC server that answers every query with an A record.
+type doqTestServer struct {
+	l     *quic.Listener
+	conns uint32
+	ids   uint32 // number of queries with a non-zero ID
+	keeps uint32 // number of queries with an EDNS0 TCP keepalive option
+}
+
+func newDoQTestServer(t *testing.T) *doqTestServer {
+	t.Helper()
+	cfg, err := ctls.NewTLSConfig("../../tls/test_cert.pem", "../../tls/test_key.pem", "../../tls/test_ca.pem")
+	if err != nil {
+		t.Fatalf("Failed to create TLS config: %s", err)
+	}
+	cfg.NextProtos = []string{doqALPN}
+
+	l, err := quic.ListenAddr("127.0.0.1:0", cfg, &quic.Config{Allow0RTT: true})
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	s := &doqTestServer{l: l}
+	go s.serve()
+	return s
+}
+
+func (s *doqTestServer) Addr() string { return s.l.Addr().String() }
+func (s *doqTestServer) Close()       { s.l.Close() }
+
+func (s *doqTestServer) serve() {
+	for {
+		c, err := s.l.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		atomic.AddUint32(&s.conns, 1)
+		go func() {
+			for {
+				stream, err := c.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				go s.serveStream(stream)
+			}
+		}()
+	}
+}
+
+func (s *doqTestServer) serveStream(stream *quic.Stream) {
+	defer stream.Close()
+
+	buf, err := io.ReadAll(stream)
+	if err != nil || len(buf) < 2 {
+		return
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(buf[2:]); err != nil {
+		return
+	}
+	if m.Id != 0 {
+		atomic.AddUint32(&s.ids, 1)
+	}
+	if opt := m.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if o.Option() == dns.EDNS0TCPKEEPALIVE {
+				atomic.AddUint32(&s.keeps, 1)
+			}
+		}
+	}
+
+	ret := new(dns.Msg)
+	ret.SetReply(m)
+	ret.Answer = append(ret.Answer, test.A("example.org. IN A 127.0.0.1"))
+	out, _ := ret.Pack()
+
+	msg := make([]byte, 2+len(out))
+	binary.BigEndian.PutUint16(msg, uint16(len(out)))
+	copy(msg[2:], out)
+	stream.Write(msg)
+}
+
+func TestProxyDoQ(t *testing.T) {
+	s := newDoQTestServer(t)
+	defer s.Close()
+
+	p := NewProxy("TestProxyDoQ", s.Addr(), transport.QUIC)
+	p.SetTLSConfig(&tls.Config{InsecureSkipVerify: true})
+	p.Start(5 * time.Second)
+	defer p.Close()
+
+	for range 3 {
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		m.Id = 1234
+		m.SetEdns0(4096, false)
+		m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE})
+
+		req := request.Request{Req: m, W: dnstest.NewRecorder(&test.ResponseWriter{})}
+		resp, err := p.Connect(context.Background(), req, Options{})
+		if err != nil {
+			t.Fatalf("Failed to connect to DoQ server: %s", err)
+		}
+		if resp.Id != 1234 {
+			t.Errorf("Expected reply ID to be restored to %d, got %d", 1234, resp.Id)
+		}
+		if x := resp.Answer[0].Header().Name; x != "example.org." {
+			t.Errorf("Expected %s, got %s", "example.org.", x)
+		}
+		if len(m.IsEdns0().Option) != 1 {
+			t.Errorf("Expected the query of the client to be left untouched")
+		}
+	}
+
+	if x := atomic.LoadUint32(&s.conns); x != 1 {
+		t.Errorf("Expected all queries to share %d connection, got %d", 1, x)
+	}
+	if x := atomic.LoadUint32(&s.ids); x != 0 {
+		t.Errorf("Expected all queries to have ID 0, got %d with a non-zero ID", x)
+	}
+	if x := atomic.LoadUint32(&s.keeps); x != 0 {
+		t.Errorf("Expected the TCP keepalive option to be stripped, got %d queries with it", x)
+	}
+}
+
+func TestProxyDoQExpire(t *testing.T) {
+	s := newDoQTestServer(t)
+	defer s.Close()
+
+	p := NewProxy("TestProxyDoQExpire", s.Addr(), transport.QUIC)
+	p.SetTLSConfig(&tls.Config{InsecureSkipVerify: true})
+	p.SetExpire(100 * time.Millisecond)
+	p.Start(5 * time.Second)
+	defer p.Close()
+
+	for range 2 {
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		req := request.Request{Req: m, W: dnstest.NewRecorder(&test.ResponseWriter{})}
+		if _, err := p.Connect(context.Background(), req, Options{}); err != nil {
+			t.Fatalf("Failed to connect to DoQ server: %s", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	if x := atomic.LoadUint32(&s.conns); x != 2 {
+		t.Errorf("Expected expired connection to be redialed, got %d connections", x)
+	}
+}
+
+func TestHealthDoQ(t *testing.T) {
+	s := newDoQTestServer(t)
+	defer s.Close()
+
+	p := NewProxy("TestHealthDoQ", s.Addr(), transport.QUIC)
+	p.SetTLSConfig(&tls.Config{InsecureSkipVerify: true})
+
+	if err := p.GetHealthchecker().Check(p); err != nil {
+		t.Errorf("check failed: %v", err)
+	}
+	if fails := atomic.LoadUint32(&p.fails); fails != 0 {
+		t.Errorf("Expected fails to be %d, got %d", 0, fails)
+	}
+}
+
+func TestZeroRTTSafe(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	if !zeroRTTSafe(m) {
+		t.Errorf("Expected A query to be safe for 0-RTT")
+	}
+
+	m.SetQuestion("example.org.", dns.TypeAXFR)
+	if zeroRTTSafe(m) {
+		t.Errorf("Expected AXFR query to not be safe for 0-RTT")
+	}
+
+	m.SetUpdate("example.org.")
+	if zeroRTTSafe(m) {
+		t.Errorf("Expected UPDATE to not be safe for 0-RTT")
+	}
+}
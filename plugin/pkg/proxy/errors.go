@@ -11,6 +11,8 @@ var (
 	ErrNoForward = errors.New("no forwarder defined")
 	// ErrCachedClosed means cached connection was closed by peer.
 	ErrCachedClosed = errors.New("cached connection was closed by peer")
+	// ErrCircuitOpen means the circuit breaker of the proxy is open.
+	ErrCircuitOpen = errors.New("circuit breaker is open")
 )
 
 // Options holds various Options that can be set.
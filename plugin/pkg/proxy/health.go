@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"context"
 	"crypto/tls"
 	"sync/atomic"
 	"time"
@@ -51,6 +52,14 @@ func NewHealthChecker(proxyName, trans string, recursionDesired bool, domain str
 			domain:           domain,
 			proxyName:        proxyName,
 		}
+
+	case transport.HTTPS, transport.HTTPS3, transport.QUIC:
+		return &transportHc{
+			recursionDesired: recursionDesired,
+			domain:           domain,
+			timeout:          1 * time.Second,
+			proxyName:        proxyName,
+		}
 	}
 
 	log.Warningf("No healthchecker for transport %q", trans)
@@ -132,3 +141,62 @@ func (h *dnsHc) send(addr string) error {
 
 	return err
 }
+
+// transportHc is a health checker for the endpoints whose connections are managed by the proxy's
+// transport (DoH, DoH3 and DoQ). Health checks share (and keep warm) those connections.
+type transportHc struct {
+	tlsConfig        *tls.Config
+	recursionDesired bool
+	domain           string
+	timeout          time.Duration
+
+	proxyName string
+}
+
+// SetTLSConfig only records cfg, the health check requests use the TLS config of the proxy's transport.
+func (h *transportHc) SetTLSConfig(cfg *tls.Config) { h.tlsConfig = cfg }
+func (h *transportHc) GetTLSConfig() *tls.Config    { return h.tlsConfig }
+
+func (h *transportHc) SetRecursionDesired(recursionDesired bool) {
+	h.recursionDesired = recursionDesired
+}
+func (h *transportHc) GetRecursionDesired() bool { return h.recursionDesired }
+
+func (h *transportHc) SetDomain(domain string) { h.domain = domain }
+func (h *transportHc) GetDomain() string       { return h.domain }
+
+// SetTCPTransport is a noop, the transport of the proxy is always used.
+func (h *transportHc) SetTCPTransport() {}
+
+func (h *transportHc) GetReadTimeout() time.Duration  { return h.timeout }
+func (h *transportHc) SetReadTimeout(t time.Duration) { h.timeout = t }
+
+func (h *transportHc) GetWriteTimeout() time.Duration  { return h.timeout }
+func (h *transportHc) SetWriteTimeout(t time.Duration) { h.timeout = t }
+
+// Check is used as the up.Func in the up.Probe.
+func (h *transportHc) Check(p *Proxy) error {
+	err := h.send(p.transport)
+	if err != nil {
+		healthcheckFailureCount.WithLabelValues(p.proxyName, p.addr).Add(1)
+		p.incrementFails()
+		return err
+	}
+
+	atomic.StoreUint32(&p.fails, 0)
+	return nil
+}
+
+func (h *transportHc) send(t *Transport) error {
+	ping := new(dns.Msg)
+	ping.SetQuestion(h.domain, dns.TypeNS)
+	ping.RecursionDesired = h.recursionDesired
+	ping.Id = 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	// Any DNS message that comes back means the upstream is alive.
+	_, err := t.exchange(ctx, ping)
+	return err
+}
@@ -38,4 +38,25 @@ var (
 		Name:      "conn_cache_misses_total",
 		Help:      "Counter of connection cache misses per upstream and protocol.",
 	}, []string{"proxy_name", "to", "proto"})
+
+	cookieFailureCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "proxy",
+		Name:      "cookie_failures_total",
+		Help:      "Counter of replies with a DNS cookie that did not match or a BADCOOKIE rcode, per upstream.",
+	}, []string{"proxy_name", "to", "reason"})
+
+	caseMismatchCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "proxy",
+		Name:      "case_mismatches_total",
+		Help:      "Counter of replies that did not echo the randomized case of the query name, per upstream.",
+	}, []string{"proxy_name", "to"})
+
+	circuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "proxy",
+		Name:      "circuit_state",
+		Help:      "Gauge of the circuit breaker state per upstream: 0 is closed, 1 is half-open and 2 is open.",
+	}, []string{"proxy_name", "to"})
 )
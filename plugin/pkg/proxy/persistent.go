@@ -2,11 +2,13 @@ package proxy
 
 import (
 	"crypto/tls"
+	"net/http"
 	"sort"
 	"sync"
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
 )
 
 // a persistConn hold the dns.Conn and the last used time.
@@ -23,6 +25,14 @@ type Transport struct {
 	addr        string
 	tlsConfig   *tls.Config
 	proxyName   string
+	trans       string       // transport protocol, see plugin/pkg/transport.
+	httpClient  *http.Client // only used for DNS-over-HTTPS upstreams.
+
+	// Only used for DNS-over-QUIC upstreams, all queries are streams on a single connection.
+	quicMu        sync.Mutex
+	quicConn      *quic.Conn
+	quicUsed      time.Time
+	quicTLSConfig *tls.Config
 
 	dial  chan string
 	yield chan *persistConn
@@ -162,14 +172,33 @@ func (t *Transport) Start() {
 func (t *Transport) Stop() {
 	defer t.wg.Wait()
 
+	if t.httpClient != nil {
+		t.httpClient.CloseIdleConnections()
+	}
 	close(t.stop)
+	if t.isDoQ() {
+		t.closeQUIC()
+	}
 }
 
 // SetExpire sets the connection expire time in transport.
-func (t *Transport) SetExpire(expire time.Duration) { t.expire = expire }
+func (t *Transport) SetExpire(expire time.Duration) {
+	t.expire = expire
+	if t.isDoH() {
+		t.setupDoH()
+	}
+}
 
 // SetTLSConfig sets the TLS config in transport.
-func (t *Transport) SetTLSConfig(cfg *tls.Config) { t.tlsConfig = cfg }
+func (t *Transport) SetTLSConfig(cfg *tls.Config) {
+	t.tlsConfig = cfg
+	switch {
+	case t.isDoH():
+		t.setupDoH()
+	case t.isDoQ():
+		t.setupDoQ()
+	}
+}
 
 // GetTLSConfig returns the TLS config in transport.
 func (t *Transport) GetTLSConfig() *tls.Config { return t.tlsConfig }
@@ -12,6 +12,11 @@ import (
 
 // Proxy defines an upstream host.
 type Proxy struct {
+	avgRTT  int64 // atomic counters need to be first in struct for proper alignment
+	lastRTT int64 // unix time in nanoseconds of the last round trip time observation
+
+	rttFailed uint32 // 1 when the last exchange failed and was counted as a penalty round trip time
+
 	fails     uint32
 	addr      string
 	proxyName string
@@ -20,6 +25,10 @@ type Proxy struct {
 
 	readTimeout time.Duration
 
+	cookie   *cookie   // nil when DNS cookies are not used
+	randCase *randCase // nil when the case of the qname is not randomized
+	breaker  *breaker  // nil when there is no circuit breaker
+
 	// health checking
 	probe  *up.Probe
 	health HealthChecker
@@ -36,6 +45,13 @@ func NewProxy(proxyName, addr, trans string) *Proxy {
 		health:      NewHealthChecker(proxyName, trans, true, "."),
 		proxyName:   proxyName,
 	}
+	p.transport.trans = trans
+	switch {
+	case p.transport.isDoH():
+		p.transport.setupDoH()
+	case p.transport.isDoQ():
+		p.transport.setupDoQ()
+	}
 
 	runtime.SetFinalizer(p, (*Proxy).finalizer)
 	return p
@@ -49,6 +65,21 @@ func (p *Proxy) SetTLSConfig(cfg *tls.Config) {
 	p.health.SetTLSConfig(cfg)
 }
 
+// EnableCookies makes the proxy send DNS cookies (RFC 7873) to the upstream and verify the ones in the replies.
+// Cookies are only used for plain DNS, they add nothing to the protection TLS gives.
+func (p *Proxy) EnableCookies() { p.cookie = newCookie() }
+
+// EnableRandCase makes the proxy randomize the case of the qname in queries to the upstream (DNS 0x20), and
+// verify the replies echo it. Like cookies, this is only used for plain DNS.
+func (p *Proxy) EnableRandCase() { p.randCase = new(randCase) }
+
+// RandCase returns true if the case of the qname is randomized for this proxy. This is false when it was
+// disabled because the upstream does not preserve the case.
+func (p *Proxy) RandCase() bool { return p.randCase != nil && p.randCase.enabled() }
+
+// SetBreaker enables a circuit breaker for this proxy, configured with cfg.
+func (p *Proxy) SetBreaker(cfg BreakerConfig) { p.breaker = newBreaker(p.proxyName, p.addr, cfg) }
+
 // SetExpire sets the expire duration in the lower p.transport.
 func (p *Proxy) SetExpire(expire time.Duration) { p.transport.SetExpire(expire) }
 
@@ -76,8 +107,11 @@ func (p *Proxy) Healthcheck() {
 	})
 }
 
-// Down returns true if this proxy is down, i.e. has *more* fails than maxfails.
+// Down returns true if this proxy is down, i.e. has *more* fails than maxfails, or its circuit breaker is open.
 func (p *Proxy) Down(maxfails uint32) bool {
+	if p.breaker != nil && p.breaker.open() {
+		return true
+	}
 	if maxfails == 0 {
 		return false
 	}
@@ -110,13 +144,64 @@ func (p *Proxy) incrementFails() {
 	atomic.AddUint32(&p.fails, 1)
 }
 
+// AvgRTT returns the moving average of the round trip time to this upstream, or 0 when nothing has
+// been observed yet. Failed exchanges count as a round trip time of the read timeout. The average halves
+// every rttHalfLife without new observations, so upstreams that have not been used for a while look
+// attractive again and get re-measured. When the last exchange failed, the average goes up to the read
+// timeout instead, so an upstream that stopped answering does not look fast again.
+func (p *Proxy) AvgRTT() time.Duration {
+	rtt := time.Duration(atomic.LoadInt64(&p.avgRTT))
+	if rtt == 0 {
+		return 0
+	}
+	halves := time.Since(time.Unix(0, atomic.LoadInt64(&p.lastRTT))) / rttHalfLife
+	if atomic.LoadUint32(&p.rttFailed) == 1 {
+		penalty := p.readTimeout
+		if halves >= 63 {
+			return penalty
+		}
+		return penalty - (penalty-rtt)>>uint(halves)
+	}
+	if halves >= 63 {
+		return 0
+	}
+	return rtt >> uint(halves)
+}
+
+// updateRTT adds rtt to the moving average of the round trip time.
+func (p *Proxy) updateRTT(rtt time.Duration) {
+	atomic.StoreUint32(&p.rttFailed, 0)
+	p.addRTT(rtt)
+}
+
+// failedRTT adds the read timeout to the moving average of the round trip time, as a penalty for a
+// failed exchange.
+func (p *Proxy) failedRTT() {
+	atomic.StoreUint32(&p.rttFailed, 1)
+	p.addRTT(p.readTimeout)
+}
+
+func (p *Proxy) addRTT(rtt time.Duration) {
+	atomic.StoreInt64(&p.lastRTT, time.Now().UnixNano())
+	if atomic.CompareAndSwapInt64(&p.avgRTT, 0, int64(rtt)) {
+		return
+	}
+	averageTimeout(&p.avgRTT, rtt, rttAvgWeight)
+}
+
 // Close removes the finalizer and stops the health checking goroutine and the transport.
 func (p *Proxy) Close() {
 	runtime.SetFinalizer(p, nil)
 	p.probe.Stop()
 	p.transport.Stop()
+	if p.breaker != nil {
+		circuitState.DeleteLabelValues(p.proxyName, p.addr)
+	}
 }
 
 const (
 	maxTimeout = 2 * time.Second
+
+	rttAvgWeight = 8
+	rttHalfLife  = 30 * time.Second
 )
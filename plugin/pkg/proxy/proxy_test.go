@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"math"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -45,6 +46,36 @@ func TestProxy(t *testing.T) {
 	}
 }
 
+func TestProxyCancel(t *testing.T) {
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		// Never reply, the query must end when the context is cancelled.
+	})
+	defer s.Close()
+
+	p := NewProxy("TestProxyCancel", s.Addr, transport.DNS)
+	p.readTimeout = 5 * time.Second
+	p.Start(5 * time.Second)
+	defer p.Close()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	req := request.Request{Req: m, W: dnstest.NewRecorder(&test.ResponseWriter{})}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := p.Connect(ctx, req, Options{PreferUDP: true})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Expected the query to end with the context, it took %s", d)
+	}
+	if rtt := p.AvgRTT(); rtt != 0 {
+		t.Errorf("Expected a cancelled query not to count in the average RTT, got %s", rtt)
+	}
+}
+
 func TestProxyTLSFail(t *testing.T) {
 	// This is an udp/tcp test server, so we shouldn't reach it with TLS.
 	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
@@ -223,3 +254,50 @@ func TestShouldTruncateResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestProxyAvgRTT(t *testing.T) {
+	p := NewProxy("TestProxyAvgRTT", "127.0.0.1:53", transport.DNS)
+	if rtt := p.AvgRTT(); rtt != 0 {
+		t.Errorf("Expected no average round trip time, got %s", rtt)
+	}
+
+	p.updateRTT(80 * time.Millisecond)
+	if rtt := p.AvgRTT(); rtt != 80*time.Millisecond {
+		t.Errorf("Expected first observation to be used as is, got %s", rtt)
+	}
+
+	p.updateRTT(160 * time.Millisecond)
+	if rtt := p.AvgRTT(); rtt != 90*time.Millisecond {
+		t.Errorf("Expected average round trip time of %s, got %s", 90*time.Millisecond, rtt)
+	}
+
+	// Pretend the last observation was two half lives ago.
+	atomic.StoreInt64(&p.lastRTT, time.Now().Add(-2*rttHalfLife).UnixNano())
+	if rtt := p.AvgRTT(); rtt != 90*time.Millisecond/4 {
+		t.Errorf("Expected decayed average round trip time of %s, got %s", 90*time.Millisecond/4, rtt)
+	}
+}
+
+func TestProxyAvgRTTFailed(t *testing.T) {
+	p := NewProxy("TestProxyAvgRTTFailed", "127.0.0.1:53", transport.DNS)
+	p.SetReadTimeout(800 * time.Millisecond)
+
+	p.updateRTT(80 * time.Millisecond)
+	p.failedRTT()
+	if rtt := p.AvgRTT(); rtt != 170*time.Millisecond {
+		t.Errorf("Expected a failed exchange to count as the read timeout, got %s", rtt)
+	}
+
+	// Pretend the last observation was two half lives ago, the average goes up to the read timeout.
+	atomic.StoreInt64(&p.lastRTT, time.Now().Add(-2*rttHalfLife).UnixNano())
+	if rtt := p.AvgRTT(); rtt != 800*time.Millisecond-630*time.Millisecond/4 {
+		t.Errorf("Expected average round trip time of %s, got %s", 800*time.Millisecond-630*time.Millisecond/4, rtt)
+	}
+
+	// A successful exchange makes the average decay to 0 again.
+	p.updateRTT(80 * time.Millisecond)
+	atomic.StoreInt64(&p.lastRTT, time.Now().Add(-2*rttHalfLife).UnixNano())
+	if rtt := p.AvgRTT(); rtt != 158750*time.Microsecond/4 {
+		t.Errorf("Expected average round trip time of %s, got %s", 158750*time.Microsecond/4, rtt)
+	}
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// caseMaxMismatches is the number of consecutive replies with a mismatched qname case after which
+	// case randomization is disabled for an upstream, as it apparently does not preserve the case.
+	caseMaxMismatches = 5
+	// caseDisableDuration is how long case randomization stays disabled.
+	caseDisableDuration = 30 * time.Minute
+)
+
+// randCase holds the state of DNS 0x20 qname case randomization for an upstream.
+type randCase struct {
+	disabledUntil int64  // unix time in nanoseconds, zero when enabled; first in struct for alignment
+	mismatches    uint32 // consecutive replies with a mismatched case
+}
+
+// enabled returns true if the case of the qname should be randomized.
+func (c *randCase) enabled() bool {
+	until := atomic.LoadInt64(&c.disabledUntil)
+	return until == 0 || time.Now().UnixNano() >= until
+}
+
+// ok records a reply that echoed the case of the qname.
+func (c *randCase) ok() {
+	atomic.StoreUint32(&c.mismatches, 0)
+	atomic.StoreInt64(&c.disabledUntil, 0)
+}
+
+// mismatch records a reply that did not echo the case of the qname, and returns true if case
+// randomization got disabled because of it.
+func (c *randCase) mismatch() bool {
+	if atomic.AddUint32(&c.mismatches, 1) < caseMaxMismatches {
+		return false
+	}
+	atomic.StoreUint32(&c.mismatches, 0)
+	atomic.StoreInt64(&c.disabledUntil, time.Now().Add(caseDisableDuration).UnixNano())
+	return true
+}
+
+// randomizeCase returns name with the case of each letter randomly flipped.
+func randomizeCase(name string) string {
+	bits := make([]byte, (len(name)+7)/8)
+	rand.Read(bits)
+
+	b := []byte(name)
+	for i, c := range b {
+		if bits[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		switch {
+		case 'a' <= c && c <= 'z':
+			b[i] = c - 'a' + 'A'
+		case 'A' <= c && c <= 'Z':
+			b[i] = c - 'A' + 'a'
+		}
+	}
+	return string(b)
+}
+
+// sendWithRandCase sends the request in state with send, with the case of the qname randomized (DNS 0x20).
+// The reply must echo the qname in the exact same case, otherwise it is treated as spoofed and the query
+// is retried over TCP with the qname as it was. A reply without a question is retried over TCP too, but
+// doesn't count as a mismatch. The case of the client is restored in the reply.
+func (p *Proxy) sendWithRandCase(ctx context.Context, state request.Request, proto string, send func(context.Context, request.Request, string) (*dns.Msg, error)) (*dns.Msg, error) {
+	if len(state.Req.Question) != 1 {
+		return send(ctx, state, proto)
+	}
+
+	qname := state.Req.Question[0].Name
+	st := state
+	st.Req = state.Req.Copy()
+	randomized := randomizeCase(qname)
+	st.Req.Question[0].Name = randomized
+
+	ret, err := send(ctx, st, proto)
+	if err != nil {
+		return ret, err
+	}
+
+	if len(ret.Question) == 1 && ret.Question[0].Name == randomized {
+		p.randCase.ok()
+		restoreCase(ret, randomized, qname)
+		return ret, nil
+	}
+	if len(ret.Question) == 0 {
+		// Error replies such as FORMERR may leave out the question, so the case can't be checked. That says
+		// nothing about the upstream preserving the case, but a reply over UDP can still be spoofed.
+		if proto == "tcp" {
+			restoreCase(ret, randomized, qname)
+			return ret, nil
+		}
+		return send(ctx, state, "tcp")
+	}
+
+	caseMismatchCount.WithLabelValues(p.proxyName, p.addr).Add(1)
+	if p.randCase.mismatch() {
+		log.Warningf("Upstream %s does not preserve the case of the query name, disabling case randomization for %s", p.addr, caseDisableDuration)
+	}
+	if proto == "tcp" {
+		// Nobody can spoof this, the upstream just doesn't preserve the case.
+		if len(ret.Question) == 1 {
+			restoreCase(ret, ret.Question[0].Name, qname)
+		}
+		return ret, nil
+	}
+	return send(ctx, state, "tcp")
+}
+
+// restoreCase sets the owner names in m that are exactly from to to.
+func restoreCase(m *dns.Msg, from, to string) {
+	for i := range m.Question {
+		if m.Question[i].Name == from {
+			m.Question[i].Name = to
+		}
+	}
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Name == from {
+				rr.Header().Name = to
+			}
+		}
+	}
+}
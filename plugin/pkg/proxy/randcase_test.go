@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+func TestRandomizeCase(t *testing.T) {
+	name := "www.example.org."
+	changed := false
+	for range 10 {
+		r := randomizeCase(name)
+		if !strings.EqualFold(r, name) {
+			t.Fatalf("Expected %q to only differ in case from %q", r, name)
+		}
+		if r != name {
+			changed = true
+		}
+	}
+	if !changed {
+		t.Errorf("Expected the case of %q to be randomized", name)
+	}
+	if r := randomizeCase(`\065.12.`); !strings.EqualFold(r, `\065.12.`) {
+		t.Errorf("Expected escapes and digits to be left alone, got %q", r)
+	}
+}
+
+func TestProxyRandCase(t *testing.T) {
+	var qname atomic.Value
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		qname.Store(r.Question[0].Name)
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		ret.Answer = append(ret.Answer, test.A(r.Question[0].Name+" IN A 127.0.0.1"))
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	p := NewProxy("TestProxyRandCase", s.Addr, transport.DNS)
+	p.EnableRandCase()
+	p.Start(5 * time.Second)
+	defer p.Close()
+
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.org.", dns.TypeA)
+	state := request.Request{Req: m, W: &test.ResponseWriter{}}
+
+	ret, err := p.Connect(context.Background(), state, Options{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if ret.Question[0].Name != "www.example.org." || ret.Answer[0].Header().Name != "www.example.org." {
+		t.Errorf("Expected the case of the client to be restored, got %q and %q", ret.Question[0].Name, ret.Answer[0].Header().Name)
+	}
+	if m.Question[0].Name != "www.example.org." {
+		t.Errorf("Expected the request not to be changed, got %q", m.Question[0].Name)
+	}
+	if !strings.EqualFold(qname.Load().(string), "www.example.org.") {
+		t.Errorf("Expected the upstream to see the query name, got %q", qname.Load())
+	}
+}
+
+func TestProxyRandCaseMismatch(t *testing.T) {
+	var udp, tcp uint32
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		if w.RemoteAddr().Network() == "tcp" {
+			atomic.AddUint32(&tcp, 1)
+		} else {
+			atomic.AddUint32(&udp, 1)
+		}
+		// Don't preserve the case.
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		ret.Question[0].Name = strings.ToLower(ret.Question[0].Name)
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	p := NewProxy("TestProxyRandCaseMismatch", s.Addr, transport.DNS)
+	p.EnableRandCase()
+	p.Start(5 * time.Second)
+	defer p.Close()
+
+	// Use a long name, so the odds of randomizing to all lowercase are negligible.
+	name := "abcdefghijklmnopqrstuvwxyz.example.org."
+	for range caseMaxMismatches {
+		if !p.RandCase() {
+			t.Fatal("Expected case randomization to be enabled")
+		}
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		state := request.Request{Req: m, W: &test.ResponseWriter{}}
+		ret, err := p.Connect(context.Background(), state, Options{})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if ret.Question[0].Name != name {
+			t.Errorf("Expected question %q, got %q", name, ret.Question[0].Name)
+		}
+	}
+
+	if n := atomic.LoadUint32(&tcp); n != caseMaxMismatches {
+		t.Errorf("Expected %d retries over TCP, got %d", caseMaxMismatches, n)
+	}
+	if p.RandCase() {
+		t.Error("Expected case randomization to be disabled")
+	}
+}
+
+func TestProxyRandCaseNoQuestion(t *testing.T) {
+	var tcp uint32
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		if w.RemoteAddr().Network() == "tcp" {
+			atomic.AddUint32(&tcp, 1)
+		}
+		// A FORMERR without the question.
+		ret := new(dns.Msg)
+		ret.SetRcode(r, dns.RcodeFormatError)
+		ret.Question = nil
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	p := NewProxy("TestProxyRandCaseNoQuestion", s.Addr, transport.DNS)
+	p.EnableRandCase()
+	p.Start(5 * time.Second)
+	defer p.Close()
+
+	for range caseMaxMismatches {
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.org.", dns.TypeA)
+		state := request.Request{Req: m, W: &test.ResponseWriter{}}
+		ret, err := p.Connect(context.Background(), state, Options{})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if ret.Rcode != dns.RcodeFormatError {
+			t.Errorf("Expected FORMERR, got %s", dns.RcodeToString[ret.Rcode])
+		}
+	}
+
+	if n := atomic.LoadUint32(&tcp); n != caseMaxMismatches {
+		t.Errorf("Expected %d retries over TCP, got %d", caseMaxMismatches, n)
+	}
+	if n := atomic.LoadUint32(&p.randCase.mismatches); n != 0 {
+		t.Errorf("Expected no mismatches, got %d", n)
+	}
+	if !p.RandCase() {
+		t.Error("Expected case randomization to stay enabled")
+	}
+}
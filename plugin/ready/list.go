@@ -53,6 +53,14 @@ func (l *list) Ready() (bool, string) {
 			continue
 		}
 		ok = false
+		if p, isPartial := r.(PartialReadiness); isPartial {
+			if parts := p.NotReady(); len(parts) > 0 {
+				for _, part := range parts {
+					s = append(s, l.names[i]+"/"+part)
+				}
+				continue
+			}
+		}
 		s = append(s, l.names[i])
 	}
 	if ok {
@@ -0,0 +1,33 @@
+package ready
+
+import "testing"
+
+type readiness bool
+
+func (r readiness) Ready() bool { return bool(r) }
+
+type partialReadiness []string
+
+func (p partialReadiness) Ready() bool        { return len(p) == 0 }
+func (p partialReadiness) NotReady() []string { return p }
+
+func TestListReady(t *testing.T) {
+	l := &list{keepReadiness: true}
+	l.Append(readiness(true), "erratic")
+	l.Append(readiness(false), "etcd")
+	l.Append(partialReadiness{"cluster-b.local.", "cluster-a.local."}, "kubernetes")
+
+	ok, s := l.Ready()
+	if ok {
+		t.Fatal("Expected not to be ready")
+	}
+	if expected := "etcd,kubernetes/cluster-a.local.,kubernetes/cluster-b.local."; s != expected {
+		t.Errorf("Expected %q, got %q", expected, s)
+	}
+
+	l.Reset()
+	l.Append(partialReadiness{}, "kubernetes")
+	if ok, s := l.Ready(); !ok {
+		t.Errorf("Expected to be ready, got %q", s)
+	}
+}
@@ -5,3 +5,11 @@ type Readiness interface {
 	// Ready is called by ready to see whether the plugin is ready.
 	Ready() bool
 }
+
+// The PartialReadiness interface can be implemented by a plugin that has parts that become ready independently,
+// such as connections to different backends. The parts that are not ready are reported as "plugin/part".
+type PartialReadiness interface {
+	Readiness
+	// NotReady returns the names of the parts that are not ready.
+	NotReady() []string
+}
@@ -42,8 +42,10 @@ type Transferer interface {
 	//
 	// If serial is not 0, it will be handled as an IXFR request. If the serial is equal to or greater (newer) than
 	// the current serial for the zone, send a single SOA record to the channel and then close it.
-	// If the serial is less (older) than the current serial for the zone, perform an AXFR fallback
-	// by proceeding as if an AXFR was requested (as above).
+	// If the serial is less (older) than the current serial for the zone, send the differences since serial
+	// as an incremental transfer (RFC 1995): the current SOA, then for each change the old SOA with the deleted
+	// records and the new SOA with the added records, and the current SOA again. If the plugin doesn't have
+	// these differences, perform an AXFR fallback by proceeding as if an AXFR was requested (as above).
 	Transfer(zone string, serial uint32) (<-chan []dns.RR, error)
 }
 
@@ -68,10 +68,13 @@ func (t *TSIGServer) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.
 
 	// strip the TSIG RR. Next, and subsequent plugins will not see the TSIG RRs.
 	// This violates forwarding cases (RFC 8945 5.5). See README.md Bugs
-	if len(r.Extra) > 1 {
-		r.Extra = r.Extra[0 : len(r.Extra)-1]
-	} else {
-		r.Extra = []dns.RR{}
+	// Dynamic updates keep it, the plugin applying the update needs to know which key signed it.
+	if r.Opcode != dns.OpcodeUpdate {
+		if len(r.Extra) > 1 {
+			r.Extra = r.Extra[0 : len(r.Extra)-1]
+		} else {
+			r.Extra = []dns.RR{}
+		}
 	}
 
 	if rcode == dns.RcodeSuccess {
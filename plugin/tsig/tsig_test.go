@@ -224,6 +224,38 @@ func TestServeDNSTsigErrors(t *testing.T) {
 	}
 }
 
+func TestServeDNSUpdate(t *testing.T) {
+	var key string
+	tsig := TSIGServer{
+		Zones: []string{"."},
+		Next: test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			if ts := r.IsTsig(); ts != nil {
+				key = ts.Hdr.Name
+			}
+			m := new(dns.Msg)
+			m.SetReply(r)
+			w.WriteMsg(m)
+			return dns.RcodeSuccess, nil
+		}),
+	}
+
+	w := dnstest.NewRecorder(&test.ResponseWriter{})
+	r := new(dns.Msg)
+	r.SetUpdate("example.")
+	r.Insert([]dns.RR{test.A("test.example.  300  IN  A  1.2.3.48")})
+	r.SetTsig("test.key.", dns.HmacSHA256, 300, time.Now().Unix())
+
+	if _, err := tsig.ServeDNS(context.TODO(), w, r); err != nil {
+		t.Fatal(err)
+	}
+	if key != "test.key." {
+		t.Errorf("expected the update to be passed on with its TSIG, got key %q", key)
+	}
+	if w.Msg.IsTsig() == nil {
+		t.Error("expected TSIG in response")
+	}
+}
+
 func testHandler() test.HandlerFunc {
 	return func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 		state := request.Request{W: w, Req: r}